@@ -0,0 +1,103 @@
+package xdrrpc
+
+import (
+	"bytes"
+	"errors"
+	"net"
+)
+
+const (
+	AuthNone AuthFlavor = 0 // No authentication
+	AuthSys  AuthFlavor = 1 // Unix style (uid+gids)
+)
+
+var errNotAuthSys = errors.New("xdrrpc: credentials are not AUTH_SYS")
+
+// CallInfo describes the RPC call being served.
+type CallInfo struct {
+	Xid        uint32
+	Program    uint32
+	Version    uint32
+	Procedure  uint32
+	Cred       OpaqueAuth
+	Verf       OpaqueAuth
+	RemoteAddr net.Addr // nil if the connection does not tell
+}
+
+// AuthSys decodes AUTH_SYS credentials of the call.
+func (c *CallInfo) AuthSys() (*AuthSysParms, error) {
+	if c.Cred.Flavor != AuthSys {
+		return nil, errNotAuthSys
+	}
+	var p AuthSysParms
+	if err := Unmarshal(bytes.NewReader(c.Cred.Body), &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Local tells whether the call was made on this host: over loopback, a
+// unix socket or an in-process pipe.
+func (c *CallInfo) Local() bool {
+	if c == nil || c.RemoteAddr == nil {
+		return false
+	}
+	switch a := c.RemoteAddr.(type) {
+	case *net.TCPAddr:
+		return a.IP.IsLoopback()
+	case *net.UDPAddr:
+		return a.IP.IsLoopback()
+	}
+	switch c.RemoteAddr.Network() {
+	case "pipe", "unix":
+		return true
+	}
+	return false
+}
+
+// Privileged tells whether the call was made from a port below 1024, which
+// only root may bind on most systems, or over a connection without ports.
+func (c *CallInfo) Privileged() bool {
+	if c == nil || c.RemoteAddr == nil {
+		return false
+	}
+	switch a := c.RemoteAddr.(type) {
+	case *net.TCPAddr:
+		return a.Port < 1024
+	case *net.UDPAddr:
+		return a.Port < 1024
+	}
+	return true
+}
+
+// AuthSysParms is the body of AUTH_SYS credentials.
+type AuthSysParms struct {
+	Stamp       uint32
+	MachineName string
+	UID         uint32
+	GID         uint32
+	GIDs        []uint32
+}
+
+// Header can be embedded in argument types of services that need to know
+// who is calling. The codec fills it in when decoding arguments; it takes
+// no space on the wire.
+type Header struct {
+	call *CallInfo
+}
+
+// Call returns the call the arguments were received with, or nil if they
+// were not received by a server codec.
+func (h *Header) Call() *CallInfo {
+	return h.call
+}
+
+// SetCall makes Call return c, for services passing arguments on to
+// others in the name of the same call.
+func (h *Header) SetCall(c *CallInfo) {
+	h.call = c
+}
+
+type callSetter interface {
+	SetCall(*CallInfo)
+}
@@ -0,0 +1,250 @@
+package xdrrpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+var (
+	errUnknownMethod  = errors.New("xdrrpc: method not registered for program version")
+	errMessageDenied  = errors.New("xdrrpc: call denied")
+	errInvalidReply   = errors.New("xdrrpc: invalid reply received")
+	errRecordTooLarge = errors.New("xdrrpc: record too large")
+)
+
+// MaxRecordSize limits records read by clients and servers.
+const MaxRecordSize = 16 << 20
+
+type clientRequest struct {
+	Xid        uint32
+	Type       MessageType
+	RPCVersion uint32
+	Program    uint32
+	Version    uint32
+	Procedure  uint32
+	Cred       OpaqueAuth
+	Verf       OpaqueAuth
+}
+
+type clientResponse struct {
+	Xid        uint32
+	Type       MessageType
+	ReplayStat ReplyStat
+}
+
+// ClientCodec is an rpc.ClientCodec calling procedures of a single program
+// version. Service methods given to rpc.Client are translated back into
+// procedure numbers through Register.
+type ClientCodec struct {
+	program uint32
+	version uint32
+
+	c   io.ReadWriteCloser
+	buf bytes.Buffer // for encoder
+
+	mu   sync.Mutex // protects cred
+	cred OpaqueAuth
+
+	// reply being read
+	rd *bytes.Reader
+}
+
+// NewClientCodec returns a ClientCodec calling program version on conn
+// with AUTH_NONE credentials.
+func NewClientCodec(conn io.ReadWriteCloser, program, version uint32) *ClientCodec {
+	return &ClientCodec{
+		program: program,
+		version: version,
+		c:       conn,
+	}
+}
+
+// SetCred makes following calls use cred.
+func (c *ClientCodec) SetCred(cred OpaqueAuth) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cred = cred
+}
+
+// NewClient returns an rpc.Client calling program version on conn.
+func NewClient(conn io.ReadWriteCloser, program, version uint32) *rpc.Client {
+	return rpc.NewClientWithCodec(NewClientCodec(conn, program, version))
+}
+
+// Dial connects to program version at address over TCP or another stream
+// network.
+func Dial(network, address string, program, version uint32) (*rpc.Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, program, version), nil
+}
+
+// NewAuthSys returns AUTH_SYS credentials carrying p.
+func NewAuthSys(p AuthSysParms) OpaqueAuth {
+	var buf bytes.Buffer
+	xdr.Marshal(&buf, p)
+	return OpaqueAuth{Flavor: AuthSys, Body: buf.Bytes()}
+}
+
+// reverse returns the procedure registered as serviceMethod.
+func reverse(program, version uint32, serviceMethod string) (uint32, bool) {
+	var (
+		procedure uint32
+		found     bool
+	)
+	DefaultMap.Range(func(k, v interface{}) bool {
+		key := k.(key)
+		if key.Program == program && key.Version == version && v.(string) == serviceMethod {
+			procedure, found = key.Procedure, true
+			return false
+		}
+		return true
+	})
+	return procedure, found
+}
+
+func (c *ClientCodec) WriteRequest(r *rpc.Request, x interface{}) error {
+	procedure, ok := reverse(c.program, c.version, r.ServiceMethod)
+	if !ok {
+		return errUnknownMethod
+	}
+
+	c.mu.Lock()
+	cred := c.cred
+	c.mu.Unlock()
+
+	c.buf.Reset()
+
+	// placeholder for record mark
+	c.buf.Write([]byte{0, 0, 0, 0})
+
+	req := clientRequest{
+		Xid:        uint32(r.Seq),
+		Type:       Call,
+		RPCVersion: 2,
+		Program:    c.program,
+		Version:    c.version,
+		Procedure:  procedure,
+		Cred:       cred,
+	}
+	if _, err := xdr.Marshal(&c.buf, req); err != nil {
+		return err
+	}
+	if x != nil {
+		if _, err := xdr.Marshal(&c.buf, x); err != nil {
+			return err
+		}
+	}
+
+	data := c.buf.Bytes()
+	binary.BigEndian.PutUint32(data[:4], uint32(len(data)-4)|0x80000000)
+
+	_, err := c.c.Write(data)
+	return err
+}
+
+// readRecord reads fragments up to and including the last one.
+func readRecord(r io.Reader) ([]byte, error) {
+	var record bytes.Buffer
+	for first := true; ; first = false {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF && !first {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		mark := binary.BigEndian.Uint32(hdr[:])
+		size := int64(mark & 0x7fffffff)
+		if int64(record.Len())+size > MaxRecordSize {
+			return nil, errRecordTooLarge
+		}
+		// buffer fragments as they arrive, peers may claim any size
+		if _, err := io.CopyN(&record, r, size); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if mark&0x80000000 != 0 {
+			return record.Bytes(), nil
+		}
+	}
+}
+
+func (c *ClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	record, err := readRecord(c.c)
+	if err != nil {
+		return err
+	}
+	c.rd = bytes.NewReader(record)
+
+	var resp clientResponse
+	if err := Unmarshal(c.rd, &resp); err != nil {
+		return err
+	}
+	if resp.Type != Reply {
+		return errInvalidReply
+	}
+	r.Seq = uint64(resp.Xid)
+
+	if resp.ReplayStat != MessageAccepted {
+		r.Error = errMessageDenied.Error()
+		return nil
+	}
+
+	var (
+		verf OpaqueAuth
+		stat AcceptStat
+	)
+	if err := Unmarshal(c.rd, &verf); err != nil {
+		return err
+	}
+	if err := Unmarshal(c.rd, &stat); err != nil {
+		return err
+	}
+	if stat != Success {
+		r.Error = stat.Error()
+	}
+	return nil
+}
+
+func (c *ClientCodec) ReadResponseBody(x interface{}) error {
+	if x == nil {
+		c.rd = nil
+		return nil
+	}
+	err := Unmarshal(c.rd, x)
+	c.rd = nil
+	return err
+}
+
+func (c *ClientCodec) Close() error {
+	return c.c.Close()
+}
+
+var acceptStatNames = map[AcceptStat]string{
+	Success:      "success",
+	ProgUnavail:  "program unavailable",
+	ProgMismatch: "program version mismatch",
+	ProcUnavail:  "procedure unavailable",
+	GarbageArgs:  "garbage arguments",
+	SystemError:  "system error",
+}
+
+func (s AcceptStat) Error() string {
+	if name, ok := acceptStatNames[s]; ok {
+		return "xdrrpc: " + name
+	}
+	return fmt.Sprintf("xdrrpc: accept stat %d", int32(s))
+}
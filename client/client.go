@@ -0,0 +1,352 @@
+// Package client is an NFSv3 client speaking to servers through xdrrpc, for
+// scripting and testing exports without a kernel mount.
+//
+// Calls return the whole reply of the procedure, along with the reply
+// status as an nfs.NFSStat error if it is not NFS3_OK, so callers can still
+// look at attributes and wcc data of failed calls.
+package client
+
+import (
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/mount"
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+const (
+	mountProg = 100005
+	mountVers = 3
+
+	// defaultSize is the transfer size used if FSINFO fails.
+	defaultSize = 32 << 10
+)
+
+// Client calls NFSv3 procedures of a single export.
+type Client struct {
+	codec *xdrrpc.ClientCodec
+	rpc   *rpc.Client
+	mnt   *rpc.Client // nil if not mounted by the client
+	path  string
+
+	// Root is the handle of the mounted directory.
+	Root []byte
+
+	cred    xdrrpc.OpaqueAuth
+	retries int
+	delay   time.Duration
+
+	once   sync.Once
+	rtmax  uint32
+	wtmax  uint32
+	dtpref uint32
+}
+
+type Option func(*Client)
+
+// WithCred makes calls with AUTH_SYS credentials cred. Clients otherwise
+// use the user and groups of the process.
+func WithCred(cred nfs.Cred) Option {
+	return func(c *Client) {
+		c.cred = authSys(cred)
+	}
+}
+
+// WithAuthNone makes calls with AUTH_NONE credentials.
+func WithAuthNone() Option {
+	return func(c *Client) {
+		c.cred = xdrrpc.OpaqueAuth{Flavor: xdrrpc.AuthNone}
+	}
+}
+
+// WithJukebox makes calls failing with NFS3ERR_JUKEBOX be tried again up
+// to retries times, first after delay and then doubling it up to
+// maxJukeboxDelay. Zero retries turns retrying off.
+func WithJukebox(retries int, delay time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.delay = delay
+	}
+}
+
+// maxJukeboxDelay is how long the Linux client waits before retrying.
+const maxJukeboxDelay = 5 * time.Second
+
+func authSys(cred nfs.Cred) xdrrpc.OpaqueAuth {
+	host, _ := os.Hostname()
+	return xdrrpc.NewAuthSys(xdrrpc.AuthSysParms{
+		Stamp:       uint32(time.Now().Unix()),
+		MachineName: host,
+		UID:         cred.UID,
+		GID:         cred.GID,
+		GIDs:        cred.GIDs,
+	})
+}
+
+func processCred() nfs.Cred {
+	cred := nfs.Cred{UID: uint32(os.Getuid()), GID: uint32(os.Getgid())}
+	gids, _ := os.Getgroups()
+	for _, gid := range gids {
+		cred.GIDs = append(cred.GIDs, uint32(gid))
+	}
+	return cred
+}
+
+// New returns a client calling NFS procedures on conn, with root as the
+// handle of the mounted directory.
+func New(conn io.ReadWriteCloser, root []byte, opts ...Option) *Client {
+	c := &Client{
+		Root:    root,
+		retries: 10,
+		delay:   100 * time.Millisecond,
+	}
+	c.cred = authSys(processCred())
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.codec = xdrrpc.NewClientCodec(conn, nfs.Nfs3Prog, nfs.Nfs3Vers)
+	c.codec.SetCred(c.cred)
+	c.rpc = rpc.NewClientWithCodec(c.codec)
+	return c
+}
+
+// Mount mounts path of the server at addr and connects to its NFS service.
+// Both MOUNT and NFS are expected on the same TCP port, like
+// simple-nfs-server serves them.
+func Mount(addr, path string, opts ...Option) (*Client, error) {
+	mconn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		mconn.Close()
+		return nil, err
+	}
+	c, err := MountConn(mconn, conn, path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// MountConn is Mount over connections established by the caller: mconn to
+// the MOUNT service and conn to the NFS service. Both are closed if
+// mounting fails.
+func MountConn(mconn, conn io.ReadWriteCloser, path string, opts ...Option) (*Client, error) {
+	c := New(conn, nil, opts...)
+
+	codec := xdrrpc.NewClientCodec(mconn, mountProg, mountVers)
+	codec.SetCred(c.cred)
+	c.mnt = rpc.NewClientWithCodec(codec)
+
+	var res mount.MountRes
+	err := c.mnt.Call("Mount.Mount", &mount.MountArgs{Dirpath: path}, &res)
+	if err == nil && res.Status != mount.MountOk {
+		err = res.Status
+	}
+	if err != nil {
+		c.mnt.Close()
+		c.rpc.Close()
+		return nil, err
+	}
+	c.Root = res.Handle
+	c.path = path
+	return c, nil
+}
+
+// Close unmounts the export if the client mounted it and closes the
+// connections.
+func (c *Client) Close() error {
+	if c.mnt != nil {
+		c.mnt.Call("Mount.Unmount", &mount.UnmountArgs{Dirpath: c.path}, nil)
+		c.mnt.Close()
+	}
+	return c.rpc.Close()
+}
+
+// call calls method, trying again while it fails with NFS3ERR_JUKEBOX. It
+// returns the status stat points to, which is part of res.
+func (c *Client) call(method string, args, res interface{}, stat *nfs.NFSStat) error {
+	delay := c.delay
+	for try := 0; ; try++ {
+		if err := c.rpc.Call(method, args, res); err != nil {
+			return err
+		}
+		if *stat != nfs.NFSStatJukebox || try >= c.retries {
+			break
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxJukeboxDelay {
+			delay = maxJukeboxDelay
+		}
+		// replies are decoded over what res holds
+		v := reflect.ValueOf(res).Elem()
+		v.Set(reflect.Zero(v.Type()))
+	}
+	if *stat != nfs.NFSStatOk {
+		return *stat
+	}
+	return nil
+}
+
+// sizes asks the server once for its preferred transfer sizes.
+func (c *Client) sizes() {
+	c.once.Do(func() {
+		c.rtmax, c.wtmax, c.dtpref = defaultSize, defaultSize, defaultSize
+		res, err := c.Fsinfo(c.Root)
+		if err != nil {
+			return
+		}
+		if res.RTMax > 0 {
+			c.rtmax = res.RTMax
+		}
+		if res.WTMax > 0 {
+			c.wtmax = res.WTMax
+		}
+		if res.DTPref > 0 {
+			c.dtpref = res.DTPref
+		}
+	})
+}
+
+func (c *Client) Null() error {
+	return c.rpc.Call("NFS.Null", &nfs.NullArgs{}, &nfs.NullRes{})
+}
+
+func (c *Client) Getattr(fh []byte) (*nfs.GETATTR3res, error) {
+	var res nfs.GETATTR3res
+	err := c.call("NFS.Getattr", &nfs.GETATTR3args{Object: fh}, &res, &res.Status)
+	return &res, err
+}
+
+// Setattr changes attributes of fh, if its ctime still is guard when set.
+func (c *Client) Setattr(fh []byte, attr nfs.Sattr3, guard nfs.Sattrguard3) (*nfs.SETATTR3res, error) {
+	var res nfs.SETATTR3res
+	err := c.call("NFS.Setattr", &nfs.SETATTR3args{Object: fh, Sattr: attr, Guard: guard}, &res, &res.Status)
+	return &res, err
+}
+
+func (c *Client) Lookup(dir []byte, name string) (*nfs.LOOKUP3res, error) {
+	var res nfs.LOOKUP3res
+	err := c.call("NFS.Lookup", &nfs.LOOKUP3args{What: nfs.Diropargs3{Dir: dir, Name: name}}, &res, &res.Status)
+	return &res, err
+}
+
+func (c *Client) Access(fh []byte, access uint32) (*nfs.ACCESS3res, error) {
+	var res nfs.ACCESS3res
+	err := c.call("NFS.Access", &nfs.ACCESS3args{Object: fh, Access: access}, &res, &res.Status)
+	return &res, err
+}
+
+// Read reads up to count bytes at offset. Data of the reply holds Count
+// bytes.
+func (c *Client) Read(fh []byte, offset uint64, count uint32) (*nfs.READ3res, error) {
+	var res nfs.READ3res
+	err := c.call("NFS.Read", &nfs.READ3args{Object: fh, Offset: offset, Count: count}, &res, &res.Status)
+	if int(res.Count) < len(res.Data) {
+		res.Data = res.Data[:res.Count]
+	}
+	return &res, err
+}
+
+// Write writes data at offset, committed as stable asks.
+func (c *Client) Write(fh []byte, offset uint64, data []byte, stable int32) (*nfs.WRITE3res, error) {
+	var res nfs.WRITE3res
+	args := &nfs.WRITE3args{
+		Object: fh,
+		Offset: offset,
+		Count:  uint32(len(data)),
+		Stable: stable,
+		Data:   data,
+	}
+	err := c.call("NFS.Write", args, &res, &res.Status)
+	return &res, err
+}
+
+func (c *Client) Create(dir []byte, name string, how nfs.Createhow3) (*nfs.CREATE3res, error) {
+	var res nfs.CREATE3res
+	err := c.call("NFS.Create", &nfs.CREATE3args{Where: nfs.Diropargs3{Dir: dir, Name: name}, How: how}, &res, &res.Status)
+	return &res, err
+}
+
+func (c *Client) Mkdir(dir []byte, name string, attr nfs.Sattr3) (*nfs.MKDIR3res, error) {
+	var res nfs.MKDIR3res
+	err := c.call("NFS.Mkdir", &nfs.MKDIR3args{Where: nfs.Diropargs3{Dir: dir, Name: name}, Attr: attr}, &res, &res.Status)
+	return &res, err
+}
+
+func (c *Client) Remove(dir []byte, name string) (*nfs.REMOVE3res, error) {
+	var res nfs.REMOVE3res
+	err := c.call("NFS.Remove", &nfs.REMOVE3args{Object: nfs.Diropargs3{Dir: dir, Name: name}}, &res, &res.Status)
+	return &res, err
+}
+
+func (c *Client) Rmdir(dir []byte, name string) (*nfs.RMDIR3res, error) {
+	var res nfs.RMDIR3res
+	err := c.call("NFS.Rmdir", &nfs.RMDIR3args{Object: nfs.Diropargs3{Dir: dir, Name: name}}, &res, &res.Status)
+	return &res, err
+}
+
+func (c *Client) Rename(fromDir []byte, fromName string, toDir []byte, toName string) (*nfs.RENAME3res, error) {
+	var res nfs.RENAME3res
+	args := &nfs.RENAME3args{
+		From: nfs.Diropargs3{Dir: fromDir, Name: fromName},
+		To:   nfs.Diropargs3{Dir: toDir, Name: toName},
+	}
+	err := c.call("NFS.Rename", args, &res, &res.Status)
+	return &res, err
+}
+
+// Link makes name in dir a hard link to fh.
+func (c *Client) Link(fh, dir []byte, name string) (*nfs.LINK3res, error) {
+	var res nfs.LINK3res
+	err := c.call("NFS.Link", &nfs.LINK3args{Object: fh, Link: nfs.Diropargs3{Dir: dir, Name: name}}, &res, &res.Status)
+	return &res, err
+}
+
+func (c *Client) Readdirplus(dir []byte, cookie, verf uint64, dirCount, maxCount uint32) (*nfs.READDIRPLUS3res, error) {
+	var res nfs.READDIRPLUS3res
+	args := &nfs.READDIRPLUS3args{
+		Dir:        dir,
+		Cookie:     cookie,
+		CookieVerf: verf,
+		DirCount:   dirCount,
+		MaxCount:   maxCount,
+	}
+	err := c.call("NFS.Readdirplus", args, &res, &res.Status)
+	return &res, err
+}
+
+func (c *Client) Fsstat(fh []byte) (*nfs.FSSTAT3res, error) {
+	var res nfs.FSSTAT3res
+	err := c.call("NFS.Fsstat", &nfs.FSSTAT3args{FSRoot: fh}, &res, &res.Status)
+	return &res, err
+}
+
+func (c *Client) Fsinfo(fh []byte) (*nfs.FSINFO3res, error) {
+	var res nfs.FSINFO3res
+	err := c.call("NFS.Fsinfo", &nfs.FSINFO3args{Object: fh}, &res, &res.Status)
+	return &res, err
+}
+
+func (c *Client) Pathconf(fh []byte) (*nfs.PATHCONF3res, error) {
+	var res nfs.PATHCONF3res
+	err := c.call("NFS.Pathconf", &nfs.PATHCONF3args{Object: fh}, &res, &res.Status)
+	return &res, err
+}
+
+func (c *Client) Commit(fh []byte, offset uint64, count uint32) (*nfs.COMMIT3res, error) {
+	var res nfs.COMMIT3res
+	err := c.call("NFS.Commit", &nfs.COMMIT3args{Object: fh, Offset: offset, Count: count}, &res, &res.Status)
+	return &res, err
+}
+
+// TODO: READLINK, SYMLINK, MKNOD and READDIR once the server implements
+// them
@@ -0,0 +1,121 @@
+package client_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dzeromsk/xdrrpc/client"
+	"github.com/dzeromsk/xdrrpc/cmd/simple-nfs-server/memfs"
+	"github.com/dzeromsk/xdrrpc/nfs"
+	"github.com/dzeromsk/xdrrpc/nfstest"
+)
+
+func emptyTree(mux nfs.ServeMux) interface{} {
+	return memfs.NewFS(memfs.NewDir(mux, map[string]memfs.Node{}))
+}
+
+func TestPaths(t *testing.T) {
+	c := nfstest.NewClient(t, emptyTree)
+	if err := c.MkdirAll("a/b/c", 0755); err != nil {
+		t.Fatal("MkdirAll:", err)
+	}
+	if err := c.MkdirAll("a/b", 0755); err != nil {
+		t.Error("MkdirAll of existing directories:", err)
+	}
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	if err := c.WriteFile("a/b/c/big", data, 0644); err != nil {
+		t.Fatal("WriteFile:", err)
+	}
+	if err := c.WriteFile("a/small", []byte("hello"), 0644); err != nil {
+		t.Fatal("WriteFile:", err)
+	}
+	got, err := c.ReadFile("a/b/c/big")
+	if err != nil {
+		t.Fatal("ReadFile:", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("ReadFile: got %d bytes, want the %d written", len(got), len(data))
+	}
+	attr, err := c.Stat("a/small")
+	if err != nil || attr.Filesize != 5 || attr.FileMode&0777 != 0644 {
+		t.Errorf("Stat: got %+v, %v", attr, err)
+	}
+
+	var walked []string
+	err = c.Walk("a", func(name string, attr *nfs.Fattr3, err error) error {
+		walked = append(walked, name)
+		return err
+	})
+	if want := "[a a/b a/b/c a/b/c/big a/small]"; err != nil || fmt.Sprint(walked) != want {
+		t.Errorf("Walk: got %v, %v, want %s", walked, err, want)
+	}
+
+	for _, tt := range []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"Stat of a missing file", stat(c, "a/missing"), fs.ErrNotExist},
+		{"ReadFile of a directory", readFile(c, "a/b"), nfs.NFSStatIsdir},
+		{"MkdirAll below a file", c.MkdirAll("a/small/d", 0755), nfs.NFSStatNotdir},
+	} {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+}
+
+func stat(c *client.Client, name string) error {
+	_, err := c.Stat(name)
+	return err
+}
+
+func readFile(c *client.Client, name string) error {
+	_, err := c.ReadFile(name)
+	return err
+}
+
+// busyDir answers GETATTR with NFS3ERR_JUKEBOX while busy is positive.
+type busyDir struct {
+	busy int32
+}
+
+func (d *busyDir) Attr() nfs.Fattr3 {
+	return nfs.Fattr3{Type: nfs.NF3Dir, FileMode: 0755, Nlink: 2, Fileid: 1}
+}
+
+func (d *busyDir) Getattr(res *nfs.GETATTR3res) error {
+	if atomic.AddInt32(&d.busy, -1) >= 0 {
+		res.Status = nfs.NFSStatJukebox
+		return nil
+	}
+	res.Status = nfs.NFSStatOk
+	res.Attr = d.Attr()
+	return nil
+}
+
+func TestJukebox(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		busy    int32
+		retries int
+		want    error
+	}{
+		{"retried", 2, 3, nil},
+		{"out of retries", 5, 3, nfs.NFSStatJukebox},
+		{"retrying off", 1, 0, nfs.NFSStatJukebox},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &busyDir{busy: tt.busy}
+			c := nfstest.NewClient(t, func(nfs.ServeMux) interface{} { return d }, client.WithJukebox(tt.retries, time.Millisecond))
+			if _, err := c.Getattr(c.Root); err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+var (
+	errClosed  = fs.ErrClosed
+	errWhence  = errors.New("client: invalid whence")
+	errOffset  = errors.New("client: negative offset")
+	errNoWrite = errors.New("client: file not open for writing")
+)
+
+// File is an open file of the export. Writes are stable before they
+// return, there is nothing to flush on Close.
+type File struct {
+	c      *Client
+	name   string
+	fh     []byte
+	offset int64
+	flag   int
+	closed bool
+}
+
+// Open opens the file name for reading.
+func (c *Client) Open(name string) (*File, error) {
+	return c.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the file name like os.OpenFile. O_CREATE, O_EXCL, O_TRUNC
+// and O_APPEND are honoured, access is checked by the server on use.
+func (c *Client) OpenFile(name string, flag int, perm fs.FileMode) (*File, error) {
+	fh, attr, err := c.LookupPath(name)
+	switch {
+	case err == nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: nfs.NFSStatExist}
+	case err == nil && attr.Type == nfs.NF3Dir && flag&(os.O_WRONLY|os.O_RDWR) != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: nfs.NFSStatIsdir}
+	case err == nil && flag&os.O_TRUNC != 0 && attr.Filesize != 0:
+		_, err = c.Setattr(fh, nfs.Sattr3{Size: nfs.Sattr3Size{IsSet: true}}, nfs.Sattrguard3{})
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	case errors.Is(err, nfs.NFSStatNoent) && flag&os.O_CREATE != 0:
+		if fh, err = c.create(name, perm); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.Unwrap(err)}
+	}
+	return &File{c: c, name: name, fh: fh, flag: flag}, nil
+}
+
+// create creates the file name, failing if it exists.
+func (c *Client) create(name string, perm fs.FileMode) ([]byte, error) {
+	dir, base, err := c.lookupParent("open", name)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Create(dir, base, nfs.Createhow3{
+		Mode: 1, // GUARDED
+		GuardedAttr: nfs.Sattr3{
+			Mode: nfs.Sattr3Mode{IsSet: true, Mode: uint32(perm.Perm())},
+		},
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if res.Handle.IsSet {
+		return res.Handle.FH, nil
+	}
+	lres, err := c.Lookup(dir, base)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return lres.Object, nil
+}
+
+// Name returns the name the file was opened with.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Handle returns the file handle of the file.
+func (f *File) Handle() []byte {
+	return f.fh
+}
+
+// Stat returns the attributes of the file.
+func (f *File) Stat() (*nfs.Fattr3, error) {
+	if f.closed {
+		return nil, f.err("stat", errClosed)
+	}
+	res, err := f.c.Getattr(f.fh)
+	if err != nil {
+		return nil, f.err("stat", err)
+	}
+	return &res.Attr, nil
+}
+
+func (f *File) err(op string, err error) error {
+	return &fs.PathError{Op: op, Path: f.name, Err: err}
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads len(p) bytes at off in as many READ calls as needed.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, f.err("read", errClosed)
+	}
+	if off < 0 {
+		return 0, f.err("read", errOffset)
+	}
+	f.c.sizes()
+	n := 0
+	for n < len(p) {
+		count := len(p) - n
+		if count > int(f.c.rtmax) {
+			count = int(f.c.rtmax)
+		}
+		res, err := f.c.Read(f.fh, uint64(off)+uint64(n), uint32(count))
+		if err != nil {
+			return n, f.err("read", err)
+		}
+		n += copy(p[n:], res.Data)
+		if res.EOF || res.Count == 0 {
+			break
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	if f.flag&os.O_APPEND != 0 {
+		attr, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		f.offset = int64(attr.Filesize)
+	}
+	n, err := f.WriteAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// WriteAt writes p at off in as many FILE_SYNC WRITE calls as needed.
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, f.err("write", errClosed)
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, f.err("write", errNoWrite)
+	}
+	if off < 0 {
+		return 0, f.err("write", errOffset)
+	}
+	f.c.sizes()
+	n := 0
+	for n < len(p) {
+		count := len(p) - n
+		if count > int(f.c.wtmax) {
+			count = int(f.c.wtmax)
+		}
+		res, err := f.c.Write(f.fh, uint64(off)+uint64(n), p[n:n+count], nfs.FileSync)
+		if err != nil {
+			return n, f.err("write", err)
+		}
+		if res.Count == 0 {
+			return n, f.err("write", io.ErrShortWrite)
+		}
+		n += int(res.Count)
+	}
+	return n, nil
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, f.err("seek", errClosed)
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		attr, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		offset += int64(attr.Filesize)
+	default:
+		return 0, f.err("seek", errWhence)
+	}
+	if offset < 0 {
+		return 0, f.err("seek", errOffset)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Close marks the file closed, NFSv3 has no state to release.
+func (f *File) Close() error {
+	if f.closed {
+		return f.err("close", errClosed)
+	}
+	f.closed = true
+	return nil
+}
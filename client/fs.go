@@ -0,0 +1,241 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+// FS is a read only fs.FS view of the export mounted by a client. Errors
+// wrap NFS statuses, which match the fs sentinel errors they stand for.
+type FS struct {
+	c *Client
+}
+
+var (
+	_ fs.FS         = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+)
+
+// NewFS returns the export mounted by c as an fs.FS.
+func NewFS(c *Client) *FS {
+	return &FS{c: c}
+}
+
+// MountFS mounts path of the server at addr as an fs.FS. Close the
+// returned client when done.
+func MountFS(addr, path string, opts ...Option) (*FS, *Client, error) {
+	c, err := Mount(addr, path, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewFS(c), c, nil
+}
+
+// lookup returns the handle and attributes of name, failing op with an
+// fs.PathError.
+func (fsys *FS) lookup(op, name string) ([]byte, *nfs.Fattr3, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	fh, attr, err := fsys.c.LookupPath(name)
+	if err != nil {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: errors.Unwrap(err)}
+	}
+	return fh, attr, nil
+}
+
+func (fsys *FS) Open(name string) (fs.File, error) {
+	fh, attr, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := &fileInfo{name: path.Base(name), attr: *attr}
+	if attr.Type == nfs.NF3Dir {
+		return &dirFile{fsys: fsys, name: name, fh: fh, info: info}, nil
+	}
+	return &file{
+		File: &File{c: fsys.c, name: name, fh: fh},
+		info: info,
+	}, nil
+}
+
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	_, attr, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path.Base(name), attr: *attr}, nil
+}
+
+// ReadDir returns the entries of the directory name sorted by name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fh, attr, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if attr.Type != nfs.NF3Dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: nfs.NFSStatNotdir}
+	}
+	entries, err := fsys.readDir(fh)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+func (fsys *FS) readDir(fh []byte) ([]fs.DirEntry, error) {
+	entries, err := fsys.c.readDir(fh)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		attr := e.Attr
+		if attr == nil {
+			res, err := fsys.c.Lookup(fh, e.Name)
+			if err == nil {
+				attr, err = fsys.c.attr(res.Object, res.Attr)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		list = append(list, fs.FileInfoToDirEntry(&fileInfo{name: e.Name, attr: *attr}))
+	}
+	return list, nil
+}
+
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, ok := f.(*dirFile); ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: nfs.NFSStatIsdir}
+	}
+	return io.ReadAll(f)
+}
+
+// fileInfo describes a file by its NFS attributes, which Sys returns.
+type fileInfo struct {
+	name string
+	attr nfs.Fattr3
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return int64(fi.attr.Filesize) }
+func (fi *fileInfo) Mode() fs.FileMode  { return FileMode(&fi.attr) }
+func (fi *fileInfo) ModTime() time.Time { return Time(fi.attr.Mtime) }
+func (fi *fileInfo) IsDir() bool        { return fi.attr.Type == nfs.NF3Dir }
+func (fi *fileInfo) Sys() interface{}   { return &fi.attr }
+
+// FileMode returns the fs.FileMode of a file with attributes attr.
+func FileMode(attr *nfs.Fattr3) fs.FileMode {
+	mode := fs.FileMode(attr.FileMode & 0777)
+	if attr.FileMode&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if attr.FileMode&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if attr.FileMode&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	switch attr.Type {
+	case nfs.NF3Dir:
+		mode |= fs.ModeDir
+	case nfs.NF3Blk:
+		mode |= fs.ModeDevice
+	case nfs.NF3Chr:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case nfs.NF3Lnk:
+		mode |= fs.ModeSymlink
+	case nfs.NF3Sock:
+		mode |= fs.ModeSocket
+	case nfs.NF3FIFO:
+		mode |= fs.ModeNamedPipe
+	}
+	return mode
+}
+
+// Time converts an NFS timestamp to time.Time.
+func Time(t nfs.NFS3Time) time.Time {
+	return time.Unix(int64(t.Seconds), int64(t.Nseconds))
+}
+
+// file is a regular file opened through FS.
+type file struct {
+	*File
+	info *fileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	attr, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: f.info.name, attr: *attr}, nil
+}
+
+// dirFile is a directory opened through FS. Its entries are read on the
+// first call to ReadDir.
+type dirFile struct {
+	fsys    *FS
+	name    string
+	fh      []byte
+	info    *fileInfo
+	entries []fs.DirEntry
+	read    bool
+	closed  bool
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "stat", Path: d.name, Err: fs.ErrClosed}
+	}
+	return d.info, nil
+}
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: nfs.NFSStatIsdir}
+}
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+	if !d.read {
+		entries, err := d.fsys.readDir(d.fh)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: err}
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 || n >= len(d.entries) {
+		if n > 0 && len(d.entries) == 0 {
+			return nil, io.EOF
+		}
+		list := d.entries
+		d.entries = nil
+		return list, nil
+	}
+	list := d.entries[:n:n]
+	d.entries = d.entries[n:]
+	return list, nil
+}
+
+func (d *dirFile) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
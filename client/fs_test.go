@@ -0,0 +1,50 @@
+package client_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/dzeromsk/xdrrpc/client"
+	"github.com/dzeromsk/xdrrpc/cmd/simple-nfs-server/memfs"
+	"github.com/dzeromsk/xdrrpc/nfs"
+	"github.com/dzeromsk/xdrrpc/nfstest"
+)
+
+func tree(mux nfs.ServeMux) interface{} {
+	return memfs.NewFS(memfs.NewDir(mux, map[string]memfs.Node{
+		"hello": memfs.NewFile("world\n"),
+		"example": memfs.NewDir(mux, map[string]memfs.Node{
+			"alice": memfs.NewFile("bob\n"),
+			"empty": memfs.NewDir(mux, map[string]memfs.Node{}),
+		}),
+	}))
+}
+
+func TestFS(t *testing.T) {
+	fsys := client.NewFS(nfstest.NewClient(t, tree))
+	if err := fstest.TestFS(fsys, "hello", "example/alice", "example/empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat(fsys, "example")
+	if err != nil || !info.IsDir() || info.Mode()&fs.ModeDir == 0 {
+		t.Errorf("Stat of a directory: got %v, %v", info, err)
+	}
+	for _, tt := range []struct {
+		name string
+		want error
+	}{
+		{"missing", fs.ErrNotExist},
+		{"hello/below", nfs.NFSStatNotdir},
+		{"../hello", fs.ErrInvalid},
+		{"/hello", fs.ErrInvalid},
+	} {
+		_, err := fsys.Open(tt.name)
+		var perr *fs.PathError
+		if !errors.Is(err, tt.want) || !errors.As(err, &perr) {
+			t.Errorf("Open(%q): got %v, want a *fs.PathError matching %v", tt.name, err, tt.want)
+		}
+	}
+}
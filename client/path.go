@@ -0,0 +1,264 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+// DirEntry is an entry of a directory read with READDIRPLUS.
+type DirEntry struct {
+	Name   string
+	Fileid uint64
+	Handle []byte      // nil if the server left it out
+	Attr   *nfs.Fattr3 // nil if the server left it out
+}
+
+// split returns the components of name, which is relative to the mounted
+// directory whether it starts with a slash or not.
+func split(name string) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+// LookupPath returns the handle and attributes of name.
+func (c *Client) LookupPath(name string) ([]byte, *nfs.Fattr3, error) {
+	fh := c.Root
+	parts := split(name)
+	if len(parts) == 0 {
+		res, err := c.Getattr(fh)
+		if err != nil {
+			return nil, nil, &fs.PathError{Op: "lookup", Path: name, Err: err}
+		}
+		return fh, &res.Attr, nil
+	}
+	var attr *nfs.Fattr3
+	for _, part := range parts {
+		res, err := c.Lookup(fh, part)
+		if err != nil {
+			return nil, nil, &fs.PathError{Op: "lookup", Path: name, Err: err}
+		}
+		fh = res.Object
+		if attr, err = c.attr(fh, res.Attr); err != nil {
+			return nil, nil, &fs.PathError{Op: "lookup", Path: name, Err: err}
+		}
+	}
+	return fh, attr, nil
+}
+
+// attr returns the attributes of fh, asking the server unless post holds
+// them.
+func (c *Client) attr(fh []byte, post nfs.PostOpAttr) (*nfs.Fattr3, error) {
+	if post.IsSet {
+		return &post.Attr, nil
+	}
+	res, err := c.Getattr(fh)
+	if err != nil {
+		return nil, err
+	}
+	return &res.Attr, nil
+}
+
+// lookupParent returns the handle of the directory holding name, and the
+// last component of name.
+func (c *Client) lookupParent(op, name string) ([]byte, string, error) {
+	parts := split(name)
+	if len(parts) == 0 {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: nfs.NFSStatExist}
+	}
+	dir, _, err := c.LookupPath(path.Join(parts[:len(parts)-1]...))
+	if err != nil {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: errors.Unwrap(err)}
+	}
+	return dir, parts[len(parts)-1], nil
+}
+
+// Stat returns the attributes of name.
+func (c *Client) Stat(name string) (*nfs.Fattr3, error) {
+	_, attr, err := c.LookupPath(name)
+	return attr, err
+}
+
+// ReadDir returns the entries of the directory name sorted by name,
+// without "." and "..".
+func (c *Client) ReadDir(name string) ([]DirEntry, error) {
+	fh, _, err := c.LookupPath(name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := c.readDir(fh)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+func (c *Client) readDir(fh []byte) ([]DirEntry, error) {
+	c.sizes()
+	var (
+		entries []DirEntry
+		cookie  uint64
+		verf    uint64
+	)
+	for {
+		res, err := c.Readdirplus(fh, cookie, verf, c.dtpref, c.dtpref*4)
+		if err != nil {
+			return nil, err
+		}
+		n := 0
+		for e := res.Reply.Entry; e != nil; e = e.Next {
+			n++
+			cookie = e.Cookie
+			if e.FileName == "." || e.FileName == ".." {
+				continue
+			}
+			entry := DirEntry{Name: e.FileName, Fileid: e.FileID}
+			if e.Handle.IsSet {
+				entry.Handle = e.Handle.FH
+			}
+			if e.Attr.IsSet {
+				attr := e.Attr.Attr
+				entry.Attr = &attr
+			}
+			entries = append(entries, entry)
+		}
+		if res.Reply.EOF {
+			break
+		}
+		if n == 0 {
+			return nil, io.ErrNoProgress
+		}
+		verf = res.CookieVerf
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// ReadFile returns the contents of the file name.
+func (c *Client) ReadFile(name string) ([]byte, error) {
+	f, err := c.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// WriteFile writes data to the file name, creating it with perm if
+// missing and truncating it otherwise.
+func (c *Client) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := c.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// MkdirAll creates the directory name and any missing parents with perm.
+func (c *Client) MkdirAll(name string, perm fs.FileMode) error {
+	fh := c.Root
+	for _, part := range split(name) {
+		res, err := c.Lookup(fh, part)
+		if err == nfs.NFSStatNoent {
+			var mres *nfs.MKDIR3res
+			mres, err = c.Mkdir(fh, part, nfs.Sattr3{
+				Mode: nfs.Sattr3Mode{IsSet: true, Mode: uint32(perm.Perm())},
+			})
+			if err == nil && mres.Handle.IsSet {
+				fh = mres.Handle.FH
+				continue
+			}
+			if err == nil || err == nfs.NFSStatExist {
+				res, err = c.Lookup(fh, part)
+			}
+		}
+		if err != nil {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+		}
+		if res.Attr.IsSet && res.Attr.Attr.Type != nfs.NF3Dir {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: nfs.NFSStatNotdir}
+		}
+		fh = res.Object
+	}
+	return nil
+}
+
+// WalkFunc is called by Walk for every file, like filepath.WalkFunc.
+// Returning fs.SkipDir skips the directory of the call, or the remaining
+// entries of the directory holding the file.
+type WalkFunc func(name string, attr *nfs.Fattr3, err error) error
+
+// Walk walks the tree rooted at root in lexical order, calling fn for
+// every file and directory in it, root included.
+func (c *Client) Walk(root string, fn WalkFunc) error {
+	fh, attr, err := c.LookupPath(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = c.walk(root, fh, attr, fn)
+	}
+	if err == fs.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) walk(name string, fh []byte, attr *nfs.Fattr3, fn WalkFunc) error {
+	if attr.Type != nfs.NF3Dir {
+		return fn(name, attr, nil)
+	}
+
+	entries, err := c.readDir(fh)
+	if err != nil {
+		err = &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if err := fn(name, attr, err); err != nil || entries == nil {
+		return err
+	}
+
+	dir := fh
+	for _, e := range entries {
+		child := path.Join(name, e.Name)
+		fh, attr := e.Handle, e.Attr
+		if fh == nil || attr == nil {
+			res, err := c.Lookup(dir, e.Name)
+			if err == nil {
+				fh = res.Object
+				attr, err = c.attr(fh, res.Attr)
+			}
+			if err != nil {
+				err = &fs.PathError{Op: "lookup", Path: child, Err: err}
+				if err := fn(child, nil, err); err != nil && err != fs.SkipDir {
+					return err
+				}
+				continue
+			}
+		}
+		err := c.walk(child, fh, attr, fn)
+		if err == fs.SkipDir {
+			if attr.Type != nfs.NF3Dir {
+				return nil
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
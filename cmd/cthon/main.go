@@ -0,0 +1,72 @@
+// Command cthon runs the Connectathon style tests of package cthon against
+// an NFSv3 server, for example simple-nfs-server:
+//
+//	cthon -addr localhost:12049 -path /
+//
+// It exits with status 1 if any test failed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/dzeromsk/xdrrpc/client"
+	"github.com/dzeromsk/xdrrpc/cthon"
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+var (
+	addr  = flag.String("addr", "localhost:12049", "Server address, serving MOUNT and NFS")
+	path  = flag.String("path", "/", "Exported directory to mount")
+	tests = flag.String("tests", "", "Comma separated categories to run: basic, general, special; all if empty")
+	uid   = flag.Int("uid", -1, "Call as this user, the user running the tests if negative")
+	gid   = flag.Int("gid", 0, "Call as this group, with -uid")
+
+	files  = flag.Int("files", cthon.DefaultOptions.Files, "Files per directory of test trees")
+	dirs   = flag.Int("dirs", cthon.DefaultOptions.Dirs, "Directories per directory of test trees")
+	levels = flag.Int("levels", cthon.DefaultOptions.Levels, "Depth of test trees")
+	size   = flag.Int("size", cthon.DefaultOptions.Size, "Bytes of files of read and write tests")
+	count  = flag.Int("count", cthon.DefaultOptions.Count, "Iterations of loops")
+)
+
+func main() {
+	flag.Parse()
+
+	var opts []client.Option
+	if *uid >= 0 {
+		opts = append(opts, client.WithCred(nfs.Cred{UID: uint32(*uid), GID: uint32(*gid)}))
+	}
+	c, err := client.Mount(*addr, *path, opts...)
+	if err != nil {
+		log.Fatalln("mount error:", err)
+	}
+	defer c.Close()
+
+	var categories []string
+	if *tests != "" {
+		categories = strings.Split(*tests, ",")
+	}
+	results := cthon.Run(c, &cthon.Options{
+		Files:  *files,
+		Dirs:   *dirs,
+		Levels: *levels,
+		Size:   *size,
+		Count:  *count,
+		Log:    os.Stdout,
+	}, categories...)
+
+	failed := 0
+	for _, r := range results {
+		if r.Failed() {
+			failed++
+		}
+	}
+	fmt.Printf("%d tests, %d failed\n", len(results), failed)
+	if failed > 0 {
+		c.Close()
+		os.Exit(1)
+	}
+}
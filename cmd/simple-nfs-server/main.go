@@ -2,13 +2,25 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/rpc"
+	"os"
+	"os/signal"
 	"runtime"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/mount"
 	"github.com/dzeromsk/xdrrpc/nfs"
+	"github.com/dzeromsk/xdrrpc/nlm"
+	"github.com/dzeromsk/xdrrpc/nsm"
+	"github.com/dzeromsk/xdrrpc/rquota"
+	"github.com/dzeromsk/xdrrpc/trace"
 
 	"github.com/dzeromsk/xdrrpc/cmd/simple-nfs-server/memfs"
 )
@@ -16,6 +28,16 @@ import (
 var (
 	listen = flag.String("listen", ":12049", "Server listen address")
 	debug  = flag.Bool("debug", false, "Enable debug prints")
+	secret = flag.String("secret", "", "Sign file handles with secret stored in this file, created if missing")
+	nfs2   = flag.Bool("nfs2", true, "Serve NFSv2, whose file handles are too short to be signed, so not with -secret")
+	grace  = flag.Duration("grace", 90*time.Second, "Only accept lock reclaims for this long after start")
+	state  = flag.String("state", "", "Keep status monitor state and hosts to notify after restart in this file")
+	record = flag.String("trace", "", "Record calls and replies of every connection to this file")
+	replay = flag.String("replay", "", "Replay calls recorded in this file against a fresh server, print replies that differ and exit")
+
+	capacity   = flag.Uint64("capacity", memfs.DefaultCapacity, "Limit bytes all files hold together, in memory")
+	quotaBytes = flag.Uint64("quota-bytes", 0, "Limit bytes used by each user, unlimited if zero")
+	quotaFiles = flag.Uint64("quota-files", 0, "Limit files created by each user, unlimited if zero")
 )
 
 func main() {
@@ -25,11 +47,25 @@ func main() {
 
 	root := []byte{0xde, 0xad, 0xbe, 0xef, 0xde, 0xad, 0xbe, 0xef}
 
-	mount := memfs.NewMount(root)
-	rpc.Register(mount)
+	var opts []nfs.Option
+	if *secret != "" {
+		if *nfs2 {
+			if flagSet("nfs2") {
+				log.Fatalln("signed file handles do not fit NFSv2, run with -nfs2=false")
+			}
+			log.Println("signed file handles do not fit NFSv2, not serving it")
+			*nfs2 = false
+		}
+		key, err := nfs.LoadSecret(*secret)
+		if err != nil {
+			log.Fatalln("secret error:", err)
+		}
+		opts = append(opts, nfs.WithHandleCodec(nfs.NewSignedCodec(nfs.DefaultCodec, key)))
+	}
+
+	var mux = nfs.NewServeMux(opts...)
 
-	var mux = nfs.NewServeMux()
-	mux.Handle(root, memfs.NewFS(
+	fs := memfs.NewFS(
 		memfs.NewDir(mux, map[string]memfs.Node{
 			"hello": memfs.NewFile("world\n"),
 			"foo":   memfs.NewFile("bar\n"),
@@ -37,19 +73,101 @@ func main() {
 				"alice": memfs.NewFile("bob\n"),
 			}),
 		}),
-	))
-	rpc.Register(mux.Receiver())
+	)
+	fs.SetCapacity(*capacity)
+	fs.SetDefaultLimits(rquota.UsrQuota, rquota.Limits{
+		BytesHard: *quotaBytes,
+		FilesHard: *quotaFiles,
+	})
+
+	mnt := mount.NewMount(mux)
+	mnt.Handle(mount.Export{
+		Path:    "/",
+		Root:    root,
+		Handler: fs,
+	})
+
+	sm, err := nsm.NewNSM(*state)
+	if err != nil {
+		log.Fatalln("state error:", err)
+	}
+	lm := nlm.NewNLM(mux, *grace)
+	lm.Monitor = func(host string) error {
+		return sm.Monitor(nsm.Mon{
+			MonID: nsm.MonID{
+				MonName: host,
+				MyID: nsm.MyID{
+					MyName: "localhost",
+					MyProg: nlm.NlmProg,
+					MyVers: nlm.NlmVers,
+					MyProc: 16, // SM_NOTIFY
+				},
+			},
+		})
+	}
+	// lock manager runs in process, skip the round trip
+	sm.Callback = func(id nsm.MyID, status nsm.Status) error {
+		lm.FreeHost(status.MonName)
+		return nil
+	}
+
+	rcvrs := []interface{}{
+		mnt,
+		rquota.NewRquota(mnt),
+		mux.Receiver(),
+		mux.ACLReceiver(),
+		mux.NFS4Receiver(),
+		lm,
+		sm,
+	}
+	if *nfs2 {
+		rcvrs = append(rcvrs, mux.NFS2Receiver())
+	}
+	for _, rcvr := range rcvrs {
+		rpc.Register(rcvr)
+	}
+
+	if *replay != "" {
+		os.Exit(replayTrace(*replay, rcvrs))
+	}
+
+	var rec *trace.Recorder
+	if *record != "" {
+		f, err := os.Create(*record)
+		if err != nil {
+			log.Fatalln("trace error:", err)
+		}
+		rec = trace.NewRecorder(f)
+		// the server only stops on signals, close the trace on the way out
+		go func() {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			<-sig
+			logTraceErr(rec)
+			if err := f.Close(); err != nil {
+				log.Fatalln("trace error:", err)
+			}
+			os.Exit(0)
+		}()
+	}
 
 	ln, err := net.Listen("tcp", *listen)
 	if err != nil {
 		log.Fatalln("listen error:", err)
 	}
 
+	if hostname, err := os.Hostname(); err == nil {
+		go sm.NotifyAll(hostname)
+	}
+
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
 			log.Fatalln("accept error:", err)
 		}
+		if rec != nil {
+			conn = rec.Conn(conn)
+		}
 		go func(conn net.Conn) {
 			defer func() {
 				if err := recover(); err != nil {
@@ -59,9 +177,62 @@ func main() {
 					log.Printf("example: panic serving %s: %v\n%s", conn.RemoteAddr(), err, buf)
 				}
 				conn.Close()
+				if rec != nil {
+					logTraceErr(rec)
+				}
 			}()
 
 			xdrrpc.ServeConn(conn)
 		}(conn)
 	}
 }
+
+var traceErrOnce sync.Once
+
+// logTraceErr logs the error that stopped rec from recording, if any, the
+// first time it is seen.
+func logTraceErr(rec *trace.Recorder) {
+	if err := rec.Err(); err != nil {
+		traceErrOnce.Do(func() {
+			log.Println("trace error, recording stopped:", err)
+		})
+	}
+}
+
+// replayTrace replays calls recorded in name against the receivers
+// registered, over pipes, prints replies that differ and returns the exit
+// status.
+func replayTrace(name string, rcvrs []interface{}) int {
+	f, err := os.Open(name)
+	if err != nil {
+		log.Fatalln("replay error:", err)
+	}
+	defer f.Close()
+
+	diffs, err := trace.Replay(trace.NewReader(f), func() (io.ReadWriteCloser, error) {
+		c, conn := net.Pipe()
+		go xdrrpc.ServeConn(conn)
+		return c, nil
+	}, rcvrs...)
+	for _, d := range diffs {
+		fmt.Println(d.String())
+	}
+	if err != nil {
+		log.Fatalln("replay error:", err)
+	}
+	fmt.Printf("%d replies differ\n", len(diffs))
+	if len(diffs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// flagSet tells whether flag name was given on the command line.
+func flagSet(name string) (set bool) {
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,39 @@
+package memfs
+
+import "github.com/dzeromsk/xdrrpc/nfs"
+
+// perm holds the permission attributes of files and directories. The
+// dispatcher checks them against callers, see nfs.ServeMux.
+type perm struct {
+	mode uint32
+	uid  uint32
+	gid  uint32
+	acl  nfs.ACL // access ACL, empty if the mode says it all
+	def  nfs.ACL // default ACL of directories
+}
+
+// set applies mode and ownership changes in s.
+func (p *perm) set(s *nfs.Sattr3) {
+	if s.Mode.IsSet {
+		p.mode = s.Mode.Mode & 07777
+		if len(p.acl) > 0 {
+			p.acl = p.acl.WithMode(p.mode)
+		}
+	}
+	if s.UID.IsSet {
+		p.uid = s.UID.UID
+	}
+	if s.GID.IsSet {
+		p.gid = s.GID.GID
+	}
+}
+
+// setACL replaces the ACLs and updates the permission bits of the mode to
+// match access.
+func (p *perm) setACL(access, def nfs.ACL) {
+	p.acl = access
+	p.def = def
+	if len(access) > 0 {
+		p.mode = p.mode&^0777 | access.Mode()
+	}
+}
@@ -0,0 +1,127 @@
+package memfs
+
+// blockSize is the size of the pieces file content is kept in. Blocks never
+// written to are holes, which read as zeros and take no memory.
+const blockSize = 64 << 10
+
+// data is the content of a file. Blocks hold bytes from their start up to
+// the last one written.
+type data struct {
+	size   uint64
+	used   int64             // bytes held by blocks
+	blocks map[uint64][]byte // by offset / blockSize
+}
+
+func newData(b []byte) data {
+	var d data
+	d.writeAt(b, 0)
+	return d
+}
+
+// grows returns how many bytes blocks would grow by to hold n bytes
+// written at off.
+func (d *data) grows(off, n uint64) int64 {
+	var grown int64
+	for end := off + n; off < end; {
+		i := off / blockSize
+		start := i * blockSize
+		to := end - start
+		if to > blockSize {
+			to = blockSize
+		}
+		if l := uint64(len(d.blocks[i])); to > l {
+			grown += int64(to - l)
+		}
+		off = start + blockSize
+	}
+	return grown
+}
+
+// writeAt writes b at off, growing the file if it ends past its size.
+func (d *data) writeAt(b []byte, off uint64) {
+	if d.blocks == nil {
+		d.blocks = map[uint64][]byte{}
+	}
+	if end := off + uint64(len(b)); end > d.size {
+		d.size = end
+	}
+	for len(b) > 0 {
+		i := off / blockSize
+		at := off - i*blockSize
+		n := uint64(len(b))
+		if n > blockSize-at {
+			n = blockSize - at
+		}
+		blk := d.blocks[i]
+		if to := at + n; to > uint64(len(blk)) {
+			// appending zeros also clears what a truncate left
+			d.used += int64(to) - int64(len(blk))
+			blk = append(blk, make([]byte, to-uint64(len(blk)))...)
+		}
+		copy(blk[at:], b[:n])
+		d.blocks[i] = blk
+		b = b[n:]
+		off += n
+	}
+}
+
+// readAt returns up to n bytes at off.
+func (d *data) readAt(off, n uint64) []byte {
+	if off >= d.size {
+		return nil
+	}
+	if n > d.size-off {
+		n = d.size - off
+	}
+	b := make([]byte, n)
+	for done := uint64(0); done < n; {
+		i := (off + done) / blockSize
+		at := off + done - i*blockSize
+		m := blockSize - at
+		if m > n-done {
+			m = n - done
+		}
+		if blk := d.blocks[i]; at < uint64(len(blk)) {
+			copy(b[done:done+m], blk[at:])
+		}
+		done += m
+	}
+	return b
+}
+
+// usedBelow returns how many bytes blocks hold before size, which is what
+// they would hold after truncating to size.
+func (d *data) usedBelow(size uint64) int64 {
+	if size >= d.size {
+		return d.used
+	}
+	var used int64
+	for i, blk := range d.blocks {
+		start := i * blockSize
+		if start >= size {
+			continue
+		}
+		end := start + uint64(len(blk))
+		if end > size {
+			end = size
+		}
+		used += int64(end - start)
+	}
+	return used
+}
+
+// truncate makes the file size bytes long, dropping data past it.
+func (d *data) truncate(size uint64) {
+	for i, blk := range d.blocks {
+		start := i * blockSize
+		switch end := start + uint64(len(blk)); {
+		case start >= size:
+			d.used -= int64(len(blk))
+			delete(d.blocks, i)
+		case end > size:
+			d.used -= int64(end - size)
+			d.blocks[i] = blk[:size-start]
+		}
+	}
+	d.size = size
+}
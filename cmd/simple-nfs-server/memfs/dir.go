@@ -3,6 +3,10 @@ package memfs
 import (
 	"encoding/binary"
 	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/dzeromsk/xdrrpc/nfs"
@@ -13,102 +17,221 @@ type Node interface {
 	Attr() nfs.Fattr3
 }
 
+// lastID is the file id given to the last file or directory made. Ids
+// are never reused, so handles of removed objects stay stale.
+var lastID uint64
+
+func newID() uint64 {
+	return atomic.AddUint64(&lastID, 1)
+}
+
+// encodeID returns the object id of file id.
+func encodeID(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, id)
+	return b
+}
+
 type dir struct {
+	id uint64
+	mu sync.Mutex
+	perm
+	quota *quotas
 	nodes map[string]Node
 	mux   nfs.ServeMux
+	mtime time.Time
+	ctime time.Time
+
+	// gen counts changes of nodes, readdir cookies are only valid as
+	// long as it stays the same
+	gen uint64
 }
 
 func NewDir(mux nfs.ServeMux, nodes map[string]Node) *dir {
+	now := time.Now()
 	d := &dir{
+		id:    newID(),
+		perm:  perm{mode: 0755},
 		mux:   mux,
 		nodes: nodes,
+		mtime: now,
+		ctime: now,
 	}
-	// d.nodes[".."] = ?
 	d.nodes["."] = d
+	for name, node := range nodes {
+		child, ok := node.(*dir)
+		if !ok || name == "." || name == ".." {
+			continue
+		}
+		if _, ok := child.nodes[".."]; !ok {
+			child.nodes[".."] = d
+		}
+	}
 	return d
 }
 
+// register makes node reachable by its id, unless it is already. The root
+// directory is registered as the fs embedding it, which must stay so when
+// it is reached again through "..".
+func (d *dir) register(node Node) []byte {
+	id := node.ID()
+	if _, ok := d.mux.Load(id); !ok {
+		d.mux.Handle(id, node)
+	}
+	return id
+}
+
+// parent returns the directory ".." names, nil for the root.
+func (d *dir) parent() *dir {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, _ := d.nodes[".."].(*dir)
+	return p
+}
+
 func (d *dir) ID() []byte {
-	b := make([]byte, 8)
-	binary.LittleEndian.PutUint64(b, uint64(uintptr(unsafe.Pointer(d))))
-	return b
+	return encodeID(d.id)
 }
 
 func (d *dir) Attr() nfs.Fattr3 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.attr()
+}
+
+// attr must be called with d.mu held.
+func (d *dir) attr() nfs.Fattr3 {
 	const size = uint64(unsafe.Sizeof(*d))
 	return nfs.Fattr3{
 		Type:     nfs.NF3Dir,
-		FileMode: 0755 | uint32(os.ModeDir),
+		FileMode: d.mode | uint32(os.ModeDir),
 		Nlink:    1,
-		UID:      0,
-		GID:      0,
+		UID:      d.uid,
+		GID:      d.gid,
 		Filesize: size,
 		Used:     size,
 		FSID:     83,
-		Fileid:   uint64(uintptr(unsafe.Pointer(d))),
-		Atime:    starttime,
-		Mtime:    starttime,
-		Ctime:    starttime,
+		Fileid:   d.id,
+		Atime:    nfs.NewNFS3Time(d.mtime),
+		Mtime:    nfs.NewNFS3Time(d.mtime),
+		Ctime:    nfs.NewNFS3Time(d.ctime),
 	}
 }
 
-func (d *dir) Access(res *nfs.ACCESS3res) error {
-	res.Status = nfs.NFSStatOk
-	res.Access = 0x3f
-	res.Attr.IsSet = true
-	res.Attr.Attr = d.Attr()
-
-	return nil
-}
-
 func (d *dir) Getattr(res *nfs.GETATTR3res) error {
 	res.Status = nfs.NFSStatOk
 	res.Attr = d.Attr()
 	return nil
 }
 
+// charged must be called with d.mu held.
+func (d *dir) charged() charge {
+	return charge{uid: d.uid, gid: d.gid, files: 1}
+}
+
+// release stops charging d to its owner, once removed.
+func (d *dir) release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.quota.update(d.charged(), charge{})
+}
+
+// release stops charging n to its owner, once removed.
+func release(n Node) {
+	switch n := n.(type) {
+	case *file:
+		n.release()
+	case *dir:
+		n.release()
+	}
+}
+
+// touch must be called with d.mu held.
+func (d *dir) touch() {
+	d.mtime = time.Now()
+	d.ctime = d.mtime
+	d.gen++
+}
+
+// Sizes of READDIRPLUS reply parts, to fit entries in the counts clients
+// ask for.
+const (
+	dirlistSize = 4 + 88 + 8 + 4 + 4 // status, attributes, verifier, eof, end of list
+	entrySize   = 8 + 4 + 8          // fileid, name length and cookie
+	entryExtra  = 88 + 4 + 64 + 4    // attributes, handle and next entry
+)
+
+// Readdirplus lists entries sorted by name. Cookies are positions in that
+// list, the cookie verifier tells clients when it changed under them. A
+// zero verifier, which NFSv2 clients have no other choice than sending,
+// is accepted with any cookie, as RFC 1813 allows.
 func (d *dir) Readdirplus(args *nfs.READDIRPLUS3args, res *nfs.READDIRPLUS3res) error {
-	var prev *nfs.Entryplus3
-
-	var limit = args.MaxCount / uint32(unsafe.Sizeof(*prev))
-	if limit > args.DirCount {
-		limit = args.DirCount
-	}
-
-	// TODO(dzeromsk): add support for cookies, create slice
-	// of names, sort it, iterate over it and use index as
-	// a cookie
-	var n uint64 = 1
-	for filename, node := range d.nodes {
-		res.Reply.Entry = &nfs.Entryplus3{
-			Cookie:   n,
-			FileID:   n,
-			FileName: filename,
-			Next:     prev,
+	// snapshot entries so we do not hold our lock while asking
+	// children (including "." and "..") for their attributes
+	d.mu.Lock()
+	names := make([]string, 0, len(d.nodes))
+	nodes := make(map[string]Node, len(d.nodes))
+	for name, node := range d.nodes {
+		names = append(names, name)
+		nodes[name] = node
+	}
+	attr := d.attr()
+	verf := d.gen + 1
+	d.mu.Unlock()
+
+	res.Attr = nfs.NewPostOpAttr(attr)
+	if args.Cookie > uint64(len(names)) || args.Cookie != 0 && args.CookieVerf != 0 && args.CookieVerf != verf {
+		res.Status = nfs.NFSStatBadcookie
+		return nil
+	}
+	sort.Strings(names)
+
+	var (
+		tail     *nfs.Entryplus3
+		dirCount uint32
+		size     uint32 = dirlistSize
+	)
+	i := int(args.Cookie)
+	for ; i < len(names); i++ {
+		name := names[i]
+		n := uint32(entrySize + (len(name)+3)&^3)
+		if dirCount+n > args.DirCount || size+n+entryExtra > args.MaxCount {
+			break
+		}
+		dirCount += n
+		size += n + entryExtra
+
+		node := nodes[name]
+
+		// handles we return must resolve without a prior lookup
+		id := d.register(node)
+
+		attr := node.Attr()
+		e := &nfs.Entryplus3{
+			FileID:   attr.Fileid,
+			FileName: name,
+			Cookie:   uint64(i + 1),
 			Handle: nfs.PostOpFH3{
 				IsSet: true,
-				FH:    node.ID(),
-			},
-			Attr: nfs.PostOpAttr{
-				IsSet: true,
-				Attr:  node.Attr(),
+				FH:    id,
 			},
+			Attr: nfs.NewPostOpAttr(attr),
 		}
-		prev = res.Reply.Entry
-
-		if n > uint64(limit) {
-			break
+		if tail == nil {
+			res.Reply.Entry = e
+		} else {
+			tail.Next = e
 		}
-		n++
+		tail = e
 	}
-	res.Reply.EOF = true
+	if tail == nil && i < len(names) {
+		res.Status = nfs.NFSStatToosmall
+		return nil
+	}
+	res.Reply.EOF = i == len(names)
 
 	res.Status = nfs.NFSStatOk
-	// removes second readdir
-	res.CookieVerf = uint64(n)
-	res.Attr.IsSet = true
-	res.Attr.Attr = d.Attr()
-
+	res.CookieVerf = verf
 	return nil
 }
 
@@ -116,54 +239,99 @@ func (d *dir) Mkdir(name string, attr *nfs.Sattr3, res *nfs.MKDIR3res) error {
 	new := NewDir(d.mux, map[string]Node{
 		"..": d,
 	})
+	new.perm.set(attr)
+	new.quota = d.quota
 
 	id := new.ID()
 
 	d.mux.Handle(id, new)
+
+	d.mu.Lock()
+	before := d.attr()
+	if _, ok := d.nodes[name]; ok {
+		res.DirWcc = nfs.NewWccData(before, before)
+		d.mu.Unlock()
+		d.mux.Delete(id)
+		res.Status = nfs.NFSStatExist
+		return nil
+	}
+	if err := d.quota.update(charge{}, new.charged()); err != nil {
+		res.DirWcc = nfs.NewWccData(before, before)
+		d.mu.Unlock()
+		d.mux.Delete(id)
+		return err
+	}
 	d.nodes[name] = new
+	d.touch()
+	res.DirWcc = nfs.NewWccData(before, d.attr())
+	d.mu.Unlock()
 
-	// xxx.nodes[name] = h
+	res.Status = nfs.NFSStatOk
 	res.Handle.IsSet = true
 	res.Handle.FH = id
-	res.Attr.IsSet = true
-	res.Attr.Attr = new.Attr()
-	//res.DirWcc
+	res.Attr = nfs.NewPostOpAttr(new.Attr())
 	return nil
 }
 
-func (d *dir) Create(name string, res *nfs.CREATE3res) error {
-	new := NewFile("")
+// Create returns the existing file if name is taken, as UNCHECKED
+// creates do; the dispatcher rejects the other modes.
+func (d *dir) Create(name string, attr *nfs.Sattr3, res *nfs.CREATE3res) error {
+	d.mu.Lock()
+	before := d.attr()
+	node, ok := d.nodes[name]
+	if ok {
+		res.DirWcc = nfs.NewWccData(before, before)
+	} else {
+		f := NewFile("")
+		f.perm.set(attr)
+		if attr.Mtime.TimeHow == 2 { // SET_TO_CLIENT_TIME
+			t := attr.Mtime.Time
+			f.mtime = time.Unix(int64(t.Seconds), int64(t.Nseconds))
+		}
+		f.quota = d.quota
+		if err := d.quota.update(charge{}, f.charged()); err != nil {
+			res.DirWcc = nfs.NewWccData(before, before)
+			d.mu.Unlock()
+			return err
+		}
+		node = f
+		d.nodes[name] = node
+		d.touch()
+		res.DirWcc = nfs.NewWccData(before, d.attr())
+	}
+	d.mu.Unlock()
 
-	id := new.ID()
+	if _, ok := node.(*file); !ok {
+		res.Status = nfs.NFSStatExist
+		return nil
+	}
 
-	d.mux.Handle(id, new)
-	d.nodes[name] = new
+	id := d.register(node)
 
+	res.Status = nfs.NFSStatOk
 	res.Handle.IsSet = true
 	res.Handle.FH = id
-	res.Attr.IsSet = true
-	res.Attr.Attr = new.Attr()
+	res.Attr = nfs.NewPostOpAttr(node.Attr())
 
 	return nil
 }
 
 func (d *dir) Lookup(name string, res *nfs.LOOKUP3res) error {
+	d.mu.Lock()
 	node, ok := d.nodes[name]
+	res.DirAttr = nfs.NewPostOpAttr(d.attr())
+	d.mu.Unlock()
+
 	if !ok {
 		res.Status = nfs.NFSStatNoent
 		return nil
 	}
 
-	id := node.ID()
-
-	d.mux.Handle(id, node)
+	id := d.register(node)
 
 	res.Status = nfs.NFSStatOk
 	res.Object = id
-	res.DirAttr.IsSet = true
-	res.DirAttr.Attr = d.Attr()
-	res.Attr.IsSet = true
-	res.Attr.Attr = node.Attr()
+	res.Attr = nfs.NewPostOpAttr(node.Attr())
 	return nil
 }
 
@@ -180,70 +348,239 @@ func (d *dir) Link(object []byte, name string, res *nfs.LINK3res) error {
 		return nil
 	}
 
+	d.mu.Lock()
+	before := d.attr()
+	if _, ok := d.nodes[name]; ok {
+		res.DirWcc = nfs.NewWccData(before, before)
+		d.mu.Unlock()
+		res.Status = nfs.NFSStatExist
+		res.Attr = nfs.NewPostOpAttr(node.Attr())
+		return nil
+	}
+	f, ok := node.(*file)
+	if !ok {
+		res.DirWcc = nfs.NewWccData(before, before)
+		d.mu.Unlock()
+		res.Status = nfs.NFSStatIsdir
+		return nil
+	}
+	f.link()
 	d.nodes[name] = node
+	d.touch()
+	res.DirWcc = nfs.NewWccData(before, d.attr())
+	d.mu.Unlock()
 
 	res.Status = nfs.NFSStatOk
-	res.Attr.IsSet = true
-	res.Attr.Attr = node.Attr()
+	res.Attr = nfs.NewPostOpAttr(node.Attr())
 
 	return nil
 }
 
 func (d *dir) Remove(name string, res *nfs.REMOVE3res) error {
+	// release after unlocking d, children are never locked while
+	// holding their parent
+	var removed Node
+	defer func() {
+		if removed != nil {
+			release(removed)
+		}
+	}()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	before := d.attr()
+	defer func() {
+		res.DirWcc = nfs.NewWccData(before, d.attr())
+	}()
+
 	node, ok := d.nodes[name]
 	if !ok {
 		res.Status = nfs.NFSStatNoent
 		return nil
 	}
-	if _, ok := node.(*file); !ok {
+	f, ok := node.(*file)
+	if !ok {
 		res.Status = nfs.NFSStatIsdir
 		return nil
 	}
 
-	id := node.ID()
-
-	d.mux.Delete(id)
 	delete(d.nodes, name)
+	d.touch()
+	// other links keep the file and its handle alive
+	if f.unlink() {
+		d.mux.Delete(f.ID())
+		removed = node
+	}
 
+	res.Status = nfs.NFSStatOk
 	return nil
 }
 
+// lockChild locks d and, if name is a directory other than d, that
+// directory too, in the order lockDirs uses. It returns the entry called
+// name, nil if there is none.
+func (d *dir) lockChild(name string) (Node, func()) {
+	for {
+		d.mu.Lock()
+		node := d.nodes[name]
+		child, ok := node.(*dir)
+		if !ok || child == d {
+			return node, d.mu.Unlock
+		}
+		d.mu.Unlock()
+
+		unlock := lockDirs(d, child)
+		if d.nodes[name] == node {
+			return node, unlock
+		}
+		unlock()
+	}
+}
+
 func (d *dir) Rmdir(name string, res *nfs.RMDIR3res) error {
-	node, ok := d.nodes[name]
-	if !ok {
+	var removed Node
+	defer func() {
+		if removed != nil {
+			release(removed)
+		}
+	}()
+
+	node, unlock := d.lockChild(name)
+	defer unlock()
+
+	before := d.attr()
+	defer func() {
+		res.DirWcc = nfs.NewWccData(before, d.attr())
+	}()
+
+	if node == nil {
 		res.Status = nfs.NFSStatNoent
 		return nil
 	}
-	if _, ok := node.(*dir); !ok {
+	child, ok := node.(*dir)
+	if !ok {
 		res.Status = nfs.NFSStatNotdir
 		return nil
 	}
+	for name := range child.nodes {
+		if name != "." && name != ".." {
+			res.Status = nfs.NFSStatNotempty
+			return nil
+		}
+	}
 
 	id := node.ID()
 
 	d.mux.Delete(id)
 	delete(d.nodes, name)
+	d.touch()
+	removed = node
 
+	res.Status = nfs.NFSStatOk
 	return nil
 }
 
 func (d *dir) Setattr(args *nfs.SETATTR3args, res *nfs.SETATTR3res) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	before := d.attr()
+	if args.Guard.IsSet && before.Ctime != args.Guard.Ctime {
+		res.ObjWcc = nfs.NewWccData(before, before)
+		return nfs.NFSStatNotsync
+	}
+	to := d.charged()
+	if args.Sattr.UID.IsSet {
+		to.uid = args.Sattr.UID.UID
+	}
+	if args.Sattr.GID.IsSet {
+		to.gid = args.Sattr.GID.GID
+	}
+	if err := d.quota.update(d.charged(), to); err != nil {
+		res.ObjWcc = nfs.NewWccData(before, before)
+		return err
+	}
+	d.perm.set(&args.Sattr)
+	switch args.Sattr.Mtime.TimeHow {
+	case 1: // SET_TO_SERVER_TIME
+		d.mtime = time.Now()
+	case 2: // SET_TO_CLIENT_TIME
+		t := args.Sattr.Mtime.Time
+		d.mtime = time.Unix(int64(t.Seconds), int64(t.Nseconds))
+	}
+	d.ctime = time.Now()
+	res.ObjWcc = nfs.NewWccData(before, d.attr())
+
 	res.Status = nfs.NFSStatOk
 	return nil
 }
 
-func (d *dir) Rename(args *nfs.RENAME3args, res *nfs.RENAME3res) error {
-	from, ok := d.nodes[args.From.Name]
-	if !ok {
-		res.Status = nfs.NFSStatNoent
-		return nil
+func (d *dir) ACL() (access, def nfs.ACL) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.acl, d.def
+}
+
+func (d *dir) SetACL(access, def nfs.ACL) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setACL(access, def)
+	d.ctime = time.Now()
+	return nil
+}
+
+// lockDirs locks dirs, skipping nils and repeats, in id order so
+// that concurrent renames in opposite directions cannot deadlock.
+func lockDirs(dirs ...*dir) (unlock func()) {
+	var locked []*dir
+	for _, d := range dirs {
+		if d == nil {
+			continue
+		}
+		seen := false
+		for _, l := range locked {
+			seen = seen || l == d
+		}
+		if !seen {
+			locked = append(locked, d)
+		}
+	}
+	sort.Slice(locked, func(i, j int) bool {
+		return locked[i].id < locked[j].id
+	})
+	for _, d := range locked {
+		d.mu.Lock()
 	}
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].mu.Unlock()
+		}
+	}
+}
+
+// renameMu serializes renames across directories. They are the only
+// changes of "..", which then stays put while checking that a directory is
+// not moved below itself.
+var renameMu sync.Mutex
+
+// below tells whether d is a or below it. Callers must hold renameMu.
+func (d *dir) below(a *dir) bool {
+	for p := d; p != nil; p = p.parent() {
+		if p == a {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dir) Rename(args *nfs.RENAME3args, res *nfs.RENAME3res) error {
 	node, ok := d.mux.Load(args.To.Dir)
 	if !ok {
 		res.Status = nfs.NFSStatInval
 		return nil
 	}
-	dir, ok := node.(*dir)
+	to, ok := node.(*dir)
 	if !ok {
 		// support for top level renames
 		fs, ok2 := node.(*fs)
@@ -251,30 +588,108 @@ func (d *dir) Rename(args *nfs.RENAME3args, res *nfs.RENAME3res) error {
 			res.Status = nfs.NFSStatNotdir
 			return nil
 		}
-		dir = fs.dir
+		to = fs.dir
+	}
+
+	var replaced Node
+	defer func() {
+		if replaced != nil {
+			release(replaced)
+		}
+	}()
+
+	if d != to {
+		renameMu.Lock()
+		defer renameMu.Unlock()
+	}
+
+	// lock both directories and the directories renamed and replaced,
+	// whose ".." and entries we look at, as they are once locked
+	var (
+		from, old     Node
+		moved, target *dir
+		unlock        func()
+	)
+	for {
+		d.mu.Lock()
+		from = d.nodes[args.From.Name]
+		d.mu.Unlock()
+		to.mu.Lock()
+		old = to.nodes[args.To.Name]
+		to.mu.Unlock()
+
+		moved, _ = from.(*dir)
+		target, _ = old.(*dir)
+		if moved != nil && d != to && to.below(moved) {
+			res.Status = nfs.NFSStatInval
+			res.FromDirWcc.Post = nfs.NewPostOpAttr(d.Attr())
+			res.ToDirWcc.Post = nfs.NewPostOpAttr(to.Attr())
+			return nil
+		}
+
+		unlock = lockDirs(d, to, moved, target)
+		if d.nodes[args.From.Name] == from && to.nodes[args.To.Name] == old {
+			break
+		}
+		unlock()
+	}
+	defer unlock()
+
+	fromBefore, toBefore := d.attr(), to.attr()
+	defer func() {
+		res.FromDirWcc = nfs.NewWccData(fromBefore, d.attr())
+		res.ToDirWcc = nfs.NewWccData(toBefore, to.attr())
+	}()
+
+	if from == nil {
+		res.Status = nfs.NFSStatNoent
+		return nil
+	}
+	if old == from {
+		// both names link the same object, nothing to do
+		res.Status = nfs.NFSStatOk
+		return nil
 	}
 
 	// If the directory, to.dir, already contains an entry with
 	// the name, to.name, the source object must be compatible
 	// with the target: either both are non-directories or both
-	// are directories and the target must be empty. If
-	// compatible, the existing target is removed before the
-	// rename occurs. If they are not compatible or if the target
-	// is a directory but not empty, the server should return the
-	// error, NFS3ERR_EXIST.
-	//
-	// _, ok = dir.nodes[args.To.Name]
-	// if ok {
-	// 	res.Status = nfs.NFSStatExist
-	// 	return nil
-	// }
+	// are directories and the target must be empty. Like Linux we
+	// tell incompatible targets with NFS3ERR_ISDIR and NFS3ERR_NOTDIR,
+	// and non-empty ones with NFS3ERR_NOTEMPTY.
+	if old != nil {
+		switch {
+		case moved == nil && target != nil:
+			res.Status = nfs.NFSStatIsdir
+			return nil
+		case moved != nil && target == nil:
+			res.Status = nfs.NFSStatNotdir
+			return nil
+		case target != nil && len(target.nodes) > 2:
+			res.Status = nfs.NFSStatNotempty
+			return nil
+		}
+		if f, isFile := old.(*file); !isFile || f.unlink() {
+			d.mux.Delete(old.ID())
+			replaced = old
+		}
+	}
 
 	// add node to dst dir
-	dir.nodes[args.To.Name] = from
+	to.nodes[args.To.Name] = from
 
 	// delete file from src dir
 	delete(d.nodes, args.From.Name)
 
+	if moved != nil && d != to {
+		moved.nodes[".."] = to
+		moved.ctime = time.Now()
+		moved.gen++
+	}
+
+	d.touch()
+	to.touch()
+
 	res.Status = nfs.NFSStatOk
 	return nil
 }
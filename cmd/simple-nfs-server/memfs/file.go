@@ -1,58 +1,91 @@
 package memfs
 
 import (
-	"encoding/binary"
-	"errors"
-	"log"
 	"sync"
+	"sync/atomic"
 	"time"
-	"unsafe"
 
 	"github.com/dzeromsk/xdrrpc/nfs"
 )
 
+// maxFileSize is the largest file size we report and allow, 16TB as
+// ext4 with 4KB blocks. Only data written takes memory, see data.
+const maxFileSize = 17592186040320
+
 type file struct {
-	mu    sync.Mutex
-	buf   []byte
-	mtime int64
-	ctime int64
+	id uint64
+	mu sync.Mutex
+	perm
+	quota *quotas
+	data  data
+	mtime time.Time
+	ctime time.Time
+
+	// nlink counts directory entries of f, it is accessed atomically
+	// as directories change it without locking f
+	nlink int32
 }
 
 func NewFile(content string) *file {
+	now := time.Now()
 	return &file{
-		buf:   []byte(content),
-		mtime: time.Now().Unix(),
-		ctime: time.Now().Unix(),
+		id:    newID(),
+		perm:  perm{mode: 0644},
+		data:  newData([]byte(content)),
+		mtime: now,
+		ctime: now,
+		nlink: 1,
 	}
 }
 
+// link counts a new directory entry for f.
+func (f *file) link() {
+	atomic.AddInt32(&f.nlink, 1)
+}
+
+// unlink drops a directory entry of f and tells whether it was the last.
+func (f *file) unlink() bool {
+	return atomic.AddInt32(&f.nlink, -1) <= 0
+}
+
 func (f *file) ID() []byte {
-	b := make([]byte, 8)
-	binary.LittleEndian.PutUint64(b, uint64(uintptr(unsafe.Pointer(f))))
-	return b
+	return encodeID(f.id)
 }
 
 func (f *file) Attr() nfs.Fattr3 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.attr()
+}
+
+// attr must be called with f.mu held.
+func (f *file) attr() nfs.Fattr3 {
 	return nfs.Fattr3{
 		Type:     nfs.NF3Reg,
-		FileMode: 0644,
-		Nlink:    1,
-		UID:      0,
-		GID:      0,
-		Filesize: uint64(len(f.buf)),
-		Used:     uint64(len(f.buf)),
+		FileMode: f.mode,
+		Nlink:    uint32(atomic.LoadInt32(&f.nlink)),
+		UID:      f.uid,
+		GID:      f.gid,
+		Filesize: f.data.size,
+		Used:     uint64(f.data.used),
 		FSID:     83,
-		Fileid:   uint64(uintptr(unsafe.Pointer(f))),
-		Atime:    nfs.NFS3Time{Seconds: uint32(f.mtime)},
-		Mtime:    nfs.NFS3Time{Seconds: uint32(f.mtime)},
-		Ctime:    nfs.NFS3Time{Seconds: uint32(f.ctime)},
+		Fileid:   f.id,
+		Atime:    nfs.NewNFS3Time(f.mtime),
+		Mtime:    nfs.NewNFS3Time(f.mtime),
+		Ctime:    nfs.NewNFS3Time(f.ctime),
 	}
 }
 
-func (t *file) Access(res *nfs.ACCESS3res) error {
-	res.Status = nfs.NFSStatOk
-	res.Access = 0x3f
-	return nil
+// charged must be called with f.mu held.
+func (f *file) charged() charge {
+	return charge{uid: f.uid, gid: f.gid, bytes: f.data.used, files: 1}
+}
+
+// release stops charging f to its owner, once removed.
+func (f *file) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.quota.update(f.charged(), charge{})
 }
 
 func (f *file) Getattr(res *nfs.GETATTR3res) error {
@@ -62,32 +95,69 @@ func (f *file) Getattr(res *nfs.GETATTR3res) error {
 }
 
 func (f *file) Setattr(args *nfs.SETATTR3args, res *nfs.SETATTR3res) error {
-	f.mtime = time.Now().Unix()
-	res.Status = nfs.NFSStatOk
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	before := f.attr()
+	defer func() {
+		res.ObjWcc = nfs.NewWccData(before, f.attr())
+	}()
+
+	if args.Guard.IsSet && before.Ctime != args.Guard.Ctime {
+		return nfs.NFSStatNotsync
+	}
+	if args.Sattr.Size.IsSet && args.Sattr.Size.Size > maxFileSize {
+		return nfs.NFSStatFbig
+	}
+	to := f.charged()
+	if args.Sattr.UID.IsSet {
+		to.uid = args.Sattr.UID.UID
+	}
+	if args.Sattr.GID.IsSet {
+		to.gid = args.Sattr.GID.GID
+	}
 	if args.Sattr.Size.IsSet {
-		length := args.Sattr.Size.Size
-		if uint64(len(f.buf)) < length {
-			new := make([]byte, length)
-			copy(new, f.buf)
-			f.buf = new
-		}
-		f.buf = f.buf[:length]
+		to.bytes = f.data.usedBelow(args.Sattr.Size.Size)
+	}
+	if err := f.quota.update(f.charged(), to); err != nil {
+		return err
 	}
+
+	now := time.Now()
+	f.perm.set(&args.Sattr)
+	if args.Sattr.Size.IsSet {
+		f.data.truncate(args.Sattr.Size.Size)
+		f.mtime = now
+	}
+	switch args.Sattr.Mtime.TimeHow {
+	case 1: // SET_TO_SERVER_TIME
+		f.mtime = now
+	case 2: // SET_TO_CLIENT_TIME
+		t := args.Sattr.Mtime.Time
+		f.mtime = time.Unix(int64(t.Seconds), int64(t.Nseconds))
+	}
+	f.ctime = now
+
+	res.Status = nfs.NFSStatOk
 	return nil
 }
 
 func (f *file) Read(args *nfs.READ3args, res *nfs.READ3res) error {
-	if args.Offset >= uint64(len(f.buf)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	res.Attr = nfs.NewPostOpAttr(f.attr())
+
+	if args.Offset >= f.data.size {
 		res.Status = nfs.NFSStatOk
 		res.EOF = true
 		return nil
 	}
 	res.Status = nfs.NFSStatOk
-	res.Data = make([]byte, args.Count)
-	n := copy(res.Data, f.buf[args.Offset:])
-	if n < len(res.Data) {
-		res.EOF = true
-	}
+	res.Data = f.data.readAt(args.Offset, uint64(args.Count))
+	n := len(res.Data)
+	// reads ending right at the end of file tell so too
+	res.EOF = args.Offset+uint64(n) == f.data.size
 	res.Count = uint32(n)
 
 	return nil
@@ -97,45 +167,53 @@ func (f *file) Write(args *nfs.WRITE3args, res *nfs.WRITE3res) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	f.mtime = time.Now().Unix()
+	before := f.attr()
+	defer func() {
+		res.FileWcc = nfs.NewWccData(before, f.attr())
+	}()
 
 	count := uint32(len(args.Data))
 	if count != args.Count {
-		return errors.New("write size mismatch")
+		return nfs.NFSStatInval
 	}
-
-	// append
-	if args.Offset == uint64(len(f.buf)) {
-		log.Println("append", args.Offset, uint64(len(f.buf)))
-		f.buf = append(f.buf, args.Data...)
-		res.Status = nfs.NFSStatOk
-		res.Count = args.Count
-		res.Committed = 2
-		return nil
+	if args.Offset > maxFileSize || uint64(count) > maxFileSize-args.Offset {
+		return nfs.NFSStatFbig
 	}
-
-	// optionally realloc
-	length := args.Offset + uint64(count)
-	if uint64(len(f.buf)) < length {
-		if uint64(cap(f.buf)) < length {
-			new := make([]byte, int64(float64(length)*1.05))
-			copy(new, f.buf)
-			f.buf = new
+	if grown := f.data.grows(args.Offset, uint64(count)); grown > 0 {
+		to := f.charged()
+		to.bytes += grown
+		if err := f.quota.update(f.charged(), to); err != nil {
+			return err
 		}
-		f.buf = f.buf[:length]
 	}
 
-	// memcopy
-	copy(f.buf[args.Offset:], args.Data)
+	f.mtime = time.Now()
+	f.ctime = f.mtime
+
+	f.data.writeAt(args.Data, args.Offset)
 	res.Status = nfs.NFSStatOk
 	res.Count = args.Count
-	res.Committed = 2
+	res.Committed = args.Stable
 
 	return nil
 }
 
-func (f *file) Commit(args *nfs.COMMIT3args, res *nfs.COMMIT3res) error {
-	f.mtime = time.Now().Unix()
-	res.Status = nfs.NFSStatOk
+// Flush has nothing to do, memory is our stable storage. Data written
+// unstably is only lost on restart, which changes the write verifier.
+func (f *file) Flush(offset uint64, count uint32) error {
+	return nil
+}
+
+func (f *file) ACL() (access, def nfs.ACL) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.acl, f.def
+}
+
+func (f *file) SetACL(access, def nfs.ACL) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setACL(access, def)
+	f.ctime = time.Now()
 	return nil
 }
@@ -1,19 +1,56 @@
 package memfs
 
 import (
-	"time"
-
 	"github.com/dzeromsk/xdrrpc/nfs"
+	"github.com/dzeromsk/xdrrpc/rquota"
 )
 
-var starttime = nfs.NFS3Time{Seconds: uint32(time.Now().Unix())}
-
 type fs struct {
 	*dir
+	quotas *quotas
 }
 
+// NewFS returns the file system rooted at dir. It tracks usage of users
+// and groups, unlimited until SetLimits or SetDefaultLimits is called.
 func NewFS(dir *dir) *fs {
-	return &fs{dir: dir}
+	q := newQuotas()
+	q.adopt(dir, map[Node]bool{})
+	return &fs{dir: dir, quotas: q}
+}
+
+// SetLimits sets limits of user or group id.
+func (f *fs) SetLimits(kind rquota.Kind, id uint32, l rquota.Limits) {
+	f.quotas.mu.Lock()
+	defer f.quotas.mu.Unlock()
+	f.quotas.limits[quotaKey{kind, id}] = l
+}
+
+// SetDefaultLimits sets limits of users or groups other than root without
+// limits of their own.
+func (f *fs) SetDefaultLimits(kind rquota.Kind, l rquota.Limits) {
+	f.quotas.mu.Lock()
+	defer f.quotas.mu.Unlock()
+	f.quotas.defaults[kind] = l
+}
+
+func (f *fs) Quota(kind rquota.Kind, id uint32) (rquota.Quota, bool) {
+	return f.quotas.Quota(kind, id)
+}
+
+// SetCapacity limits how many bytes files may hold together,
+// DefaultCapacity by default. Writes past it fail with NFSStatNospc.
+func (f *fs) SetCapacity(bytes uint64) {
+	f.quotas.mu.Lock()
+	defer f.quotas.mu.Unlock()
+	f.quotas.capacity = bytes
+}
+
+// Lookup finds the root itself for "..", as Linux does at export roots.
+func (f *fs) Lookup(name string, res *nfs.LOOKUP3res) error {
+	if name == ".." {
+		name = "."
+	}
+	return f.dir.Lookup(name, res)
 }
 
 func (f *fs) Fsinfo(res *nfs.FSINFO3res) error {
@@ -26,7 +63,7 @@ func (f *fs) Fsinfo(res *nfs.FSINFO3res) error {
 	res.WTMult = 4096
 	// res.DTPref = 4096
 	res.DTPref = 32768 // max on linux
-	res.Size = 17592186040320
+	res.Size = maxFileSize
 	res.TimeDelta = nfs.NFS3Time{Seconds: 1}
 	res.Properties = 0x0000001b
 	return nil
@@ -34,9 +71,8 @@ func (f *fs) Fsinfo(res *nfs.FSINFO3res) error {
 
 func (f *fs) Fsstat(res *nfs.FSSTAT3res) error {
 	res.Status = nfs.NFSStatOk
-	res.Tbytes = 1 * 1024 * 1024 * 1024 * 1024 * 1024
-	res.Fbytes = 0.5 * 1024 * 1024 * 1024 * 1024 * 1024
-	res.Abytes = 0.5 * 1024 * 1024 * 1024 * 1024 * 1024
+	res.Tbytes, res.Fbytes = f.quotas.space()
+	res.Abytes = res.Fbytes
 	res.Tfiles = 1024
 	res.Ffiles = 512
 	res.Afiles = 512
@@ -46,5 +82,7 @@ func (f *fs) Fsstat(res *nfs.FSSTAT3res) error {
 
 func (f *fs) Pathconf(res *nfs.PATHCONF3res) error {
 	res.Status = nfs.NFSStatOk
+	res.CaseInsensitive = false
+	res.CasePreserving = true
 	return nil
 }
@@ -0,0 +1,28 @@
+package memfs_test
+
+import (
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc/cmd/simple-nfs-server/memfs"
+	"github.com/dzeromsk/xdrrpc/nfs"
+	"github.com/dzeromsk/xdrrpc/nfstest"
+)
+
+// seedTree is the tree simple-nfs-server starts with, in its default
+// configuration. Holes take no memory, so inputs cannot grow files past
+// their own size.
+func seedTree(mux nfs.ServeMux) interface{} {
+	return memfs.NewFS(
+		memfs.NewDir(mux, map[string]memfs.Node{
+			"hello": memfs.NewFile("world\n"),
+			"foo":   memfs.NewFile("bar\n"),
+			"example": memfs.NewDir(mux, map[string]memfs.Node{
+				"alice": memfs.NewFile("bob\n"),
+			}),
+		}),
+	)
+}
+
+func FuzzDispatch(f *testing.F) {
+	nfstest.FuzzDispatch(f, seedTree)
+}
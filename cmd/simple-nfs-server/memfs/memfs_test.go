@@ -0,0 +1,503 @@
+package memfs_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/client"
+	"github.com/dzeromsk/xdrrpc/cmd/simple-nfs-server/memfs"
+	"github.com/dzeromsk/xdrrpc/cthon"
+	"github.com/dzeromsk/xdrrpc/nfs"
+	"github.com/dzeromsk/xdrrpc/nfstest"
+	"github.com/dzeromsk/xdrrpc/rquota"
+)
+
+// emptyTree is an empty file system without limits.
+func emptyTree(mux nfs.ServeMux) interface{} {
+	return memfs.NewFS(memfs.NewDir(mux, map[string]memfs.Node{}))
+}
+
+func TestBackend(t *testing.T) {
+	nfstest.TestBackend(t, emptyTree)
+}
+
+func TestCthon(t *testing.T) {
+	opts := &cthon.Options{Files: 3, Dirs: 2, Levels: 2, Size: 100000, Count: 3}
+	if testing.Short() {
+		opts = &cthon.Options{Files: 2, Dirs: 1, Levels: 1, Size: 10000, Count: 1}
+	}
+	c := nfstest.NewClient(t, emptyTree)
+	for _, r := range cthon.Run(c, opts) {
+		for _, e := range r.Errors {
+			t.Errorf("%s/%s: %s", r.Test.Category, r.Test.Name, e)
+		}
+	}
+}
+
+func TestIDsNotReused(t *testing.T) {
+	c := nfstest.NewClient(t, emptyTree)
+	seen := map[uint64]bool{}
+	var handles [][]byte
+	for i := 0; i < 100; i++ {
+		res, err := c.Create(c.Root, "file", nfs.Createhow3{Mode: 1})
+		if err != nil {
+			t.Fatal("create:", err)
+		}
+		id := res.Attr.Attr.Fileid
+		if seen[id] {
+			t.Fatalf("file id %d reused", id)
+		}
+		seen[id] = true
+		handles = append(handles, res.Handle.FH)
+		if _, err := c.Remove(c.Root, "file"); err != nil {
+			t.Fatal("remove:", err)
+		}
+	}
+	for _, fh := range handles {
+		if _, err := c.Getattr(fh); err != nfs.NFSStatStale {
+			t.Fatalf("getattr of removed file: got %v, want %v", err, nfs.NFSStatStale)
+		}
+	}
+}
+
+// manyFiles is a file system with n files named file00, file01 and so on.
+func manyFiles(n int) nfstest.Factory {
+	return func(mux nfs.ServeMux) interface{} {
+		nodes := map[string]memfs.Node{}
+		for i := 0; i < n; i++ {
+			nodes[fmt.Sprintf("file%02d", i)] = memfs.NewFile("")
+		}
+		return memfs.NewFS(memfs.NewDir(mux, nodes))
+	}
+}
+
+func TestReaddir2Paging(t *testing.T) {
+	srv := nfstest.NewServer(manyFiles(50))
+	b, err := srv.Mux.EncodeHandle(nfs.FileHandle{Export: 1, Object: nfstest.Root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, ok := nfs.NewFHandle2(b)
+	if !ok {
+		t.Fatal("root handle too long for NFSv2")
+	}
+	r := srv.Mux.NFS2Receiver().(*nfs.NFS2)
+
+	var names []string
+	cookie := uint32(0)
+	for pages := 0; ; pages++ {
+		if pages > 50 {
+			t.Fatal("READDIR never reached the end")
+		}
+		var res nfs.READDIR2res
+		r.Readdir(&nfs.READDIR2args{Dir: root, Cookie: cookie, Count: 256}, &res)
+		if res.Status != nfs.NFSStatOk {
+			t.Fatalf("READDIR at cookie %d: %v", cookie, res.Status)
+		}
+		for e := res.Reply.Entries; e != nil; e = e.Next {
+			if e.Name != "." && e.Name != ".." {
+				names = append(names, e.Name)
+			}
+			cookie = e.Cookie
+		}
+		if res.Reply.EOF {
+			break
+		}
+	}
+	if len(names) != 50 {
+		t.Fatalf("listed %d files, want 50", len(names))
+	}
+	for i, name := range names {
+		if want := fmt.Sprintf("file%02d", i); name != want {
+			t.Fatalf("entry %d is %s, want %s", i, name, want)
+		}
+	}
+}
+
+func TestReaddirplusCookieVerf(t *testing.T) {
+	srv := nfstest.NewServer(manyFiles(50))
+	root, err := srv.Mux.EncodeHandle(nfs.FileHandle{Export: 1, Object: nfstest.Root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := srv.Mux.Receiver().(*nfs.NFS)
+	var first nfs.READDIRPLUS3res
+	r.Readdirplus(&nfs.READDIRPLUS3args{Dir: root, DirCount: 256, MaxCount: 4096}, &first)
+	if first.Status != nfs.NFSStatOk || first.Reply.EOF {
+		t.Fatalf("first page: %v, eof %v", first.Status, first.Reply.EOF)
+	}
+	for _, tt := range []struct {
+		name string
+		verf uint64
+		want nfs.NFSStat
+	}{
+		{"verifier returned", first.CookieVerf, nfs.NFSStatOk},
+		{"no verifier", 0, nfs.NFSStatOk},
+		{"other verifier", first.CookieVerf + 1, nfs.NFSStatBadcookie},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var res nfs.READDIRPLUS3res
+			r.Readdirplus(&nfs.READDIRPLUS3args{Dir: root, Cookie: 3, CookieVerf: tt.verf, DirCount: 256, MaxCount: 4096}, &res)
+			if res.Status != tt.want {
+				t.Errorf("got %v, want %v", res.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetattrGuard(t *testing.T) {
+	mux := nfs.NewServeMux()
+	for _, node := range []memfs.Node{
+		memfs.NewFile("data"),
+		memfs.NewDir(mux, map[string]memfs.Node{}),
+	} {
+		ctime := node.Attr().Ctime
+		stale := ctime
+		stale.Seconds--
+		for _, tt := range []struct {
+			name  string
+			guard nfs.Sattrguard3
+			want  nfs.NFSStat
+		}{
+			{"stale ctime", nfs.Sattrguard3{IsSet: true, Ctime: stale}, nfs.NFSStatNotsync},
+			{"current ctime", nfs.Sattrguard3{IsSet: true, Ctime: ctime}, nfs.NFSStatOk},
+			{"no guard", nfs.Sattrguard3{}, nfs.NFSStatOk},
+		} {
+			t.Run(fmt.Sprintf("%T/%s", node, tt.name), func(t *testing.T) {
+				var res nfs.SETATTR3res
+				args := &nfs.SETATTR3args{Sattr: nfs.Sattr3{Mode: nfs.Sattr3Mode{IsSet: true, Mode: 0700}}, Guard: tt.guard}
+				stat := nfs.StatusFromError(node.(nfs.Setattrer).Setattr(args, &res))
+				if stat == nfs.NFSStatOk {
+					stat = res.Status
+				}
+				if stat != tt.want {
+					t.Errorf("got %v, want %v", stat, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestSparseFiles(t *testing.T) {
+	c := nfstest.NewClient(t, emptyTree)
+	res, err := c.Create(c.Root, "file", nfs.Createhow3{Mode: 1})
+	if err != nil {
+		t.Fatal("create:", err)
+	}
+	fh := res.Handle.FH
+
+	const hole = 1 << 40
+	if _, err := c.Write(fh, hole, []byte("x"), nfs.FileSync); err != nil {
+		t.Fatal("write past a hole:", err)
+	}
+	attr, err := c.Getattr(fh)
+	if err != nil {
+		t.Fatal("getattr:", err)
+	}
+	if attr.Attr.Filesize != hole+1 || attr.Attr.Used != 1 {
+		t.Errorf("size %d, used %d, want %d and 1", attr.Attr.Filesize, attr.Attr.Used, hole+1)
+	}
+	read, err := c.Read(fh, hole-3, 10)
+	if err != nil {
+		t.Fatal("read:", err)
+	}
+	if string(read.Data) != "\x00\x00\x00x" || !read.EOF {
+		t.Errorf("read %q, eof %v", read.Data, read.EOF)
+	}
+
+	info, err := c.Fsinfo(c.Root)
+	if err != nil {
+		t.Fatal("fsinfo:", err)
+	}
+	for _, tt := range []struct {
+		name string
+		do   func() error
+		want nfs.NFSStat
+	}{
+		{"grow to the largest size", func() error {
+			_, err := c.Setattr(fh, nfs.Sattr3{Size: nfs.Sattr3Size{IsSet: true, Size: info.Size}}, nfs.Sattrguard3{})
+			return err
+		}, nfs.NFSStatOk},
+		{"grow past the largest size", func() error {
+			_, err := c.Setattr(fh, nfs.Sattr3{Size: nfs.Sattr3Size{IsSet: true, Size: info.Size + 1}}, nfs.Sattrguard3{})
+			return err
+		}, nfs.NFSStatFbig},
+		{"write past the largest size", func() error {
+			_, err := c.Write(fh, info.Size, []byte("x"), nfs.FileSync)
+			return err
+		}, nfs.NFSStatFbig},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if stat := nfs.StatusFromError(tt.do()); stat != tt.want {
+				t.Errorf("got %v, want %v", stat, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	c := nfstest.NewClient(t, func(mux nfs.ServeMux) interface{} {
+		fs := memfs.NewFS(memfs.NewDir(mux, map[string]memfs.Node{}))
+		fs.SetCapacity(1 << 20)
+		return fs
+	})
+	res, err := c.Create(c.Root, "file", nfs.Createhow3{Mode: 1})
+	if err != nil {
+		t.Fatal("create:", err)
+	}
+	fh := res.Handle.FH
+	data := make([]byte, 600<<10)
+	if _, err := c.Write(fh, 0, data, nfs.FileSync); err != nil {
+		t.Fatal("first write:", err)
+	}
+	if _, err := c.Write(fh, 1<<30, data, nfs.FileSync); err != nfs.NFSStatNospc {
+		t.Fatalf("write past capacity: got %v, want %v", err, nfs.NFSStatNospc)
+	}
+	stat, err := c.Fsstat(c.Root)
+	if err != nil {
+		t.Fatal("fsstat:", err)
+	}
+	if stat.Tbytes != 1<<20 || stat.Fbytes != 1<<20-600<<10 {
+		t.Errorf("fsstat: total %d, free %d", stat.Tbytes, stat.Fbytes)
+	}
+	if _, err := c.Setattr(fh, nfs.Sattr3{Size: nfs.Sattr3Size{IsSet: true}}, nfs.Sattrguard3{}); err != nil {
+		t.Fatal("truncate:", err)
+	}
+	if _, err := c.Write(fh, 1<<30, data, nfs.FileSync); err != nil {
+		t.Errorf("write after truncate freed space: %v", err)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	s := nfstest.NewServer(func(mux nfs.ServeMux) interface{} {
+		fs := memfs.NewFS(memfs.NewDir(mux, map[string]memfs.Node{}))
+		fs.SetLimits(rquota.UsrQuota, 1000, rquota.Limits{BytesHard: 100, FilesHard: 2})
+		return fs
+	})
+	defer s.Close()
+	root, err := s.Client(client.WithCred(nfs.Cred{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	user, err := s.Client(client.WithCred(nfs.Cred{UID: 1000, GID: 100}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer user.Close()
+
+	mkdir, err := root.Mkdir(root.Root, "shared", nfs.Sattr3{Mode: nfs.Sattr3Mode{IsSet: true, Mode: 0777}})
+	if err != nil {
+		t.Fatal("mkdir:", err)
+	}
+	dir := mkdir.Handle.FH
+	create := func(name string) ([]byte, error) {
+		res, err := user.Create(dir, name, nfs.Createhow3{Mode: 1})
+		if err != nil {
+			return nil, err
+		}
+		return res.Handle.FH, nil
+	}
+	fh, err := create("a")
+	if err != nil {
+		t.Fatal("create:", err)
+	}
+	if _, err := create("b"); err != nil {
+		t.Fatal("create:", err)
+	}
+	if _, err := create("c"); err != nfs.NFSStatDquot {
+		t.Errorf("create past the file limit: got %v, want %v", err, nfs.NFSStatDquot)
+	}
+	if _, err := user.Write(fh, 0, make([]byte, 60), nfs.FileSync); err != nil {
+		t.Fatal("write:", err)
+	}
+	if _, err := user.Write(fh, 60, make([]byte, 60), nfs.FileSync); err != nfs.NFSStatDquot {
+		t.Errorf("write past the byte limit: got %v, want %v", err, nfs.NFSStatDquot)
+	}
+	if _, err := root.Write(fh, 60, make([]byte, 60), nfs.FileSync); err != nfs.NFSStatDquot {
+		t.Errorf("root writing to a file of the user: got %v, want %v", err, nfs.NFSStatDquot)
+	}
+
+	rq := rquota.NewRquota(s.Mount)
+	for _, tt := range []struct {
+		name string
+		uid  uint32
+		want rquota.Status
+	}{
+		{"own quota", 1000, rquota.QOk},
+		{"quota of another user", 1001, rquota.QEperm},
+		{"root", 0, rquota.QOk},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			args := &rquota.GetquotaArgs{Path: "/shared", UID: 1000}
+			args.SetCall(&xdrrpc.CallInfo{Cred: xdrrpc.NewAuthSys(xdrrpc.AuthSysParms{UID: tt.uid, GID: 100})})
+			var res rquota.GetquotaRes
+			rq.Getquota(args, &res)
+			if res.Status != tt.want {
+				t.Fatalf("got %v, want %v", res.Status, tt.want)
+			}
+			if q := res.Quota; res.Status == rquota.QOk && (q.CurFiles != 2 || q.FHardLimit != 2 || q.CurBlocks == 0) {
+				t.Errorf("got %+v, want 2 of 2 files and blocks in use", q)
+			}
+		})
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	s := nfstest.NewServer(emptyTree)
+	defer s.Close()
+	as := func(cred nfs.Cred) *client.Client {
+		c, err := s.Client(client.WithCred(cred))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	root := as(nfs.Cred{})
+	owner := as(nfs.Cred{UID: 1000, GID: 100})
+	member := as(nfs.Cred{UID: 1001, GID: 200, GIDs: []uint32{100}})
+	other := as(nfs.Cred{UID: 1002, GID: 200})
+
+	mkdir, err := root.Mkdir(root.Root, "tmp", nfs.Sattr3{Mode: nfs.Sattr3Mode{IsSet: true, Mode: 01777}})
+	if err != nil {
+		t.Fatal("mkdir:", err)
+	}
+	tmp := mkdir.Handle.FH
+	create, err := owner.Create(tmp, "file", nfs.Createhow3{Mode: 1, GuardedAttr: nfs.Sattr3{Mode: nfs.Sattr3Mode{IsSet: true, Mode: 0640}}})
+	if err != nil {
+		t.Fatal("create:", err)
+	}
+	fh := create.Handle.FH
+	if _, err := owner.Write(fh, 0, []byte("data"), nfs.FileSync); err != nil {
+		t.Fatal("write:", err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		c      *client.Client
+		access uint32
+		read   error
+		write  error
+		remove error
+	}{
+		{"owner", owner, nfs.AccessRead | nfs.AccessModify | nfs.AccessExtend, nil, nil, nil},
+		{"group member", member, nfs.AccessRead, nil, nfs.NFSStatAcces, nfs.NFSStatAcces},
+		{"other", other, 0, nfs.NFSStatAcces, nfs.NFSStatAcces, nfs.NFSStatAcces},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := tt.c.Access(fh, nfs.AccessRead|nfs.AccessModify|nfs.AccessExtend|nfs.AccessExecute)
+			if err != nil {
+				t.Fatal("ACCESS:", err)
+			}
+			if res.Access != tt.access {
+				t.Errorf("ACCESS: got %#x, want %#x", res.Access, tt.access)
+			}
+			if _, err := tt.c.Read(fh, 0, 4); err != tt.read {
+				t.Errorf("READ: got %v, want %v", err, tt.read)
+			}
+			if _, err := tt.c.Write(fh, 0, []byte("x"), nfs.FileSync); err != tt.write {
+				t.Errorf("WRITE: got %v, want %v", err, tt.write)
+			}
+			// the directory is sticky, only the owner may remove the file
+			if tt.remove != nil {
+				if _, err := tt.c.Remove(tmp, "file"); err != tt.remove {
+					t.Errorf("REMOVE: got %v, want %v", err, tt.remove)
+				}
+			}
+		})
+	}
+	if _, err := owner.Remove(tmp, "file"); err != nil {
+		t.Errorf("REMOVE by the owner: %v", err)
+	}
+}
+
+func TestWcc(t *testing.T) {
+	// objects of the tree every case starts with
+	type objects struct{ from, to, file []byte }
+	for _, tt := range []struct {
+		name string
+		run  func(c *client.Client, o objects) ([]nfs.WccData, error)
+		of   func(o objects) [][]byte // objects the results describe
+	}{
+		{
+			"CREATE",
+			func(c *client.Client, o objects) ([]nfs.WccData, error) {
+				res, err := c.Create(o.from, "new", nfs.Createhow3{UncheckedAttr: nfs.Sattr3{Mode: nfs.Sattr3Mode{IsSet: true, Mode: 0644}}})
+				return []nfs.WccData{res.DirWcc}, err
+			},
+			func(o objects) [][]byte { return [][]byte{o.from} },
+		},
+		{
+			"WRITE",
+			func(c *client.Client, o objects) ([]nfs.WccData, error) {
+				res, err := c.Write(o.file, 4, []byte("more"), nfs.FileSync)
+				return []nfs.WccData{res.FileWcc}, err
+			},
+			func(o objects) [][]byte { return [][]byte{o.file} },
+		},
+		{
+			"REMOVE",
+			func(c *client.Client, o objects) ([]nfs.WccData, error) {
+				res, err := c.Remove(o.from, "file")
+				return []nfs.WccData{res.DirWcc}, err
+			},
+			func(o objects) [][]byte { return [][]byte{o.from} },
+		},
+		{
+			"RENAME",
+			func(c *client.Client, o objects) ([]nfs.WccData, error) {
+				res, err := c.Rename(o.from, "file", o.to, "renamed")
+				return []nfs.WccData{res.FromDirWcc, res.ToDirWcc}, err
+			},
+			func(o objects) [][]byte { return [][]byte{o.from, o.to} },
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := nfstest.NewClient(t, emptyTree)
+			mkdir := func(name string) []byte {
+				res, err := c.Mkdir(c.Root, name, nfs.Sattr3{Mode: nfs.Sattr3Mode{IsSet: true, Mode: 0755}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return res.Handle.FH
+			}
+			o := objects{from: mkdir("from"), to: mkdir("to")}
+			res, err := c.Create(o.from, "file", nfs.Createhow3{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			o.file = res.Handle.FH
+			if _, err := c.Write(o.file, 0, []byte("data"), nfs.FileSync); err != nil {
+				t.Fatal(err)
+			}
+
+			attrs := func() []nfs.Fattr3 {
+				var attrs []nfs.Fattr3
+				for _, fh := range tt.of(o) {
+					res, err := c.Getattr(fh)
+					if err != nil {
+						t.Fatal(err)
+					}
+					attrs = append(attrs, res.Attr)
+				}
+				return attrs
+			}
+			before := attrs()
+			wcc, err := tt.run(c, o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after := attrs()
+			for i := range wcc {
+				if want := nfs.NewWccData(before[i], after[i]); wcc[i] != want {
+					t.Errorf("object %d: got %+v, want %+v", i, wcc[i], want)
+				}
+				if wcc[i].Pre.Attr.Mtime == wcc[i].Post.Attr.Mtime {
+					t.Errorf("object %d: mtime %v did not change", i, wcc[i].Post.Attr.Mtime)
+				}
+			}
+		})
+	}
+}
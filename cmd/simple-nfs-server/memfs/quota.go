@@ -0,0 +1,222 @@
+package memfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+	"github.com/dzeromsk/xdrrpc/rquota"
+)
+
+// quotaGrace is how long users and groups may stay over soft limits.
+const quotaGrace = 7 * 24 * time.Hour
+
+// DefaultCapacity is how many bytes files may hold together, as they are
+// kept in memory, unless SetCapacity is called.
+const DefaultCapacity = 4 << 30
+
+type quotaKey struct {
+	kind rquota.Kind
+	id   uint32
+}
+
+type usage struct {
+	bytes, files uint64
+	bytesOver    time.Time // when the soft limit was exceeded
+	filesOver    time.Time
+}
+
+// charge is what an object counts against the quotas of its owner.
+type charge struct {
+	uid, gid     uint32
+	bytes, files int64
+}
+
+// quotas tracks bytes and files used by users and groups and enforces
+// their limits, and capacity. Methods of a nil *quotas do nothing.
+type quotas struct {
+	mu       sync.Mutex
+	usage    map[quotaKey]*usage
+	limits   map[quotaKey]rquota.Limits
+	defaults [2]rquota.Limits // by kind
+	capacity uint64           // bytes all files may hold
+	used     uint64
+}
+
+func newQuotas() *quotas {
+	return &quotas{
+		usage:    map[quotaKey]*usage{},
+		limits:   map[quotaKey]rquota.Limits{},
+		capacity: DefaultCapacity,
+	}
+}
+
+// limit must be called with q.mu held. Root is only limited explicitly.
+func (q *quotas) limit(k quotaKey) rquota.Limits {
+	if l, ok := q.limits[k]; ok {
+		return l
+	}
+	if k.id == 0 {
+		return rquota.Limits{}
+	}
+	return q.defaults[k.kind]
+}
+
+// update moves an object charged as from to to, as it is created, grows,
+// changes owner or is removed. It fails with NFSStatDquot, changing
+// nothing, if usage that grows would exceed a hard limit or a soft limit
+// exceeded for longer than quotaGrace, and with NFSStatNospc if all files
+// would hold more than q.capacity.
+func (q *quotas) update(from, to charge) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	type delta struct{ bytes, files int64 }
+	deltas := map[quotaKey]delta{}
+	for _, c := range []struct {
+		charge
+		sign int64
+	}{{from, -1}, {to, 1}} {
+		for _, k := range []quotaKey{{rquota.UsrQuota, c.uid}, {rquota.GrpQuota, c.gid}} {
+			d := deltas[k]
+			d.bytes += c.sign * c.bytes
+			d.files += c.sign * c.files
+			deltas[k] = d
+		}
+	}
+
+	now := time.Now()
+	for k, d := range deltas {
+		u := q.usage[k]
+		if u == nil {
+			u = &usage{}
+		}
+		l := q.limit(k)
+		if d.bytes > 0 && exceeds(u.bytes+uint64(d.bytes), l.BytesSoft, l.BytesHard, u.bytesOver, now) {
+			return nfs.NFSStatDquot
+		}
+		if d.files > 0 && exceeds(u.files+uint64(d.files), l.FilesSoft, l.FilesHard, u.filesOver, now) {
+			return nfs.NFSStatDquot
+		}
+	}
+	grown := to.bytes - from.bytes
+	if grown > 0 && q.used+uint64(grown) > q.capacity {
+		return nfs.NFSStatNospc
+	}
+	q.used = add(q.used, grown)
+	for k, d := range deltas {
+		if d.bytes == 0 && d.files == 0 {
+			continue
+		}
+		u := q.usage[k]
+		if u == nil {
+			u = &usage{}
+			q.usage[k] = u
+		}
+		l := q.limit(k)
+		u.bytes = add(u.bytes, d.bytes)
+		u.files = add(u.files, d.files)
+		u.bytesOver = over(u.bytes, l.BytesSoft, u.bytesOver, now)
+		u.filesOver = over(u.files, l.FilesSoft, u.filesOver, now)
+	}
+	return nil
+}
+
+func exceeds(n, soft, hard uint64, since, now time.Time) bool {
+	if hard != 0 && n > hard {
+		return true
+	}
+	return soft != 0 && n > soft && !since.IsZero() && now.Sub(since) > quotaGrace
+}
+
+// over returns when usage n went over soft, now if it just did.
+func over(n, soft uint64, since, now time.Time) time.Time {
+	if soft == 0 || n <= soft {
+		return time.Time{}
+	}
+	if since.IsZero() {
+		return now
+	}
+	return since
+}
+
+// add returns n+d, or zero if usage would go negative, as it can for hard
+// linked files.
+func add(n uint64, d int64) uint64 {
+	if d < 0 && uint64(-d) > n {
+		return 0
+	}
+	return n + uint64(d)
+}
+
+// space returns the capacity and how many bytes files may still grow by.
+func (q *quotas) space() (total, free uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.used > q.capacity {
+		return q.capacity, 0
+	}
+	return q.capacity, q.capacity - q.used
+}
+
+// Quota implements rquota.Quotaer.
+func (q *quotas) Quota(kind rquota.Kind, id uint32) (rquota.Quota, bool) {
+	if kind != rquota.UsrQuota && kind != rquota.GrpQuota {
+		return rquota.Quota{}, false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	k := quotaKey{kind, id}
+	quota := rquota.Quota{Limits: q.limit(k)}
+	if u := q.usage[k]; u != nil {
+		quota.Bytes, quota.Files = u.bytes, u.files
+		quota.BytesGrace = graceLeft(u.bytesOver)
+		quota.FilesGrace = graceLeft(u.filesOver)
+	}
+	return quota, true
+}
+
+func graceLeft(since time.Time) time.Duration {
+	if since.IsZero() {
+		return 0
+	}
+	if left := quotaGrace - time.Since(since); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// adopt makes n and its children, if it is a directory, account their
+// usage to q.
+func (q *quotas) adopt(n Node, seen map[Node]bool) {
+	if seen[n] {
+		return
+	}
+	seen[n] = true
+	switch n := n.(type) {
+	case *file:
+		n.mu.Lock()
+		n.quota = q
+		q.update(charge{}, n.charged())
+		n.mu.Unlock()
+	case *dir:
+		n.mu.Lock()
+		n.quota = q
+		q.update(charge{}, n.charged())
+		var children []Node
+		for name, child := range n.nodes {
+			if name != "." && name != ".." {
+				children = append(children, child)
+			}
+		}
+		n.mu.Unlock()
+		for _, child := range children {
+			q.adopt(child, seen)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package cthon
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+var basicTests = []Test{
+	{Basic, "test1", "file and directory creation", test1},
+	{Basic, "test2", "file and directory removal", test2},
+	{Basic, "test3", "lookups across mount point", test3},
+	{Basic, "test4", "setattr, getattr and lookup", test4},
+	{Basic, "test4a", "getattr and lookup", test4a},
+	{Basic, "test5", "read and write", test5},
+	{Basic, "test6", "readdir", test6},
+	{Basic, "test7", "link and rename", test7},
+	{Basic, "test8", "symlink and readlink", test8},
+	{Basic, "test9", "statfs", test9},
+}
+
+// tree makes Files files and Dirs directories in dir and recurses into the
+// directories for Levels levels. It returns the number of files and
+// directories made.
+func (t *T) tree(dir []byte, level int) (files, dirs int) {
+	for i := 0; i < t.Options.Files; i++ {
+		t.create(dir, fmt.Sprintf("file.%d", i), nil)
+		files++
+	}
+	if level >= t.Options.Levels {
+		return files, dirs
+	}
+	for i := 0; i < t.Options.Dirs; i++ {
+		sub := t.mkdir(dir, fmt.Sprintf("dir.%d", i))
+		f, d := t.tree(sub, level+1)
+		files += f
+		dirs += d + 1
+	}
+	return files, dirs
+}
+
+// rmtree removes what tree made in dir, returning the same counts.
+func (t *T) rmtree(dir []byte, level int) (files, dirs int) {
+	for i := 0; i < t.Options.Files; i++ {
+		_, err := t.C.Remove(dir, fmt.Sprintf("file.%d", i))
+		t.must(fmt.Sprintf("remove file.%d", i), err)
+		files++
+	}
+	if level >= t.Options.Levels {
+		return files, dirs
+	}
+	for i := 0; i < t.Options.Dirs; i++ {
+		name := fmt.Sprintf("dir.%d", i)
+		f, d := t.rmtree(t.lookup(dir, name), level+1)
+		_, err := t.C.Rmdir(dir, name)
+		t.must("rmdir "+name, err)
+		files += f
+		dirs += d + 1
+	}
+	return files, dirs
+}
+
+// check looks up everything tree made in dir.
+func (t *T) check(dir []byte, level int) {
+	for i := 0; i < t.Options.Files; i++ {
+		res, err := t.C.Lookup(dir, fmt.Sprintf("file.%d", i))
+		t.must(fmt.Sprintf("lookup file.%d", i), err)
+		if res.Attr.IsSet && res.Attr.Attr.Type != nfs.NF3Reg {
+			t.Errorf("file.%d: type %d, want a regular file", i, res.Attr.Attr.Type)
+		}
+	}
+	if level >= t.Options.Levels {
+		return
+	}
+	for i := 0; i < t.Options.Dirs; i++ {
+		t.check(t.lookup(dir, fmt.Sprintf("dir.%d", i)), level+1)
+	}
+}
+
+func test1(t *T) {
+	files, dirs := t.tree(t.Dir, 1)
+	t.check(t.Dir, 1)
+	want := t.Options.Files
+	if t.Options.Levels > 1 {
+		want += t.Options.Dirs
+	}
+	if n := len(t.names(t.Dir)); n != want {
+		t.Errorf("readdir: %d entries, want %d", n, want)
+	}
+	if files == 0 && dirs == 0 {
+		t.Errorf("nothing created, check the options")
+	}
+}
+
+func test2(t *T) {
+	files, dirs := t.tree(t.Dir, 1)
+	rfiles, rdirs := t.rmtree(t.Dir, 1)
+	if rfiles != files || rdirs != dirs {
+		t.Errorf("removed %d files and %d directories of %d and %d", rfiles, rdirs, files, dirs)
+	}
+	if names := t.names(t.Dir); len(names) != 0 {
+		t.Errorf("directory not empty after removal: %v", names)
+	}
+}
+
+func test3(t *T) {
+	id := t.getattr(t.Dir).Fileid
+	sub := t.mkdir(t.Dir, "sub")
+	subid := t.getattr(sub).Fileid
+	for i := 0; i < t.Options.Count; i++ {
+		res, err := t.C.Lookup(t.Dir, ".")
+		t.must("lookup .", err)
+		if got := t.getattr(res.Object).Fileid; got != id {
+			t.Fatalf("lookup .: fileid %d, want %d", got, id)
+		}
+		res, err = t.C.Lookup(sub, "..")
+		t.must("lookup ..", err)
+		if got := t.getattr(res.Object).Fileid; got != id {
+			t.Fatalf("lookup ..: fileid %d, want %d", got, id)
+		}
+		if res.DirAttr.IsSet && res.DirAttr.Attr.Fileid != subid {
+			t.Fatalf("lookup ..: directory attributes of %d, want %d", res.DirAttr.Attr.Fileid, subid)
+		}
+	}
+}
+
+func test4(t *T) {
+	for i := 0; i < t.Options.Files; i++ {
+		name := fmt.Sprintf("file.%d", i)
+		fh := t.create(t.Dir, name, nil)
+		last := t.getattr(fh)
+		for _, m := range []uint32{0, 0600, 0644, 0755, 0777} {
+			res, err := t.C.Setattr(fh, mode(m), nfs.Sattrguard3{})
+			t.must(fmt.Sprintf("chmod %s %o", name, m), err)
+			attr := t.getattr(fh)
+			if attr.FileMode&07777 != m {
+				t.Errorf("%s: mode %o after chmod %o", name, attr.FileMode&07777, m)
+			}
+			if !res.ObjWcc.Post.IsSet {
+				t.Errorf("%s: setattr without post-op attributes", name)
+			} else if res.ObjWcc.Post.Attr != attr {
+				t.Errorf("%s: setattr post-op attributes %+v, getattr %+v", name, res.ObjWcc.Post.Attr, attr)
+			}
+			if before(attr.Ctime, last.Ctime) {
+				t.Errorf("%s: ctime went back from %v to %v", name, last.Ctime, attr.Ctime)
+			}
+			last = attr
+		}
+
+		// a guard not matching ctime must stop the change
+		stale := nfs.Sattrguard3{IsSet: true, Ctime: nfs.NFS3Time{Seconds: last.Ctime.Seconds - 1}}
+		_, err := t.C.Setattr(fh, mode(0644), stale)
+		t.expect("setattr with stale guard", err, nfs.NFSStatNotsync)
+		if m := t.getattr(fh).FileMode & 07777; m != 0777 {
+			t.Errorf("%s: mode %o changed by failed setattr", name, m)
+		}
+		_, err = t.C.Setattr(fh, mode(0644), nfs.Sattrguard3{IsSet: true, Ctime: last.Ctime})
+		t.must("setattr with guard", err)
+	}
+}
+
+// before tells whether a is earlier than b.
+func before(a, b nfs.NFS3Time) bool {
+	return a.Seconds < b.Seconds || a.Seconds == b.Seconds && a.Nseconds < b.Nseconds
+}
+
+func test4a(t *T) {
+	var fhs [][]byte
+	for i := 0; i < t.Options.Files; i++ {
+		fhs = append(fhs, t.create(t.Dir, fmt.Sprintf("file.%d", i), pattern(i, i*100)))
+	}
+	for n := 0; n < t.Options.Count; n++ {
+		for i, fh := range fhs {
+			name := fmt.Sprintf("file.%d", i)
+			attr := t.getattr(fh)
+			res, err := t.C.Lookup(t.Dir, name)
+			t.must("lookup "+name, err)
+			if !bytes.Equal(res.Object, fh) {
+				t.Fatalf("%s: lookup returned another handle", name)
+			}
+			if !res.Attr.IsSet {
+				continue
+			}
+			got := res.Attr.Attr
+			if got.Fileid != attr.Fileid || got.Filesize != attr.Filesize || got.FileMode != attr.FileMode || got.Mtime != attr.Mtime {
+				t.Fatalf("%s: lookup attributes %+v, getattr %+v", name, got, attr)
+			}
+			if got.Filesize != uint64(i*100) {
+				t.Fatalf("%s: size %d, want %d", name, got.Filesize, i*100)
+			}
+		}
+	}
+}
+
+func test5(t *T) {
+	data := pattern(5, t.Options.Size)
+	fh := t.create(t.Dir, "bigfile", nil)
+	for off := 0; off < len(data); off += 8192 {
+		end := off + 8192
+		if end > len(data) {
+			end = len(data)
+		}
+		t.write(fh, uint64(off), data[off:end])
+	}
+	if size := t.getattr(fh).Filesize; size != uint64(len(data)) {
+		t.Fatalf("size %d after writing %d bytes", size, len(data))
+	}
+	for off := 0; off < len(data); off += 8192 {
+		res, err := t.C.Read(fh, uint64(off), 8192)
+		t.must("read", err)
+		end := off + 8192
+		if end > len(data) {
+			end = len(data)
+		}
+		if !bytes.Equal(res.Data, data[off:end]) {
+			t.Fatalf("read at %d: data differs from what was written", off)
+		}
+		if res.EOF != (end == len(data)) {
+			t.Errorf("read at %d: eof %v", off, res.EOF)
+		}
+	}
+	if !bytes.Equal(t.read(fh), data) {
+		t.Errorf("reading in large chunks: data differs from what was written")
+	}
+}
+
+func test6(t *T) {
+	const files = 200
+	want := make(map[string]bool)
+	for i := 0; i < files; i++ {
+		name := fmt.Sprintf("file.%d", i)
+		t.create(t.Dir, name, nil)
+		want[name] = true
+	}
+	for i := 0; i < files; i++ {
+		got := t.names(t.Dir)
+		for name := range want {
+			if !got[name] {
+				t.Fatalf("readdir: %s missing", name)
+			}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("readdir: %d entries, want %d", len(got), len(want))
+		}
+		name := fmt.Sprintf("file.%d", i)
+		_, err := t.C.Remove(t.Dir, name)
+		t.must("remove "+name, err)
+		delete(want, name)
+	}
+	if got := t.names(t.Dir); len(got) != 0 {
+		t.Errorf("readdir: %v left after removing all", got)
+	}
+}
+
+func test7(t *T) {
+	nlink := func(fh []byte, want uint32, what string) {
+		if got := t.getattr(fh).Nlink; got != want {
+			t.Errorf("%s: nlink %d, want %d", what, got, want)
+		}
+	}
+	for i := 0; i < t.Options.Files; i++ {
+		name, newname := fmt.Sprintf("file.%d", i), fmt.Sprintf("newfile.%d", i)
+		fh := t.create(t.Dir, name, pattern(i, 100))
+		_, err := t.C.Rename(t.Dir, name, t.Dir, newname)
+		t.must("rename "+name, err)
+		_, err = t.C.Lookup(t.Dir, name)
+		t.expect("lookup of old name", err, nfs.NFSStatNoent)
+		if !bytes.Equal(t.lookup(t.Dir, newname), fh) {
+			t.Errorf("%s: renamed file has another handle", newname)
+		}
+		nlink(fh, 1, "after rename")
+
+		res, err := t.C.Link(fh, t.Dir, name)
+		t.must("link "+name, err)
+		if res.Attr.IsSet && res.Attr.Attr.Nlink != 2 {
+			t.Errorf("link: post-op nlink %d, want 2", res.Attr.Attr.Nlink)
+		}
+		nlink(fh, 2, "after link")
+
+		_, err = t.C.Remove(t.Dir, newname)
+		t.must("remove "+newname, err)
+		nlink(fh, 1, "after removing a link")
+		if !bytes.Equal(t.read(t.lookup(t.Dir, name)), pattern(i, 100)) {
+			t.Errorf("%s: data lost removing the other link", name)
+		}
+		_, err = t.C.Remove(t.Dir, name)
+		t.must("remove "+name, err)
+	}
+}
+
+func test8(t *T) {
+	t.Skipf("the client has no SYMLINK and READLINK")
+}
+
+func test9(t *T) {
+	for i := 0; i < t.Options.Count; i++ {
+		res, err := t.C.Fsstat(t.Dir)
+		t.must("fsstat", err)
+		if res.Fbytes > res.Tbytes || res.Abytes > res.Fbytes {
+			t.Fatalf("fsstat: bytes total %d, free %d, available %d", res.Tbytes, res.Fbytes, res.Abytes)
+		}
+		if res.Ffiles > res.Tfiles || res.Afiles > res.Ffiles {
+			t.Fatalf("fsstat: files total %d, free %d, available %d", res.Tfiles, res.Ffiles, res.Afiles)
+		}
+	}
+}
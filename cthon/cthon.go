@@ -0,0 +1,357 @@
+// Package cthon ports the Connectathon NFS test suite to the xdrrpc client.
+// Tests run against any server over NFSv3, each in a scratch directory of
+// its own below the mounted directory.
+//
+// The basic tests follow the numbered tests of the original suite, the
+// general ones replace its compile and nroff runs with workloads of the
+// same shape and the special ones check corner cases clients run into.
+package cthon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dzeromsk/xdrrpc/client"
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+// Categories of tests, in the order Run runs them.
+const (
+	Basic   = "basic"
+	General = "general"
+	Special = "special"
+)
+
+// Test is a test of the suite.
+type Test struct {
+	Category string
+	Name     string
+	Desc     string
+	fn       func(t *T)
+}
+
+// Tests lists the whole suite.
+func Tests() []Test {
+	var tests []Test
+	tests = append(tests, basicTests...)
+	tests = append(tests, generalTests...)
+	tests = append(tests, specialTests...)
+	return tests
+}
+
+// Options sizes the tests.
+type Options struct {
+	Files  int // files per directory of test trees
+	Dirs   int // directories per directory of test trees
+	Levels int // depth of test trees
+	Size   int // bytes of files written by read and write tests
+	Count  int // iterations of loops
+
+	// Log receives a line for every test and every failure, if set.
+	Log io.Writer
+}
+
+// DefaultOptions are those of the original suite.
+var DefaultOptions = Options{
+	Files:  5,
+	Dirs:   2,
+	Levels: 2,
+	Size:   1 << 20,
+	Count:  50,
+}
+
+// Result is the outcome of a test.
+type Result struct {
+	Test     Test
+	Errors   []string
+	Skipped  string // reason, empty if the test ran
+	Duration time.Duration
+}
+
+func (r *Result) Failed() bool {
+	return len(r.Errors) > 0
+}
+
+// Run runs the tests of categories, all if none are given, using c. Tests
+// work in a directory created below the mounted one and removed when done.
+func Run(c *client.Client, opts *Options, categories ...string) []Result {
+	if opts == nil {
+		opts = &DefaultOptions
+	}
+	want := make(map[string]bool)
+	for _, cat := range categories {
+		want[cat] = true
+	}
+
+	top := fmt.Sprintf("cthon.%d", time.Now().UnixNano())
+	var results []Result
+	for _, test := range Tests() {
+		if len(want) > 0 && !want[test.Category] {
+			continue
+		}
+		r := run(c, opts, top, test)
+		if opts.Log != nil {
+			switch {
+			case r.Skipped != "":
+				fmt.Fprintf(opts.Log, "SKIP %s/%s: %s\n", test.Category, test.Name, r.Skipped)
+			case r.Failed():
+				fmt.Fprintf(opts.Log, "FAIL %s/%s (%v)\n", test.Category, test.Name, r.Duration)
+				for _, e := range r.Errors {
+					fmt.Fprintf(opts.Log, "\t%s\n", e)
+				}
+			default:
+				fmt.Fprintf(opts.Log, "ok   %s/%s (%v)\n", test.Category, test.Name, r.Duration)
+			}
+		}
+		results = append(results, r)
+	}
+	removeAll(c, top)
+	return results
+}
+
+func run(c *client.Client, opts *Options, top string, test Test) (r Result) {
+	r.Test = test
+	start := time.Now()
+
+	t := &T{C: c, Options: opts, Path: path.Join(top, test.Category+"."+test.Name)}
+	defer func() {
+		switch v := recover().(type) {
+		case nil, failNow:
+		case skipNow:
+			r.Skipped = string(v)
+		default:
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			t.Errorf("panic: %v\n%s", v, buf)
+		}
+		r.Errors = t.errors
+		r.Duration = time.Since(start)
+		removeAll(c, t.Path)
+	}()
+
+	if err := c.MkdirAll(t.Path, 0777); err != nil {
+		t.Fatalf("making test directory: %v", err)
+	}
+	fh, _, err := c.LookupPath(t.Path)
+	if err != nil {
+		t.Fatalf("test directory: %v", err)
+	}
+	t.Dir = fh
+	test.fn(t)
+	return r
+}
+
+// removeAll removes name and everything below it, ignoring errors.
+func removeAll(c *client.Client, name string) {
+	dir, base := path.Split(path.Clean(name))
+	parent, _, err := c.LookupPath(dir)
+	if err != nil {
+		return
+	}
+	fh, attr, err := c.LookupPath(name)
+	if err != nil {
+		return
+	}
+	if attr.Type == nfs.NF3Dir {
+		entries, _ := c.ReadDir(name)
+		for _, e := range entries {
+			if e.Attr != nil && e.Attr.Type != nfs.NF3Dir {
+				c.Remove(fh, e.Name)
+				continue
+			}
+			removeAll(c, path.Join(name, e.Name))
+		}
+		c.Rmdir(parent, base)
+		return
+	}
+	c.Remove(parent, base)
+}
+
+type (
+	failNow struct{}
+	skipNow string
+)
+
+// T is passed to tests, like testing.T. Fatalf and Skipf must be called
+// from the goroutine running the test.
+type T struct {
+	C       *client.Client
+	Options *Options
+	Path    string // scratch directory of the test
+	Dir     []byte // its handle
+
+	mu     sync.Mutex
+	errors []string
+}
+
+func (t *T) Errorf(format string, args ...interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *T) Fatalf(format string, args ...interface{}) {
+	t.Errorf(format, args...)
+	panic(failNow{})
+}
+
+func (t *T) Skipf(format string, args ...interface{}) {
+	panic(skipNow(fmt.Sprintf(format, args...)))
+}
+
+// status returns the NFS status of err, failing for transport errors.
+func (t *T) status(err error) nfs.NFSStat {
+	if err == nil {
+		return nfs.NFSStatOk
+	}
+	var stat nfs.NFSStat
+	if !errors.As(err, &stat) {
+		t.Fatalf("%v", err)
+	}
+	return stat
+}
+
+// must fails the test right away if the call described by what failed.
+func (t *T) must(what string, err error) {
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+}
+
+// expect checks that the call described by what failed with one of want.
+func (t *T) expect(what string, err error, want ...nfs.NFSStat) {
+	got := t.status(err)
+	for _, w := range want {
+		if got == w {
+			return
+		}
+	}
+	names := make([]string, len(want))
+	for i, w := range want {
+		names[i] = w.String()
+	}
+	t.Errorf("%s: got %v, want %s", what, got, strings.Join(names, " or "))
+}
+
+func mode(m uint32) nfs.Sattr3 {
+	return nfs.Sattr3{Mode: nfs.Sattr3Mode{IsSet: true, Mode: m}}
+}
+
+func size(n uint64) nfs.Sattr3 {
+	return nfs.Sattr3{Size: nfs.Sattr3Size{IsSet: true, Size: n}}
+}
+
+// create makes the file name in dir holding data and returns its handle.
+func (t *T) create(dir []byte, name string, data []byte) []byte {
+	res, err := t.C.Create(dir, name, nfs.Createhow3{Mode: 1, GuardedAttr: mode(0666)})
+	t.must("create "+name, err)
+	fh := t.handle(dir, name, res.Handle)
+	if len(data) > 0 {
+		t.write(fh, 0, data)
+	}
+	return fh
+}
+
+// mkdir makes the directory name in dir and returns its handle.
+func (t *T) mkdir(dir []byte, name string) []byte {
+	res, err := t.C.Mkdir(dir, name, mode(0777))
+	t.must("mkdir "+name, err)
+	return t.handle(dir, name, res.Handle)
+}
+
+func (t *T) handle(dir []byte, name string, fh nfs.PostOpFH3) []byte {
+	if fh.IsSet {
+		return fh.FH
+	}
+	return t.lookup(dir, name)
+}
+
+func (t *T) lookup(dir []byte, name string) []byte {
+	res, err := t.C.Lookup(dir, name)
+	t.must("lookup "+name, err)
+	return res.Object
+}
+
+func (t *T) getattr(fh []byte) nfs.Fattr3 {
+	res, err := t.C.Getattr(fh)
+	t.must("getattr", err)
+	return res.Attr
+}
+
+// write writes all of data at off in FILE_SYNC calls.
+func (t *T) write(fh []byte, off uint64, data []byte) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > 64<<10 {
+			n = 64 << 10
+		}
+		res, err := t.C.Write(fh, off, data[:n], nfs.FileSync)
+		t.must("write", err)
+		if res.Count == 0 {
+			t.Fatalf("write: no progress at %d", off)
+		}
+		off += uint64(res.Count)
+		data = data[res.Count:]
+	}
+}
+
+// read returns the whole file.
+func (t *T) read(fh []byte) []byte {
+	var buf bytes.Buffer
+	for {
+		res, err := t.C.Read(fh, uint64(buf.Len()), 64<<10)
+		t.must("read", err)
+		buf.Write(res.Data)
+		if res.EOF || res.Count == 0 {
+			return buf.Bytes()
+		}
+	}
+}
+
+// names returns the entries of dir but "." and "..", failing on
+// duplicates.
+func (t *T) names(dir []byte) map[string]bool {
+	names := make(map[string]bool)
+	var cookie, verf uint64
+	for {
+		res, err := t.C.Readdirplus(dir, cookie, verf, 1024, 8192)
+		t.must("readdirplus", err)
+		for e := res.Reply.Entry; e != nil; e = e.Next {
+			cookie = e.Cookie
+			if e.FileName == "." || e.FileName == ".." {
+				continue
+			}
+			if names[e.FileName] {
+				t.Errorf("readdir: %q listed twice", e.FileName)
+			}
+			names[e.FileName] = true
+		}
+		if res.Reply.EOF {
+			return names
+		}
+		if res.Reply.Entry == nil {
+			t.Fatalf("readdir: empty reply before end")
+		}
+		verf = res.CookieVerf
+	}
+}
+
+// pattern returns n bytes of data depending on seed, to tell files apart.
+func pattern(seed, n int) []byte {
+	b := make([]byte, n)
+	x := uint32(seed)*2654435761 + 1
+	for i := range b {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		b[i] = byte(x)
+	}
+	return b
+}
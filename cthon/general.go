@@ -0,0 +1,234 @@
+package cthon
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"path"
+	"sort"
+
+	"github.com/dzeromsk/xdrrpc/client"
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+var generalTests = []Test{
+	{General, "smallfiles", "many small files in a tree", smallfiles},
+	{General, "bigfile", "unstable writes, commit and random reads", bigfile},
+	{General, "concurrent", "concurrent creates and writes in one directory", concurrent},
+	{General, "copytree", "copy a tree and compare", copytree},
+}
+
+// smallfiles writes, reads back and removes files of a few bytes, like
+// the object files of the compile test of the original suite.
+func smallfiles(t *T) {
+	const dirs, files = 10, 50
+	for i := 0; i < dirs; i++ {
+		dir := t.mkdir(t.Dir, fmt.Sprintf("dir.%d", i))
+		for j := 0; j < files; j++ {
+			t.create(dir, fmt.Sprintf("file.%d", j), []byte(fmt.Sprintf("file %d of %d\n", j, i)))
+		}
+	}
+	n := 0
+	err := t.C.Walk(t.Path, func(name string, attr *nfs.Fattr3, err error) error {
+		if err != nil {
+			return err
+		}
+		if attr.Type != nfs.NF3Reg {
+			return nil
+		}
+		n++
+		var i, j int
+		if _, err := fmt.Sscanf(name, t.Path+"/dir.%d/file.%d", &i, &j); err != nil {
+			t.Errorf("unexpected file %s", name)
+			return nil
+		}
+		data, err := t.C.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if want := fmt.Sprintf("file %d of %d\n", j, i); string(data) != want {
+			t.Errorf("%s: read %q, want %q", name, data, want)
+		}
+		return nil
+	})
+	t.must("walk", err)
+	if n != dirs*files {
+		t.Errorf("walk: %d files, want %d", n, dirs*files)
+	}
+	for i := 0; i < dirs; i++ {
+		name := fmt.Sprintf("dir.%d", i)
+		dir := t.lookup(t.Dir, name)
+		for j := 0; j < files; j++ {
+			_, err := t.C.Remove(dir, fmt.Sprintf("file.%d", j))
+			t.must("remove", err)
+		}
+		_, err := t.C.Rmdir(t.Dir, name)
+		t.must("rmdir "+name, err)
+	}
+}
+
+// bigfile writes a file unstably, commits it and reads it back in chunks
+// of random sizes at random offsets.
+func bigfile(t *T) {
+	size := 8 * t.Options.Size
+	data := pattern(7, size)
+	fh := t.create(t.Dir, "bigfile", nil)
+
+	var verf [8]byte
+	for off := 0; off < size; {
+		end := off + 64<<10
+		if end > size {
+			end = size
+		}
+		res, err := t.C.Write(fh, uint64(off), data[off:end], nfs.Unstable)
+		t.must("write", err)
+		if off == 0 {
+			verf = res.Verf
+		} else if res.Verf != verf {
+			// the server restarted, start over
+			t.Fatalf("write verifier changed while writing")
+		}
+		off += int(res.Count)
+	}
+	res, err := t.C.Commit(fh, 0, 0)
+	t.must("commit", err)
+	if res.Verf != verf {
+		t.Fatalf("commit verifier %x, writes had %x", res.Verf, verf)
+	}
+
+	rnd := rand.New(rand.NewSource(int64(size)))
+	for i := 0; i < t.Options.Count; i++ {
+		off := rnd.Intn(size)
+		count := 1 + rnd.Intn(128<<10)
+		res, err := t.C.Read(fh, uint64(off), uint32(count))
+		t.must("read", err)
+		end := off + count
+		if end > size {
+			end = size
+		}
+		if !bytes.Equal(res.Data, data[off:end]) {
+			t.Fatalf("read of %d bytes at %d: data differs from what was written", count, off)
+		}
+		if res.EOF != (end == size) {
+			t.Errorf("read of %d bytes at %d: eof %v", count, off, res.EOF)
+		}
+	}
+}
+
+// concurrent creates and writes files from several goroutines in the same
+// directory, then checks every one is listed once and holds its data.
+func concurrent(t *T) {
+	const workers, files = 8, 50
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			errs <- func() error {
+				for i := 0; i < files; i++ {
+					name := fmt.Sprintf("file.%d.%d", w, i)
+					res, err := t.C.Create(t.Dir, name, nfs.Createhow3{Mode: 1, GuardedAttr: mode(0644)})
+					if err != nil {
+						return fmt.Errorf("create %s: %v", name, err)
+					}
+					if !res.Handle.IsSet {
+						return fmt.Errorf("create %s: no handle", name)
+					}
+					if _, err := t.C.Write(res.Handle.FH, 0, pattern(w*files+i, 1000), nfs.FileSync); err != nil {
+						return fmt.Errorf("write %s: %v", name, err)
+					}
+				}
+				return nil
+			}()
+		}(w)
+	}
+	for w := 0; w < workers; w++ {
+		if err := <-errs; err != nil {
+			t.Errorf("%v", err)
+		}
+	}
+
+	names := t.names(t.Dir)
+	if len(names) != workers*files {
+		t.Errorf("readdir: %d entries, want %d", len(names), workers*files)
+	}
+	for w := 0; w < workers; w++ {
+		for i := 0; i < files; i++ {
+			name := fmt.Sprintf("file.%d.%d", w, i)
+			if !names[name] {
+				t.Errorf("readdir: %s missing", name)
+				continue
+			}
+			if !bytes.Equal(t.read(t.lookup(t.Dir, name)), pattern(w*files+i, 1000)) {
+				t.Errorf("%s: data differs from what was written", name)
+			}
+		}
+	}
+}
+
+// copytree copies a tree file by file and compares both with Walk.
+func copytree(t *T) {
+	src := path.Join(t.Path, "src")
+	t.must("mkdir", t.C.MkdirAll(src, 0755))
+	for i := 0; i < t.Options.Dirs+1; i++ {
+		dir := path.Join(src, fmt.Sprintf("dir.%d", i), "sub")
+		t.must("mkdir", t.C.MkdirAll(dir, 0755))
+		for j := 0; j < t.Options.Files; j++ {
+			name := path.Join(dir, fmt.Sprintf("file.%d", j))
+			t.must("write "+name, t.C.WriteFile(name, pattern(i*100+j, 100*j+i), 0644))
+		}
+	}
+
+	dst := path.Join(t.Path, "dst")
+	err := t.C.Walk(src, func(name string, attr *nfs.Fattr3, err error) error {
+		if err != nil {
+			return err
+		}
+		to := dst + name[len(src):]
+		if attr.Type == nfs.NF3Dir {
+			return t.C.MkdirAll(to, client.FileMode(attr).Perm())
+		}
+		data, err := t.C.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		return t.C.WriteFile(to, data, client.FileMode(attr).Perm())
+	})
+	t.must("copy", err)
+
+	a, b := t.listing(src), t.listing(dst)
+	if len(a) != len(b) {
+		t.Fatalf("copy has %d objects, source %d", len(b), len(a))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Errorf("copy differs: %s, source %s", b[i], a[i])
+		}
+	}
+}
+
+// listing describes the objects below root by name, type, mode and a
+// digest of their content.
+func (t *T) listing(root string) []string {
+	var lines []string
+	err := t.C.Walk(root, func(name string, attr *nfs.Fattr3, err error) error {
+		if err != nil {
+			return err
+		}
+		line := fmt.Sprintf("%s %v", name[len(root):], client.FileMode(attr))
+		if attr.Type == nfs.NF3Reg {
+			data, err := t.C.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			var sum uint32
+			for _, b := range data {
+				sum = sum*31 + uint32(b)
+			}
+			line += fmt.Sprintf(" %d %08x", len(data), sum)
+		}
+		lines = append(lines, line)
+		return nil
+	})
+	t.must("walk "+root, err)
+	sort.Strings(lines)
+	return lines
+}
@@ -0,0 +1,405 @@
+package cthon
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+var specialTests = []Test{
+	{Special, "wcc", "weak cache consistency data of modifying calls", wcc},
+	{Special, "rename", "rename over existing objects and across directories", rename},
+	{Special, "exclusive", "exclusive create and its retries", exclusive},
+	{Special, "holey", "files with holes", holey},
+	{Special, "truncate", "truncates, extensions and writes", truncate},
+	{Special, "nfsidem", "repeated create, rename, link and remove", nfsidem},
+	{Special, "bigoffset", "offsets past the largest file size", bigoffset},
+	{Special, "stale", "handles of removed objects", stale},
+	{Special, "names", "names at and past the limits", names},
+}
+
+// checkWcc checks w holds attributes of fh from before and after the call
+// described by what.
+func (t *T) checkWcc(what string, w nfs.WccData, before nfs.Fattr3, fh []byte) {
+	if !w.Pre.IsSet {
+		t.Errorf("%s: no pre-op attributes", what)
+	} else if want := (nfs.WccAttr{Size: before.Filesize, Mtime: before.Mtime, Ctime: before.Ctime}); w.Pre.Attr != want {
+		t.Errorf("%s: pre-op attributes %+v, want %+v", what, w.Pre.Attr, want)
+	}
+	t.checkPost(what, w.Post, fh)
+}
+
+// checkPost checks post holds the attributes of fh.
+func (t *T) checkPost(what string, post nfs.PostOpAttr, fh []byte) {
+	if !post.IsSet {
+		t.Errorf("%s: no post-op attributes", what)
+		return
+	}
+	got, want := post.Attr, t.getattr(fh)
+	if got.Fileid != want.Fileid || got.Filesize != want.Filesize || got.FileMode != want.FileMode ||
+		got.Nlink != want.Nlink || got.Mtime != want.Mtime || got.Ctime != want.Ctime {
+		t.Errorf("%s: post-op attributes %+v, getattr %+v", what, got, want)
+	}
+}
+
+func wcc(t *T) {
+	a := t.mkdir(t.Dir, "a")
+	b := t.mkdir(t.Dir, "b")
+
+	before := t.getattr(a)
+	cres, err := t.C.Create(a, "f", nfs.Createhow3{Mode: 1, GuardedAttr: mode(0644)})
+	t.must("create", err)
+	t.checkWcc("create", cres.DirWcc, before, a)
+	f := t.handle(a, "f", cres.Handle)
+	if cres.Attr.IsSet {
+		t.checkPost("create", cres.Attr, f)
+	}
+
+	cres, err = t.C.Create(a, "f", nfs.Createhow3{Mode: 1, GuardedAttr: mode(0644)})
+	t.expect("guarded create of existing file", err, nfs.NFSStatExist)
+	t.checkPost("failed create", cres.DirWcc.Post, a)
+
+	before = t.getattr(a)
+	mres, err := t.C.Mkdir(a, "d", mode(0755))
+	t.must("mkdir", err)
+	t.checkWcc("mkdir", mres.DirWcc, before, a)
+
+	before = t.getattr(f)
+	wres, err := t.C.Write(f, 0, pattern(1, 5000), nfs.Unstable)
+	t.must("write", err)
+	t.checkWcc("write", wres.FileWcc, before, f)
+
+	cmres, err := t.C.Commit(f, 0, 0)
+	t.must("commit", err)
+	t.checkPost("commit", cmres.FileWcc.Post, f)
+
+	before = t.getattr(f)
+	sres, err := t.C.Setattr(f, size(100), nfs.Sattrguard3{})
+	t.must("setattr", err)
+	t.checkWcc("setattr", sres.ObjWcc, before, f)
+
+	before = t.getattr(b)
+	lres, err := t.C.Link(f, b, "g")
+	t.must("link", err)
+	t.checkWcc("link", lres.DirWcc, before, b)
+	t.checkPost("link", lres.Attr, f)
+
+	before, before2 := t.getattr(a), t.getattr(b)
+	rres, err := t.C.Rename(a, "f", b, "h")
+	t.must("rename", err)
+	t.checkWcc("rename source", rres.FromDirWcc, before, a)
+	t.checkWcc("rename target", rres.ToDirWcc, before2, b)
+
+	before = t.getattr(b)
+	rres, err = t.C.Rename(b, "h", b, "i")
+	t.must("rename in place", err)
+	t.checkWcc("rename in place", rres.FromDirWcc, before, b)
+	t.checkPost("rename in place", rres.ToDirWcc.Post, b)
+
+	before = t.getattr(b)
+	rmres, err := t.C.Remove(b, "g")
+	t.must("remove", err)
+	t.checkWcc("remove", rmres.DirWcc, before, b)
+
+	rmres, err = t.C.Remove(b, "g")
+	t.expect("remove of missing file", err, nfs.NFSStatNoent)
+	t.checkPost("failed remove", rmres.DirWcc.Post, b)
+
+	before = t.getattr(a)
+	rdres, err := t.C.Rmdir(a, "d")
+	t.must("rmdir", err)
+	t.checkWcc("rmdir", rdres.DirWcc, before, a)
+}
+
+func rename(t *T) {
+	dir := func(name string) []byte { return t.mkdir(t.Dir, name) }
+	full := dir("full")
+	t.create(full, "x", nil)
+	dir("empty")
+	a := dir("a")
+	t.create(a, "x", []byte("a"))
+	f := t.create(t.Dir, "f", []byte("f"))
+	g := t.create(t.Dir, "g", []byte("g"))
+
+	_, err := t.C.Rename(t.Dir, "f", t.Dir, "a")
+	t.expect("rename file over directory", err, nfs.NFSStatIsdir, nfs.NFSStatExist)
+	_, err = t.C.Rename(t.Dir, "a", t.Dir, "f")
+	t.expect("rename directory over file", err, nfs.NFSStatNotdir, nfs.NFSStatExist)
+	_, err = t.C.Rename(t.Dir, "a", t.Dir, "full")
+	t.expect("rename directory over non-empty directory", err, nfs.NFSStatNotempty, nfs.NFSStatExist)
+	if names := t.names(full); !names["x"] {
+		t.Errorf("non-empty target lost its entries: %v", names)
+	}
+	_, err = t.C.Rename(t.Dir, "a", a, "loop")
+	t.expect("rename directory into itself", err, nfs.NFSStatInval)
+	sub := t.mkdir(a, "sub")
+	_, err = t.C.Rename(t.Dir, "a", sub, "loop")
+	t.expect("rename directory below itself", err, nfs.NFSStatInval)
+	if !bytes.Equal(t.lookup(t.Dir, "a"), a) {
+		t.Fatalf("failed renames moved the directory")
+	}
+
+	_, err = t.C.Rename(t.Dir, "a", t.Dir, "empty")
+	t.must("rename directory over empty directory", err)
+	if !bytes.Equal(t.lookup(t.Dir, "empty"), a) {
+		t.Errorf("rename over empty directory: target is not the source")
+	}
+	if names := t.names(a); !names["x"] || !names["sub"] {
+		t.Errorf("renamed directory lost its entries: %v", names)
+	}
+
+	_, err = t.C.Rename(t.Dir, "f", t.Dir, "g")
+	t.must("rename file over file", err)
+	if got := t.read(t.lookup(t.Dir, "g")); string(got) != "f" {
+		t.Errorf("rename file over file: target holds %q, want %q", got, "f")
+	}
+	if !bytes.Equal(t.lookup(t.Dir, "g"), f) {
+		t.Errorf("rename file over file: target is not the source")
+	}
+	_, err = t.C.Getattr(g)
+	t.expect("getattr of replaced file", err, nfs.NFSStatStale)
+
+	_, err = t.C.Link(f, t.Dir, "link")
+	t.must("link", err)
+	_, err = t.C.Rename(t.Dir, "link", t.Dir, "g")
+	t.must("rename over another link of the same file", err)
+	names := t.names(t.Dir)
+	if !names["g"] || !names["link"] {
+		t.Errorf("rename over another link: entries %v, want both links", names)
+	}
+	if n := t.getattr(f).Nlink; n != 2 {
+		t.Errorf("rename over another link: nlink %d, want 2", n)
+	}
+
+	// moving a directory must update its ".."
+	_, err = t.C.Rename(t.Dir, "empty", full, "moved")
+	t.must("rename directory across directories", err)
+	res, err := t.C.Lookup(a, "..")
+	t.must("lookup ..", err)
+	if got, want := t.getattr(res.Object).Fileid, t.getattr(full).Fileid; got != want {
+		t.Errorf("lookup .. of moved directory: fileid %d, want %d of its new parent", got, want)
+	}
+	_, err = t.C.Rename(full, "moved", t.Dir, "back")
+	t.must("rename directory back", err)
+	res, err = t.C.Lookup(a, "..")
+	t.must("lookup ..", err)
+	if got, want := t.getattr(res.Object).Fileid, t.getattr(t.Dir).Fileid; got != want {
+		t.Errorf("lookup .. of directory moved back: fileid %d, want %d", got, want)
+	}
+}
+
+func exclusive(t *T) {
+	verf := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	how := nfs.Createhow3{Mode: 2, CreateVerf: verf}
+	res, err := t.C.Create(t.Dir, "f", how)
+	t.must("exclusive create", err)
+	fh := t.handle(t.Dir, "f", res.Handle)
+
+	// a retry of the same call, after a lost reply, must succeed
+	res, err = t.C.Create(t.Dir, "f", how)
+	t.must("exclusive create retry", err)
+	if got := t.handle(t.Dir, "f", res.Handle); !bytes.Equal(got, fh) {
+		t.Errorf("exclusive create retry: another handle")
+	}
+
+	how.CreateVerf[0]++
+	_, err = t.C.Create(t.Dir, "f", how)
+	t.expect("exclusive create with another verifier", err, nfs.NFSStatExist)
+
+	// clients set the attributes they could not send
+	_, err = t.C.Setattr(fh, nfs.Sattr3{
+		Mode:  nfs.Sattr3Mode{IsSet: true, Mode: 0640},
+		Mtime: nfs.Sattr3Time{TimeHow: 1},
+	}, nfs.Sattrguard3{})
+	t.must("setattr after exclusive create", err)
+	if m := t.getattr(fh).FileMode & 07777; m != 0640 {
+		t.Errorf("mode %o after setattr, want 640", m)
+	}
+
+	t.write(fh, 0, []byte("data"))
+	_, err = t.C.Create(t.Dir, "f", nfs.Createhow3{Mode: 1, GuardedAttr: mode(0644)})
+	t.expect("guarded create of existing file", err, nfs.NFSStatExist)
+	res, err = t.C.Create(t.Dir, "f", nfs.Createhow3{Mode: 0, UncheckedAttr: mode(0644)})
+	t.must("unchecked create of existing file", err)
+	if got := t.handle(t.Dir, "f", res.Handle); !bytes.Equal(got, fh) {
+		t.Errorf("unchecked create of existing file: another handle")
+	}
+	if got := t.read(fh); string(got) != "data" {
+		t.Errorf("unchecked create without size changed data to %q", got)
+	}
+}
+
+func holey(t *T) {
+	const hole = 1 << 20
+	fh := t.create(t.Dir, "f", nil)
+	data := pattern(3, 100)
+	t.write(fh, hole, data)
+	if size := t.getattr(fh).Filesize; size != hole+100 {
+		t.Fatalf("size %d, want %d", size, hole+100)
+	}
+	got := t.read(fh)
+	if len(got) != hole+100 {
+		t.Fatalf("read %d bytes, want %d", len(got), hole+100)
+	}
+	if !bytes.Equal(got[:hole], make([]byte, hole)) {
+		t.Errorf("hole does not read as zeros")
+	}
+	if !bytes.Equal(got[hole:], data) {
+		t.Errorf("data after the hole differs from what was written")
+	}
+
+	// data cut by a truncate must not come back in a later hole
+	_, err := t.C.Setattr(fh, size(10), nfs.Sattrguard3{})
+	t.must("truncate", err)
+	t.write(fh, 200, data)
+	got = t.read(fh)
+	if !bytes.Equal(got[10:200], make([]byte, 190)) {
+		t.Errorf("hole after truncate holds old data")
+	}
+}
+
+func truncate(t *T) {
+	fh := t.create(t.Dir, "f", nil)
+	var model []byte
+	rnd := rand.New(rand.NewSource(1))
+	for i, n := range []int{0, 100, 65536, 10, 200000, 0, 4096, 1} {
+		_, err := t.C.Setattr(fh, size(uint64(n)), nfs.Sattrguard3{})
+		t.must(fmt.Sprintf("truncate to %d", n), err)
+		if n < len(model) {
+			model = model[:n]
+		} else {
+			model = append(model, make([]byte, n-len(model))...)
+		}
+
+		off, data := rnd.Intn(n+1000), pattern(i, 1+rnd.Intn(3000))
+		t.write(fh, uint64(off), data)
+		if end := off + len(data); end > len(model) {
+			model = append(model, make([]byte, end-len(model))...)
+		}
+		copy(model[off:], data)
+
+		if size := t.getattr(fh).Filesize; size != uint64(len(model)) {
+			t.Fatalf("step %d: size %d, want %d", i, size, len(model))
+		}
+		if !bytes.Equal(t.read(fh), model) {
+			t.Fatalf("step %d: data differs from what was written", i)
+		}
+	}
+}
+
+func nfsidem(t *T) {
+	for i := 0; i < t.Options.Count; i++ {
+		d := t.mkdir(t.Dir, "d")
+		f := t.create(d, "f", pattern(i, 1000))
+		_, err := t.C.Rename(d, "f", d, "g")
+		t.must("rename", err)
+		_, err = t.C.Link(f, d, "h")
+		t.must("link", err)
+		_, err = t.C.Remove(d, "g")
+		t.must("remove", err)
+		if !bytes.Equal(t.read(t.lookup(d, "h")), pattern(i, 1000)) {
+			t.Fatalf("iteration %d: data differs from what was written", i)
+		}
+		_, err = t.C.Setattr(f, mode(0600), nfs.Sattrguard3{})
+		t.must("setattr", err)
+		_, err = t.C.Rmdir(t.Dir, "d")
+		t.expect("rmdir of non-empty directory", err, nfs.NFSStatNotempty, nfs.NFSStatExist)
+		_, err = t.C.Remove(d, "h")
+		t.must("remove", err)
+		_, err = t.C.Rmdir(t.Dir, "d")
+		t.must("rmdir", err)
+	}
+	if names := t.names(t.Dir); len(names) != 0 {
+		t.Errorf("entries left: %v", names)
+	}
+}
+
+func bigoffset(t *T) {
+	info, err := t.C.Fsinfo(t.Dir)
+	t.must("fsinfo", err)
+	fh := t.create(t.Dir, "f", nil)
+
+	if info.Size < 1<<63 {
+		_, err = t.C.Write(fh, info.Size, []byte("x"), nfs.FileSync)
+		t.expect("write past the largest file size", err, nfs.NFSStatFbig)
+		_, err = t.C.Setattr(fh, size(info.Size+1), nfs.Sattrguard3{})
+		t.expect("truncate past the largest file size", err, nfs.NFSStatFbig)
+	}
+	_, err = t.C.Write(fh, 1<<63, []byte("x"), nfs.FileSync)
+	t.expect("write at 2^63", err, nfs.NFSStatFbig, nfs.NFSStatInval)
+	_, err = t.C.Write(fh, 1<<64-1, []byte("x"), nfs.FileSync)
+	t.expect("write at 2^64-1", err, nfs.NFSStatFbig, nfs.NFSStatInval)
+	if size := t.getattr(fh).Filesize; size != 0 {
+		t.Errorf("failed writes left size %d", size)
+	}
+
+	for _, off := range []uint64{1 << 20, 1 << 63, 1<<64 - 1} {
+		res, err := t.C.Read(fh, off, 100)
+		t.must(fmt.Sprintf("read at %d", off), err)
+		if res.Count != 0 || !res.EOF {
+			t.Errorf("read at %d: count %d, eof %v, want 0 and true", off, res.Count, res.EOF)
+		}
+	}
+}
+
+func stale(t *T) {
+	f := t.create(t.Dir, "f", nil)
+	d := t.mkdir(t.Dir, "d")
+	t.create(d, "x", nil)
+	_, err := t.C.Rename(t.Dir, "d", t.Dir, "e")
+	t.must("rename", err)
+	t.getattr(d) // still valid after a rename
+
+	_, err = t.C.Remove(t.Dir, "f")
+	t.must("remove", err)
+	_, err = t.C.Getattr(f)
+	t.expect("getattr of removed file", err, nfs.NFSStatStale)
+	_, err = t.C.Write(f, 0, []byte("x"), nfs.FileSync)
+	t.expect("write to removed file", err, nfs.NFSStatStale)
+
+	_, err = t.C.Remove(d, "x")
+	t.must("remove", err)
+	_, err = t.C.Rmdir(t.Dir, "e")
+	t.must("rmdir", err)
+	_, err = t.C.Getattr(d)
+	t.expect("getattr of removed directory", err, nfs.NFSStatStale)
+	_, err = t.C.Lookup(d, "x")
+	t.expect("lookup in removed directory", err, nfs.NFSStatStale)
+	_, err = t.C.Create(d, "y", nfs.Createhow3{Mode: 1})
+	t.expect("create in removed directory", err, nfs.NFSStatStale)
+}
+
+func names(t *T) {
+	res, err := t.C.Pathconf(t.Dir)
+	t.must("pathconf", err)
+	max := int(res.NameMax)
+	if max == 0 || max > 4096 {
+		t.Skipf("pathconf: name max %d", max)
+	}
+
+	long := strings.Repeat("x", max)
+	t.create(t.Dir, long, nil)
+	if !t.names(t.Dir)[long] {
+		t.Errorf("readdir: name of %d bytes missing", max)
+	}
+	_, err = t.C.Remove(t.Dir, long)
+	t.must("remove of longest name", err)
+
+	if res.NoTrunc {
+		_, err = t.C.Create(t.Dir, long+"x", nfs.Createhow3{Mode: 1})
+		t.expect("create of too long name", err, nfs.NFSStatNametoolong)
+	}
+	_, err = t.C.Create(t.Dir, ".", nfs.Createhow3{Mode: 1})
+	t.expect(`create of "."`, err, nfs.NFSStatExist)
+	_, err = t.C.Mkdir(t.Dir, "..", mode(0755))
+	t.expect(`mkdir of ".."`, err, nfs.NFSStatExist)
+	_, err = t.C.Rmdir(t.Dir, ".")
+	t.expect(`rmdir of "."`, err, nfs.NFSStatInval)
+	_, err = t.C.Remove(t.Dir, "")
+	t.expect("remove of empty name", err, nfs.NFSStatAcces, nfs.NFSStatNoent, nfs.NFSStatInval)
+	_, err = t.C.Lookup(t.Dir, "a/b")
+	t.expect("lookup of name with a slash", err, nfs.NFSStatAcces, nfs.NFSStatNoent, nfs.NFSStatInval)
+}
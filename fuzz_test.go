@@ -0,0 +1,100 @@
+package xdrrpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/rpc"
+	"reflect"
+	"testing"
+)
+
+// maxCalls bounds calls read from a single input.
+const maxCalls = 1000
+
+// pipe feeds a codec with data and discards what it writes.
+type pipe struct{ io.Reader }
+
+func (pipe) Write(p []byte) (int, error) { return len(p), nil }
+func (pipe) Close() error                { return nil }
+
+type call struct {
+	Xid    uint64
+	Method string
+	Body   []byte
+}
+
+// readCalls reads calls from data as a server would, up to the first
+// error.
+func readCalls(data []byte) []call {
+	c := NewServerCodec(pipe{bytes.NewReader(data)}).(*serverCodec)
+	var calls []call
+	for len(calls) < maxCalls {
+		var r rpc.Request
+		if err := c.ReadRequestHeader(&r); err != nil {
+			return calls
+		}
+		body := make([]byte, c.rd.Len())
+		c.rd.Read(body)
+		c.rd.Reset(body)
+		// portmap arguments are the only ones this package knows
+		var args interface{}
+		if r.ServiceMethod == "Portmap.Getport" {
+			args = new(Mapping)
+		}
+		c.ReadRequestBody(args)
+		calls = append(calls, call{r.Seq, r.ServiceMethod, body})
+	}
+	return calls
+}
+
+// fragment returns records of data, up to the first broken one, marked
+// again in fragments of at most size bytes.
+func fragment(data []byte, size int) []byte {
+	var out bytes.Buffer
+	r := bytes.NewReader(data)
+	for i := 0; i < maxCalls; i++ {
+		record, err := readRecord(r)
+		if err != nil {
+			break
+		}
+		for last := false; !last; {
+			n := len(record)
+			if n > size {
+				n = size
+			}
+			last = n == len(record)
+			mark := uint32(n)
+			if last {
+				mark |= 0x80000000
+			}
+			binary.Write(&out, binary.BigEndian, mark)
+			out.Write(record[:n])
+			record = record[n:]
+		}
+	}
+	return out.Bytes()
+}
+
+// FuzzServerCodec checks that the server codec survives any stream and
+// reads the same calls however records are fragmented.
+func FuzzServerCodec(f *testing.F) {
+	// NULL call of portmap v2, in one fragment
+	var null bytes.Buffer
+	for _, v := range []uint32{0x80000028, 1, uint32(Call), 2, PmapProg, PmapVers, 0, 0, 0, 0, 0} {
+		binary.Write(&null, binary.BigEndian, v)
+	}
+	f.Add(null.Bytes(), uint16(3))
+	f.Fuzz(func(t *testing.T, data []byte, size uint16) {
+		calls := readCalls(data)
+		again := readCalls(fragment(data, int(size)+1))
+		if len(calls) != len(again) {
+			t.Fatalf("got %d calls, %d when fragmented", len(calls), len(again))
+		}
+		for i := range calls {
+			if !reflect.DeepEqual(calls[i], again[i]) {
+				t.Fatalf("call %d: got %+v, %+v when fragmented", i, calls[i], again[i])
+			}
+		}
+	})
+}
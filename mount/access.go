@@ -0,0 +1,184 @@
+package mount
+
+import (
+	"net"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+// Authorize enforces options of the export with id export. It implements
+// nfs.Authorizer. The ServeMux only lets handles name exports their object
+// was reached through, see nfs.ServeMux.DecodeHandle.
+func (m *Mount) Authorize(call *xdrrpc.CallInfo, export uint32, write bool) (nfs.Cred, nfs.NFSStat) {
+	m.mu.RLock()
+	var e *Export
+	if export > 0 && int(export) <= len(m.exports) {
+		e = m.exports[export-1]
+	}
+	m.mu.RUnlock()
+
+	if e == nil {
+		// handle was not issued by us
+		return nfs.Cred{}, nfs.NFSStatStale
+	}
+	if stat := m.check(e, call); stat != nfs.NFSStatOk {
+		return nfs.Cred{}, stat
+	}
+	cred := squash(e, call)
+	if write && e.ReadOnly {
+		return cred, nfs.NFSStatRofs
+	}
+	return cred, nfs.NFSStatOk
+}
+
+// check tells whether call is allowed to use e at all.
+func (m *Mount) check(e *Export, call *xdrrpc.CallInfo) nfs.NFSStat {
+	var (
+		flavor = xdrrpc.AuthNone
+		ip     net.IP
+		port   = -1
+	)
+	if call != nil {
+		flavor = call.Cred.Flavor
+		if a, ok := call.RemoteAddr.(*net.TCPAddr); ok {
+			ip, port = a.IP, a.Port
+		}
+		if a, ok := call.RemoteAddr.(*net.UDPAddr); ok {
+			ip, port = a.IP, a.Port
+		}
+	}
+
+	accepted := false
+	for _, f := range e.AuthFlavors {
+		if xdrrpc.AuthFlavor(f) == flavor {
+			accepted = true
+		}
+	}
+	if !accepted {
+		return nfs.NFSStatAcces
+	}
+	if e.Secure && (port < 0 || port >= 1024) {
+		return nfs.NFSStatAcces
+	}
+	if len(e.Clients) > 0 && (ip == nil || !m.allowed(e, ip)) {
+		return nfs.NFSStatAcces
+	}
+	return nfs.NFSStatOk
+}
+
+// squash returns credentials call is served with on e.
+func squash(e *Export, call *xdrrpc.CallInfo) nfs.Cred {
+	anon := nfs.Cred{UID: *e.AnonUID, GID: *e.AnonGID}
+	if call == nil || call.Cred.Flavor != xdrrpc.AuthSys || e.AllSquash {
+		return anon
+	}
+	cred := nfs.CredFromCall(call)
+	if !e.RootSquash {
+		return cred
+	}
+	if cred.UID == 0 {
+		cred.UID = anon.UID
+	}
+	if cred.GID == 0 {
+		cred.GID = anon.GID
+	}
+	gids := make([]uint32, len(cred.GIDs))
+	for i, gid := range cred.GIDs {
+		if gid == 0 {
+			gid = anon.GID
+		}
+		gids[i] = gid
+	}
+	cred.GIDs = gids
+	return cred
+}
+
+// Answers of matchClient are cached for at most maxClients addresses, for
+// clientTTL as host names may resolve to other addresses later.
+const (
+	maxClients = 4096
+	clientTTL  = 5 * time.Minute
+)
+
+type clientKey struct {
+	export *Export
+	ip     string
+}
+
+type clientEntry struct {
+	ok      bool
+	expires time.Time
+}
+
+// allowed tells whether ip matches any of e.Clients. Answers are cached as
+// matching host names takes DNS lookups.
+func (m *Mount) allowed(e *Export, ip net.IP) bool {
+	key := clientKey{export: e, ip: ip.String()}
+	now := time.Now()
+
+	m.mu.RLock()
+	c, cached := m.clients[key]
+	m.mu.RUnlock()
+	if cached && now.Before(c.expires) {
+		return c.ok
+	}
+
+	ok := false
+	for _, client := range e.Clients {
+		if matchClient(client, ip) {
+			ok = true
+			break
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.clients) >= maxClients {
+		for k, c := range m.clients {
+			if !now.Before(c.expires) {
+				delete(m.clients, k)
+			}
+		}
+	}
+	for k := range m.clients {
+		if len(m.clients) < maxClients {
+			break
+		}
+		delete(m.clients, k)
+	}
+	m.clients[key] = clientEntry{ok: ok, expires: now.Add(clientTTL)}
+	return ok
+}
+
+func matchClient(client string, ip net.IP) bool {
+	if client == "*" {
+		return true
+	}
+	if _, network, err := net.ParseCIDR(client); err == nil {
+		return network.Contains(ip)
+	}
+	if addr := net.ParseIP(client); addr != nil {
+		return addr.Equal(ip)
+	}
+	if strings.ContainsAny(client, "*?[") {
+		names, _ := net.LookupAddr(ip.String())
+		for _, name := range names {
+			name = strings.TrimSuffix(name, ".")
+			if ok, _ := path.Match(client, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+	addrs, _ := net.LookupHost(client)
+	for _, addr := range addrs {
+		if net.ParseIP(addr).Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package mount
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+func authSys(uid, gid uint32, gids ...uint32) *xdrrpc.CallInfo {
+	return &xdrrpc.CallInfo{
+		Cred: xdrrpc.NewAuthSys(xdrrpc.AuthSysParms{UID: uid, GID: gid, GIDs: gids}),
+	}
+}
+
+func id(v uint32) *uint32 { return &v }
+
+func TestSquash(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		export Export
+		call   *xdrrpc.CallInfo
+		want   nfs.Cred
+	}{
+		{"no squashing", Export{}, authSys(0, 0, 0), nfs.Cred{UID: 0, GID: 0, GIDs: []uint32{0}}},
+		{"root squashed", Export{RootSquash: true}, authSys(0, 0, 0, 10), nfs.Cred{UID: nfs.Nobody, GID: nfs.Nobody, GIDs: []uint32{nfs.Nobody, 10}}},
+		{"user kept", Export{RootSquash: true}, authSys(1000, 100), nfs.Cred{UID: 1000, GID: 100, GIDs: []uint32{}}},
+		{"all squashed", Export{AllSquash: true, AnonUID: id(500), AnonGID: id(50)}, authSys(1000, 100), nfs.Cred{UID: 500, GID: 50}},
+		{"anonymous root", Export{AllSquash: true, AnonUID: id(0), AnonGID: id(0)}, authSys(1000, 100), nfs.Cred{UID: 0, GID: 0}},
+		{"AUTH_NONE", Export{}, &xdrrpc.CallInfo{}, nfs.Cred{UID: nfs.Nobody, GID: nfs.Nobody}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMount(nfs.NewServeMux())
+			tt.export.Path = "/"
+			m.Handle(tt.export)
+			cred, stat := m.Authorize(tt.call, 1, false)
+			if stat != nfs.NFSStatOk {
+				t.Fatalf("status %v", stat)
+			}
+			if !reflect.DeepEqual(cred, tt.want) {
+				t.Errorf("got %+v, want %+v", cred, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientCacheBounded(t *testing.T) {
+	m := NewMount(nfs.NewServeMux())
+	m.Handle(Export{Path: "/", Clients: []string{"10.0.0.0/8"}})
+	for i := 0; i < 2*maxClients; i++ {
+		ip := net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))
+		call := &xdrrpc.CallInfo{RemoteAddr: &net.TCPAddr{IP: ip, Port: 700}}
+		if _, stat := m.Authorize(call, 1, false); stat != nfs.NFSStatOk {
+			t.Fatalf("%v: status %v", ip, stat)
+		}
+	}
+	if n := len(m.clients); n > maxClients {
+		t.Errorf("%d addresses cached, want at most %d", n, maxClients)
+	}
+	call := &xdrrpc.CallInfo{RemoteAddr: &net.TCPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 700}}
+	if _, stat := m.Authorize(call, 1, false); stat != nfs.NFSStatAcces {
+		t.Errorf("address outside the network: status %v, want %v", stat, nfs.NFSStatAcces)
+	}
+}
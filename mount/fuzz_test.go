@@ -0,0 +1,12 @@
+package mount_test
+
+import (
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc/mount"
+	"github.com/dzeromsk/xdrrpc/nfstest"
+)
+
+func FuzzArgs(f *testing.F) {
+	nfstest.FuzzArgs(f, &mount.Mount{})
+}
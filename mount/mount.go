@@ -1,6 +1,8 @@
 package mount
 
 import (
+	"strconv"
+
 	"github.com/dzeromsk/xdrrpc"
 	"github.com/dzeromsk/xdrrpc/nfs"
 )
@@ -12,6 +14,17 @@ func init() {
 	xdrrpc.Register(100005, 3, 3, "Mount", "Unmount")
 	xdrrpc.Register(100005, 3, 4, "Mount", "UnmountAll")
 	xdrrpc.Register(100005, 3, 5, "Mount", "Export")
+
+	// MOUNT v1 and v2 for NFSv2 clients, v2 only adds PATHCONF
+	for _, vers := range []uint32{1, 2} {
+		xdrrpc.Register(100005, vers, 0, "Mount", "Null")
+		xdrrpc.Register(100005, vers, 1, "Mount", "Mount1")
+		xdrrpc.Register(100005, vers, 2, "Mount", "Dump")
+		xdrrpc.Register(100005, vers, 3, "Mount", "Unmount")
+		xdrrpc.Register(100005, vers, 4, "Mount", "UnmountAll")
+		xdrrpc.Register(100005, vers, 5, "Mount", "Export")
+	}
+	// xdrrpc.Register(100005, 2, 7, "Mount", "Pathconf")
 }
 
 type NullArgs struct{}
@@ -19,11 +32,95 @@ type NullArgs struct{}
 type NullRes struct{}
 
 type MountArgs struct {
+	xdrrpc.Header
 	Dirpath string
 }
 
+type MountStat int32
+
+const (
+	MountOk             MountStat = 0     // no error
+	MountErrPerm        MountStat = 1     // not owner
+	MountErrNoent       MountStat = 2     // no such file or directory
+	MountErrIO          MountStat = 5     // I/O error
+	MountErrAcces       MountStat = 13    // permission denied
+	MountErrNotdir      MountStat = 20    // not a directory
+	MountErrInval       MountStat = 22    // invalid argument
+	MountErrNametoolong MountStat = 63    // filename too long
+	MountErrNotsupp     MountStat = 10004 // operation not supported
+	MountErrServerfault MountStat = 10006 // a failure on the server
+)
+
+var statusNames = map[MountStat]string{
+	MountOk:             "MNT3_OK",
+	MountErrPerm:        "MNT3ERR_PERM",
+	MountErrNoent:       "MNT3ERR_NOENT",
+	MountErrIO:          "MNT3ERR_IO",
+	MountErrAcces:       "MNT3ERR_ACCES",
+	MountErrNotdir:      "MNT3ERR_NOTDIR",
+	MountErrInval:       "MNT3ERR_INVAL",
+	MountErrNametoolong: "MNT3ERR_NAMETOOLONG",
+	MountErrNotsupp:     "MNT3ERR_NOTSUPP",
+	MountErrServerfault: "MNT3ERR_SERVERFAULT",
+}
+
+func (s MountStat) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return "MountStat(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Error makes MountStat an error, for clients failing to mount.
+func (s MountStat) Error() string {
+	return "mount: " + s.String()
+}
+
 type MountRes struct {
-	Status      int32
-	Handle      []byte
-	AuthFlavors []nfs.AuthFlavor
+	Status      MountStat        `xdr:"union"`
+	Handle      []byte           `xdr:"unioncase=0"`
+	AuthFlavors []nfs.AuthFlavor `xdr:"unioncase=0"`
+}
+
+// MountRes1 is the MNT reply of MOUNT v1, with an NFSv2 file handle.
+type MountRes1 struct {
+	Status MountStat    `xdr:"union"`
+	Handle nfs.FHandle2 `xdr:"unioncase=0"`
+}
+
+type DumpRes struct {
+	List *MountBody `xdr:"optional"`
+}
+
+// MountBody is an entry of the list of mounted directories.
+type MountBody struct {
+	Hostname  string
+	Directory string
+	Next      *MountBody `xdr:"optional"`
+}
+
+type UnmountArgs struct {
+	xdrrpc.Header
+	Dirpath string
+}
+
+type UnmountAllArgs struct {
+	xdrrpc.Header
+}
+
+type ExportRes struct {
+	List *ExportNode `xdr:"optional"`
+}
+
+// ExportNode is an entry of the list of exported directories. Groups lists
+// clients allowed to mount Dir, nil meaning everyone.
+type ExportNode struct {
+	Dir    string
+	Groups *GroupNode  `xdr:"optional"`
+	Next   *ExportNode `xdr:"optional"`
+}
+
+type GroupNode struct {
+	Name string
+	Next *GroupNode `xdr:"optional"`
 }
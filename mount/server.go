@@ -0,0 +1,362 @@
+package mount
+
+import (
+	"net"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+// Export is a directory tree clients can mount. The zero value of the
+// options exports read-write to everybody, like exports(5) with rw,
+// no_root_squash and insecure would.
+type Export struct {
+	Path    string      // absolute path clients mount, e.g. "/data"
+	Root    []byte      // object id of the root directory
+	Handler interface{} // handler for Root, see nfs.ServeMux
+
+	// Clients lists hosts allowed to use the export: IP addresses,
+	// networks in CIDR notation, host names or wildcards such as
+	// "*.example.com". Everybody if empty.
+	Clients []string
+
+	ReadOnly   bool    // modifications fail with NFSStatRofs
+	RootSquash bool    // map uid and gid 0 to the anonymous ids
+	AllSquash  bool    // map all users to the anonymous ids
+	AnonUID    *uint32 // anonymous uid, nfs.Nobody if nil
+	AnonGID    *uint32 // anonymous gid, nfs.Nobody if nil
+
+	// Secure requires calls to come from ports below 1024.
+	Secure bool
+
+	// AuthFlavors accepted, in order of preference, and reported to
+	// clients on mount. AUTH_SYS and AUTH_NONE if empty.
+	AuthFlavors []nfs.AuthFlavor
+}
+
+// Mount answers MOUNT requests for exports registered with Handle. Clients
+// may mount any directory below an export, too.
+type Mount struct {
+	mux nfs.ServeMux
+
+	mu      sync.RWMutex
+	exports []*Export // export id is index + 1
+	mounts  []mountEntry
+	clients map[clientKey]clientEntry // cached results of matchClient
+}
+
+// mountEntry records a successful MNT, for DUMP.
+type mountEntry struct {
+	host    string
+	dirpath string
+}
+
+// NewMount returns a Mount serving exports through mux. It also makes mux
+// enforce options of those exports, see Authorize.
+func NewMount(mux nfs.ServeMux) *Mount {
+	m := &Mount{
+		mux:     mux,
+		clients: map[clientKey]clientEntry{},
+	}
+	mux.SetAuthorizer(m)
+	return m
+}
+
+// Handle registers e and its root handler with the ServeMux. It panics if
+// e.Path is not absolute, already exported, or nested deeper than the
+// root directory of another export, which NFSv4 clients could not reach.
+func (m *Mount) Handle(e Export) {
+	if !path.IsAbs(e.Path) {
+		panic("mount: export path must be absolute: " + e.Path)
+	}
+	e.Path = path.Clean(e.Path)
+	e.AnonUID = anonID(e.AnonUID)
+	e.AnonGID = anonID(e.AnonGID)
+	if len(e.AuthFlavors) == 0 {
+		e.AuthFlavors = []nfs.AuthFlavor{nfs.AuthSys, nfs.AuthNone}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, x := range m.exports {
+		if x.Path == e.Path {
+			panic("mount: path already exported: " + e.Path)
+		}
+	}
+	m.mux.Export(e.Path, uint32(len(m.exports)+1), e.Root)
+	m.mux.Handle(e.Root, e.Handler)
+	m.exports = append(m.exports, &e)
+}
+
+// anonID returns a copy of id, nfs.Nobody if nil, so that callers of
+// Handle cannot change it later.
+func anonID(id *uint32) *uint32 {
+	v := uint32(nfs.Nobody)
+	if id != nil {
+		v = *id
+	}
+	return &v
+}
+
+// Exports returns registered exports indexed by export id minus one.
+func (m *Mount) Exports() []Export {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	exports := make([]Export, len(m.exports))
+	for i, e := range m.exports {
+		exports[i] = *e
+	}
+	return exports
+}
+
+// Lookup returns the export dirpath belongs to and its id, for side
+// protocols naming files by path.
+func (m *Mount) Lookup(dirpath string) (Export, uint32, bool) {
+	if !path.IsAbs(dirpath) {
+		return Export{}, 0, false
+	}
+	e, id, _ := m.lookupExport(path.Clean(dirpath))
+	if e == nil {
+		return Export{}, 0, false
+	}
+	return *e, id, true
+}
+
+// lookupExport returns the export dirpath belongs to, its id and the path
+// of dirpath relative to the export root.
+func (m *Mount) lookupExport(dirpath string) (*Export, uint32, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var (
+		best *Export
+		id   uint32
+		rest string
+	)
+	for i, e := range m.exports {
+		var r string
+		switch {
+		case dirpath == e.Path:
+			r = ""
+		case e.Path == "/":
+			r = dirpath[1:]
+		case strings.HasPrefix(dirpath, e.Path+"/"):
+			r = dirpath[len(e.Path)+1:]
+		default:
+			continue
+		}
+		if best == nil || len(e.Path) > len(best.Path) {
+			best, id, rest = e, uint32(i+1), r
+		}
+	}
+	return best, id, rest
+}
+
+// walk looks up rest one component at a time starting at the export root
+// and returns the object id of the directory it names.
+func (m *Mount) walk(e *Export, rest string) ([]byte, MountStat) {
+	object := e.Root
+	if rest == "" {
+		return object, MountOk
+	}
+	for _, name := range strings.Split(rest, "/") {
+		node, ok := m.mux.Load(object)
+		if !ok {
+			return nil, MountErrServerfault
+		}
+		dir, ok := node.(nfs.Lookuper)
+		if !ok {
+			return nil, MountErrNotdir
+		}
+		var res nfs.LOOKUP3res
+		if err := dir.Lookup(name, &res); err != nil {
+			res.Status = nfs.StatusFromError(err)
+		}
+		if res.Status != nfs.NFSStatOk {
+			return nil, mountStat(res.Status)
+		}
+		if res.Attr.IsSet && res.Attr.Attr.Type != nfs.NF3Dir {
+			return nil, MountErrNotdir
+		}
+		object = res.Object
+	}
+	return object, MountOk
+}
+
+// mountStat converts status of a failed lookup.
+func mountStat(stat nfs.NFSStat) MountStat {
+	switch stat {
+	case nfs.NFSStatPerm, nfs.NFSStatNoent, nfs.NFSStatIo,
+		nfs.NFSStatAcces, nfs.NFSStatNotdir, nfs.NFSStatInval,
+		nfs.NFSStatNametoolong, nfs.NFSStatNotsupp:
+		return MountStat(stat)
+	}
+	return MountErrServerfault
+}
+
+func (m *Mount) Null(args *NullArgs, res *NullRes) error {
+	return nil
+}
+
+// Mount returns the handle of args.Dirpath. Paths outside of any export are
+// refused with MountErrAcces, like Linux mountd does, while missing
+// directories inside an export get MountErrNoent.
+func (m *Mount) Mount(args *MountArgs, res *MountRes) error {
+	fh, e, stat := m.mount(args)
+	res.Status = stat
+	if stat == MountOk {
+		res.Handle = fh
+		res.AuthFlavors = e.AuthFlavors
+	}
+	return nil
+}
+
+// Mount1 is Mount for MOUNT v1 and v2 clients, which take NFSv2 handles.
+// Their statuses are errnos, so failures MOUNT v3 added become
+// MountErrIO, as do handles too long for NFSv2.
+func (m *Mount) Mount1(args *MountArgs, res *MountRes1) error {
+	fh, _, stat := m.mount(args)
+	if stat != MountOk {
+		res.Status = stat
+		if stat == MountErrNotsupp || stat == MountErrServerfault {
+			res.Status = MountErrIO
+		}
+		return nil
+	}
+	h, ok := nfs.NewFHandle2(fh)
+	if !ok {
+		res.Status = MountErrIO
+		return nil
+	}
+	res.Status = MountOk
+	res.Handle = h
+	return nil
+}
+
+// mount resolves args.Dirpath to a file handle and records the mount.
+func (m *Mount) mount(args *MountArgs) ([]byte, *Export, MountStat) {
+	if !path.IsAbs(args.Dirpath) {
+		return nil, nil, MountErrInval
+	}
+	dirpath := path.Clean(args.Dirpath)
+
+	e, id, rest := m.lookupExport(dirpath)
+	if e == nil {
+		return nil, nil, MountErrAcces
+	}
+	if stat := m.check(e, args.Call()); stat != nfs.NFSStatOk {
+		return nil, nil, MountErrAcces
+	}
+
+	object, stat := m.walk(e, rest)
+	if stat != MountOk {
+		return nil, nil, stat
+	}
+
+	fh, err := m.mux.EncodeHandle(nfs.FileHandle{
+		Export: id,
+		Object: object,
+	})
+	if err != nil {
+		return nil, nil, MountErrServerfault
+	}
+
+	m.addMount(host(args.Call()), dirpath)
+	return fh, e, MountOk
+}
+
+// host names the client making call in the mount table.
+func host(call *xdrrpc.CallInfo) string {
+	if call == nil {
+		return ""
+	}
+	if call.RemoteAddr != nil {
+		h, _, err := net.SplitHostPort(call.RemoteAddr.String())
+		if err == nil {
+			return h
+		}
+	}
+	if p, err := call.AuthSys(); err == nil {
+		return p.MachineName
+	}
+	return ""
+}
+
+func (m *Mount) addMount(host, dirpath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.mounts {
+		if e.host == host && e.dirpath == dirpath {
+			return
+		}
+	}
+	m.mounts = append(m.mounts, mountEntry{host: host, dirpath: dirpath})
+}
+
+// removeMounts deletes mount table entries of host, all of them if
+// dirpath is empty.
+func (m *Mount) removeMounts(host, dirpath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mounts := m.mounts[:0]
+	for _, e := range m.mounts {
+		if e.host == host && (dirpath == "" || e.dirpath == dirpath) {
+			continue
+		}
+		mounts = append(mounts, e)
+	}
+	m.mounts = mounts
+}
+
+// Dump lists mounts recorded by Mount and not yet removed by Unmount or
+// UnmountAll.
+func (m *Mount) Dump(args *NullArgs, res *DumpRes) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := len(m.mounts) - 1; i >= 0; i-- {
+		res.List = &MountBody{
+			Hostname:  m.mounts[i].host,
+			Directory: m.mounts[i].dirpath,
+			Next:      res.List,
+		}
+	}
+	return nil
+}
+
+func (m *Mount) Unmount(args *UnmountArgs, res *NullRes) error {
+	m.removeMounts(host(args.Call()), path.Clean(args.Dirpath))
+	return nil
+}
+
+func (m *Mount) UnmountAll(args *UnmountAllArgs, res *NullRes) error {
+	m.removeMounts(host(args.Call()), "")
+	return nil
+}
+
+// Export lists exported directories and clients allowed to mount them.
+func (m *Mount) Export(args *NullArgs, res *ExportRes) error {
+	exports := m.Exports()
+	for i := len(exports) - 1; i >= 0; i-- {
+		node := &ExportNode{
+			Dir:  exports[i].Path,
+			Next: res.List,
+		}
+		clients := exports[i].Clients
+		for j := len(clients) - 1; j >= 0; j-- {
+			node.Groups = &GroupNode{
+				Name: clients[j],
+				Next: node.Groups,
+			}
+		}
+		res.List = node
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package mount_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/mount"
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+// dir is a directory with nothing in it.
+type dir struct{}
+
+func (dir) Attr() nfs.Fattr3 {
+	return nfs.Fattr3{Type: nfs.NF3Dir, FileMode: 0755, Nlink: 2, Fileid: 1}
+}
+
+func TestMount1(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		opts    []nfs.Option
+		dirpath string
+		want    mount.MountStat
+	}{
+		{"short handle", nil, "/data", mount.MountOk},
+		{"signed handle", []nfs.Option{nfs.WithHandleCodec(nfs.NewSignedCodec(nfs.DefaultCodec, []byte("secret")))}, "/data", mount.MountErrIO},
+		{"outside exports", nil, "/other", mount.MountErrAcces},
+		{"relative path", nil, "data", mount.MountErrInval},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mount.NewMount(nfs.NewServeMux(tt.opts...))
+			m.Handle(mount.Export{Path: "/data", Root: []byte("root"), Handler: dir{}})
+
+			var res1 mount.MountRes1
+			m.Mount1(&mount.MountArgs{Dirpath: tt.dirpath}, &res1)
+			if res1.Status != tt.want {
+				t.Fatalf("MOUNT v1: got %v, want %v", res1.Status, tt.want)
+			}
+			var res mount.MountRes
+			m.Mount(&mount.MountArgs{Dirpath: tt.dirpath}, &res)
+			if tt.want == mount.MountOk && string(res1.Handle.Handle()) != string(res.Handle) {
+				t.Errorf("MOUNT v1 handle %x, v3 handle %x", res1.Handle.Handle(), res.Handle)
+			}
+		})
+	}
+}
+
+// tree is a directory holding a directory "sub" and a file "file".
+type tree struct{ dir }
+
+func (tree) Lookup(name string, res *nfs.LOOKUP3res) error {
+	switch name {
+	case "sub":
+		res.Attr = nfs.NewPostOpAttr(nfs.Fattr3{Type: nfs.NF3Dir})
+	case "file":
+		res.Attr = nfs.NewPostOpAttr(nfs.Fattr3{Type: nfs.NF3Reg})
+	default:
+		res.Status = nfs.NFSStatNoent
+		return nil
+	}
+	res.Status = nfs.NFSStatOk
+	res.Object = []byte(name)
+	return nil
+}
+
+func from(ip string) *xdrrpc.CallInfo {
+	return &xdrrpc.CallInfo{RemoteAddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 700}}
+}
+
+func TestMountPaths(t *testing.T) {
+	mux := nfs.NewServeMux()
+	mux.Handle([]byte("sub"), dir{})
+	m := mount.NewMount(mux)
+	m.Handle(mount.Export{Path: "/data", Root: []byte("data"), Handler: tree{}})
+	m.Handle(mount.Export{Path: "/data/logs", Root: []byte("logs"), Handler: dir{}})
+	m.Handle(mount.Export{Path: "/private", Root: []byte("private"), Handler: dir{}, Clients: []string{"10.0.0.0/8"}})
+
+	for _, tt := range []struct {
+		dirpath string
+		call    *xdrrpc.CallInfo
+		want    mount.MountStat
+		export  uint32
+		object  string
+	}{
+		{"/data", nil, mount.MountOk, 1, "data"},
+		{"/data/sub", nil, mount.MountOk, 1, "sub"},
+		{"/data/sub/../sub/", nil, mount.MountOk, 1, "sub"},
+		{"/data/logs", nil, mount.MountOk, 2, "logs"},
+		{"/data/missing", nil, mount.MountErrNoent, 0, ""},
+		{"/data/file", nil, mount.MountErrNotdir, 0, ""},
+		{"/other", nil, mount.MountErrAcces, 0, ""},
+		{"/private", from("10.1.2.3"), mount.MountOk, 3, "private"},
+		{"/private", from("192.0.2.1"), mount.MountErrAcces, 0, ""},
+	} {
+		var res mount.MountRes
+		args := &mount.MountArgs{Dirpath: tt.dirpath}
+		args.SetCall(tt.call)
+		m.Mount(args, &res)
+		if res.Status != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.dirpath, res.Status, tt.want)
+			continue
+		}
+		if res.Status != mount.MountOk {
+			continue
+		}
+		fh, err := mux.DecodeHandle(res.Handle)
+		if err != nil {
+			t.Errorf("%s: %v", tt.dirpath, err)
+			continue
+		}
+		if fh.Export != tt.export || string(fh.Object) != tt.object {
+			t.Errorf("%s: got export %d object %q, want %d %q", tt.dirpath, fh.Export, fh.Object, tt.export, tt.object)
+		}
+	}
+}
+
+// dump returns the mount table as host:dirpath.
+func dump(m *mount.Mount) []string {
+	var res mount.DumpRes
+	m.Dump(&mount.NullArgs{}, &res)
+	var mounts []string
+	for b := res.List; b != nil; b = b.Next {
+		mounts = append(mounts, b.Hostname+":"+b.Directory)
+	}
+	return mounts
+}
+
+func TestMountTable(t *testing.T) {
+	m := mount.NewMount(nfs.NewServeMux())
+	m.Handle(mount.Export{Path: "/data", Root: []byte("data"), Handler: dir{}})
+	m.Handle(mount.Export{Path: "/logs", Root: []byte("logs"), Handler: dir{}})
+	mnt := func(call *xdrrpc.CallInfo, dirpath string) {
+		args := &mount.MountArgs{Dirpath: dirpath}
+		args.SetCall(call)
+		var res mount.MountRes
+		if m.Mount(args, &res); res.Status != mount.MountOk {
+			t.Fatalf("MNT %s: %v", dirpath, res.Status)
+		}
+	}
+	a, b := from("192.0.2.1"), from("192.0.2.2")
+	mnt(a, "/data")
+	mnt(a, "/logs")
+	mnt(a, "/data")
+	mnt(b, "/data")
+	if got, want := fmt.Sprint(dump(m)), "[192.0.2.1:/data 192.0.2.1:/logs 192.0.2.2:/data]"; got != want {
+		t.Errorf("DUMP after MNT: got %s, want %s", got, want)
+	}
+
+	umnt := &mount.UnmountArgs{Dirpath: "/data/"}
+	umnt.SetCall(a)
+	m.Unmount(umnt, &mount.NullRes{})
+	if got, want := fmt.Sprint(dump(m)), "[192.0.2.1:/logs 192.0.2.2:/data]"; got != want {
+		t.Errorf("DUMP after UMNT: got %s, want %s", got, want)
+	}
+
+	umntall := &mount.UnmountAllArgs{}
+	umntall.SetCall(b)
+	m.UnmountAll(umntall, &mount.NullRes{})
+	if got, want := fmt.Sprint(dump(m)), "[192.0.2.1:/logs]"; got != want {
+		t.Errorf("DUMP after UMNTALL: got %s, want %s", got, want)
+	}
+}
+
+func TestExportList(t *testing.T) {
+	m := mount.NewMount(nfs.NewServeMux())
+	m.Handle(mount.Export{Path: "/data", Root: []byte("data"), Handler: dir{}})
+	m.Handle(mount.Export{Path: "/private", Root: []byte("private"), Handler: dir{}, Clients: []string{"10.0.0.0/8", "*.example.com"}})
+
+	var res mount.ExportRes
+	m.Export(&mount.NullArgs{}, &res)
+	var exports []string
+	for e := res.List; e != nil; e = e.Next {
+		export := e.Dir
+		for g := e.Groups; g != nil; g = g.Next {
+			export += " " + g.Name
+		}
+		exports = append(exports, export)
+	}
+	if got, want := fmt.Sprintf("%q", exports), `["/data" "/private 10.0.0.0/8 *.example.com"]`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestHandleNested(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		first      string
+		second     string
+		wantPanics bool
+	}{
+		{"in root directory", "/data", "/data/logs", false},
+		{"in root export", "/", "/data", false},
+		{"deeper", "/data", "/data/logs/old", true},
+		{"deeper below root", "/", "/data/logs", true},
+		{"above deeper", "/data/logs/old", "/data", true},
+		{"sharing a prefix", "/data", "/database/logs", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mount.NewMount(nfs.NewServeMux())
+			m.Handle(mount.Export{Path: tt.first, Root: []byte("first"), Handler: dir{}})
+			panicked := func() (panicked bool) {
+				defer func() { panicked = recover() != nil }()
+				m.Handle(mount.Export{Path: tt.second, Root: []byte("second"), Handler: dir{}})
+				return false
+			}()
+			if panicked != tt.wantPanics {
+				t.Fatalf("Handle(%q) after %q: panicked %v, want %v", tt.second, tt.first, panicked, tt.wantPanics)
+			}
+			var res mount.ExportRes
+			m.Export(&mount.NullArgs{}, &res)
+			n := 0
+			for e := res.List; e != nil; e = e.Next {
+				n++
+			}
+			want := 2
+			if tt.wantPanics {
+				want = 1
+			}
+			if n != want {
+				t.Errorf("%d exports listed, want %d", n, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package nfs
+
+// POSIX ACL entry tags, see acl(5).
+const (
+	ACLUserObj  = 0x01
+	ACLUser     = 0x02
+	ACLGroupObj = 0x04
+	ACLGroup    = 0x08
+	ACLMask     = 0x10
+	ACLOther    = 0x20
+)
+
+// ACE is an entry of a POSIX ACL. ID is only meaningful for ACLUser and
+// ACLGroup entries. Perm holds rwx bits.
+type ACE struct {
+	Tag  uint32
+	ID   uint32
+	Perm uint32
+}
+
+// ACL is a POSIX access or default ACL. An access ACL with only the owner,
+// group and other entries is equivalent to the mode bits and may be left
+// empty.
+type ACL []ACE
+
+// ACLer is implemented by handlers storing POSIX ACLs. ServeMux checks
+// permissions against the access ACL and makes new children of directories
+// inherit their default ACL. SetACL must keep the mode bits in sync with
+// the access ACL, see ACL.Mode.
+type ACLer interface {
+	ACL() (access, def ACL)
+	SetACL(access, def ACL) error
+}
+
+// extended tells whether a has entries the mode bits cannot express.
+func (a ACL) extended() bool {
+	for _, e := range a {
+		switch e.Tag {
+		case ACLUser, ACLGroup, ACLMask:
+			return true
+		}
+	}
+	return false
+}
+
+// Valid tells whether a has exactly one owner, group and other entry, a
+// mask entry if it has named user or group entries, and no duplicate
+// names.
+func (a ACL) Valid() bool {
+	var (
+		count = map[uint32]int{}
+		users = map[uint32]bool{}
+		group = map[uint32]bool{}
+	)
+	for _, e := range a {
+		switch e.Tag {
+		case ACLUser:
+			if users[e.ID] {
+				return false
+			}
+			users[e.ID] = true
+		case ACLGroup:
+			if group[e.ID] {
+				return false
+			}
+			group[e.ID] = true
+		case ACLUserObj, ACLGroupObj, ACLMask, ACLOther:
+		default:
+			return false
+		}
+		if e.Perm&^7 != 0 {
+			return false
+		}
+		count[e.Tag]++
+	}
+	if count[ACLUserObj] != 1 || count[ACLGroupObj] != 1 || count[ACLOther] != 1 || count[ACLMask] > 1 {
+		return false
+	}
+	return count[ACLMask] == 1 || len(users)+len(group) == 0
+}
+
+// groupTag returns the tag of the entry holding the group class bits of
+// the mode: the mask if there is one.
+func (a ACL) groupTag() uint32 {
+	for _, e := range a {
+		if e.Tag == ACLMask {
+			return ACLMask
+		}
+	}
+	return ACLGroupObj
+}
+
+// Mode returns the permission bits of the mode equivalent to a.
+func (a ACL) Mode() uint32 {
+	var mode uint32
+	group := a.groupTag()
+	for _, e := range a {
+		switch e.Tag {
+		case ACLUserObj:
+			mode |= e.Perm << 6
+		case group:
+			mode |= e.Perm << 3
+		case ACLOther:
+			mode |= e.Perm
+		}
+	}
+	return mode
+}
+
+// WithMode returns a copy of a with the owner, group class and other
+// entries set to the permission bits of mode, as chmod does.
+func (a ACL) WithMode(mode uint32) ACL {
+	b := make(ACL, len(a))
+	group := a.groupTag()
+	for i, e := range a {
+		switch e.Tag {
+		case ACLUserObj:
+			e.Perm = mode >> 6 & 7
+		case group:
+			e.Perm = mode >> 3 & 7
+		case ACLOther:
+			e.Perm = mode & 7
+		}
+		b[i] = e
+	}
+	return b
+}
+
+// inherited returns the access ACL of an object created with mode in a
+// directory with default ACL def: def with the owner, group class and
+// other entries restricted to mode.
+func inherited(def ACL, mode uint32) ACL {
+	return def.WithMode(mode & def.Mode())
+}
+
+// aclOf returns the access ACL of node, if it has one beyond the mode
+// bits.
+func aclOf(node interface{}) ACL {
+	n, ok := node.(ACLer)
+	if !ok {
+		return nil
+	}
+	access, _ := n.ACL()
+	if !access.extended() {
+		return nil
+	}
+	return access
+}
+
+// aclGrants tells whether a grants c all of the rwx bits want, following
+// acl(5): the owner entry applies to the owner, named user entries to
+// those users, group entries to members of those groups and the other
+// entry to everybody else. Members of several groups need a single group
+// entry granting all of want. All but the owner and other entries are
+// limited by the mask.
+func aclGrants(a ACL, attr Fattr3, c Cred, want uint32) bool {
+	mask := uint32(7)
+	for _, e := range a {
+		if e.Tag == ACLMask {
+			mask = e.Perm
+		}
+	}
+	for _, e := range a {
+		if e.Tag == ACLUserObj && c.UID == attr.UID {
+			return e.Perm&want == want
+		}
+	}
+	for _, e := range a {
+		if e.Tag == ACLUser && c.UID == e.ID {
+			return e.Perm&mask&want == want
+		}
+	}
+	matched := false
+	for _, e := range a {
+		if e.Tag == ACLGroupObj && c.inGroup(attr.GID) || e.Tag == ACLGroup && c.inGroup(e.ID) {
+			if e.Perm&mask&want == want {
+				return true
+			}
+			matched = true
+		}
+	}
+	if matched {
+		return false
+	}
+	for _, e := range a {
+		if e.Tag == ACLOther {
+			return e.Perm&want == want
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package nfs
+
+import "testing"
+
+func TestACLPermits(t *testing.T) {
+	attr := Fattr3{Type: NF3Reg, FileMode: 0640, UID: 1, GID: 10}
+	acl := ACL{
+		{Tag: ACLUserObj, Perm: 6},
+		{Tag: ACLUser, ID: 2, Perm: 7},
+		{Tag: ACLGroupObj, Perm: 4},
+		{Tag: ACLGroup, ID: 20, Perm: 2},
+		{Tag: ACLGroup, ID: 30, Perm: 6},
+		{Tag: ACLMask, Perm: 6},
+		{Tag: ACLOther, Perm: 0},
+	}
+	for _, tt := range []struct {
+		name string
+		cred Cred
+		want uint32
+		ok   bool
+	}{
+		{"owner", Cred{UID: 1}, mayRead | mayWrite, true},
+		{"named user", Cred{UID: 2}, mayRead | mayWrite, true},
+		{"named user masked", Cred{UID: 2}, mayExec, false},
+		{"owning group", Cred{UID: 3, GID: 10}, mayRead, true},
+		{"bits of two groups", Cred{UID: 3, GID: 10, GIDs: []uint32{20}}, mayRead | mayWrite, false},
+		{"read of two groups", Cred{UID: 3, GID: 10, GIDs: []uint32{20}}, mayRead, true},
+		{"write of two groups", Cred{UID: 3, GID: 10, GIDs: []uint32{20}}, mayWrite, true},
+		{"one group granting all", Cred{UID: 3, GID: 20, GIDs: []uint32{30}}, mayRead | mayWrite, true},
+		{"group masked", Cred{UID: 3, GID: 30}, mayExec, false},
+		{"group does not fall back to other", Cred{UID: 3, GID: 20}, mayRead, false},
+		{"other", Cred{UID: 3}, mayRead, false},
+		{"root", Cred{UID: 0}, mayRead | mayWrite, true},
+		{"root exec", Cred{UID: 0}, mayExec, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if ok := permits(attr, acl, tt.cred, tt.want); ok != tt.ok {
+				t.Errorf("permits %o: got %v, want %v", tt.want, ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestAccessACL(t *testing.T) {
+	attr := Fattr3{Type: NF3Reg, FileMode: 0660, UID: 1, GID: 10}
+	acl := ACL{
+		{Tag: ACLUserObj, Perm: 6},
+		{Tag: ACLGroupObj, Perm: 4},
+		{Tag: ACLGroup, ID: 20, Perm: 2},
+		{Tag: ACLMask, Perm: 6},
+		{Tag: ACLOther, Perm: 0},
+	}
+	c := Cred{UID: 3, GID: 10, GIDs: []uint32{20}}
+	want := uint32(AccessRead | AccessModify | AccessExtend)
+	if got := access(attr, acl, c); got != want {
+		t.Errorf("got %#x, want %#x", got, want)
+	}
+}
@@ -0,0 +1,284 @@
+package nfs
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/dzeromsk/xdrrpc"
+	xdr "github.com/rasky/go-xdr/xdr2"
+)
+
+// NFSv4 attribute numbers we support.
+const (
+	Attr4SupportedAttrs  = 0
+	Attr4Type            = 1
+	Attr4FhExpireType    = 2
+	Attr4Change          = 3
+	Attr4Size            = 4
+	Attr4LinkSupport     = 5
+	Attr4SymlinkSupport  = 6
+	Attr4NamedAttr       = 7
+	Attr4Fsid            = 8
+	Attr4UniqueHandles   = 9
+	Attr4LeaseTime       = 10
+	Attr4RdattrError     = 11
+	Attr4Filehandle      = 19
+	Attr4Fileid          = 20
+	Attr4Maxfilesize     = 27
+	Attr4Maxlink         = 28
+	Attr4Maxname         = 29
+	Attr4Maxread         = 30
+	Attr4Maxwrite        = 31
+	Attr4Mode            = 33
+	Attr4Numlinks        = 35
+	Attr4Owner           = 36
+	Attr4OwnerGroup      = 37
+	Attr4Rawdev          = 41
+	Attr4SpaceUsed       = 45
+	Attr4TimeAccess      = 47
+	Attr4TimeAccessSet   = 48
+	Attr4TimeMetadata    = 52
+	Attr4TimeModify      = 53
+	Attr4TimeModifySet   = 54
+	Attr4MountedOnFileid = 55
+)
+
+// NewBitmap4 returns the set of attrs.
+func NewBitmap4(attrs ...int) Bitmap4 {
+	var b Bitmap4
+	for _, a := range attrs {
+		b.Set(a)
+	}
+	return b
+}
+
+// Has tells whether attr is in b.
+func (b Bitmap4) Has(attr int) bool {
+	return attr/32 < len(b) && b[attr/32]&(1<<uint(attr%32)) != 0
+}
+
+// Set adds attr to b.
+func (b *Bitmap4) Set(attr int) {
+	for len(*b) <= attr/32 {
+		*b = append(*b, 0)
+	}
+	(*b)[attr/32] |= 1 << uint(attr%32)
+}
+
+// attrs returns attribute numbers in b in increasing order.
+func (b Bitmap4) attrs() []int {
+	var attrs []int
+	for w, word := range b {
+		for bit := 0; bit < 32; bit++ {
+			if word&(1<<uint(bit)) != 0 {
+				attrs = append(attrs, w*32+bit)
+			}
+		}
+	}
+	return attrs
+}
+
+var supportedAttrs4 = NewBitmap4(
+	Attr4SupportedAttrs, Attr4Type, Attr4FhExpireType, Attr4Change,
+	Attr4Size, Attr4LinkSupport, Attr4SymlinkSupport, Attr4NamedAttr,
+	Attr4Fsid, Attr4UniqueHandles, Attr4LeaseTime, Attr4RdattrError,
+	Attr4Filehandle, Attr4Fileid, Attr4Maxfilesize, Attr4Maxlink,
+	Attr4Maxname, Attr4Maxread, Attr4Maxwrite, Attr4Mode, Attr4Numlinks,
+	Attr4Owner, Attr4OwnerGroup, Attr4Rawdev, Attr4SpaceUsed,
+	Attr4TimeAccess, Attr4TimeAccessSet, Attr4TimeMetadata,
+	Attr4TimeModify, Attr4TimeModifySet, Attr4MountedOnFileid,
+)
+
+type Fsid4 struct {
+	Major uint64
+	Minor uint64
+}
+
+// attrSource is what fattr4 encodes attributes from.
+type attrSource struct {
+	attr   Fattr3
+	fh     []byte
+	export uint32
+	lease  uint32
+	info   func() FSINFO3res // limits, asked only if requested
+	pc     Pathconf
+}
+
+// fattr4 encodes attributes in mask we support. Others are left out of
+// the result mask, as clients expect.
+func fattr4(src attrSource, mask Bitmap4) Fattr4 {
+	var (
+		res  Fattr4
+		buf  bytes.Buffer
+		a    = src.attr
+		info *FSINFO3res
+	)
+	limits := func() *FSINFO3res {
+		if info == nil {
+			i := src.info()
+			info = &i
+		}
+		return info
+	}
+	for _, n := range mask.attrs() {
+		if !supportedAttrs4.Has(n) {
+			continue
+		}
+		var v interface{}
+		switch n {
+		case Attr4SupportedAttrs:
+			v = supportedAttrs4
+		case Attr4Type:
+			v = a.Type
+		case Attr4FhExpireType:
+			v = uint32(0) // FH4_PERSISTENT
+		case Attr4Change:
+			v = change(a)
+		case Attr4Size:
+			v = a.Filesize
+		case Attr4LinkSupport:
+			v = true
+		case Attr4SymlinkSupport, Attr4NamedAttr:
+			v = false
+		case Attr4Fsid:
+			v = Fsid4{Major: uint64(src.export), Minor: a.FSID}
+		case Attr4UniqueHandles:
+			v = true
+		case Attr4LeaseTime:
+			v = src.lease
+		case Attr4RdattrError:
+			v = NFSStatOk
+		case Attr4Filehandle:
+			v = src.fh
+		case Attr4Fileid, Attr4MountedOnFileid:
+			v = a.Fileid
+		case Attr4Maxfilesize:
+			v = limits().Size
+		case Attr4Maxlink:
+			v = src.pc.linkMax()
+		case Attr4Maxname:
+			v = src.pc.NameMax
+		case Attr4Maxread:
+			v = uint64(limits().RTMax)
+		case Attr4Maxwrite:
+			v = uint64(limits().WTMax)
+		case Attr4Mode:
+			v = a.FileMode & 07777
+		case Attr4Numlinks:
+			v = a.Nlink
+		case Attr4Owner:
+			v = strconv.FormatUint(uint64(a.UID), 10)
+		case Attr4OwnerGroup:
+			v = strconv.FormatUint(uint64(a.GID), 10)
+		case Attr4Rawdev:
+			v = Specdata4{Major: a.SpecData[0], Minor: a.SpecData[1]}
+		case Attr4SpaceUsed:
+			v = a.Used
+		case Attr4TimeAccess:
+			v = nfs4Time(a.Atime)
+		case Attr4TimeMetadata:
+			v = nfs4Time(a.Ctime)
+		case Attr4TimeModify:
+			v = nfs4Time(a.Mtime)
+		default:
+			// write-only
+			continue
+		}
+		xdr.Marshal(&buf, v)
+		res.Mask.Set(n)
+	}
+	res.Vals = buf.Bytes()
+	return res
+}
+
+func nfs4Time(t NFS3Time) NFS4Time {
+	return NFS4Time{Seconds: int64(t.Seconds), Nseconds: t.Nseconds}
+}
+
+// Settime4 is how SETATTR sets a time: to the server time or the time
+// given.
+type Settime4 struct {
+	How  uint32   `xdr:"union"`
+	Time NFS4Time `xdr:"unioncase=1"` // SET_TO_CLIENT_TIME4
+}
+
+// sattr4 decodes the attributes clients may set into a Sattr3. It fails
+// with NFSStatAttrnotsupp for attributes we do not support, NFSStatInval
+// for read-only ones and NFSStatBadowner for owners that are not numeric
+// ids. The mask returned holds the attributes decoded.
+func sattr4(f Fattr4) (Sattr3, Bitmap4, NFSStat) {
+	var (
+		s   Sattr3
+		set Bitmap4
+		r   = bytes.NewReader(f.Vals)
+	)
+	for _, n := range f.Mask.attrs() {
+		if !supportedAttrs4.Has(n) {
+			return s, set, NFSStatAttrnotsupp
+		}
+		var err error
+		switch n {
+		case Attr4Size:
+			s.Size.IsSet = true
+			err = xdrrpc.Unmarshal(r, &s.Size.Size)
+		case Attr4Mode:
+			s.Mode.IsSet = true
+			err = xdrrpc.Unmarshal(r, &s.Mode.Mode)
+			s.Mode.Mode &= 07777
+		case Attr4Owner, Attr4OwnerGroup:
+			var name string
+			if err = xdrrpc.Unmarshal(r, &name); err != nil {
+				break
+			}
+			id, ok := parseOwner(name)
+			if !ok {
+				return s, set, NFSStatBadowner
+			}
+			if n == Attr4Owner {
+				s.UID = Sattr3UID{IsSet: true, UID: id}
+			} else {
+				s.GID = Sattr3GID{IsSet: true, GID: id}
+			}
+		case Attr4TimeAccessSet, Attr4TimeModifySet:
+			var t Settime4
+			if err = xdrrpc.Unmarshal(r, &t); err != nil {
+				break
+			}
+			st := Sattr3Time{TimeHow: 1} // SET_TO_SERVER_TIME
+			if t.How == 1 {
+				st = Sattr3Time{TimeHow: 2, Time: NFS3Time{
+					Seconds:  uint32(t.Time.Seconds),
+					Nseconds: t.Time.Nseconds,
+				}}
+			}
+			if n == Attr4TimeAccessSet {
+				s.Atime = st
+			} else {
+				s.Mtime = st
+			}
+		default:
+			return s, set, NFSStatInval
+		}
+		if err != nil {
+			return s, set, NFSStatBadxdr
+		}
+		set.Set(n)
+	}
+	if r.Len() != 0 {
+		return s, set, NFSStatBadxdr
+	}
+	return s, set, NFSStatOk
+}
+
+// parseOwner parses owners as we send them, numeric ids, and
+// "nobody".
+func parseOwner(name string) (uint32, bool) {
+	if name == "nobody" {
+		return Nobody, true
+	}
+	id, err := strconv.ParseUint(name, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(id), true
+}
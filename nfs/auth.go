@@ -0,0 +1,38 @@
+package nfs
+
+import "github.com/dzeromsk/xdrrpc"
+
+// Nobody is the user and group id anonymous callers act as.
+const Nobody = 65534
+
+// Cred identifies the user a call is made on behalf of.
+type Cred struct {
+	UID  uint32
+	GID  uint32
+	GIDs []uint32 // supplementary groups
+}
+
+// CredFromCall returns AUTH_SYS credentials of call, or Nobody for calls
+// using any other flavor.
+func CredFromCall(call *xdrrpc.CallInfo) Cred {
+	if call != nil {
+		if p, err := call.AuthSys(); err == nil {
+			return Cred{UID: p.UID, GID: p.GID, GIDs: p.GIDs}
+		}
+	}
+	return Cred{UID: Nobody, GID: Nobody}
+}
+
+// Authorizer decides whether call may use objects reached through export,
+// and modify them if write is set. It returns the credentials the call is
+// served with, or the status to fail it with.
+type Authorizer interface {
+	Authorize(call *xdrrpc.CallInfo, export uint32, write bool) (Cred, NFSStat)
+}
+
+// allowAll serves every call with the credentials it was made with.
+type allowAll struct{}
+
+func (allowAll) Authorize(call *xdrrpc.CallInfo, export uint32, write bool) (Cred, NFSStat) {
+	return CredFromCall(call), NFSStatOk
+}
@@ -0,0 +1,132 @@
+package nfs
+
+import (
+	"errors"
+	"io/fs"
+	"strconv"
+	"syscall"
+)
+
+var statusNames = map[NFSStat]string{
+	NFSStatOk:          "NFS3_OK",
+	NFSStatPerm:        "NFS3ERR_PERM",
+	NFSStatNoent:       "NFS3ERR_NOENT",
+	NFSStatIo:          "NFS3ERR_IO",
+	NFSStatNxio:        "NFS3ERR_NXIO",
+	NFSStatAcces:       "NFS3ERR_ACCES",
+	NFSStatExist:       "NFS3ERR_EXIST",
+	NFSStatXdev:        "NFS3ERR_XDEV",
+	NFSStatNodev:       "NFS3ERR_NODEV",
+	NFSStatNotdir:      "NFS3ERR_NOTDIR",
+	NFSStatIsdir:       "NFS3ERR_ISDIR",
+	NFSStatInval:       "NFS3ERR_INVAL",
+	NFSStatFbig:        "NFS3ERR_FBIG",
+	NFSStatNospc:       "NFS3ERR_NOSPC",
+	NFSStatRofs:        "NFS3ERR_ROFS",
+	NFSStatMlink:       "NFS3ERR_MLINK",
+	NFSStatNametoolong: "NFS3ERR_NAMETOOLONG",
+	NFSStatNotempty:    "NFS3ERR_NOTEMPTY",
+	NFSStatDquot:       "NFS3ERR_DQUOT",
+	NFSStatStale:       "NFS3ERR_STALE",
+	NFSStatRemote:      "NFS3ERR_REMOTE",
+	NFSStatBadhandle:   "NFS3ERR_BADHANDLE",
+	NFSStatNotsync:     "NFS3ERR_NOT_SYNC",
+	NFSStatBadcookie:   "NFS3ERR_BAD_COOKIE",
+	NFSStatNotsupp:     "NFS3ERR_NOTSUPP",
+	NFSStatToosmall:    "NFS3ERR_TOOSMALL",
+	NFSStatServerfault: "NFS3ERR_SERVERFAULT",
+	NFSStatBadtype:     "NFS3ERR_BADTYPE",
+	NFSStatJukebox:     "NFS3ERR_JUKEBOX",
+}
+
+func (s NFSStat) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return "NFSStat(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Error makes NFSStat an error, so handlers can return a status directly.
+func (s NFSStat) Error() string {
+	return "nfs: " + s.String()
+}
+
+// Is makes statuses match the io/fs sentinel errors they stand for, so
+// clients can test errors with errors.Is(err, fs.ErrNotExist).
+func (s NFSStat) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return s == NFSStatNoent || s == NFSStatStale
+	case fs.ErrExist:
+		return s == NFSStatExist || s == NFSStatNotempty
+	case fs.ErrPermission:
+		return s == NFSStatPerm || s == NFSStatAcces || s == NFSStatRofs
+	case fs.ErrInvalid:
+		return s == NFSStatInval || s == NFSStatBadhandle
+	}
+	return false
+}
+
+var errnoStatus = map[syscall.Errno]NFSStat{
+	syscall.EPERM:        NFSStatPerm,
+	syscall.ENOENT:       NFSStatNoent,
+	syscall.EIO:          NFSStatIo,
+	syscall.ENXIO:        NFSStatNxio,
+	syscall.EACCES:       NFSStatAcces,
+	syscall.EEXIST:       NFSStatExist,
+	syscall.EXDEV:        NFSStatXdev,
+	syscall.ENODEV:       NFSStatNodev,
+	syscall.ENOTDIR:      NFSStatNotdir,
+	syscall.EISDIR:       NFSStatIsdir,
+	syscall.EINVAL:       NFSStatInval,
+	syscall.EFBIG:        NFSStatFbig,
+	syscall.ENOSPC:       NFSStatNospc,
+	syscall.EROFS:        NFSStatRofs,
+	syscall.EMLINK:       NFSStatMlink,
+	syscall.ENAMETOOLONG: NFSStatNametoolong,
+	syscall.ENOTEMPTY:    NFSStatNotempty,
+	syscall.EDQUOT:       NFSStatDquot,
+	syscall.ESTALE:       NFSStatStale,
+	syscall.EREMOTE:      NFSStatRemote,
+	syscall.ENOTSUP:      NFSStatNotsupp,
+	syscall.EAGAIN:       NFSStatJukebox,
+}
+
+// StatusFromError returns the NFS status that best describes err. Errors
+// wrapping an NFSStat or a syscall.Errno map to the matching status, the
+// io/fs sentinel errors to their POSIX equivalents and anything else to
+// NFSStatIo.
+func StatusFromError(err error) NFSStat {
+	if err == nil {
+		return NFSStatOk
+	}
+
+	var stat NFSStat
+	if errors.As(err, &stat) {
+		return stat
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if stat, ok := errnoStatus[errno]; ok {
+			return stat
+		}
+		return NFSStatIo
+	}
+
+	switch {
+	case errors.Is(err, ErrStale):
+		return NFSStatStale
+	case errors.Is(err, ErrBadHandle):
+		return NFSStatBadhandle
+	case errors.Is(err, fs.ErrNotExist):
+		return NFSStatNoent
+	case errors.Is(err, fs.ErrExist):
+		return NFSStatExist
+	case errors.Is(err, fs.ErrPermission):
+		return NFSStatAcces
+	case errors.Is(err, fs.ErrInvalid):
+		return NFSStatInval
+	}
+	return NFSStatIo
+}
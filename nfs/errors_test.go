@@ -0,0 +1,70 @@
+package nfs_test
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+func TestStatusFromError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want nfs.NFSStat
+	}{
+		{nil, nfs.NFSStatOk},
+		{nfs.NFSStatRofs, nfs.NFSStatRofs},
+		{fmt.Errorf("write: %w", nfs.NFSStatDquot), nfs.NFSStatDquot},
+		{syscall.ENOSPC, nfs.NFSStatNospc},
+		{&fs.PathError{Op: "open", Path: "f", Err: syscall.ENAMETOOLONG}, nfs.NFSStatNametoolong},
+		{syscall.ENOEXEC, nfs.NFSStatIo},
+		{fs.ErrNotExist, nfs.NFSStatNoent},
+		{&fs.PathError{Op: "open", Path: "f", Err: fs.ErrPermission}, nfs.NFSStatAcces},
+		{fs.ErrExist, nfs.NFSStatExist},
+		{nfs.ErrStale, nfs.NFSStatStale},
+		{errors.New("disk on fire"), nfs.NFSStatIo},
+	} {
+		if got := nfs.StatusFromError(tt.err); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestStatusIs(t *testing.T) {
+	for _, tt := range []struct {
+		stat   nfs.NFSStat
+		target error
+		want   bool
+	}{
+		{nfs.NFSStatNoent, fs.ErrNotExist, true},
+		{nfs.NFSStatStale, fs.ErrNotExist, true},
+		{nfs.NFSStatAcces, fs.ErrPermission, true},
+		{nfs.NFSStatNotempty, fs.ErrExist, true},
+		{nfs.NFSStatIo, fs.ErrNotExist, false},
+	} {
+		if got := errors.Is(tt.stat, tt.target); got != tt.want {
+			t.Errorf("errors.Is(%v, %v): got %v, want %v", tt.stat, tt.target, got, tt.want)
+		}
+	}
+}
+
+// fullFile fails every write with ENOSPC.
+type fullFile struct{ flushFile }
+
+func (fullFile) Write(args *nfs.WRITE3args, res *nfs.WRITE3res) error {
+	return syscall.ENOSPC
+}
+
+func TestHandlerErrors(t *testing.T) {
+	r, fh := serve(t, fullFile{})
+	var res nfs.WRITE3res
+	if err := r.Write(&nfs.WRITE3args{Object: fh, Count: 1, Data: []byte{1}}, &res); err != nil {
+		t.Fatalf("WRITE failed the call: %v", err)
+	}
+	if res.Status != nfs.NFSStatNospc || !res.FileWcc.Post.IsSet {
+		t.Errorf("WRITE: status %v, post op attributes %v, want NFS3ERR_NOSPC with attributes", res.Status, res.FileWcc.Post.IsSet)
+	}
+}
@@ -0,0 +1,12 @@
+package nfs_test
+
+import (
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+	"github.com/dzeromsk/xdrrpc/nfstest"
+)
+
+func FuzzArgs(f *testing.F) {
+	nfstest.FuzzArgs(f, &nfs.NFS{}, &nfs.NFSACL{}, &nfs.NFS2{}, &nfs.NFS4{})
+}
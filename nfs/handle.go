@@ -0,0 +1,73 @@
+package nfs
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// FHSize is the maximum size in bytes of an NFSv3 file handle.
+const FHSize = 64
+
+var (
+	ErrBadHandle = errors.New("nfs: malformed file handle")
+	ErrStale     = errors.New("nfs: stale file handle")
+)
+
+// FileHandle is the decoded form of the opaque handle exchanged with
+// clients. Object is the key the handler was registered under with
+// ServeMux.Handle; backends only ever see Object.
+type FileHandle struct {
+	Export     uint32 // export the object was reached through
+	Generation uint32 // distinguishes reuses of the same Object
+	Object     []byte // backend object id, e.g. an inode number
+}
+
+// HandleCodec translates between FileHandle and the bytes put on the wire.
+// DecodeHandle returns ErrBadHandle for handles it cannot parse and ErrStale
+// for handles that parse but can no longer be honoured.
+type HandleCodec interface {
+	EncodeHandle(fh FileHandle) ([]byte, error)
+	DecodeHandle(b []byte) (FileHandle, error)
+}
+
+// DefaultCodec lays out handles as big endian export and generation
+// followed by the object id, which must be 1 to FHSize-8 bytes long.
+var DefaultCodec HandleCodec = defaultCodec{}
+
+type defaultCodec struct{}
+
+const defaultCodecHeader = 8
+
+func (defaultCodec) EncodeHandle(fh FileHandle) ([]byte, error) {
+	if len(fh.Object) == 0 || len(fh.Object) > FHSize-defaultCodecHeader {
+		return nil, ErrBadHandle
+	}
+	b := make([]byte, defaultCodecHeader+len(fh.Object))
+	binary.BigEndian.PutUint32(b[0:4], fh.Export)
+	binary.BigEndian.PutUint32(b[4:8], fh.Generation)
+	copy(b[defaultCodecHeader:], fh.Object)
+	return b, nil
+}
+
+func (defaultCodec) DecodeHandle(b []byte) (FileHandle, error) {
+	if len(b) <= defaultCodecHeader || len(b) > FHSize {
+		return FileHandle{}, ErrBadHandle
+	}
+	return FileHandle{
+		Export:     binary.BigEndian.Uint32(b[0:4]),
+		Generation: binary.BigEndian.Uint32(b[4:8]),
+		Object:     b[defaultCodecHeader:],
+	}, nil
+}
+
+// handleStatus maps errors returned by HandleCodec to NFS status codes.
+func handleStatus(err error) NFSStat {
+	switch err {
+	case nil:
+		return NFSStatOk
+	case ErrStale:
+		return NFSStatStale
+	default:
+		return NFSStatBadhandle
+	}
+}
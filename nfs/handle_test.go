@@ -0,0 +1,132 @@
+package nfs_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+func TestDefaultCodec(t *testing.T) {
+	fh := nfs.FileHandle{Export: 1, Generation: 2, Object: []byte("object")}
+	b, err := nfs.DefaultCodec.EncodeHandle(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := nfs.DefaultCodec.DecodeHandle(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Export != fh.Export || got.Generation != fh.Generation || !bytes.Equal(got.Object, fh.Object) {
+		t.Errorf("got %+v, want %+v", got, fh)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		object []byte
+	}{
+		{"empty object", nil},
+		{"longest object", make([]byte, nfs.FHSize-8)},
+		{"object too long", make([]byte, nfs.FHSize-7)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := nfs.DefaultCodec.EncodeHandle(nfs.FileHandle{Object: tt.object})
+			if want := len(tt.object) == 0 || len(tt.object) > nfs.FHSize-8; (err == nfs.ErrBadHandle) != want {
+				t.Errorf("got %v, want bad handle %v", err, want)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name   string
+		handle []byte
+	}{
+		{"empty", nil},
+		{"header only", make([]byte, 8)},
+		{"too long", make([]byte, nfs.FHSize+1)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := nfs.DefaultCodec.DecodeHandle(tt.handle); err != nfs.ErrBadHandle {
+				t.Errorf("got %v, want %v", err, nfs.ErrBadHandle)
+			}
+		})
+	}
+}
+
+func TestBadHandles(t *testing.T) {
+	mux := nfs.NewServeMux()
+	mux.Handle([]byte("root"), statFile{})
+	mux.Export("/", 1, []byte("root"))
+	r := mux.Receiver().(*nfs.NFS)
+
+	fh, err := mux.EncodeHandle(nfs.FileHandle{Export: 1, Object: []byte("root")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := mux.EncodeHandle(nfs.FileHandle{Export: 1, Object: []byte("gone")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		handle []byte
+		want   nfs.NFSStat
+	}{
+		{"valid", fh, nfs.NFSStatOk},
+		{"short", []byte{1, 2, 3}, nfs.NFSStatBadhandle},
+		{"too long", make([]byte, nfs.FHSize+1), nfs.NFSStatBadhandle},
+		{"unknown object", gone, nfs.NFSStatStale},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var res nfs.GETATTR3res
+			if err := r.Getattr(&nfs.GETATTR3args{Object: tt.handle}, &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Status != tt.want {
+				t.Errorf("got %v, want %v", res.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleExports(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		codec nfs.HandleCodec
+		want  nfs.NFSStat
+	}{
+		{"unsigned", nfs.DefaultCodec, nfs.NFSStatStale},
+		{"signed", nfs.NewSignedCodec(nfs.DefaultCodec, []byte("secret")), nfs.NFSStatOk},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := nfs.NewServeMux(nfs.WithHandleCodec(tt.codec))
+			mux.Handle([]byte("private"), statFile{})
+			mux.Handle([]byte("public"), statFile{})
+			mux.Handle([]byte("secret"), statFile{})
+			mux.Export("/private", 1, []byte("private"))
+			mux.Export("/public", 2, []byte("public"))
+			r := mux.Receiver().(*nfs.NFS)
+			getattr := func(fh []byte) nfs.NFSStat {
+				var res nfs.GETATTR3res
+				r.Getattr(&nfs.GETATTR3args{Object: fh}, &res)
+				return res.Status
+			}
+
+			issued, err := mux.EncodeHandle(nfs.FileHandle{Export: 1, Object: []byte("secret")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stat := getattr(issued); stat != nfs.NFSStatOk {
+				t.Fatalf("handle issued through the export: %v", stat)
+			}
+			// clients can only make these if handles are not signed
+			forged, err := tt.codec.EncodeHandle(nfs.FileHandle{Export: 2, Object: []byte("secret")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stat := getattr(forged); stat != tt.want {
+				t.Errorf("object paired with another export: got %v, want %v", stat, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package nfs
 
 import (
+	"crypto/rand"
 	"encoding/binary"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dzeromsk/xdrrpc"
 )
@@ -38,317 +41,978 @@ type ServeMux interface {
 	Handle(object []byte, handler interface{})
 	Load(object []byte) (handler interface{}, ok bool)
 	Delete(object []byte)
+	EncodeHandle(fh FileHandle) ([]byte, error)
+	DecodeHandle(b []byte) (FileHandle, error)
+	WriteVerifier() [8]byte
+	ResetWriteVerifier()
+	SetAuthorizer(a Authorizer)
+	Authorize(call *xdrrpc.CallInfo, export uint32, write bool) (Cred, NFSStat)
 	Receiver() interface{}
+	ACLReceiver() interface{}
+	NFS4Receiver() interface{}
+	NFS2Receiver() interface{}
+	Export(path string, export uint32, root []byte)
 }
 
-func NewServeMux() ServeMux {
+// Option configures a ServeMux.
+type Option func(*serveMux)
+
+// WithHandleCodec makes the ServeMux use codec to translate file handles.
+func WithHandleCodec(codec HandleCodec) Option {
+	return func(mux *serveMux) {
+		mux.codec = codec
+	}
+}
+
+func NewServeMux(opts ...Option) ServeMux {
 	m := &serveMux{
-	// objects: map[uint64]interface{}{},
+		codec:    DefaultCodec,
+		pathconf: DefaultPathconf,
+		objects:  map[Handle]object{},
+		gens:     map[Handle]uint32{},
+		pseudo:   newPseudoFS(),
+	}
+	m.nfs4 = NFS4{m, newState4()}
+	for _, opt := range opts {
+		opt(m)
 	}
 	m.rpc = NFS{m}
+	m.acl = NFSACL{m}
+	m.nfs2 = NFS2{m}
+	m.ResetWriteVerifier()
 	return m
 }
 
 type serveMux struct {
-	rpc     NFS
-	objects sync.Map
+	rpc      NFS
+	acl      NFSACL
+	nfs4     NFS4
+	nfs2     NFS2
+	pseudo   *pseudoFS
+	codec    HandleCodec
+	pathconf Pathconf
+
+	mu      sync.RWMutex
+	objects map[Handle]object
+	// generations of deleted objects, so a handle issued before an
+	// object id was reused for a new object is detected as stale. At
+	// most maxGens are kept, objects with ids not in gens start at
+	// minGen, past the generations dropped from it.
+	gens   map[Handle]uint32
+	minGen uint32
+
+	verfMu sync.Mutex
+	verf   [8]byte
+
+	auth atomic.Value // Authorizer
+}
+
+// maxGens bounds generations of deleted objects a ServeMux remembers.
+const maxGens = 1 << 16
+
+type object struct {
+	handler    interface{}
+	generation uint32
+	exports    []uint32 // handles were issued through, see EncodeHandle
+}
+
+// issued tells whether a handle of e was issued through export.
+func (e *object) issued(export uint32) bool {
+	for _, x := range e.exports {
+		if x == export {
+			return true
+		}
+	}
+	return false
+}
+
+func (mux *serveMux) Handle(o []byte, handler interface{}) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	key := Handle(o)
+	if e, ok := mux.objects[key]; ok {
+		e.handler = handler
+		mux.objects[key] = e
+		return
+	}
+	mux.objects[key] = object{
+		handler:    handler,
+		generation: mux.generation(key),
+	}
+	delete(mux.gens, key)
+}
+
+func (mux *serveMux) Load(o []byte) (handler interface{}, ok bool) {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+
+	e, ok := mux.objects[Handle(o)]
+	return e.handler, ok
+}
+
+func (mux *serveMux) Delete(o []byte) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	key := Handle(o)
+	if e, ok := mux.objects[key]; ok {
+		for k, gen := range mux.gens {
+			if len(mux.gens) < maxGens {
+				break
+			}
+			if gen > mux.minGen {
+				mux.minGen = gen
+			}
+			delete(mux.gens, k)
+		}
+		mux.gens[key] = e.generation + 1
+		delete(mux.objects, key)
+	}
+}
+
+// generation must be called with mux.mu held.
+func (mux *serveMux) generation(key Handle) uint32 {
+	if e, ok := mux.objects[key]; ok {
+		return e.generation
+	}
+	if gen, ok := mux.gens[key]; ok {
+		return gen
+	}
+	return mux.minGen
+}
+
+// EncodeHandle overrides fh.Generation with the current generation of
+// fh.Object. It records that fh.Object was reached through fh.Export, so
+// that DecodeHandle can refuse handles pairing an object with an export it
+// was never reached through.
+func (mux *serveMux) EncodeHandle(fh FileHandle) ([]byte, error) {
+	key := Handle(fh.Object)
+	mux.mu.Lock()
+	fh.Generation = mux.generation(key)
+	if e, ok := mux.objects[key]; ok && !e.issued(fh.Export) {
+		e.exports = append(e.exports, fh.Export)
+		mux.objects[key] = e
+	}
+	mux.mu.Unlock()
+
+	b, err := mux.codec.EncodeHandle(fh)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 || len(b) > FHSize {
+		return nil, ErrBadHandle
+	}
+	return b, nil
 }
 
-func (mux *serveMux) Handle(object []byte, handler interface{}) {
-	o := binary.LittleEndian.Uint64(object)
-	mux.objects.Store(o, handler)
+// DecodeHandle returns ErrStale for handles to objects that were deleted
+// since the handle was issued, even if their id is in use again, and for
+// handles naming an export the object was not reached through. Export
+// options are enforced by the export id of handles, so the latter keeps
+// clients from pairing an object of a restricted export with the id of
+// another one. Signed handles cannot be forged and are trusted to name the
+// export they were issued through, even by another run of the server.
+func (mux *serveMux) DecodeHandle(b []byte) (FileHandle, error) {
+	if len(b) == 0 || len(b) > FHSize {
+		return FileHandle{}, ErrBadHandle
+	}
+	fh, err := mux.codec.DecodeHandle(b)
+	if err != nil {
+		return fh, err
+	}
+
+	_, signed := mux.codec.(*signedCodec)
+
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+
+	e, ok := mux.objects[Handle(fh.Object)]
+	if !ok || e.generation != fh.Generation || !signed && !e.issued(fh.Export) {
+		return fh, ErrStale
+	}
+	return fh, nil
 }
 
-func (mux *serveMux) Load(object []byte) (handler interface{}, ok bool) {
-	o := binary.LittleEndian.Uint64(object)
-	return mux.objects.Load(o)
+// WriteVerifier returns the verifier sent with WRITE and COMMIT replies.
+// Clients resend data written unstably when it changes.
+func (mux *serveMux) WriteVerifier() [8]byte {
+	mux.verfMu.Lock()
+	defer mux.verfMu.Unlock()
+	return mux.verf
 }
 
-func (mux *serveMux) Delete(object []byte) {
-	o := binary.LittleEndian.Uint64(object)
-	mux.objects.Delete(o)
+// ResetWriteVerifier picks a new write verifier. ServeMux calls it on start
+// and when a Flush fails; backends call it whenever else unstable data
+// could have been lost.
+func (mux *serveMux) ResetWriteVerifier() {
+	mux.verfMu.Lock()
+	defer mux.verfMu.Unlock()
+
+	old := mux.verf
+	for mux.verf == old {
+		if _, err := rand.Read(mux.verf[:]); err != nil {
+			binary.BigEndian.PutUint64(mux.verf[:], uint64(time.Now().UnixNano()))
+		}
+	}
+}
+
+// SetAuthorizer makes the ServeMux consult a before serving any procedure
+// on a file handle. By default all calls are allowed.
+func (mux *serveMux) SetAuthorizer(a Authorizer) {
+	mux.auth.Store(&a)
+}
+
+func (mux *serveMux) authorizer() Authorizer {
+	if a, ok := mux.auth.Load().(*Authorizer); ok {
+		return *a
+	}
+	return allowAll{}
+}
+
+// Authorize asks the Authorizer set with SetAuthorizer. Side protocols
+// serving file handles use it to apply the same export options.
+func (mux *serveMux) Authorize(call *xdrrpc.CallInfo, export uint32, write bool) (Cred, NFSStat) {
+	return mux.authorizer().Authorize(call, export, write)
 }
 
 func (mux *serveMux) Receiver() interface{} {
 	return &mux.rpc
 }
 
+// NFS translates file handles sent by clients into handlers registered
+// with ServeMux, and object ids returned by handlers back into file handles.
+// Errors returned by handlers are sent to clients as NFS status codes, see
+// StatusFromError.
 type NFS struct {
 	mux *serveMux
 }
 
-func (r *NFS) Null(args *NullArgs, res *NullRes) error {
-	return nil
-}
-
-func (r *NFS) Fsinfo(args *FSINFO3args, res *FSINFO3res) error {
-	node, ok := r.mux.Load(args.Object)
+// load returns the handler for the file handle b along with its decoded
+// form and the credentials to serve call with. Calls that would modify the
+// object must set write.
+func (r *NFS) load(call *xdrrpc.CallInfo, b []byte, write bool) (interface{}, FileHandle, Cred, NFSStat) {
+	fh, err := r.mux.DecodeHandle(b)
+	if err != nil {
+		return nil, fh, Cred{}, handleStatus(err)
+	}
+	cred, stat := r.mux.authorizer().Authorize(call, fh.Export, write)
+	if stat != NFSStatOk {
+		return nil, fh, cred, stat
+	}
+	node, ok := r.mux.Load(fh.Object)
 	if !ok {
-		res.Status = NFSStatStale
-		return nil
+		return nil, fh, cred, NFSStatStale
 	}
-	n, ok := node.(interface {
-		Fsinfo(*FSINFO3res) error
+	return node, fh, cred, NFSStatOk
+}
+
+// encode returns the file handle for object reached through the same
+// export as parent.
+func (r *NFS) encode(parent FileHandle, object []byte) ([]byte, NFSStat) {
+	b, err := r.mux.EncodeHandle(FileHandle{
+		Export: parent.Export,
+		Object: object,
 	})
-	if !ok {
-		res.Status = NFSStatInval
-		return nil
+	if err != nil {
+		return nil, NFSStatServerfault
 	}
-	return n.Fsinfo(res)
+	return b, NFSStatOk
 }
 
-func (r *NFS) Getattr(args *GETATTR3args, res *GETATTR3res) error {
-	node, ok := r.mux.Load(args.Object)
-	if !ok {
-		res.Status = NFSStatStale
-		return nil
+// postOp returns post operation attributes of node, if it can tell.
+func postOp(node interface{}) PostOpAttr {
+	if n, ok := node.(Attrer); ok {
+		return NewPostOpAttr(n.Attr())
 	}
-	n, ok := node.(interface {
-		Getattr(*GETATTR3res) error
-	})
+	return PostOpAttr{}
+}
+
+// implemented tells whether a handler answering with stat implements the
+// procedure, rather than answering for UnimplementedNode it embeds.
+// ServeMux falls back to what it does for handlers lacking the method
+// otherwise.
+func implemented(stat NFSStat) bool {
+	return stat != NFSStatNotsupp
+}
+
+// unsupported returns the status for procedures node has no method for:
+// NFS3ERR_NOTDIR or NFS3ERR_ISDIR if it is not of the type the procedure
+// works on, want, and NFS3ERR_NOTSUPP otherwise.
+func unsupported(node interface{}, want uint32) NFSStat {
+	if n, ok := node.(Attrer); ok {
+		switch typ := n.Attr().Type; {
+		case want == NF3Dir && typ != NF3Dir:
+			return NFSStatNotdir
+		case want != NF3Dir && typ == NF3Dir:
+			return NFSStatIsdir
+		}
+	}
+	return NFSStatNotsupp
+}
+
+// exists tells whether directory node has an entry called name.
+func exists(node interface{}, name string) bool {
+	n, ok := node.(Lookuper)
 	if !ok {
-		res.Status = NFSStatInval
-		return nil
+		return false
+	}
+	var res LOOKUP3res
+	if err := n.Lookup(name, &res); err != nil {
+		return false
 	}
-	return n.Getattr(res)
+	return res.Status == NFSStatOk
 }
 
-func (r *NFS) Access(args *ACCESS3args, res *ACCESS3res) error {
-	node, ok := r.mux.Load(args.Object)
+// verfTime returns the mtime EXCLUSIVE creates store their verifier as,
+// like Linux does, until clients set the attributes they wanted.
+func verfTime(verf [8]byte) NFS3Time {
+	return NFS3Time{
+		Seconds:  binary.BigEndian.Uint32(verf[0:4]),
+		Nseconds: binary.BigEndian.Uint32(verf[4:8]) % 1e9,
+	}
+}
+
+// createdWith tells whether the entry name of directory node is a file an
+// EXCLUSIVE create with verf made.
+func createdWith(node interface{}, name string, verf [8]byte) bool {
+	n, ok := node.(Lookuper)
 	if !ok {
-		res.Status = NFSStatStale
-		return nil
+		return false
 	}
-	n, ok := node.(interface {
-		Access(*ACCESS3res) error
-	})
+	var res LOOKUP3res
+	if err := n.Lookup(name, &res); err != nil || res.Status != NFSStatOk || !res.Attr.IsSet {
+		return false
+	}
+	return res.Attr.Attr.Type == NF3Reg && res.Attr.Attr.Mtime == verfTime(verf)
+}
+
+func (r *NFS) Null(args *NullArgs, res *NullRes) error {
+	return nil
+}
+
+// root returns the handler of the root of the export fh was reached
+// through. Handlers often only implement procedures describing the whole
+// file system there, while clients call them with any handle.
+func (r *NFS) root(fh FileHandle) interface{} {
+	object, ok := r.mux.pseudo.exportRoot(fh.Export)
 	if !ok {
-		res.Status = NFSStatInval
 		return nil
 	}
-	return n.Access(res)
+	node, _ := r.mux.Load(object)
+	return node
 }
 
-func (r *NFS) Fsstat(args *FSSTAT3args, res *FSSTAT3res) error {
-	node, ok := r.mux.Load(args.FSRoot)
-	if !ok {
-		res.Status = NFSStatStale
+func (r *NFS) Fsinfo(args *FSINFO3args, res *FSINFO3res) error {
+	node, fh, _, stat := r.load(args.Call(), args.Object, false)
+	if stat != NFSStatOk {
+		res.Status = stat
+		return nil
+	}
+	for _, h := range []interface{}{node, r.root(fh)} {
+		n, ok := h.(Fsinfoer)
+		if !ok {
+			continue
+		}
+		*res = FSINFO3res{}
+		if err := n.Fsinfo(res); err != nil {
+			res.Status = StatusFromError(err)
+			res.Attr = postOp(node)
+			return nil
+		}
+		if implemented(res.Status) {
+			if !res.Attr.IsSet {
+				res.Attr = postOp(node)
+			}
+			return nil
+		}
+	}
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (r *NFS) Getattr(args *GETATTR3args, res *GETATTR3res) error {
+	node, _, _, stat := r.load(args.Call(), args.Object, false)
+	if stat != NFSStatOk {
+		res.Status = stat
 		return nil
 	}
-	n, ok := node.(interface {
-		Fsstat(*FSSTAT3res) error
-	})
+	n, ok := node.(Getattrer)
 	if !ok {
-		res.Status = NFSStatInval
+		res.Status = NFSStatNotsupp
 		return nil
 	}
-	return n.Fsstat(res)
+	if err := n.Getattr(res); err != nil {
+		res.Status = StatusFromError(err)
+	}
+	return nil
 }
 
-func (r *NFS) Pathconf(args *PATHCONF3args, res *PATHCONF3res) error {
-	node, ok := r.mux.Load(args.Object)
-	if !ok {
-		res.Status = NFSStatStale
-		return nil
+// Access grants the requested permissions the mode bits of handlers
+// implementing Attrer allow. Handlers implementing Accesser may restrict
+// them further.
+func (r *NFS) Access(args *ACCESS3args, res *ACCESS3res) error {
+	node, fh, cred, stat := r.load(args.Call(), args.Object, false)
+	if stat != NFSStatOk {
+		res.Status = stat
+		return nil
+	}
+	a, isAttrer := node.(Attrer)
+	n, isAccesser := node.(Accesser)
+	if !isAttrer && !isAccesser {
+		res.Status = NFSStatNotsupp
+		return nil
+	}
+	res.Status = NFSStatOk
+	res.Access = args.Access
+	if isAccesser {
+		if err := n.Access(res); err != nil {
+			res.Status = StatusFromError(err)
+			res.Attr = postOp(node)
+			return nil
+		}
+		if !implemented(res.Status) && isAttrer {
+			// mode bits alone decide
+			res.Status = NFSStatOk
+			res.Access = args.Access
+		}
+		if res.Status != NFSStatOk {
+			return nil
+		}
+		res.Access &= args.Access
+	}
+	if isAttrer {
+		attr := a.Attr()
+		res.Access &= access(attr, aclOf(node), cred)
+		if !res.Attr.IsSet {
+			res.Attr = NewPostOpAttr(attr)
+		}
+	}
+	// tell clients upfront what read-only exports will refuse
+	if _, stat := r.mux.authorizer().Authorize(args.Call(), fh.Export, true); stat == NFSStatRofs {
+		res.Access &^= AccessModify | AccessExtend | AccessDelete
 	}
-	n, ok := node.(interface {
-		Pathconf(*PATHCONF3res) error
-	})
-	if !ok {
-		res.Status = NFSStatInval
-		return nil
+	return nil
+}
+
+func (r *NFS) Fsstat(args *FSSTAT3args, res *FSSTAT3res) error {
+	node, fh, _, stat := r.load(args.Call(), args.FSRoot, false)
+	if stat != NFSStatOk {
+		res.Status = stat
+		return nil
+	}
+	for _, h := range []interface{}{node, r.root(fh)} {
+		n, ok := h.(Fsstater)
+		if !ok {
+			continue
+		}
+		*res = FSSTAT3res{}
+		if err := n.Fsstat(res); err != nil {
+			res.Status = StatusFromError(err)
+			res.Attr = postOp(node)
+			return nil
+		}
+		if implemented(res.Status) {
+			if !res.Attr.IsSet {
+				res.Attr = postOp(node)
+			}
+			return nil
+		}
+	}
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+// Pathconf reports limits from the Pathconf ServeMux was configured with.
+// Handlers implementing Pathconfer only describe case sensitivity.
+func (r *NFS) Pathconf(args *PATHCONF3args, res *PATHCONF3res) error {
+	node, fh, _, stat := r.load(args.Call(), args.Object, false)
+	if stat != NFSStatOk {
+		res.Status = stat
+		return nil
+	}
+	res.Status = NFSStatOk
+	res.CasePreserving = true
+	for _, h := range []interface{}{node, r.root(fh)} {
+		n, ok := h.(Pathconfer)
+		if !ok {
+			continue
+		}
+		pc := PATHCONF3res{Status: NFSStatOk, CasePreserving: true}
+		if err := n.Pathconf(&pc); err != nil {
+			res.Status = StatusFromError(err)
+			res.Attr = postOp(node)
+			return nil
+		}
+		if implemented(pc.Status) {
+			*res = pc
+			break
+		}
+	}
+	if res.Status != NFSStatOk {
+		return nil
+	}
+	pc := r.mux.pathconf
+	res.NameMax = pc.NameMax
+	res.LinkMax = pc.linkMax()
+	res.NoTrunc = pc.NoTrunc
+	res.ChownRestricted = true
+	if !res.Attr.IsSet {
+		res.Attr = postOp(node)
 	}
-	return n.Pathconf(res)
+	return nil
 }
 
 func (r *NFS) Lookup(args *LOOKUP3args, res *LOOKUP3res) error {
-	node, ok := r.mux.Load(args.What.Dir)
-	if !ok {
-		res.Status = NFSStatStale
+	node, fh, cred, stat := r.load(args.Call(), args.What.Dir, false)
+	if stat != NFSStatOk {
+		res.Status = stat
 		return nil
 	}
-	n, ok := node.(interface {
-		Lookup(string, *LOOKUP3res) error
-	})
+	name, stat := r.mux.pathconf.checkName(args.What.Name, lookupName)
+	if stat == NFSStatOk {
+		stat = checkDir(node, cred, mayExec)
+	}
+	if stat != NFSStatOk {
+		res.Status = stat
+		res.DirAttr = postOp(node)
+		return nil
+	}
+	n, ok := node.(Lookuper)
 	if !ok {
-		res.Status = NFSStatInval
+		res.Status = unsupported(node, NF3Dir)
+		return nil
+	}
+	if err := n.Lookup(name, res); err != nil {
+		res.Status = StatusFromError(err)
+		res.DirAttr = postOp(node)
 		return nil
 	}
-	return n.Lookup(args.What.Name, res)
+	if res.Status == NFSStatOk {
+		res.Object, res.Status = r.encode(fh, res.Object)
+	}
+	return nil
 }
 
 func (r *NFS) Readdirplus(args *READDIRPLUS3args, res *READDIRPLUS3res) error {
-	node, ok := r.mux.Load(args.Dir)
-	if !ok {
-		res.Status = NFSStatStale
+	node, fh, cred, stat := r.load(args.Call(), args.Dir, false)
+	if stat == NFSStatOk {
+		stat = checkDir(node, cred, mayRead)
+	}
+	if stat != NFSStatOk {
+		res.Status = stat
+		res.Attr = postOp(node)
 		return nil
 	}
-	n, ok := node.(interface {
-		Readdirplus(*READDIRPLUS3args, *READDIRPLUS3res) error
-	})
+	n, ok := node.(Readdirpluser)
 	if !ok {
-		res.Status = NFSStatInval
+		res.Status = unsupported(node, NF3Dir)
+		return nil
+	}
+	args.Dir = fh.Object
+	if err := n.Readdirplus(args, res); err != nil {
+		res.Status = StatusFromError(err)
+		res.Attr = postOp(node)
 		return nil
 	}
-	return n.Readdirplus(args, res)
+	for e := res.Reply.Entry; e != nil; e = e.Next {
+		if !e.Handle.IsSet {
+			continue
+		}
+		// clients fall back to LOOKUP for entries without handle
+		b, stat := r.encode(fh, e.Handle.FH)
+		e.Handle.IsSet = stat == NFSStatOk
+		e.Handle.FH = b
+	}
+	return nil
 }
 
 func (r *NFS) Read(args *READ3args, res *READ3res) error {
-	node, ok := r.mux.Load(args.Object)
-	if !ok {
-		res.Status = NFSStatStale
+	node, fh, cred, stat := r.load(args.Call(), args.Object, false)
+	if stat == NFSStatOk {
+		stat = checkRead(node, cred)
+	}
+	if stat != NFSStatOk {
+		res.Status = stat
+		res.Attr = postOp(node)
 		return nil
 	}
-	n, ok := node.(interface {
-		Read(*READ3args, *READ3res) error
-	})
+	n, ok := node.(Reader)
 	if !ok {
-		res.Status = NFSStatInval
+		res.Status = unsupported(node, NF3Reg)
 		return nil
 	}
-	return n.Read(args, res)
+	args.Object = fh.Object
+	if err := n.Read(args, res); err != nil {
+		res.Status = StatusFromError(err)
+		res.Attr = postOp(node)
+	}
+	return nil
 }
 
 func (r *NFS) Mkdir(args *MKDIR3args, res *MKDIR3res) error {
-	node, ok := r.mux.Load(args.Where.Dir)
-	if !ok {
-		res.Status = NFSStatStale
+	node, fh, cred, stat := r.load(args.Call(), args.Where.Dir, true)
+	if stat != NFSStatOk {
+		res.Status = stat
 		return nil
 	}
-	n, ok := node.(interface {
-		Mkdir(string, *Sattr3, *MKDIR3res) error
-	})
+	name, stat := r.mux.pathconf.checkName(args.Where.Name, createName)
+	if stat == NFSStatOk {
+		stat = checkDir(node, cred, mayWrite|mayExec)
+	}
+	if stat == NFSStatOk && exists(node, name) {
+		stat = NFSStatExist
+	}
+	if stat != NFSStatOk {
+		res.Status = stat
+		res.DirWcc.Post = postOp(node)
+		return nil
+	}
+	n, ok := node.(Mkdirer)
 	if !ok {
-		res.Status = NFSStatInval
+		res.Status = unsupported(node, NF3Dir)
 		return nil
 	}
-	return n.Mkdir(args.Where.Name, &args.Attr, res)
+	inherit(node, cred, &args.Attr)
+	if err := n.Mkdir(name, &args.Attr, res); err != nil {
+		res.Status = StatusFromError(err)
+		res.DirWcc.Post = postOp(node)
+		return nil
+	}
+	if res.Status == NFSStatOk && res.Handle.IsSet {
+		if child, ok := r.mux.Load(res.Handle.FH); ok {
+			inheritACL(node, child)
+			res.Attr = postOp(child)
+		}
+		res.Handle.FH, res.Status = r.encode(fh, res.Handle.FH)
+	}
+	return nil
 }
 
 func (r *NFS) Create(args *CREATE3args, res *CREATE3res) error {
-	node, ok := r.mux.Load(args.Where.Dir)
-	if !ok {
-		res.Status = NFSStatStale
+	node, fh, cred, stat := r.load(args.Call(), args.Where.Dir, true)
+	if stat != NFSStatOk {
+		res.Status = stat
 		return nil
 	}
-	n, ok := node.(interface {
-		Create(string, *CREATE3res) error
-	})
+	name, stat := r.mux.pathconf.checkName(args.Where.Name, createName)
+	if stat == NFSStatOk {
+		stat = checkDir(node, cred, mayWrite|mayExec)
+	}
+	// a retried EXCLUSIVE create finds the file it made, see verfTime
+	retried := stat == NFSStatOk && args.How.Mode == 2 && createdWith(node, name, args.How.CreateVerf)
+	if stat == NFSStatOk && args.How.Mode != 0 && !retried && exists(node, name) {
+		// GUARDED and EXCLUSIVE creates must not reuse existing files
+		stat = NFSStatExist
+	}
+	if stat != NFSStatOk {
+		res.Status = stat
+		res.DirWcc.Post = postOp(node)
+		return nil
+	}
+	n, ok := node.(Creater)
 	if !ok {
-		res.Status = NFSStatInval
+		res.Status = unsupported(node, NF3Dir)
 		return nil
 	}
-	return n.Create(args.Where.Name, res)
+	var attr Sattr3
+	switch args.How.Mode {
+	case 0: // UNCHECKED
+		attr = args.How.UncheckedAttr
+	case 1: // GUARDED
+		attr = args.How.GuardedAttr
+	case 2: // EXCLUSIVE
+		attr.Mtime = Sattr3Time{TimeHow: 2, Time: verfTime(args.How.CreateVerf)}
+	}
+	inherit(node, cred, &attr)
+	// UNCHECKED creates of existing files keep their ACLs
+	existed := retried || args.How.Mode == 0 && exists(node, name)
+	if err := n.Create(name, &attr, res); err != nil {
+		res.Status = StatusFromError(err)
+		res.DirWcc.Post = postOp(node)
+		return nil
+	}
+	if res.Status == NFSStatOk && res.Handle.IsSet {
+		if child, ok := r.mux.Load(res.Handle.FH); ok && !existed {
+			inheritACL(node, child)
+			res.Attr = postOp(child)
+		}
+		res.Handle.FH, res.Status = r.encode(fh, res.Handle.FH)
+	}
+	return nil
 }
 
 func (r *NFS) Setattr(args *SETATTR3args, res *SETATTR3res) error {
-	node, ok := r.mux.Load(args.Object)
-	if !ok {
-		res.Status = NFSStatStale
+	node, fh, cred, stat := r.load(args.Call(), args.Object, true)
+	if stat == NFSStatOk {
+		stat = checkSetattr(node, cred, &args.Sattr)
+	}
+	if a, ok := node.(Attrer); ok && stat == NFSStatOk {
+		// handlers check the guard again along with the change, see
+		// Setattrer
+		switch attr := a.Attr(); {
+		case args.Guard.IsSet && attr.Ctime != args.Guard.Ctime:
+			stat = NFSStatNotsync
+		case args.Sattr.Size.IsSet && attr.Type == NF3Dir:
+			stat = NFSStatIsdir
+		}
+	}
+	if stat != NFSStatOk {
+		res.Status = stat
+		res.ObjWcc.Post = postOp(node)
 		return nil
 	}
-	n, ok := node.(interface {
-		Setattr(*SETATTR3args, *SETATTR3res) error
-	})
+	n, ok := node.(Setattrer)
 	if !ok {
-		res.Status = NFSStatInval
+		res.Status = NFSStatNotsupp
 		return nil
 	}
-	return n.Setattr(args, res)
+	args.Object = fh.Object
+	if err := n.Setattr(args, res); err != nil {
+		res.Status = StatusFromError(err)
+		res.ObjWcc.Post = postOp(node)
+	}
+	return nil
 }
 
 func (r *NFS) Link(args *LINK3args, res *LINK3res) error {
-	node, ok := r.mux.Load(args.Link.Dir)
-	if !ok {
-		res.Status = NFSStatStale
+	node, fh, cred, stat := r.load(args.Call(), args.Link.Dir, true)
+	if stat != NFSStatOk {
+		res.Status = stat
 		return nil
 	}
-	n, ok := node.(interface {
-		Link([]byte, string, *LINK3res) error
-	})
+	file, target, _, stat := r.load(args.Call(), args.Object, false)
+	if stat != NFSStatOk {
+		res.Status = stat
+		return nil
+	}
+	if target.Export != fh.Export {
+		res.Status = NFSStatXdev
+		return nil
+	}
+	name, stat := r.mux.pathconf.checkName(args.Link.Name, createName)
+	if stat == NFSStatOk {
+		stat = checkDir(node, cred, mayWrite|mayExec)
+	}
+	if stat == NFSStatOk && exists(node, name) {
+		stat = NFSStatExist
+	}
+	if a, ok := file.(Attrer); ok && stat == NFSStatOk {
+		switch attr := a.Attr(); {
+		case attr.Type == NF3Dir:
+			// no hard links to directories, like Linux
+			stat = NFSStatIsdir
+		case attr.Nlink >= r.mux.pathconf.linkMax():
+			stat = NFSStatMlink
+		}
+	}
+	if stat != NFSStatOk {
+		res.Status = stat
+		res.Attr = postOp(file)
+		res.DirWcc.Post = postOp(node)
+		return nil
+	}
+	n, ok := node.(Linker)
 	if !ok {
-		res.Status = NFSStatInval
+		res.Status = unsupported(node, NF3Dir)
 		return nil
 	}
-	return n.Link(args.Object, args.Link.Name, res)
+	if err := n.Link(target.Object, name, res); err != nil {
+		res.Status = StatusFromError(err)
+		res.Attr = postOp(file)
+		res.DirWcc.Post = postOp(node)
+	}
+	return nil
 }
 
 func (r *NFS) Remove(args *REMOVE3args, res *REMOVE3res) error {
-	node, ok := r.mux.Load(args.Object.Dir)
-	if !ok {
-		res.Status = NFSStatStale
+	node, _, cred, stat := r.load(args.Call(), args.Object.Dir, true)
+	if stat != NFSStatOk {
+		res.Status = stat
 		return nil
 	}
-	n, ok := node.(interface {
-		Remove(string, *REMOVE3res) error
-	})
+	name, stat := r.mux.pathconf.checkName(args.Object.Name, removeName)
+	if stat == NFSStatOk {
+		stat = checkDir(node, cred, mayWrite|mayExec)
+	}
+	if stat == NFSStatOk {
+		stat = checkSticky(node, name, cred)
+	}
+	if stat != NFSStatOk {
+		res.Status = stat
+		res.DirWcc.Post = postOp(node)
+		return nil
+	}
+	n, ok := node.(Remover)
 	if !ok {
-		res.Status = NFSStatInval
+		res.Status = unsupported(node, NF3Dir)
 		return nil
 	}
-	return n.Remove(args.Object.Name, res)
+	if err := n.Remove(name, res); err != nil {
+		res.Status = StatusFromError(err)
+		res.DirWcc.Post = postOp(node)
+	}
+	return nil
 }
 
 func (r *NFS) Rmdir(args *RMDIR3args, res *RMDIR3res) error {
-	node, ok := r.mux.Load(args.Object.Dir)
-	if !ok {
-		res.Status = NFSStatStale
+	node, _, cred, stat := r.load(args.Call(), args.Object.Dir, true)
+	if stat != NFSStatOk {
+		res.Status = stat
 		return nil
 	}
-	n, ok := node.(interface {
-		Rmdir(string, *RMDIR3res) error
-	})
+	name, stat := r.mux.pathconf.checkName(args.Object.Name, removeName)
+	if stat == NFSStatOk {
+		stat = checkDir(node, cred, mayWrite|mayExec)
+	}
+	if stat == NFSStatOk {
+		stat = checkSticky(node, name, cred)
+	}
+	if stat != NFSStatOk {
+		res.Status = stat
+		res.DirWcc.Post = postOp(node)
+		return nil
+	}
+	n, ok := node.(Rmdirer)
 	if !ok {
-		res.Status = NFSStatInval
+		res.Status = unsupported(node, NF3Dir)
 		return nil
 	}
-	return n.Rmdir(args.Object.Name, res)
+	if err := n.Rmdir(name, res); err != nil {
+		res.Status = StatusFromError(err)
+		res.DirWcc.Post = postOp(node)
+	}
+	return nil
 }
 
 func (r *NFS) Write(args *WRITE3args, res *WRITE3res) error {
-	node, ok := r.mux.Load(args.Object)
-	if !ok {
-		res.Status = NFSStatStale
+	node, fh, cred, stat := r.load(args.Call(), args.Object, true)
+	if stat == NFSStatOk {
+		stat = check(node, cred, mayWrite)
+	}
+	if stat != NFSStatOk {
+		res.Status = stat
+		res.FileWcc.Post = postOp(node)
 		return nil
 	}
-	n, ok := node.(interface {
-		Write(*WRITE3args, *WRITE3res) error
-	})
+	n, ok := node.(Writer)
 	if !ok {
-		res.Status = NFSStatInval
+		res.Status = unsupported(node, NF3Reg)
+		return nil
+	}
+	args.Object = fh.Object
+	if err := n.Write(args, res); err != nil {
+		res.Status = StatusFromError(err)
+		res.FileWcc.Post = postOp(node)
 		return nil
 	}
-	return n.Write(args, res)
+	if res.Status != NFSStatOk {
+		return nil
+	}
+	if f, ok := node.(Flusher); ok && res.Committed < args.Stable {
+		if err := f.Flush(args.Offset, args.Count); err != nil {
+			r.mux.ResetWriteVerifier()
+			res.Status = StatusFromError(err)
+			res.FileWcc.Post = postOp(node)
+			return nil
+		}
+		res.Committed = args.Stable
+	}
+	res.Verf = r.mux.WriteVerifier()
+	return nil
 }
 
+// Commit calls Committer if the handler implements it, otherwise Flusher.
 func (r *NFS) Commit(args *COMMIT3args, res *COMMIT3res) error {
-	node, ok := r.mux.Load(args.Object)
-	if !ok {
-		res.Status = NFSStatStale
+	node, fh, _, stat := r.load(args.Call(), args.Object, true)
+	if stat != NFSStatOk {
+		res.Status = stat
 		return nil
 	}
-	n, ok := node.(interface {
-		Commit(*COMMIT3args, *COMMIT3res) error
-	})
+	args.Object = fh.Object
+	if n, ok := node.(Committer); ok {
+		if err := n.Commit(args, res); err != nil {
+			res.Status = StatusFromError(err)
+			res.FileWcc.Post = postOp(node)
+			return nil
+		}
+		if implemented(res.Status) {
+			res.Verf = r.mux.WriteVerifier()
+			return nil
+		}
+	}
+	f, ok := node.(Flusher)
 	if !ok {
-		res.Status = NFSStatInval
+		res.Status = NFSStatNotsupp
+		return nil
+	}
+	var before PreOpAttr
+	if a, ok := node.(Attrer); ok {
+		before = NewPreOpAttr(a.Attr())
+	}
+	err := f.Flush(args.Offset, args.Count)
+	res.Status = StatusFromError(err)
+	res.FileWcc.Pre = before
+	res.FileWcc.Post = postOp(node)
+	if err != nil {
+		// data written unstably is gone, make clients resend it
+		r.mux.ResetWriteVerifier()
 		return nil
 	}
-	return n.Commit(args, res)
+	res.Verf = r.mux.WriteVerifier()
+	return nil
 }
 
 func (r *NFS) Rename(args *RENAME3args, res *RENAME3res) error {
-	node, ok := r.mux.Load(args.From.Dir)
-	if !ok {
-		res.Status = NFSStatStale
+	node, from, cred, stat := r.load(args.Call(), args.From.Dir, true)
+	if stat != NFSStatOk {
+		res.Status = stat
 		return nil
 	}
-	n, ok := node.(interface {
-		Rename(*RENAME3args, *RENAME3res) error
-	})
+	dst, to, _, stat := r.load(args.Call(), args.To.Dir, true)
+	if stat != NFSStatOk {
+		res.Status = stat
+		return nil
+	}
+	if to.Export != from.Export {
+		res.Status = NFSStatXdev
+		return nil
+	}
+	var stat2 NFSStat
+	args.From.Name, stat = r.mux.pathconf.checkName(args.From.Name, removeName)
+	args.To.Name, stat2 = r.mux.pathconf.checkName(args.To.Name, createName)
+	if stat == NFSStatOk {
+		stat = stat2
+	}
+	for _, dir := range []struct {
+		node interface{}
+		name string
+	}{{node, args.From.Name}, {dst, args.To.Name}} {
+		if stat == NFSStatOk {
+			stat = checkDir(dir.node, cred, mayWrite|mayExec)
+		}
+		if stat == NFSStatOk {
+			stat = checkSticky(dir.node, dir.name, cred)
+		}
+	}
+	if stat != NFSStatOk {
+		res.Status = stat
+		res.FromDirWcc.Post = postOp(node)
+		res.ToDirWcc.Post = postOp(dst)
+		return nil
+	}
+	n, ok := node.(Renamer)
 	if !ok {
-		res.Status = NFSStatInval
+		res.Status = unsupported(node, NF3Dir)
 		return nil
 	}
-	return n.Rename(args, res)
+	// handlers see object ids only
+	args.From.Dir = from.Object
+	args.To.Dir = to.Object
+	if err := n.Rename(args, res); err != nil {
+		res.Status = StatusFromError(err)
+		res.FromDirWcc.Post = postOp(node)
+		res.ToDirWcc.Post = postOp(dst)
+	}
+	return nil
 }
@@ -0,0 +1,42 @@
+package nfs
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestServeMuxStaleHandles(t *testing.T) {
+	mux := NewServeMux().(*serveMux)
+	id := func(i int) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(i))
+		return b
+	}
+	mux.Handle(id(0), "first")
+	old, err := mux.EncodeHandle(FileHandle{Object: id(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.Delete(id(0))
+
+	// push id 0 out of the generations remembered
+	for i := 1; i <= 2*maxGens; i++ {
+		mux.Handle(id(i), i)
+		mux.Delete(id(i))
+	}
+	if n := len(mux.gens); n > maxGens {
+		t.Errorf("%d generations remembered, want at most %d", n, maxGens)
+	}
+
+	mux.Handle(id(0), "reused")
+	if _, err := mux.DecodeHandle(old); err != ErrStale {
+		t.Errorf("handle issued before the id was reused: got %v, want %v", err, ErrStale)
+	}
+	fh, err := mux.EncodeHandle(FileHandle{Object: id(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mux.DecodeHandle(fh); err != nil {
+		t.Errorf("handle of the new object: %v", err)
+	}
+}
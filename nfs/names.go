@@ -0,0 +1,68 @@
+package nfs
+
+import (
+	"math"
+	"strings"
+)
+
+// Pathconf holds the limits ServeMux reports in PATHCONF replies and
+// enforces on every procedure taking a file name.
+type Pathconf struct {
+	NameMax uint32 // longest file name
+	LinkMax uint32 // most hard links to a single object, 0 for no limit
+	NoTrunc bool   // reject names longer than NameMax instead of truncating
+}
+
+var DefaultPathconf = Pathconf{
+	NameMax: 255,
+	LinkMax: 32000,
+	NoTrunc: true,
+}
+
+// WithPathconf makes the ServeMux enforce and report pc.
+func WithPathconf(pc Pathconf) Option {
+	return func(mux *serveMux) {
+		mux.pathconf = pc
+	}
+}
+
+// linkMax returns the most hard links to a single object, as reported to
+// clients.
+func (pc *Pathconf) linkMax() uint32 {
+	if pc.LinkMax == 0 {
+		return math.MaxUint32
+	}
+	return pc.LinkMax
+}
+
+type nameUse int
+
+const (
+	lookupName nameUse = iota // name of an entry being looked up
+	createName                // name of an entry being created
+	removeName                // name of an entry being removed or renamed
+)
+
+// checkName validates a file name sent by a client. It returns the name
+// handlers should see, which is truncated if the Pathconf says so.
+func (pc *Pathconf) checkName(name string, use nameUse) (string, NFSStat) {
+	// same errors as lookup_one_len(9) gives the Linux server
+	if name == "" || strings.ContainsAny(name, "/\x00") {
+		return name, NFSStatAcces
+	}
+	if uint32(len(name)) > pc.NameMax {
+		if pc.NoTrunc {
+			return name, NFSStatNametoolong
+		}
+		name = name[:pc.NameMax]
+	}
+	if name == "." || name == ".." {
+		switch use {
+		case createName:
+			return name, NFSStatExist
+		case removeName:
+			return name, NFSStatInval
+		}
+	}
+	return name, NFSStatOk
+}
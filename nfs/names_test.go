@@ -0,0 +1,114 @@
+package nfs
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc"
+)
+
+func TestCheckName(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	for _, tt := range []struct {
+		name string
+		use  nameUse
+		pc   Pathconf
+		want NFSStat
+		got  string
+	}{
+		{"file", createName, DefaultPathconf, NFSStatOk, "file"},
+		{"", lookupName, DefaultPathconf, NFSStatAcces, ""},
+		{"a/b", createName, DefaultPathconf, NFSStatAcces, "a/b"},
+		{"a\x00", lookupName, DefaultPathconf, NFSStatAcces, "a\x00"},
+		{".", lookupName, DefaultPathconf, NFSStatOk, "."},
+		{"..", createName, DefaultPathconf, NFSStatExist, ".."},
+		{".", removeName, DefaultPathconf, NFSStatInval, "."},
+		{long, createName, DefaultPathconf, NFSStatNametoolong, long},
+		{long, createName, Pathconf{NameMax: 8}, NFSStatOk, "xxxxxxxx"},
+	} {
+		got, stat := tt.pc.checkName(tt.name, tt.use)
+		if stat != tt.want || got != tt.got {
+			t.Errorf("%.10q (use %d): got %.10q, %v, want %.10q, %v", tt.name, tt.use, got, stat, tt.got, tt.want)
+		}
+	}
+}
+
+// caseFolding is a directory of a file system ignoring case.
+type caseFolding struct{}
+
+func (caseFolding) Attr() Fattr3 {
+	return Fattr3{Type: NF3Dir, FileMode: 0755, Nlink: 2, Fileid: 1}
+}
+
+func (caseFolding) Pathconf(res *PATHCONF3res) error {
+	res.CaseInsensitive = true
+	return nil
+}
+
+func TestPathconf(t *testing.T) {
+	pc := Pathconf{NameMax: 64, LinkMax: 1, NoTrunc: false}
+	mux := NewServeMux(WithPathconf(pc))
+	mux.Handle([]byte("root"), caseFolding{})
+	mux.Export("/", 1, []byte("root"))
+	fh, err := mux.EncodeHandle(FileHandle{Export: 1, Object: []byte("root")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res PATHCONF3res
+	mux.Receiver().(*NFS).Pathconf(&PATHCONF3args{Object: fh}, &res)
+	if res.Status != NFSStatOk || res.NameMax != 64 || res.LinkMax != 1 || res.NoTrunc || !res.CaseInsensitive || !res.CasePreserving {
+		t.Errorf("got %+v, want limits of %+v, case insensitive and preserving", res, pc)
+	}
+}
+
+// linkDir is a directory taking any link.
+type linkDir struct{ caseFolding }
+
+func (linkDir) Link(object []byte, name string, res *LINK3res) error {
+	return nil
+}
+
+// linkedFile is a file with nlink names.
+type linkedFile uint32
+
+func (n linkedFile) Attr() Fattr3 {
+	return Fattr3{Type: NF3Reg, FileMode: 0644, Nlink: uint32(n), Fileid: 2}
+}
+
+func TestLinkMax(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		linkMax uint32
+		nlink   linkedFile
+		want    NFSStat
+		report  uint32
+	}{
+		{"below limit", 2, 1, NFSStatOk, 2},
+		{"at limit", 2, 2, NFSStatMlink, 2},
+		{"no limit", 0, 32000, NFSStatOk, math.MaxUint32},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := NewServeMux(WithPathconf(Pathconf{NameMax: 255, LinkMax: tt.linkMax}))
+			mux.Handle([]byte("root"), linkDir{})
+			mux.Handle([]byte("file"), tt.nlink)
+			mux.Export("/", 1, []byte("root"))
+			root, _ := mux.EncodeHandle(FileHandle{Export: 1, Object: []byte("root")})
+			file, _ := mux.EncodeHandle(FileHandle{Export: 1, Object: []byte("file")})
+			r := mux.Receiver().(*NFS)
+
+			args := &LINK3args{Object: file, Link: Diropargs3{Dir: root, Name: "link"}}
+			args.SetCall(&xdrrpc.CallInfo{Cred: xdrrpc.NewAuthSys(xdrrpc.AuthSysParms{})})
+			var res LINK3res
+			r.Link(args, &res)
+			if res.Status != tt.want {
+				t.Errorf("LINK: got %v, want %v", res.Status, tt.want)
+			}
+			var pc PATHCONF3res
+			r.Pathconf(&PATHCONF3args{Object: root}, &pc)
+			if pc.LinkMax != tt.report {
+				t.Errorf("PATHCONF: link max %d, want %d", pc.LinkMax, tt.report)
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package nfs
+
+import (
+	"math"
+
+	"github.com/dzeromsk/xdrrpc"
+)
+
+func init() {
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 0, "NFS2", "Null")
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 1, "NFS2", "Getattr")
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 2, "NFS2", "Setattr")
+	// xdrrpc.Register(Nfs3Prog, Nfs2Vers, 3, "NFS2", "Root")
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 4, "NFS2", "Lookup")
+	// xdrrpc.Register(Nfs3Prog, Nfs2Vers, 5, "NFS2", "Readlink")
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 6, "NFS2", "Read")
+	// xdrrpc.Register(Nfs3Prog, Nfs2Vers, 7, "NFS2", "Writecache")
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 8, "NFS2", "Write")
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 9, "NFS2", "Create")
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 10, "NFS2", "Remove")
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 11, "NFS2", "Rename")
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 12, "NFS2", "Link")
+	// xdrrpc.Register(Nfs3Prog, Nfs2Vers, 13, "NFS2", "Symlink")
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 14, "NFS2", "Mkdir")
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 15, "NFS2", "Rmdir")
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 16, "NFS2", "Readdir")
+	xdrrpc.Register(Nfs3Prog, Nfs2Vers, 17, "NFS2", "Statfs")
+}
+
+func (mux *serveMux) NFS2Receiver() interface{} {
+	return &mux.nfs2
+}
+
+// NFS2 serves NFSv2 by translating calls to NFSv3 procedures of NFS, so
+// handlers serve both versions alike. Files and offsets past 4GB cannot
+// be reached.
+type NFS2 struct {
+	mux *serveMux
+}
+
+// stat2 maps status codes NFSv2 lacks to the closest it has.
+func stat2(stat NFSStat) NFSStat {
+	switch stat {
+	case NFSStatOk, NFSStatPerm, NFSStatNoent, NFSStatIo, NFSStatNxio,
+		NFSStatAcces, NFSStatExist, NFSStatXdev, NFSStatNodev,
+		NFSStatNotdir, NFSStatIsdir, NFSStatInval, NFSStatFbig,
+		NFSStatNospc, NFSStatRofs, NFSStatMlink, NFSStatNametoolong,
+		NFSStatNotempty, NFSStatDquot, NFSStatStale:
+		return stat
+	case NFSStatBadhandle:
+		return NFSStatStale
+	default:
+		return NFSStatIo
+	}
+}
+
+// File type bits of NFSv2 modes.
+const (
+	modeDir  = 0040000
+	modeChr  = 0020000
+	modeBlk  = 0060000
+	modeReg  = 0100000
+	modeLnk  = 0120000
+	modeSock = 0140000
+	modeFIFO = 0010000
+)
+
+func fattr2(a Fattr3) Fattr2 {
+	mode := a.FileMode & 07777
+	typ := a.Type
+	switch a.Type {
+	case NF3Reg:
+		mode |= modeReg
+	case NF3Dir:
+		mode |= modeDir
+	case NF3Blk:
+		mode |= modeBlk
+	case NF3Chr:
+		mode |= modeChr
+	case NF3Lnk:
+		mode |= modeLnk
+	case NF3Sock:
+		// NFSv2 only knows sockets and FIFOs by their mode
+		mode |= modeSock
+		typ = 0
+	case NF3FIFO:
+		mode |= modeFIFO
+		typ = 0
+	}
+	return Fattr2{
+		Type:      typ,
+		Mode:      mode,
+		Nlink:     a.Nlink,
+		UID:       a.UID,
+		GID:       a.GID,
+		Size:      clamp32(a.Filesize),
+		Blocksize: MaxData2,
+		Rdev:      a.SpecData[0]<<8 | a.SpecData[1]&0xff,
+		Blocks:    clamp32((a.Used + 511) / 512),
+		FSID:      uint32(a.FSID),
+		Fileid:    uint32(a.Fileid ^ a.Fileid>>32),
+		Atime:     timeval2(a.Atime),
+		Mtime:     timeval2(a.Mtime),
+		Ctime:     timeval2(a.Ctime),
+	}
+}
+
+func clamp32(n uint64) uint32 {
+	if n > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(n)
+}
+
+func timeval2(t NFS3Time) Timeval2 {
+	return Timeval2{Seconds: t.Seconds, Useconds: t.Nseconds / 1000}
+}
+
+// unset2 marks fields of Sattr2 to leave alone.
+const unset2 = math.MaxUint32
+
+func sattr2(s Sattr2) Sattr3 {
+	var attr Sattr3
+	if s.Mode != unset2 {
+		attr.Mode = Sattr3Mode{IsSet: true, Mode: s.Mode & 07777}
+	}
+	if s.UID != unset2 {
+		attr.UID = Sattr3UID{IsSet: true, UID: s.UID}
+	}
+	if s.GID != unset2 {
+		attr.GID = Sattr3GID{IsSet: true, GID: s.GID}
+	}
+	if s.Size != unset2 {
+		attr.Size = Sattr3Size{IsSet: true, Size: uint64(s.Size)}
+	}
+	attr.Atime = settime2(s.Atime)
+	attr.Mtime = settime2(s.Mtime)
+	return attr
+}
+
+// settime2 follows Linux in taking a million microseconds for the time of
+// the server.
+func settime2(t Timeval2) Sattr3Time {
+	switch {
+	case t.Seconds == unset2 && t.Useconds == unset2:
+		return Sattr3Time{}
+	case t.Useconds == 1000000:
+		return Sattr3Time{TimeHow: 1}
+	}
+	return Sattr3Time{TimeHow: 2, Time: NFS3Time{Seconds: t.Seconds, Nseconds: t.Useconds * 1000}}
+}
+
+func header2(h xdrrpc.Header) xdrrpc.Header {
+	var c xdrrpc.Header
+	c.SetCall(h.Call())
+	return c
+}
+
+// attr returns NFSv2 attributes of the file with NFSv3 handle b.
+func (r *NFS2) attr(h xdrrpc.Header, b []byte) (Fattr2, NFSStat) {
+	var res GETATTR3res
+	r.mux.rpc.Getattr(&GETATTR3args{Header: header2(h), Object: b}, &res)
+	return fattr2(res.Attr), stat2(res.Status)
+}
+
+// attrstat answers with the attributes of b if stat is NFSStatOk.
+func (r *NFS2) attrstat(h xdrrpc.Header, b []byte, stat NFSStat, res *Attrstat2) {
+	if stat != NFSStatOk {
+		res.Status = stat2(stat)
+		return
+	}
+	res.Attr, res.Status = r.attr(h, b)
+}
+
+// diropres answers with the handle and attributes of b if stat is
+// NFSStatOk.
+func (r *NFS2) diropres(h xdrrpc.Header, b []byte, stat NFSStat, res *Diropres2) {
+	if stat != NFSStatOk {
+		res.Status = stat2(stat)
+		return
+	}
+	fh, ok := NewFHandle2(b)
+	if !ok {
+		// too long, like those of NewSignedCodec
+		res.Status = NFSStatIo
+		return
+	}
+	res.File = fh
+	res.Attr, res.Status = r.attr(h, b)
+}
+
+func (r *NFS2) Null(args *NullArgs, res *NullRes) error {
+	return nil
+}
+
+func (r *NFS2) Getattr(args *GETATTR2args, res *Attrstat2) error {
+	r.attrstat(args.Header, args.File.Handle(), NFSStatOk, res)
+	return nil
+}
+
+func (r *NFS2) Setattr(args *SETATTR2args, res *Attrstat2) error {
+	b := args.File.Handle()
+	var res3 SETATTR3res
+	r.mux.rpc.Setattr(&SETATTR3args{
+		Header: header2(args.Header),
+		Object: b,
+		Sattr:  sattr2(args.Attr),
+	}, &res3)
+	r.attrstat(args.Header, b, res3.Status, res)
+	return nil
+}
+
+func (r *NFS2) Lookup(args *LOOKUP2args, res *Diropres2) error {
+	var res3 LOOKUP3res
+	r.mux.rpc.Lookup(&LOOKUP3args{
+		Header: header2(args.Header),
+		What:   Diropargs3{Dir: args.What.Dir.Handle(), Name: args.What.Name},
+	}, &res3)
+	r.diropres(args.Header, res3.Object, res3.Status, res)
+	return nil
+}
+
+func (r *NFS2) Read(args *READ2args, res *READ2res) error {
+	b := args.File.Handle()
+	count := args.Count
+	if count > MaxData2 {
+		count = MaxData2
+	}
+	var res3 READ3res
+	r.mux.rpc.Read(&READ3args{
+		Header: header2(args.Header),
+		Object: b,
+		Offset: uint64(args.Offset),
+		Count:  count,
+	}, &res3)
+	if res3.Status != NFSStatOk {
+		res.Status = stat2(res3.Status)
+		return nil
+	}
+	res.Data = res3.Data
+	if int(res3.Count) < len(res.Data) {
+		res.Data = res.Data[:res3.Count]
+	}
+	res.Attr, res.Status = r.attr(args.Header, b)
+	return nil
+}
+
+// Write writes synchronously, NFSv2 knows no other way.
+func (r *NFS2) Write(args *WRITE2args, res *Attrstat2) error {
+	b := args.File.Handle()
+	switch {
+	case len(args.Data) > MaxData2:
+		res.Status = NFSStatInval
+		return nil
+	case uint64(args.Offset)+uint64(len(args.Data)) > math.MaxUint32:
+		res.Status = NFSStatFbig
+		return nil
+	}
+	var res3 WRITE3res
+	r.mux.rpc.Write(&WRITE3args{
+		Header: header2(args.Header),
+		Object: b,
+		Offset: uint64(args.Offset),
+		Count:  uint32(len(args.Data)),
+		Stable: FileSync,
+		Data:   args.Data,
+	}, &res3)
+	r.attrstat(args.Header, b, res3.Status, res)
+	return nil
+}
+
+// Create truncates existing files if asked to, as NFSv2 clients expect
+// for creat(2).
+func (r *NFS2) Create(args *CREATE2args, res *Diropres2) error {
+	attr := sattr2(args.Attr)
+	var res3 CREATE3res
+	r.mux.rpc.Create(&CREATE3args{
+		Header: header2(args.Header),
+		Where:  Diropargs3{Dir: args.Where.Dir.Handle(), Name: args.Where.Name},
+		How:    Createhow3{UncheckedAttr: attr},
+	}, &res3)
+	if res3.Status == NFSStatOk && attr.Size.IsSet {
+		var set SETATTR3res
+		r.mux.rpc.Setattr(&SETATTR3args{
+			Header: header2(args.Header),
+			Object: res3.Handle.FH,
+			Sattr:  Sattr3{Size: attr.Size},
+		}, &set)
+		res3.Status = set.Status
+	}
+	r.diropres(args.Header, res3.Handle.FH, res3.Status, res)
+	return nil
+}
+
+func (r *NFS2) Remove(args *REMOVE2args, res *Stat2res) error {
+	var res3 REMOVE3res
+	r.mux.rpc.Remove(&REMOVE3args{
+		Header: header2(args.Header),
+		Object: Diropargs3{Dir: args.What.Dir.Handle(), Name: args.What.Name},
+	}, &res3)
+	res.Status = stat2(res3.Status)
+	return nil
+}
+
+func (r *NFS2) Rename(args *RENAME2args, res *Stat2res) error {
+	var res3 RENAME3res
+	r.mux.rpc.Rename(&RENAME3args{
+		Header: header2(args.Header),
+		From:   Diropargs3{Dir: args.From.Dir.Handle(), Name: args.From.Name},
+		To:     Diropargs3{Dir: args.To.Dir.Handle(), Name: args.To.Name},
+	}, &res3)
+	res.Status = stat2(res3.Status)
+	return nil
+}
+
+func (r *NFS2) Link(args *LINK2args, res *Stat2res) error {
+	var res3 LINK3res
+	r.mux.rpc.Link(&LINK3args{
+		Header: header2(args.Header),
+		Object: args.From.Handle(),
+		Link:   Diropargs3{Dir: args.To.Dir.Handle(), Name: args.To.Name},
+	}, &res3)
+	res.Status = stat2(res3.Status)
+	return nil
+}
+
+func (r *NFS2) Mkdir(args *CREATE2args, res *Diropres2) error {
+	var res3 MKDIR3res
+	r.mux.rpc.Mkdir(&MKDIR3args{
+		Header: header2(args.Header),
+		Where:  Diropargs3{Dir: args.Where.Dir.Handle(), Name: args.Where.Name},
+		Attr:   sattr2(args.Attr),
+	}, &res3)
+	r.diropres(args.Header, res3.Handle.FH, res3.Status, res)
+	return nil
+}
+
+func (r *NFS2) Rmdir(args *REMOVE2args, res *Stat2res) error {
+	var res3 RMDIR3res
+	r.mux.rpc.Rmdir(&RMDIR3args{
+		Header: header2(args.Header),
+		Object: Diropargs3{Dir: args.What.Dir.Handle(), Name: args.What.Name},
+	}, &res3)
+	res.Status = stat2(res3.Status)
+	return nil
+}
+
+// Readdir returns as many entries as fit in args.Count bytes. Entries
+// with cookies past 32 bits are left for NFSv3 clients.
+func (r *NFS2) Readdir(args *READDIR2args, res *READDIR2res) error {
+	count := args.Count
+	if count < readdirBatch {
+		count = readdirBatch
+	}
+	var res3 READDIRPLUS3res
+	r.mux.rpc.Readdirplus(&READDIRPLUS3args{
+		Header:   header2(args.Header),
+		Dir:      args.Dir.Handle(),
+		Cookie:   uint64(args.Cookie),
+		DirCount: count,
+		MaxCount: count,
+	}, &res3)
+	if res3.Status != NFSStatOk {
+		res.Status = stat2(res3.Status)
+		return nil
+	}
+	// status, list end and eof
+	size := 4 + 4 + 4
+	eof := res3.Reply.EOF
+	var tail *Entry2
+	for e := res3.Reply.Entry; e != nil; e = e.Next {
+		size += 4 + 4 + xdrSize(len(e.FileName)) + 4
+		if size > int(args.Count) || e.Cookie > math.MaxUint32 {
+			if tail == nil {
+				res.Status = NFSStatInval
+				return nil
+			}
+			eof = false
+			break
+		}
+		next := &Entry2{
+			Fileid: uint32(e.FileID ^ e.FileID>>32),
+			Name:   e.FileName,
+			Cookie: uint32(e.Cookie),
+		}
+		if tail == nil {
+			res.Reply.Entries = next
+		} else {
+			tail.Next = next
+		}
+		tail = next
+	}
+	res.Status = NFSStatOk
+	res.Reply.EOF = eof
+	return nil
+}
+
+// statfsBlock is the block size Statfs reports sizes in.
+const statfsBlock = 4096
+
+func (r *NFS2) Statfs(args *STATFS2args, res *STATFS2res) error {
+	var res3 FSSTAT3res
+	r.mux.rpc.Fsstat(&FSSTAT3args{Header: header2(args.Header), FSRoot: args.File.Handle()}, &res3)
+	if res3.Status != NFSStatOk {
+		res.Status = stat2(res3.Status)
+		return nil
+	}
+	res.Status = NFSStatOk
+	res.Tsize = MaxData2
+	res.Bsize = statfsBlock
+	res.Blocks = clamp32(res3.Tbytes / statfsBlock)
+	res.Bfree = clamp32(res3.Fbytes / statfsBlock)
+	res.Bavail = clamp32(res3.Abytes / statfsBlock)
+	return nil
+}
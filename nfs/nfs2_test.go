@@ -0,0 +1,57 @@
+package nfs_test
+
+import (
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+// lookupDir is a directory whose entries are objects named like them.
+type lookupDir struct{ fsRoot }
+
+func (lookupDir) Lookup(name string, res *nfs.LOOKUP3res) error {
+	res.Status = nfs.NFSStatOk
+	res.Object = []byte(name)
+	return nil
+}
+
+// statFile is flushFile answering GETATTR.
+type statFile struct{ flushFile }
+
+func (f statFile) Getattr(res *nfs.GETATTR3res) error {
+	res.Status = nfs.NFSStatOk
+	res.Attr = f.Attr()
+	return nil
+}
+
+func TestNFS2LongHandles(t *testing.T) {
+	mux := nfs.NewServeMux(nfs.WithHandleCodec(nfs.NewSignedCodec(nfs.DefaultCodec, []byte("secret"))))
+	mux.Handle([]byte("r"), lookupDir{})
+	mux.Handle([]byte("f"), statFile{})
+	mux.Handle([]byte("long file"), statFile{})
+	mux.Export("/", 1, []byte("r"))
+	b, err := mux.EncodeHandle(nfs.FileHandle{Export: 1, Object: []byte("r")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, ok := nfs.NewFHandle2(b)
+	if !ok {
+		t.Fatalf("signed handle of a one byte object is %d bytes, too long for NFSv2", len(b))
+	}
+	r := mux.NFS2Receiver().(*nfs.NFS2)
+	for _, tt := range []struct {
+		name string
+		want nfs.NFSStat
+	}{
+		{"f", nfs.NFSStatOk},
+		{"long file", nfs.NFSStatIo},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var res nfs.Diropres2
+			r.Lookup(&nfs.LOOKUP2args{What: nfs.Diropargs2{Dir: root, Name: tt.name}}, &res)
+			if res.Status != tt.want {
+				t.Errorf("got %v, want %v", res.Status, tt.want)
+			}
+		})
+	}
+}
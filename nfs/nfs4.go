@@ -0,0 +1,947 @@
+package nfs
+
+import (
+	"encoding/binary"
+
+	"github.com/dzeromsk/xdrrpc"
+)
+
+func init() {
+	xdrrpc.Register(Nfs3Prog, Nfs4Vers, 0, "NFS4", "Null")
+	xdrrpc.Register(Nfs3Prog, Nfs4Vers, 1, "NFS4", "Compound")
+}
+
+func (mux *serveMux) NFS4Receiver() interface{} {
+	return &mux.nfs4
+}
+
+// Export makes the root of export reachable at path from the NFSv4 pseudo
+// root. Mount.Handle calls it for every export. It panics if path is
+// nested deeper than the root directory of another export, or another
+// export deeper in path.
+func (mux *serveMux) Export(path string, export uint32, root []byte) {
+	for _, d := range mux.pseudo.add(path, export, root) {
+		mux.Handle(d.object, d)
+	}
+}
+
+// NFS4 serves NFSv4.0 COMPOUND requests by running each operation through
+// the NFSv3 procedures of NFS, so handlers serve both versions alike.
+// Exports are joined in a pseudo file system, see ServeMux.Export.
+//
+// TODO: byte range locks, delegations, symlinks and named attributes.
+type NFS4 struct {
+	mux   *serveMux
+	state *state4
+}
+
+// fh4 is a file handle of a COMPOUND, current or saved.
+type fh4 struct {
+	b      []byte
+	fh     FileHandle
+	pseudo *pseudoDir
+}
+
+type compound4 struct {
+	call       *xdrrpc.CallInfo
+	cur, saved fh4
+}
+
+// header returns the Header NFSv3 arguments are sent with, so they are
+// served in the name of the COMPOUND.
+func (c *compound4) header() xdrrpc.Header {
+	var h xdrrpc.Header
+	h.SetCall(c.call)
+	return h
+}
+
+func (r *NFS4) Null(args *NullArgs, res *NullRes) error {
+	return nil
+}
+
+// Compound runs args.Ops in order, stopping at the first that fails.
+func (r *NFS4) Compound(args *COMPOUND4args, res *COMPOUND4res) error {
+	res.Tag = args.Tag
+	if args.MinorVersion != 0 {
+		res.Status = NFSStatMinorVersMismatch
+		return nil
+	}
+	c := &compound4{call: args.Call()}
+	for i := range args.Ops {
+		op := &args.Ops[i]
+		res.Ops = append(res.Ops, Resop4{Op: op.Op})
+		stat := r.op(c, op, &res.Ops[len(res.Ops)-1])
+		res.Status = stat
+		if stat != NFSStatOk {
+			break
+		}
+	}
+	return nil
+}
+
+// op runs a single operation and returns its status, which it also puts
+// in res.
+func (r *NFS4) op(c *compound4, op *Argop4, res *Resop4) NFSStat {
+	switch op.Op {
+	case OpAccess:
+		res.Access.Status = r.access(c, &op.Access, &res.Access)
+		return res.Access.Status
+	case OpClose:
+		res.Close.Status = r.close(c, &op.Close, &res.Close)
+		return res.Close.Status
+	case OpCommit:
+		res.Commit.Status = r.commit(c, &op.Commit, &res.Commit)
+		return res.Commit.Status
+	case OpCreate:
+		res.Create.Status = r.create(c, &op.Create, &res.Create)
+		return res.Create.Status
+	case OpGetattr:
+		res.Getattr.Status = r.getattr(c, &op.Getattr, &res.Getattr)
+		return res.Getattr.Status
+	case OpGetfh:
+		res.Getfh.Status = NFSStatNofilehandle
+		if c.cur.b != nil {
+			res.Getfh.Status = NFSStatOk
+			res.Getfh.Object = c.cur.b
+		}
+		return res.Getfh.Status
+	case OpLink:
+		res.Link.Status = r.link(c, &op.Link, &res.Link)
+		return res.Link.Status
+	case OpLookup:
+		res.Lookup.Status = r.lookup(c, op.Lookup.Name)
+		return res.Lookup.Status
+	case OpLookupp:
+		res.Lookupp.Status = r.lookupp(c)
+		return res.Lookupp.Status
+	case OpOpen:
+		res.Open.Status = r.open(c, &op.Open, &res.Open)
+		return res.Open.Status
+	case OpOpenConfirm:
+		res.OpenConfirm.Status = r.openConfirm(c, &op.OpenConfirm, &res.OpenConfirm)
+		return res.OpenConfirm.Status
+	case OpOpenDowngrade:
+		res.OpenDowngrade.Status = r.openDowngrade(c, &op.OpenDowngrade, &res.OpenDowngrade)
+		return res.OpenDowngrade.Status
+	case OpPutfh:
+		res.Putfh.Status = r.set(c, op.Putfh.Object)
+		return res.Putfh.Status
+	case OpPutpubfh:
+		res.Putpubfh.Status = r.putrootfh(c)
+		return res.Putpubfh.Status
+	case OpPutrootfh:
+		res.Putrootfh.Status = r.putrootfh(c)
+		return res.Putrootfh.Status
+	case OpRead:
+		res.Read.Status = r.read(c, &op.Read, &res.Read)
+		return res.Read.Status
+	case OpReaddir:
+		res.Readdir.Status = r.readdir(c, &op.Readdir, &res.Readdir)
+		return res.Readdir.Status
+	case OpRemove:
+		res.Remove.Status = r.remove(c, &op.Remove, &res.Remove)
+		return res.Remove.Status
+	case OpRename:
+		res.Rename.Status = r.rename(c, &op.Rename, &res.Rename)
+		return res.Rename.Status
+	case OpRenew:
+		res.Renew.Status = r.state.renew(op.Renew.Clientid)
+		return res.Renew.Status
+	case OpRestorefh:
+		res.Restorefh.Status = NFSStatRestorefh
+		if c.saved.b != nil {
+			res.Restorefh.Status = NFSStatOk
+			c.cur = c.saved
+		}
+		return res.Restorefh.Status
+	case OpSavefh:
+		res.Savefh.Status = NFSStatNofilehandle
+		if c.cur.b != nil {
+			res.Savefh.Status = NFSStatOk
+			c.saved = c.cur
+		}
+		return res.Savefh.Status
+	case OpSecinfo:
+		res.Secinfo.Status = r.secinfo(c, &op.Secinfo, &res.Secinfo)
+		return res.Secinfo.Status
+	case OpSetattr:
+		res.Setattr.Status = r.setattr(c, &op.Setattr, &res.Setattr)
+		return res.Setattr.Status
+	case OpSetclientid:
+		args := &op.Setclientid
+		id, confirm, inUse, stat := r.state.setclientid(args.Client.ID, args.Client.Verifier,
+			principal(c.call), args.Callback.Location)
+		res.Setclientid.Status = stat
+		switch stat {
+		case NFSStatOk:
+			res.Setclientid.Resok = SETCLIENTID4resok{Clientid: id, Confirm: confirm}
+		case NFSStatClidInuse:
+			res.Setclientid.InUse = inUse
+		}
+		return stat
+	case OpSetclientidConfirm:
+		args := &op.SetclientidConfirm
+		res.SetclientidConfirm.Status = r.state.confirm(args.Clientid, args.Confirm)
+		return res.SetclientidConfirm.Status
+	case OpWrite:
+		res.Write.Status = r.write(c, &op.Write, &res.Write)
+		return res.Write.Status
+	case OpReleaseLockowner:
+		// we hand out no lock state to release
+		res.ReleaseLockowner.Status = NFSStatOk
+		return NFSStatOk
+	case OpDelegpurge:
+		res.Delegpurge.Status = NFSStatNotsupp
+		return NFSStatNotsupp
+	case OpDelegreturn:
+		res.Delegreturn.Status = NFSStatNotsupp
+		return NFSStatNotsupp
+	case OpLock:
+		res.Lock.Status = NFSStatNotsupp
+		return NFSStatNotsupp
+	case OpLockt:
+		res.Lockt.Status = NFSStatNotsupp
+		return NFSStatNotsupp
+	case OpLocku:
+		res.Locku.Status = NFSStatNotsupp
+		return NFSStatNotsupp
+	case OpNverify:
+		res.Nverify.Status = NFSStatNotsupp
+		return NFSStatNotsupp
+	case OpOpenattr:
+		res.Openattr.Status = NFSStatNotsupp
+		return NFSStatNotsupp
+	case OpReadlink:
+		res.Readlink.Status = NFSStatNotsupp
+		return NFSStatNotsupp
+	case OpVerify:
+		res.Verify.Status = NFSStatNotsupp
+		return NFSStatNotsupp
+	default:
+		res.Op = OpIllegal
+		res.Illegal.Status = NFSStatOpIllegal
+		return NFSStatOpIllegal
+	}
+}
+
+// set makes b the current file handle. Handles of pseudo directories name
+// no export.
+func (r *NFS4) set(c *compound4, b []byte) NFSStat {
+	fh, err := r.mux.DecodeHandle(b)
+	if err != nil {
+		return handleStatus(err)
+	}
+	cur := fh4{b: b, fh: fh}
+	if fh.Export == 0 {
+		node, _ := r.mux.Load(fh.Object)
+		d, ok := node.(*pseudoDir)
+		if !ok {
+			return NFSStatStale
+		}
+		cur.pseudo = d
+	}
+	c.cur = cur
+	return NFSStatOk
+}
+
+// setObject makes object reached through export the current file handle.
+func (r *NFS4) setObject(c *compound4, export uint32, object []byte) NFSStat {
+	b, err := r.mux.EncodeHandle(FileHandle{Export: export, Object: object})
+	if err != nil {
+		return NFSStatServerfault
+	}
+	return r.set(c, b)
+}
+
+func (r *NFS4) putrootfh(c *compound4) NFSStat {
+	export, object, ok := r.mux.pseudo.root()
+	if !ok {
+		return NFSStatNoent
+	}
+	return r.setObject(c, export, object)
+}
+
+// attr returns NFSv3 attributes of the current file.
+func (r *NFS4) attr(c *compound4) (Fattr3, NFSStat) {
+	if c.cur.b == nil {
+		return Fattr3{}, NFSStatNofilehandle
+	}
+	if c.cur.pseudo != nil {
+		return r.mux.pseudo.attr(c.cur.pseudo), NFSStatOk
+	}
+	var res GETATTR3res
+	r.mux.rpc.Getattr(&GETATTR3args{Header: c.header(), Object: c.cur.b}, &res)
+	return res.Attr, res.Status
+}
+
+// dir returns attributes of the current file, which must be a directory.
+func (r *NFS4) dir(c *compound4) (Fattr3, NFSStat) {
+	attr, stat := r.attr(c)
+	if stat == NFSStatOk && attr.Type != NF3Dir {
+		stat = NFSStatNotdir
+	}
+	return attr, stat
+}
+
+// regular checks the current file is one we read and write.
+func (r *NFS4) regular(c *compound4) NFSStat {
+	attr, stat := r.attr(c)
+	switch {
+	case stat != NFSStatOk:
+		return stat
+	case attr.Type == NF3Dir:
+		return NFSStatIsdir
+	case attr.Type == NF3Lnk:
+		return NFSStatSymlink
+	case attr.Type != NF3Reg:
+		return NFSStatInval
+	}
+	return NFSStatOk
+}
+
+// change returns the change attribute of a.
+func change(a Fattr3) uint64 {
+	return uint64(a.Ctime.Seconds)*1e9 + uint64(a.Ctime.Nseconds)
+}
+
+// changeInfo returns how the directory with attributes before changed.
+func (r *NFS4) changeInfo(c *compound4, before Fattr3) ChangeInfo4 {
+	after, _ := r.attr(c)
+	return ChangeInfo4{Before: change(before), After: change(after)}
+}
+
+func (r *NFS4) access(c *compound4, args *ACCESS4args, res *ACCESS4res) NFSStat {
+	if c.cur.b == nil {
+		return NFSStatNofilehandle
+	}
+	res.Supported = args.Access & (AccessRead | AccessLookup | AccessModify |
+		AccessExtend | AccessDelete | AccessExecute)
+	if c.cur.pseudo != nil {
+		res.Access = res.Supported & (AccessRead | AccessLookup | AccessExecute)
+		return NFSStatOk
+	}
+	var res3 ACCESS3res
+	r.mux.rpc.Access(&ACCESS3args{Header: c.header(), Object: c.cur.b, Access: res.Supported}, &res3)
+	res.Access = res3.Access
+	return res3.Status
+}
+
+// fattr4 encodes mask of attributes a of the file with handle fh.
+func (r *NFS4) fattr4(c *compound4, a Fattr3, fh []byte, export uint32, mask Bitmap4) Fattr4 {
+	return fattr4(attrSource{
+		attr:   a,
+		fh:     fh,
+		export: export,
+		lease:  uint32(r.state.lease.Seconds()),
+		pc:     r.mux.pathconf,
+		info: func() FSINFO3res {
+			var res FSINFO3res
+			root, ok := r.mux.pseudo.exportRoot(export)
+			if !ok {
+				return res
+			}
+			b, err := r.mux.EncodeHandle(FileHandle{Export: export, Object: root})
+			if err != nil {
+				return res
+			}
+			r.mux.rpc.Fsinfo(&FSINFO3args{Header: c.header(), Object: b}, &res)
+			return res
+		},
+	}, mask)
+}
+
+func (r *NFS4) getattr(c *compound4, args *GETATTR4args, res *GETATTR4res) NFSStat {
+	a, stat := r.attr(c)
+	if stat != NFSStatOk {
+		return stat
+	}
+	res.Attrs = r.fattr4(c, a, c.cur.b, c.cur.fh.Export, args.AttrRequest)
+	return NFSStatOk
+}
+
+func (r *NFS4) lookup(c *compound4, name string) NFSStat {
+	if _, stat := r.dir(c); stat != NFSStatOk {
+		return stat
+	}
+	var (
+		e  pseudoEntry
+		ok bool
+	)
+	if d := c.cur.pseudo; d != nil {
+		if e, ok = r.mux.pseudo.lookup(d, name); !ok {
+			return NFSStatNoent
+		}
+	} else if r.mux.pseudo.isRoot(c.cur.fh.Export, c.cur.fh.Object) {
+		e, ok = r.mux.pseudo.mounted(c.cur.fh.Export, name)
+	}
+	switch {
+	case ok && e.dir != nil:
+		return r.setObject(c, 0, e.object)
+	case ok:
+		if _, stat := r.mux.Authorize(c.call, e.export, false); stat != NFSStatOk {
+			return stat
+		}
+		return r.setObject(c, e.export, e.object)
+	}
+	var res LOOKUP3res
+	r.mux.rpc.Lookup(&LOOKUP3args{
+		Header: c.header(),
+		What:   Diropargs3{Dir: c.cur.b, Name: name},
+	}, &res)
+	if res.Status != NFSStatOk {
+		return res.Status
+	}
+	return r.set(c, res.Object)
+}
+
+// lookupp goes up from export roots into the pseudo file system.
+func (r *NFS4) lookupp(c *compound4) NFSStat {
+	if _, stat := r.dir(c); stat != NFSStatOk {
+		return stat
+	}
+	if c.cur.pseudo != nil || r.mux.pseudo.isRoot(c.cur.fh.Export, c.cur.fh.Object) {
+		export, object, ok := r.mux.pseudo.parent(c.cur.pseudo, c.cur.fh.Export)
+		if !ok {
+			return NFSStatNoent
+		}
+		return r.setObject(c, export, object)
+	}
+	return r.lookup(c, "..")
+}
+
+func (r *NFS4) create(c *compound4, args *CREATE4args, res *CREATE4res) NFSStat {
+	before, stat := r.dir(c)
+	if stat != NFSStatOk {
+		return stat
+	}
+	if c.cur.pseudo != nil {
+		return NFSStatRofs
+	}
+	if args.Objtype.Type != NF3Dir {
+		// TODO: symlinks and special files
+		return NFSStatBadtype
+	}
+	attr, set, stat := sattr4(args.Attrs)
+	if stat != NFSStatOk {
+		return stat
+	}
+	var res3 MKDIR3res
+	r.mux.rpc.Mkdir(&MKDIR3args{
+		Header: c.header(),
+		Where:  Diropargs3{Dir: c.cur.b, Name: args.Name},
+		Attr:   attr,
+	}, &res3)
+	if res3.Status != NFSStatOk {
+		return res3.Status
+	}
+	res.Cinfo = r.changeInfo(c, before)
+	res.Attrset = set
+	return r.set(c, res3.Handle.FH)
+}
+
+func (r *NFS4) link(c *compound4, args *LINK4args, res *ChangeInfo4res) NFSStat {
+	if c.saved.b == nil {
+		return NFSStatNofilehandle
+	}
+	before, stat := r.dir(c)
+	if stat != NFSStatOk {
+		return stat
+	}
+	if c.cur.pseudo != nil || c.saved.pseudo != nil {
+		return NFSStatRofs
+	}
+	var res3 LINK3res
+	r.mux.rpc.Link(&LINK3args{
+		Header: c.header(),
+		Object: c.saved.b,
+		Link:   Diropargs3{Dir: c.cur.b, Name: args.Name},
+	}, &res3)
+	if res3.Status == NFSStatOk {
+		res.Cinfo = r.changeInfo(c, before)
+	}
+	return res3.Status
+}
+
+func (r *NFS4) remove(c *compound4, args *REMOVE4args, res *ChangeInfo4res) NFSStat {
+	before, stat := r.dir(c)
+	if stat != NFSStatOk {
+		return stat
+	}
+	if c.cur.pseudo != nil {
+		return NFSStatRofs
+	}
+	var lookup LOOKUP3res
+	r.mux.rpc.Lookup(&LOOKUP3args{
+		Header: c.header(),
+		What:   Diropargs3{Dir: c.cur.b, Name: args.Target},
+	}, &lookup)
+	if lookup.Status != NFSStatOk {
+		return lookup.Status
+	}
+	what := Diropargs3{Dir: c.cur.b, Name: args.Target}
+	if lookup.Attr.IsSet && lookup.Attr.Attr.Type == NF3Dir {
+		var res3 RMDIR3res
+		r.mux.rpc.Rmdir(&RMDIR3args{Header: c.header(), Object: what}, &res3)
+		stat = res3.Status
+	} else {
+		var res3 REMOVE3res
+		r.mux.rpc.Remove(&REMOVE3args{Header: c.header(), Object: what}, &res3)
+		stat = res3.Status
+	}
+	if stat == NFSStatOk {
+		res.Cinfo = r.changeInfo(c, before)
+	}
+	return stat
+}
+
+// rename moves args.OldName in the saved directory to args.NewName in the
+// current one.
+func (r *NFS4) rename(c *compound4, args *RENAME4args, res *RENAME4res) NFSStat {
+	if c.saved.b == nil {
+		return NFSStatNofilehandle
+	}
+	to, stat := r.dir(c)
+	if stat != NFSStatOk {
+		return stat
+	}
+	cur := c.cur
+	c.cur = c.saved
+	from, stat := r.dir(c)
+	c.cur = cur
+	if stat != NFSStatOk {
+		return stat
+	}
+	if c.cur.pseudo != nil || c.saved.pseudo != nil {
+		return NFSStatRofs
+	}
+	var res3 RENAME3res
+	r.mux.rpc.Rename(&RENAME3args{
+		Header: c.header(),
+		From:   Diropargs3{Dir: c.saved.b, Name: args.OldName},
+		To:     Diropargs3{Dir: c.cur.b, Name: args.NewName},
+	}, &res3)
+	if res3.Status != NFSStatOk {
+		return res3.Status
+	}
+	res.TargetCinfo = r.changeInfo(c, to)
+	c.cur = c.saved
+	res.SourceCinfo = r.changeInfo(c, from)
+	c.cur = cur
+	return NFSStatOk
+}
+
+func (r *NFS4) secinfo(c *compound4, args *SECINFO4args, res *SECINFO4res) NFSStat {
+	if stat := r.lookup(c, args.Name); stat != NFSStatOk {
+		return stat
+	}
+	// SECINFO consumes the current file handle
+	c.cur = fh4{}
+	res.Flavors = []Secinfo4{{Flavor: AuthSys}, {Flavor: AuthNone}}
+	return NFSStatOk
+}
+
+func (r *NFS4) setattr(c *compound4, args *SETATTR4args, res *SETATTR4res) NFSStat {
+	if c.cur.b == nil {
+		return NFSStatNofilehandle
+	}
+	if c.cur.pseudo != nil {
+		return NFSStatRofs
+	}
+	attr, set, stat := sattr4(args.Attrs)
+	if stat != NFSStatOk {
+		return stat
+	}
+	if attr.Size.IsSet {
+		if stat := r.regular(c); stat != NFSStatOk {
+			return stat
+		}
+		if stat := r.state.checkIO(args.Stateid, Handle(c.cur.fh.Object), true); stat != NFSStatOk {
+			return stat
+		}
+	}
+	var res3 SETATTR3res
+	r.mux.rpc.Setattr(&SETATTR3args{Header: c.header(), Object: c.cur.b, Sattr: attr}, &res3)
+	if res3.Status == NFSStatOk {
+		res.Attrsset = set
+	}
+	return res3.Status
+}
+
+// open opens, and creates if asked, the file args.Claim.File in the
+// current directory, which it replaces as the current file handle.
+func (r *NFS4) open(c *compound4, args *OPEN4args, res *OPEN4res) NFSStat {
+	owner := ownerKey{args.Owner.Clientid, string(args.Owner.Owner)}
+	if stat := r.state.renew(owner.clientid); stat != NFSStatOk {
+		return stat
+	}
+	last, stat := r.state.seqid(owner, args.Seqid, OpOpen, Stateid4{})
+	if stat != NFSStatOk {
+		return stat
+	}
+	if last != nil {
+		*res = last.res.(OPEN4res)
+		c.cur = last.cur
+		return last.stat
+	}
+	stat = r.doOpen(c, owner, args, res)
+	r.state.advance(owner, args.Seqid, &replay4{op: OpOpen, stat: stat, res: *res, cur: c.cur})
+	return stat
+}
+
+func (r *NFS4) doOpen(c *compound4, owner ownerKey, args *OPEN4args, res *OPEN4res) NFSStat {
+	before, stat := r.dir(c)
+	if stat != NFSStatOk {
+		return stat
+	}
+	switch args.Claim.Claim {
+	case 0: // CLAIM_NULL
+	case 1: // CLAIM_PREVIOUS
+		return NFSStatNoGrace
+	default:
+		return NFSStatNotsupp
+	}
+	access, deny := args.ShareAccess, args.ShareDeny
+	if access == 0 || access&^(ShareAccessRead|ShareAccessWrite) != 0 ||
+		deny&^(ShareDenyRead|ShareDenyWrite) != 0 {
+		return NFSStatInval
+	}
+	if c.cur.pseudo != nil {
+		if _, ok := r.mux.pseudo.lookup(c.cur.pseudo, args.Claim.File); ok {
+			return NFSStatIsdir
+		}
+		if args.Openhow.Create {
+			return NFSStatRofs
+		}
+		return NFSStatNoent
+	}
+
+	dir := c.cur
+	var lookup LOOKUP3res
+	r.mux.rpc.Lookup(&LOOKUP3args{
+		Header: c.header(),
+		What:   Diropargs3{Dir: dir.b, Name: args.Claim.File},
+	}, &lookup)
+	switch {
+	case lookup.Status == NFSStatOk:
+		// a retried EXCLUSIVE create finds the file it made, see verfTime
+		found := lookup.Attr.Attr
+		retried := args.Openhow.Create && args.Openhow.How.Mode == 2 && lookup.Attr.IsSet &&
+			found.Type == NF3Reg && found.Mtime == verfTime(args.Openhow.How.CreateVerf)
+		if args.Openhow.Create && args.Openhow.How.Mode != 0 && !retried {
+			// GUARDED and EXCLUSIVE creates must not reuse existing files
+			return NFSStatExist
+		}
+		if stat := r.set(c, lookup.Object); stat != NFSStatOk {
+			return stat
+		}
+		if stat := r.regular(c); stat != NFSStatOk {
+			c.cur = dir
+			return stat
+		}
+		if stat := r.openAccess(c, access); stat != NFSStatOk {
+			c.cur = dir
+			return stat
+		}
+		if args.Openhow.Create {
+			attr, _, stat := sattr4(args.Openhow.How.Unchecked)
+			if stat == NFSStatOk && attr.Size.IsSet {
+				var res3 SETATTR3res
+				r.mux.rpc.Setattr(&SETATTR3args{
+					Header: c.header(),
+					Object: c.cur.b,
+					Sattr:  Sattr3{Size: attr.Size},
+				}, &res3)
+				stat = res3.Status
+				res.Attrset = NewBitmap4(Attr4Size)
+			}
+			if stat != NFSStatOk {
+				c.cur = dir
+				return stat
+			}
+		}
+	case lookup.Status == NFSStatNoent && args.Openhow.Create:
+		how := Createhow3{Mode: int32(args.Openhow.How.Mode)}
+		var fattr Fattr4
+		switch how.Mode {
+		case 0:
+			fattr = args.Openhow.How.Unchecked
+		case 1:
+			fattr = args.Openhow.How.Guarded
+		case 2:
+			how.CreateVerf = args.Openhow.How.CreateVerf
+		}
+		attr, set, stat := sattr4(fattr)
+		if stat != NFSStatOk {
+			return stat
+		}
+		how.UncheckedAttr, how.GuardedAttr = attr, attr
+		var res3 CREATE3res
+		r.mux.rpc.Create(&CREATE3args{
+			Header: c.header(),
+			Where:  Diropargs3{Dir: dir.b, Name: args.Claim.File},
+			How:    how,
+		}, &res3)
+		if res3.Status != NFSStatOk {
+			return res3.Status
+		}
+		if !res3.Handle.IsSet {
+			return NFSStatServerfault
+		}
+		if stat := r.set(c, res3.Handle.FH); stat != NFSStatOk {
+			return stat
+		}
+		res.Attrset = set
+	default:
+		return lookup.Status
+	}
+
+	stateid, stat := r.state.open(owner, Handle(c.cur.fh.Object), access, deny)
+	if stat != NFSStatOk {
+		c.cur = dir
+		return stat
+	}
+	res.Stateid = stateid
+	res.Rflags = OpenResultLocktypePosix
+	cur := c.cur
+	c.cur = dir
+	res.Cinfo = r.changeInfo(c, before)
+	c.cur = cur
+	return NFSStatOk
+}
+
+// openAccess checks the caller may open the current file for access.
+func (r *NFS4) openAccess(c *compound4, access uint32) NFSStat {
+	var want uint32
+	if access&ShareAccessRead != 0 {
+		want |= AccessRead
+	}
+	if access&ShareAccessWrite != 0 {
+		want |= AccessModify
+	}
+	var res3 ACCESS3res
+	r.mux.rpc.Access(&ACCESS3args{Header: c.header(), Object: c.cur.b, Access: want}, &res3)
+	if res3.Status != NFSStatOk {
+		return res3.Status
+	}
+	if res3.Access != want {
+		return NFSStatAcces
+	}
+	return NFSStatOk
+}
+
+// stateful runs op, one of the operations on open stateids, on the open
+// stateid names, checking seqid against its owner.
+func (r *NFS4) stateful(c *compound4, op uint32, stateid Stateid4, seqid uint32, res *Stateid4res, do func(file Handle) (Stateid4, NFSStat)) NFSStat {
+	if c.cur.b == nil {
+		return NFSStatNofilehandle
+	}
+	owner, stat := r.state.owner(stateid)
+	if stat != NFSStatOk {
+		return stat
+	}
+	last, stat := r.state.seqid(owner, seqid, op, stateid)
+	if stat != NFSStatOk {
+		return stat
+	}
+	if last != nil {
+		*res = last.res.(Stateid4res)
+		return last.stat
+	}
+	res.Stateid, stat = do(Handle(c.cur.fh.Object))
+	r.state.advance(owner, seqid, &replay4{op: op, stateid: stateid, stat: stat, res: *res})
+	return stat
+}
+
+func (r *NFS4) openConfirm(c *compound4, args *OPEN_CONFIRM4args, res *Stateid4res) NFSStat {
+	return r.stateful(c, OpOpenConfirm, args.Stateid, args.Seqid, res, func(file Handle) (Stateid4, NFSStat) {
+		return r.state.confirmOpen(args.Stateid, file)
+	})
+}
+
+func (r *NFS4) openDowngrade(c *compound4, args *OPEN_DOWNGRADE4args, res *Stateid4res) NFSStat {
+	return r.stateful(c, OpOpenDowngrade, args.Stateid, args.Seqid, res, func(file Handle) (Stateid4, NFSStat) {
+		return r.state.downgrade(args.Stateid, file, args.ShareAccess, args.ShareDeny)
+	})
+}
+
+func (r *NFS4) close(c *compound4, args *CLOSE4args, res *Stateid4res) NFSStat {
+	return r.stateful(c, OpClose, args.Stateid, args.Seqid, res, func(file Handle) (Stateid4, NFSStat) {
+		return r.state.close(args.Stateid, file)
+	})
+}
+
+func (r *NFS4) read(c *compound4, args *READ4args, res *READ4res) NFSStat {
+	if stat := r.regular(c); stat != NFSStatOk {
+		return stat
+	}
+	if stat := r.state.checkIO(args.Stateid, Handle(c.cur.fh.Object), false); stat != NFSStatOk {
+		return stat
+	}
+	var res3 READ3res
+	r.mux.rpc.Read(&READ3args{
+		Header: c.header(),
+		Object: c.cur.b,
+		Offset: args.Offset,
+		Count:  args.Count,
+	}, &res3)
+	res.EOF = res3.EOF
+	res.Data = res3.Data
+	if int(res3.Count) < len(res.Data) {
+		res.Data = res.Data[:res3.Count]
+	}
+	return res3.Status
+}
+
+func (r *NFS4) write(c *compound4, args *WRITE4args, res *WRITE4res) NFSStat {
+	if stat := r.regular(c); stat != NFSStatOk {
+		return stat
+	}
+	if stat := r.state.checkIO(args.Stateid, Handle(c.cur.fh.Object), true); stat != NFSStatOk {
+		return stat
+	}
+	var res3 WRITE3res
+	r.mux.rpc.Write(&WRITE3args{
+		Header: c.header(),
+		Object: c.cur.b,
+		Offset: args.Offset,
+		Count:  uint32(len(args.Data)),
+		Stable: args.Stable,
+		Data:   args.Data,
+	}, &res3)
+	res.Count = res3.Count
+	res.Committed = res3.Committed
+	res.Verf = res3.Verf
+	return res3.Status
+}
+
+func (r *NFS4) commit(c *compound4, args *COMMIT4args, res *COMMIT4res) NFSStat {
+	if stat := r.regular(c); stat != NFSStatOk {
+		return stat
+	}
+	var res3 COMMIT3res
+	r.mux.rpc.Commit(&COMMIT3args{
+		Header: c.header(),
+		Object: c.cur.b,
+		Offset: args.Offset,
+		Count:  args.Count,
+	}, &res3)
+	res.Verf = res3.Verf
+	return res3.Status
+}
+
+const (
+	// readdirCookie is the first cookie we hand out, 1 and 2 are reserved.
+	readdirCookie = 3
+	// readdirBatch is the least we ask NFSv3 READDIRPLUS for.
+	readdirBatch = 32 << 10
+)
+
+// readdir lists the current directory but for "." and "..", as many
+// entries as fit in args.MaxCount.
+func (r *NFS4) readdir(c *compound4, args *READDIR4args, res *READDIR4res) NFSStat {
+	if _, stat := r.dir(c); stat != NFSStatOk {
+		return stat
+	}
+	type entry struct {
+		cookie uint64
+		name   string
+		attrs  Fattr4
+	}
+	var (
+		entries []entry
+		eof     = true
+	)
+	if d := c.cur.pseudo; d != nil {
+		for i, e := range r.mux.pseudo.entries(d) {
+			cookie := uint64(i) + readdirCookie
+			if cookie <= args.Cookie {
+				continue
+			}
+			export, a := uint32(0), Fattr3{}
+			if e.dir != nil {
+				a = r.mux.pseudo.attr(e.dir)
+			} else {
+				export = e.export
+				b, err := r.mux.EncodeHandle(FileHandle{Export: export, Object: e.object})
+				if err != nil {
+					continue
+				}
+				var res3 GETATTR3res
+				r.mux.rpc.Getattr(&GETATTR3args{Header: c.header(), Object: b}, &res3)
+				a = res3.Attr
+			}
+			b, _ := r.mux.EncodeHandle(FileHandle{Export: export, Object: e.object})
+			entries = append(entries, entry{cookie, e.name, r.fattr4(c, a, b, export, args.AttrRequest)})
+		}
+	} else {
+		var cookie uint64
+		if args.Cookie >= readdirCookie {
+			cookie = args.Cookie - (readdirCookie - 1)
+		}
+		// NFSv3 entries carry other attributes than asked for, so their
+		// size tells little about ours: ask for plenty and trim below
+		count := args.MaxCount
+		if count < readdirBatch {
+			count = readdirBatch
+		}
+		var res3 READDIRPLUS3res
+		r.mux.rpc.Readdirplus(&READDIRPLUS3args{
+			Header:     c.header(),
+			Dir:        c.cur.b,
+			Cookie:     cookie,
+			CookieVerf: binary.BigEndian.Uint64(args.CookieVerf[:]),
+			DirCount:   count,
+			MaxCount:   count,
+		}, &res3)
+		if res3.Status != NFSStatOk {
+			return res3.Status
+		}
+		binary.BigEndian.PutUint64(res.CookieVerf[:], res3.CookieVerf)
+		eof = res3.Reply.EOF
+		for e := res3.Reply.Entry; e != nil; e = e.Next {
+			if e.FileName == "." || e.FileName == ".." {
+				continue
+			}
+			a := e.Attr.Attr
+			if !e.Attr.IsSet && e.Handle.IsSet {
+				var res3 GETATTR3res
+				r.mux.rpc.Getattr(&GETATTR3args{Header: c.header(), Object: e.Handle.FH}, &res3)
+				a = res3.Attr
+			}
+			entries = append(entries, entry{
+				cookie: e.Cookie + (readdirCookie - 1),
+				name:   e.FileName,
+				attrs:  r.fattr4(c, a, e.Handle.FH, c.cur.fh.Export, args.AttrRequest),
+			})
+		}
+	}
+
+	// status, verifier, list end and eof
+	size := 4 + 8 + 4 + 4
+	var tail *Entry4
+	for i, e := range entries {
+		size += 4 + 8 + xdrSize(len(e.name)) + 4*(1+len(e.attrs.Mask)) + xdrSize(len(e.attrs.Vals))
+		if size > int(args.MaxCount) {
+			if i == 0 {
+				return NFSStatToosmall
+			}
+			eof = false
+			break
+		}
+		next := &Entry4{Cookie: e.cookie, Name: e.name, Attrs: e.attrs}
+		if tail == nil {
+			res.Reply.Entries = next
+		} else {
+			tail.Next = next
+		}
+		tail = next
+	}
+	res.Reply.EOF = eof
+	return NFSStatOk
+}
+
+// xdrSize returns the size of variable length opaque data of n bytes on
+// the wire.
+func xdrSize(n int) int {
+	return 4 + (n+3)&^3
+}
@@ -0,0 +1,180 @@
+package nfs_test
+
+import (
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/cmd/simple-nfs-server/memfs"
+	"github.com/dzeromsk/xdrrpc/nfs"
+	"github.com/dzeromsk/xdrrpc/nfstest"
+)
+
+// nfs4 runs COMPOUNDs as uid against an empty memfs tree.
+type nfs4 struct {
+	t   *testing.T
+	r   *nfs.NFS4
+	uid uint32
+}
+
+func newNFS4(t *testing.T) *nfs4 {
+	srv := nfstest.NewServer(func(mux nfs.ServeMux) interface{} {
+		return memfs.NewDir(mux, map[string]memfs.Node{})
+	})
+	return &nfs4{t: t, r: srv.Mux.NFS4Receiver().(*nfs.NFS4)}
+}
+
+func (c *nfs4) compound(ops ...nfs.Argop4) nfs.COMPOUND4res {
+	args := &nfs.COMPOUND4args{Ops: ops}
+	args.SetCall(&xdrrpc.CallInfo{Cred: xdrrpc.NewAuthSys(xdrrpc.AuthSysParms{UID: c.uid})})
+	var res nfs.COMPOUND4res
+	c.r.Compound(args, &res)
+	return res
+}
+
+// clientid returns a confirmed client id.
+func (c *nfs4) clientid() uint64 {
+	res := c.compound(nfs.Argop4{Op: nfs.OpSetclientid, Setclientid: nfs.SETCLIENTID4args{
+		Client: nfs.NFSClientID4{ID: []byte("test")},
+	}})
+	ok := res.Ops[0].Setclientid.Resok
+	res = c.compound(nfs.Argop4{Op: nfs.OpSetclientidConfirm, SetclientidConfirm: nfs.SETCLIENTID_CONFIRM4args{
+		Clientid: ok.Clientid,
+		Confirm:  ok.Confirm,
+	}})
+	if res.Status != nfs.NFSStatOk {
+		c.t.Fatal("SETCLIENTID_CONFIRM:", res.Status)
+	}
+	return ok.Clientid
+}
+
+func open4(clientid uint64, seqid uint32, name string, how nfs.Createhow4) nfs.Argop4 {
+	return nfs.Argop4{Op: nfs.OpOpen, Open: nfs.OPEN4args{
+		Seqid:       seqid,
+		ShareAccess: nfs.ShareAccessRead | nfs.ShareAccessWrite,
+		Owner:       nfs.OpenOwner4{Clientid: clientid, Owner: []byte("owner")},
+		Openhow:     nfs.Openflag4{Create: true, How: how},
+		Claim:       nfs.OpenClaim4{File: name},
+	}}
+}
+
+func close4(seqid uint32, stateid nfs.Stateid4) nfs.Argop4 {
+	return nfs.Argop4{Op: nfs.OpClose, Close: nfs.CLOSE4args{Seqid: seqid, Stateid: stateid}}
+}
+
+var (
+	putroot = nfs.Argop4{Op: nfs.OpPutrootfh}
+	getfh   = nfs.Argop4{Op: nfs.OpGetfh}
+)
+
+func TestNFS4ExclusiveOpenRetry(t *testing.T) {
+	exclusive := func(verf byte) nfs.Createhow4 {
+		return nfs.Createhow4{Mode: 2, CreateVerf: [8]byte{1, 2, 3, 4, 5, 6, 7, verf}}
+	}
+	for _, tt := range []struct {
+		name string
+		how  nfs.Createhow4
+		want nfs.NFSStat
+	}{
+		{"same verifier", exclusive(8), nfs.NFSStatOk},
+		{"other verifier", exclusive(9), nfs.NFSStatExist},
+		{"guarded", nfs.Createhow4{Mode: 1}, nfs.NFSStatExist},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newNFS4(t)
+			id := c.clientid()
+			first := c.compound(putroot, open4(id, 1, "file", exclusive(8)), getfh)
+			if first.Status != nfs.NFSStatOk {
+				t.Fatal("OPEN:", first.Status)
+			}
+			// a retry after the reply to the first was lost and the
+			// seqid moved on, as after a server restart
+			res := c.compound(putroot, open4(id, 2, "file", tt.how), getfh)
+			if res.Status != tt.want {
+				t.Fatalf("retried OPEN: got %v, want %v", res.Status, tt.want)
+			}
+			if tt.want == nfs.NFSStatOk && string(res.Ops[2].Getfh.Object) != string(first.Ops[2].Getfh.Object) {
+				t.Error("retried OPEN opened another file")
+			}
+		})
+	}
+}
+
+func TestNFS4ClientidInUse(t *testing.T) {
+	c := newNFS4(t)
+	callback := nfs.ClientAddr4{Netid: "tcp", Addr: "127.0.0.1.3.232"}
+	res := c.compound(nfs.Argop4{Op: nfs.OpSetclientid, Setclientid: nfs.SETCLIENTID4args{
+		Client:   nfs.NFSClientID4{ID: []byte("test")},
+		Callback: nfs.CBClient4{Location: callback},
+	}})
+	ok := res.Ops[0].Setclientid.Resok
+	c.compound(nfs.Argop4{Op: nfs.OpSetclientidConfirm, SetclientidConfirm: nfs.SETCLIENTID_CONFIRM4args{
+		Clientid: ok.Clientid,
+		Confirm:  ok.Confirm,
+	}})
+
+	for _, tt := range []struct {
+		name string
+		uid  uint32
+		want nfs.NFSStat
+	}{
+		{"same principal", 0, nfs.NFSStatOk},
+		{"other principal", 1000, nfs.NFSStatClidInuse},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			other := &nfs4{t: t, r: c.r, uid: tt.uid}
+			res := other.compound(nfs.Argop4{Op: nfs.OpSetclientid, Setclientid: nfs.SETCLIENTID4args{
+				Client: nfs.NFSClientID4{ID: []byte("test"), Verifier: [8]byte{1}},
+			}})
+			if res.Status != tt.want {
+				t.Fatalf("got %v, want %v", res.Status, tt.want)
+			}
+			if got := res.Ops[0].Setclientid.InUse; tt.want == nfs.NFSStatClidInuse && got != callback {
+				t.Errorf("in use by %+v, want %+v", got, callback)
+			}
+		})
+	}
+}
+
+func TestNFS4Retransmission(t *testing.T) {
+	c := newNFS4(t)
+	id := c.clientid()
+	how := nfs.Createhow4{Mode: 1}
+
+	first := c.compound(putroot, open4(id, 1, "file", how), getfh)
+	if first.Status != nfs.NFSStatOk {
+		t.Fatal("OPEN:", first.Status)
+	}
+	res := c.compound(putroot, open4(id, 1, "file", how), getfh)
+	if res.Status != nfs.NFSStatOk || res.Ops[1].Open.Stateid != first.Ops[1].Open.Stateid {
+		t.Fatalf("retransmitted OPEN: %v, stateid %v, want %v", res.Status, res.Ops[1].Open.Stateid, first.Ops[1].Open.Stateid)
+	}
+	if string(res.Ops[2].Getfh.Object) != string(first.Ops[2].Getfh.Object) {
+		t.Error("retransmitted OPEN left another current file handle")
+	}
+
+	file := nfs.Argop4{Op: nfs.OpPutfh, Putfh: nfs.PUTFH4args{Object: first.Ops[2].Getfh.Object}}
+	stateid := first.Ops[1].Open.Stateid
+	closed := c.compound(file, close4(2, stateid))
+	if closed.Status != nfs.NFSStatOk {
+		t.Fatal("CLOSE:", closed.Status)
+	}
+	res = c.compound(file, close4(2, stateid))
+	if res.Status != nfs.NFSStatOk || res.Ops[1].Close.Stateid != closed.Ops[1].Close.Stateid {
+		t.Fatalf("retransmitted CLOSE: %v", res.Status)
+	}
+
+	for _, tt := range []struct {
+		name string
+		op   nfs.Argop4
+		want nfs.NFSStat
+	}{
+		{"old seqid", close4(1, stateid), nfs.NFSStatBadSeqid},
+		{"next seqid on closed stateid", close4(3, stateid), nfs.NFSStatBadStateid},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := c.compound(file, tt.op); res.Status != tt.want {
+				t.Errorf("got %v, want %v", res.Status, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package nfs
+
+import (
+	"github.com/dzeromsk/xdrrpc"
+)
+
+const (
+	NfsACLProg = 100227
+	NfsACLVers = 3
+)
+
+func init() {
+	xdrrpc.Register(NfsACLProg, NfsACLVers, 0, "NFSACL", "Null")
+	xdrrpc.Register(NfsACLProg, NfsACLVers, 1, "NFSACL", "Getacl")
+	xdrrpc.Register(NfsACLProg, NfsACLVers, 2, "NFSACL", "Setacl")
+}
+
+// GETACL and SETACL mask bits.
+const (
+	ACLMaskAccess     = 0x01 // access ACL
+	ACLMaskAccessCnt  = 0x02 // number of access ACL entries
+	ACLMaskDefault    = 0x04 // default ACL
+	ACLMaskDefaultCnt = 0x08 // number of default ACL entries
+)
+
+// aclDefault flags entries of default ACLs on the wire.
+const aclDefault = 0x1000
+
+// ACLEntries is an ACL as sent over the wire.
+type ACLEntries struct {
+	Count   uint32
+	Entries []ACE
+}
+
+type GETACL3args struct {
+	xdrrpc.Header
+	Object []byte
+	Mask   uint32
+}
+
+type GETACL3res struct {
+	Status  NFSStat    `xdr:"union"`
+	Attr    PostOpAttr `xdr:"unioncase=0"`
+	Mask    uint32     `xdr:"unioncase=0"`
+	Access  ACLEntries `xdr:"unioncase=0"`
+	Default ACLEntries `xdr:"unioncase=0"`
+}
+
+type SETACL3args struct {
+	xdrrpc.Header
+	Object  []byte
+	Mask    uint32
+	Access  ACLEntries
+	Default ACLEntries
+}
+
+type SETACL3res struct {
+	Status NFSStat
+	Attr   PostOpAttr
+}
+
+func (mux *serveMux) ACLReceiver() interface{} {
+	return &mux.acl
+}
+
+// NFSACL serves POSIX ACLs of handlers implementing ACLer, as Linux
+// clients expect for getfacl and setfacl.
+type NFSACL struct {
+	mux *serveMux
+}
+
+func (r *NFSACL) Null(args *NullArgs, res *NullRes) error {
+	return nil
+}
+
+// encodeACL returns a as sent to clients, owner and group entries naming
+// attr's owner and group like Linux does.
+func encodeACL(a ACL, attr Fattr3, flags uint32) ACLEntries {
+	entries := make([]ACE, len(a))
+	for i, e := range a {
+		switch e.Tag {
+		case ACLUserObj:
+			e.ID = attr.UID
+		case ACLGroupObj:
+			e.ID = attr.GID
+		case ACLMask, ACLOther:
+			e.ID = 0
+		}
+		e.Tag |= flags
+		entries[i] = e
+	}
+	return ACLEntries{
+		Count:   uint32(len(entries)),
+		Entries: entries,
+	}
+}
+
+func decodeACL(e ACLEntries) ACL {
+	a := make(ACL, len(e.Entries))
+	for i, ace := range e.Entries {
+		ace.Tag &^= aclDefault
+		a[i] = ace
+	}
+	return a
+}
+
+// modeACL returns the access ACL equivalent to mode.
+func modeACL(mode uint32) ACL {
+	return ACL{
+		{Tag: ACLUserObj, Perm: mode >> 6 & 7},
+		{Tag: ACLGroupObj, Perm: mode >> 3 & 7},
+		{Tag: ACLOther, Perm: mode & 7},
+	}
+}
+
+// Getacl reports the mode bits as access ACL of handlers without ACLs.
+func (r *NFSACL) Getacl(args *GETACL3args, res *GETACL3res) error {
+	node, _, _, stat := r.mux.rpc.load(args.Call(), args.Object, false)
+	if stat != NFSStatOk {
+		res.Status = stat
+		return nil
+	}
+	n, ok := node.(Attrer)
+	if !ok {
+		res.Status = NFSStatNotsupp
+		return nil
+	}
+	attr := n.Attr()
+
+	var access, def ACL
+	if a, ok := node.(ACLer); ok {
+		access, def = a.ACL()
+	}
+	if len(access) == 0 {
+		access = modeACL(attr.FileMode)
+	}
+
+	res.Status = NFSStatOk
+	res.Attr = NewPostOpAttr(attr)
+	res.Mask = args.Mask & (ACLMaskAccess | ACLMaskAccessCnt | ACLMaskDefault | ACLMaskDefaultCnt)
+	if args.Mask&(ACLMaskAccess|ACLMaskAccessCnt) != 0 {
+		res.Access = encodeACL(access, attr, 0)
+	}
+	if args.Mask&(ACLMaskDefault|ACLMaskDefaultCnt) != 0 {
+		res.Default = encodeACL(def, attr, aclDefault)
+	}
+	return nil
+}
+
+// Setacl replaces the ACLs selected by args.Mask. Only the owner and root
+// may change them, and only directories have default ACLs.
+func (r *NFSACL) Setacl(args *SETACL3args, res *SETACL3res) error {
+	node, _, cred, stat := r.mux.rpc.load(args.Call(), args.Object, true)
+	if stat != NFSStatOk {
+		res.Status = stat
+		return nil
+	}
+	n, ok := node.(ACLer)
+	a, ok2 := node.(Attrer)
+	if !ok || !ok2 {
+		res.Status = NFSStatNotsupp
+		return nil
+	}
+	attr := a.Attr()
+	if cred.UID != 0 && cred.UID != attr.UID {
+		res.Status = NFSStatPerm
+		res.Attr = NewPostOpAttr(attr)
+		return nil
+	}
+
+	access, def := n.ACL()
+	if args.Mask&ACLMaskAccess != 0 {
+		access = decodeACL(args.Access)
+		if len(access) > 0 && !access.Valid() {
+			res.Status = NFSStatInval
+			res.Attr = NewPostOpAttr(attr)
+			return nil
+		}
+		if len(access) == 0 {
+			access = modeACL(attr.FileMode)
+		}
+	}
+	if args.Mask&ACLMaskDefault != 0 {
+		def = decodeACL(args.Default)
+		if len(def) > 0 && attr.Type != NF3Dir {
+			res.Status = NFSStatAcces
+			res.Attr = NewPostOpAttr(attr)
+			return nil
+		}
+		if len(def) > 0 && !def.Valid() {
+			res.Status = NFSStatInval
+			res.Attr = NewPostOpAttr(attr)
+			return nil
+		}
+	}
+	if err := n.SetACL(access, def); err != nil {
+		res.Status = StatusFromError(err)
+		res.Attr = postOp(node)
+		return nil
+	}
+	res.Status = NFSStatOk
+	res.Attr = postOp(node)
+	return nil
+}
+
+// inheritACL gives the object created in dir the default ACL of dir, as
+// its access ACL and, for directories, its default ACL.
+func inheritACL(dir, object interface{}) {
+	d, ok := dir.(ACLer)
+	if !ok {
+		return
+	}
+	_, def := d.ACL()
+	if len(def) == 0 {
+		return
+	}
+	n, ok := object.(ACLer)
+	if !ok {
+		return
+	}
+	a, ok := object.(Attrer)
+	if !ok {
+		return
+	}
+	attr := a.Attr()
+	var childDef ACL
+	if attr.Type == NF3Dir {
+		childDef = def
+	}
+	n.SetACL(inherited(def, attr.FileMode), childDef)
+}
@@ -0,0 +1,222 @@
+package nfs
+
+// Handlers registered with ServeMux implement any subset of the interfaces
+// below. Procedures a handler does not implement are answered with
+// NFSStatNotsupp.
+
+// Getattrer handles GETATTR.
+type Getattrer interface {
+	Getattr(*GETATTR3res) error
+}
+
+// Setattrer handles SETATTR. If args.Guard is set, Setattr must fail with
+// NFSStatNotsync unless the ctime is args.Guard.Ctime, checked atomically
+// with the change.
+type Setattrer interface {
+	Setattr(*SETATTR3args, *SETATTR3res) error
+}
+
+// Lookuper handles LOOKUP.
+type Lookuper interface {
+	Lookup(string, *LOOKUP3res) error
+}
+
+// Accesser handles ACCESS. ServeMux sets res.Access to the requested
+// permissions beforehand, handlers clear those they do not grant.
+type Accesser interface {
+	Access(*ACCESS3res) error
+}
+
+// Reader handles READ.
+type Reader interface {
+	Read(*READ3args, *READ3res) error
+}
+
+// Writer handles WRITE.
+type Writer interface {
+	Write(*WRITE3args, *WRITE3res) error
+}
+
+// Creater handles CREATE.
+type Creater interface {
+	Create(string, *Sattr3, *CREATE3res) error
+}
+
+// Mkdirer handles MKDIR.
+type Mkdirer interface {
+	Mkdir(string, *Sattr3, *MKDIR3res) error
+}
+
+// Remover handles REMOVE.
+type Remover interface {
+	Remove(string, *REMOVE3res) error
+}
+
+// Rmdirer handles RMDIR.
+type Rmdirer interface {
+	Rmdir(string, *RMDIR3res) error
+}
+
+// Renamer handles RENAME.
+type Renamer interface {
+	Rename(*RENAME3args, *RENAME3res) error
+}
+
+// Linker handles LINK.
+type Linker interface {
+	Link([]byte, string, *LINK3res) error
+}
+
+// Readdirpluser handles READDIRPLUS.
+type Readdirpluser interface {
+	Readdirplus(*READDIRPLUS3args, *READDIRPLUS3res) error
+}
+
+// Fsstater handles FSSTAT.
+type Fsstater interface {
+	Fsstat(*FSSTAT3res) error
+}
+
+// Fsinfoer handles FSINFO.
+type Fsinfoer interface {
+	Fsinfo(*FSINFO3res) error
+}
+
+// Pathconfer handles PATHCONF.
+type Pathconfer interface {
+	Pathconf(*PATHCONF3res) error
+}
+
+// Committer handles COMMIT.
+type Committer interface {
+	Commit(*COMMIT3args, *COMMIT3res) error
+}
+
+// Flusher is implemented by handlers that accept UNSTABLE writes. ServeMux
+// calls Flush to handle COMMIT, and to honour DATA_SYNC and FILE_SYNC
+// writes the handler only committed unstably.
+type Flusher interface {
+	Flush(offset uint64, count uint32) error
+}
+
+// Attrer is implemented by handlers that can report their attributes
+// cheaply. ServeMux uses it to send post operation attributes when a
+// handler fails.
+type Attrer interface {
+	Attr() Fattr3
+}
+
+// Node is implemented by handlers that support every procedure.
+type Node interface {
+	Getattrer
+	Setattrer
+	Lookuper
+	Accesser
+	Reader
+	Writer
+	Creater
+	Mkdirer
+	Remover
+	Rmdirer
+	Renamer
+	Linker
+	Readdirpluser
+	Fsstater
+	Fsinfoer
+	Pathconfer
+	Committer
+}
+
+// UnimplementedNode answers every procedure with NFSStatNotsupp. Embed it
+// in a handler to satisfy Node and override only what the backend supports.
+// ServeMux takes NFSStatNotsupp from Access, Fsstat, Fsinfo, Pathconf and
+// Commit as if the handler had no such method, and falls back to what it
+// does then.
+type UnimplementedNode struct{}
+
+var _ Node = UnimplementedNode{}
+
+func (UnimplementedNode) Getattr(res *GETATTR3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Setattr(args *SETATTR3args, res *SETATTR3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Lookup(name string, res *LOOKUP3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Access(res *ACCESS3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Read(args *READ3args, res *READ3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Write(args *WRITE3args, res *WRITE3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Create(name string, attr *Sattr3, res *CREATE3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Mkdir(name string, attr *Sattr3, res *MKDIR3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Remove(name string, res *REMOVE3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Rmdir(name string, res *RMDIR3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Rename(args *RENAME3args, res *RENAME3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Link(object []byte, name string, res *LINK3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Readdirplus(args *READDIRPLUS3args, res *READDIRPLUS3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Fsstat(res *FSSTAT3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Fsinfo(res *FSINFO3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Pathconf(res *PATHCONF3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
+
+func (UnimplementedNode) Commit(args *COMMIT3args, res *COMMIT3res) error {
+	res.Status = NFSStatNotsupp
+	return nil
+}
@@ -0,0 +1,118 @@
+package nfs_test
+
+import (
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+// flushFile reports attributes and commits by flushing, and nothing else.
+type flushFile struct{}
+
+func (flushFile) Attr() nfs.Fattr3 {
+	return nfs.Fattr3{Type: nfs.NF3Reg, FileMode: 0666, Nlink: 1, Fileid: 2}
+}
+
+func (flushFile) Flush(offset uint64, count uint32) error { return nil }
+
+// unimplementedFile is flushFile answering other procedures with
+// NFSStatNotsupp, as handlers embedding UnimplementedNode do.
+type unimplementedFile struct {
+	nfs.UnimplementedNode
+	flushFile
+}
+
+// fsRoot describes the whole file system.
+type fsRoot struct {
+	nfs.UnimplementedNode
+}
+
+func (fsRoot) Attr() nfs.Fattr3 {
+	return nfs.Fattr3{Type: nfs.NF3Dir, FileMode: 0777, Nlink: 2, Fileid: 1}
+}
+
+func (fsRoot) Fsinfo(res *nfs.FSINFO3res) error {
+	res.Status = nfs.NFSStatOk
+	res.RTMax = 4096
+	return nil
+}
+
+func (fsRoot) Fsstat(res *nfs.FSSTAT3res) error {
+	res.Status = nfs.NFSStatOk
+	res.Tbytes = 1 << 20
+	return nil
+}
+
+// serve returns the NFS receiver of a mux exporting fsRoot with file in it,
+// and the handle of file.
+func serve(t *testing.T, file interface{}) (*nfs.NFS, []byte) {
+	t.Helper()
+	mux := nfs.NewServeMux()
+	mux.Handle([]byte("root"), fsRoot{})
+	mux.Handle([]byte("file"), file)
+	mux.Export("/", 1, []byte("root"))
+	fh, err := mux.EncodeHandle(nfs.FileHandle{Export: 1, Object: []byte("file")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mux.Receiver().(*nfs.NFS), fh
+}
+
+func TestUnimplementedNodeFallback(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file interface{}
+	}{
+		{"methods missing", flushFile{}},
+		{"UnimplementedNode embedded", unimplementedFile{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, fh := serve(t, tt.file)
+
+			var commit nfs.COMMIT3res
+			r.Commit(&nfs.COMMIT3args{Object: fh}, &commit)
+			if commit.Status != nfs.NFSStatOk || !commit.FileWcc.Post.IsSet {
+				t.Errorf("COMMIT: status %v, post op attributes %v, want flushed", commit.Status, commit.FileWcc.Post.IsSet)
+			}
+
+			var access nfs.ACCESS3res
+			r.Access(&nfs.ACCESS3args{Object: fh, Access: nfs.AccessRead | nfs.AccessExecute}, &access)
+			if access.Status != nfs.NFSStatOk || access.Access != nfs.AccessRead {
+				t.Errorf("ACCESS: status %v, access %#x, want read granted by mode bits", access.Status, access.Access)
+			}
+
+			var pathconf nfs.PATHCONF3res
+			r.Pathconf(&nfs.PATHCONF3args{Object: fh}, &pathconf)
+			if pathconf.Status != nfs.NFSStatOk || !pathconf.CasePreserving || pathconf.NameMax != nfs.DefaultPathconf.NameMax {
+				t.Errorf("PATHCONF: got %+v, want defaults", pathconf)
+			}
+
+			var fsinfo nfs.FSINFO3res
+			r.Fsinfo(&nfs.FSINFO3args{Object: fh}, &fsinfo)
+			if fsinfo.Status != nfs.NFSStatOk || fsinfo.RTMax != 4096 || fsinfo.Attr.Attr.Fileid != 2 {
+				t.Errorf("FSINFO: got %+v, want the export root's, with attributes of the file", fsinfo)
+			}
+
+			var fsstat nfs.FSSTAT3res
+			r.Fsstat(&nfs.FSSTAT3args{FSRoot: fh}, &fsstat)
+			if fsstat.Status != nfs.NFSStatOk || fsstat.Tbytes != 1<<20 {
+				t.Errorf("FSSTAT: got %+v, want the export root's", fsstat)
+			}
+		})
+	}
+}
+
+func TestUnimplementedNodeNotsupp(t *testing.T) {
+	r, fh := serve(t, nfs.UnimplementedNode{})
+
+	var commit nfs.COMMIT3res
+	r.Commit(&nfs.COMMIT3args{Object: fh}, &commit)
+	if commit.Status != nfs.NFSStatNotsupp {
+		t.Errorf("COMMIT: status %v, want NFS3ERR_NOTSUPP", commit.Status)
+	}
+	var access nfs.ACCESS3res
+	r.Access(&nfs.ACCESS3args{Object: fh, Access: nfs.AccessRead}, &access)
+	if access.Status != nfs.NFSStatNotsupp {
+		t.Errorf("ACCESS: status %v, want NFS3ERR_NOTSUPP", access.Status)
+	}
+}
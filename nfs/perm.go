@@ -0,0 +1,190 @@
+package nfs
+
+// Permission bits of a single owner, group or other class.
+const (
+	mayRead  = 4
+	mayWrite = 2
+	mayExec  = 1
+)
+
+const (
+	modeSetgid = 02000
+	modeSticky = 01000
+)
+
+// inGroup tells whether c is a member of gid, through its primary or any
+// supplementary group.
+func (c Cred) inGroup(gid uint32) bool {
+	if c.GID == gid {
+		return true
+	}
+	for _, g := range c.GIDs {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// permits tells whether attr and acl, if not empty, grant c all of the
+// rwx bits want under POSIX rules: the owner class applies to the owner,
+// the group class to members of the group and the other class to
+// everybody else. Root may read and write anything, and execute anything
+// somebody may execute.
+func permits(attr Fattr3, acl ACL, c Cred, want uint32) bool {
+	mode := attr.FileMode
+	if c.UID == 0 {
+		p := uint32(mayRead | mayWrite)
+		if attr.Type == NF3Dir || mode&0111 != 0 {
+			p |= mayExec
+		}
+		return p&want == want
+	}
+	if len(acl) > 0 {
+		return aclGrants(acl, attr, c, want)
+	}
+	var p uint32
+	switch {
+	case c.UID == attr.UID:
+		p = mode >> 6 & 7
+	case c.inGroup(attr.GID):
+		p = mode >> 3 & 7
+	default:
+		p = mode & 7
+	}
+	return p&want == want
+}
+
+// access returns the ACCESS bits attr and acl grant c. Each is checked on
+// its own, as clients ask for them separately.
+func access(attr Fattr3, acl ACL, c Cred) uint32 {
+	var a uint32
+	if permits(attr, acl, c, mayRead) {
+		a |= AccessRead
+	}
+	if permits(attr, acl, c, mayWrite) {
+		a |= AccessModify | AccessExtend
+		if attr.Type == NF3Dir {
+			a |= AccessDelete
+		}
+	}
+	if permits(attr, acl, c, mayExec) {
+		if attr.Type == NF3Dir {
+			a |= AccessLookup
+		} else {
+			a |= AccessExecute
+		}
+	}
+	return a
+}
+
+// check returns NFSStatAcces unless c has all of want on node. Handlers
+// that do not implement Attrer are expected to check permissions
+// themselves.
+func check(node interface{}, c Cred, want uint32) NFSStat {
+	n, ok := node.(Attrer)
+	if !ok {
+		return NFSStatOk
+	}
+	if !permits(n.Attr(), aclOf(node), c, want) {
+		return NFSStatAcces
+	}
+	return NFSStatOk
+}
+
+// checkDir is check for procedures working on directories. Other objects
+// fail with NFSStatNotdir before permissions are looked at, like Linux
+// does.
+func checkDir(node interface{}, c Cred, want uint32) NFSStat {
+	if n, ok := node.(Attrer); ok && n.Attr().Type != NF3Dir {
+		return NFSStatNotdir
+	}
+	return check(node, c, want)
+}
+
+// checkRead is check for READ. Execute permission is enough, as clients
+// have to read programs to run them.
+func checkRead(node interface{}, c Cred) NFSStat {
+	if check(node, c, mayRead) == NFSStatOk {
+		return NFSStatOk
+	}
+	return check(node, c, mayExec)
+}
+
+// checkSticky returns NFSStatAcces if dir has the sticky bit set and c owns
+// neither dir nor its entry name, which c then may not remove or rename.
+func checkSticky(dir interface{}, name string, c Cred) NFSStat {
+	d, ok := dir.(Attrer)
+	if !ok || c.UID == 0 {
+		return NFSStatOk
+	}
+	attr := d.Attr()
+	if attr.FileMode&modeSticky == 0 || attr.UID == c.UID {
+		return NFSStatOk
+	}
+	n, ok := dir.(Lookuper)
+	if !ok {
+		return NFSStatOk
+	}
+	var res LOOKUP3res
+	if err := n.Lookup(name, &res); err != nil || res.Status != NFSStatOk || !res.Attr.IsSet {
+		// missing entries are reported by the handler
+		return NFSStatOk
+	}
+	if res.Attr.Attr.UID == c.UID {
+		return NFSStatOk
+	}
+	return NFSStatAcces
+}
+
+// checkSetattr applies the POSIX rules of chmod, chown, truncate and
+// utimes to s.
+func checkSetattr(node interface{}, c Cred, s *Sattr3) NFSStat {
+	n, ok := node.(Attrer)
+	if !ok || c.UID == 0 {
+		return NFSStatOk
+	}
+	attr := n.Attr()
+	owner := c.UID == attr.UID
+	write := permits(attr, aclOf(node), c, mayWrite)
+
+	if s.Mode.IsSet && !owner {
+		return NFSStatPerm
+	}
+	if s.UID.IsSet && s.UID.UID != attr.UID {
+		return NFSStatPerm
+	}
+	if s.GID.IsSet && s.GID.GID != attr.GID && !(owner && c.inGroup(s.GID.GID)) {
+		return NFSStatPerm
+	}
+	for _, t := range []Sattr3Time{s.Atime, s.Mtime} {
+		switch {
+		case t.TimeHow == 2 && !owner: // SET_TO_CLIENT_TIME
+			return NFSStatPerm
+		case t.TimeHow == 1 && !owner && !write: // SET_TO_SERVER_TIME
+			return NFSStatAcces
+		}
+	}
+	if s.Size.IsSet && !write {
+		return NFSStatAcces
+	}
+	return NFSStatOk
+}
+
+// inherit makes c the owner of an object created in dir with attributes s.
+// Objects in set-group-ID directories get the group of the directory.
+// Only root may pick another owner, and others only groups they belong to.
+func inherit(dir interface{}, c Cred, s *Sattr3) {
+	gid := c.GID
+	if d, ok := dir.(Attrer); ok {
+		if attr := d.Attr(); attr.FileMode&modeSetgid != 0 {
+			gid = attr.GID
+		}
+	}
+	if !s.UID.IsSet || c.UID != 0 {
+		s.UID = Sattr3UID{IsSet: true, UID: c.UID}
+	}
+	if !s.GID.IsSet || (c.UID != 0 && !c.inGroup(s.GID.GID)) {
+		s.GID = Sattr3GID{IsSet: true, GID: gid}
+	}
+}
@@ -0,0 +1,34 @@
+package nfs
+
+import "testing"
+
+func TestAccessMode(t *testing.T) {
+	file := Fattr3{Type: NF3Reg, FileMode: 0754, UID: 1, GID: 10}
+	dir := Fattr3{Type: NF3Dir, FileMode: 0750, UID: 1, GID: 10}
+	const (
+		all     = AccessRead | AccessModify | AccessExtend | AccessExecute
+		allDir  = AccessRead | AccessModify | AccessExtend | AccessDelete | AccessLookup
+		readRun = AccessRead | AccessExecute
+	)
+	for _, tt := range []struct {
+		name string
+		attr Fattr3
+		c    Cred
+		want uint32
+	}{
+		{"owner", file, Cred{UID: 1, GID: 1}, all},
+		{"group", file, Cred{UID: 2, GID: 10}, readRun},
+		{"supplementary group", file, Cred{UID: 2, GID: 20, GIDs: []uint32{10}}, readRun},
+		{"other", file, Cred{UID: 2, GID: 20}, AccessRead},
+		{"owner not in other classes", Fattr3{Type: NF3Reg, FileMode: 0077, UID: 1}, Cred{UID: 1}, 0},
+		{"root", file, Cred{}, all},
+		{"root without exec bits", Fattr3{Type: NF3Reg, FileMode: 0600}, Cred{}, AccessRead | AccessModify | AccessExtend},
+		{"directory owner", dir, Cred{UID: 1, GID: 1}, allDir},
+		{"directory group", dir, Cred{UID: 2, GID: 10}, AccessRead | AccessLookup},
+		{"directory other", dir, Cred{UID: 2, GID: 20}, 0},
+	} {
+		if got := access(tt.attr, nil, tt.c); got != tt.want {
+			t.Errorf("%s: got %#x, want %#x", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package nfs
+
+import (
+	"encoding/binary"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pseudoFS joins export roots into the single tree NFSv4 clients mount,
+// adding read-only directories on the way to exports not at "/".
+type pseudoFS struct {
+	mu      sync.RWMutex
+	dirs    map[string]*pseudoDir // by path
+	exports map[uint32]pseudoExport
+	started time.Time
+}
+
+type pseudoExport struct {
+	path string
+	root []byte // object id of the root directory
+}
+
+// pseudoDir is registered with ServeMux under object, so its file handle
+// decodes like any other. Its handles name export 0, which Authorizers
+// reject, so NFSv3 clients cannot use them.
+type pseudoDir struct {
+	path   string
+	object []byte
+	fileid uint64
+}
+
+// pseudoPrefix starts object ids of pseudo directories, to keep them
+// apart from ids of backends.
+const pseudoPrefix = "\x00nfs4pseudo"
+
+func newPseudoFS() *pseudoFS {
+	return &pseudoFS{
+		dirs:    map[string]*pseudoDir{},
+		exports: map[uint32]pseudoExport{},
+		started: time.Now(),
+	}
+}
+
+// add makes root of export reachable at p. It returns pseudo directories
+// created on the way, which must be registered with the ServeMux. Exports
+// can only be crossed into from the root directory of another, so add
+// panics if p is deeper below another export or another deeper below p.
+func (ps *pseudoFS) add(p string, export uint32, root []byte) []*pseudoDir {
+	p = path.Clean("/" + p)
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for _, e := range ps.exports {
+		if deepBelow(p, e.path) || deepBelow(e.path, p) {
+			panic("nfs: exports nested deeper than the root directory of another: " + e.path + ", " + p)
+		}
+	}
+	ps.exports[export] = pseudoExport{path: p, root: root}
+
+	var created []*pseudoDir
+	for dir := p; dir != "/"; {
+		dir = path.Dir(dir)
+		if d := ps.dir(dir); d != nil {
+			created = append(created, d)
+		}
+	}
+	return created
+}
+
+// deepBelow tells whether p is below dir but not in it.
+func deepBelow(p, dir string) bool {
+	return strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/") && path.Dir(p) != dir
+}
+
+// dir creates the pseudo directory at p unless it exists. It must be
+// called with ps.mu held.
+func (ps *pseudoFS) dir(p string) *pseudoDir {
+	if _, ok := ps.dirs[p]; ok {
+		return nil
+	}
+	id := uint64(len(ps.dirs) + 1)
+	object := make([]byte, len(pseudoPrefix)+8)
+	copy(object, pseudoPrefix)
+	binary.BigEndian.PutUint64(object[len(pseudoPrefix):], id)
+	d := &pseudoDir{
+		path:   p,
+		object: object,
+		fileid: id,
+	}
+	ps.dirs[p] = d
+	return d
+}
+
+// root returns the export at "/" or else the pseudo root, which may not
+// exist if nothing is exported.
+func (ps *pseudoFS) root() (export uint32, object []byte, ok bool) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	for id, e := range ps.exports {
+		if e.path == "/" {
+			return id, e.root, true
+		}
+	}
+	if d, ok := ps.dirs["/"]; ok {
+		return 0, d.object, true
+	}
+	return 0, nil, false
+}
+
+// pseudoEntry is a name in a pseudo directory: another pseudo directory or the
+// root of an export, which wins if both are at the same path.
+type pseudoEntry struct {
+	name   string
+	export uint32
+	object []byte
+	dir    *pseudoDir // nil for exports
+}
+
+// entries returns the names in pseudo directory d, sorted.
+func (ps *pseudoFS) entries(d *pseudoDir) []pseudoEntry {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	byName := map[string]pseudoEntry{}
+	for p, child := range ps.dirs {
+		if p != "/" && path.Dir(p) == d.path {
+			byName[path.Base(p)] = pseudoEntry{name: path.Base(p), object: child.object, dir: child}
+		}
+	}
+	for id, e := range ps.exports {
+		if e.path != "/" && path.Dir(e.path) == d.path {
+			byName[path.Base(e.path)] = pseudoEntry{name: path.Base(e.path), export: id, object: e.root}
+		}
+	}
+	entries := make([]pseudoEntry, 0, len(byName))
+	for _, e := range byName {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	return entries
+}
+
+func (ps *pseudoFS) lookup(d *pseudoDir, name string) (pseudoEntry, bool) {
+	for _, e := range ps.entries(d) {
+		if e.name == name {
+			return e, true
+		}
+	}
+	return pseudoEntry{}, false
+}
+
+// parent returns what is above d, or above the root of export if d is
+// nil: an export root or a pseudo directory. ok is false at the top.
+func (ps *pseudoFS) parent(d *pseudoDir, export uint32) (uint32, []byte, bool) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	var p string
+	if d != nil {
+		p = d.path
+	} else if e, ok := ps.exports[export]; ok {
+		p = e.path
+	}
+	if p == "" || p == "/" {
+		return 0, nil, false
+	}
+	p = path.Dir(p)
+	for id, e := range ps.exports {
+		if e.path == p {
+			return id, e.root, true
+		}
+	}
+	if parent, ok := ps.dirs[p]; ok {
+		return 0, parent.object, true
+	}
+	return 0, nil, false
+}
+
+// mounted returns the export whose root is name in the root directory of
+// export, for exports nested in others. READDIR of the root directory
+// lists them only if the backend has a name of their own.
+func (ps *pseudoFS) mounted(export uint32, name string) (pseudoEntry, bool) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	parent, ok := ps.exports[export]
+	if !ok {
+		return pseudoEntry{}, false
+	}
+	p := path.Join(parent.path, name)
+	for id, e := range ps.exports {
+		if e.path == p {
+			return pseudoEntry{name: name, export: id, object: e.root}, true
+		}
+	}
+	return pseudoEntry{}, false
+}
+
+// isRoot tells whether object is the root of export.
+func (ps *pseudoFS) isRoot(export uint32, object []byte) bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	e, ok := ps.exports[export]
+	return ok && string(e.root) == string(object)
+}
+
+// exportRoot returns the root object of export.
+func (ps *pseudoFS) exportRoot(export uint32) ([]byte, bool) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	e, ok := ps.exports[export]
+	return e.root, ok
+}
+
+// attr returns attributes of d: a directory only root may change, which
+// it cannot.
+func (ps *pseudoFS) attr(d *pseudoDir) Fattr3 {
+	t := NewNFS3Time(ps.started)
+	return Fattr3{
+		Type:     NF3Dir,
+		FileMode: 0555,
+		Nlink:    2,
+		Fileid:   d.fileid,
+		Atime:    t,
+		Mtime:    t,
+		Ctime:    t,
+	}
+}
@@ -0,0 +1,114 @@
+package nfs_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+func TestSignedCodec(t *testing.T) {
+	codec := nfs.NewSignedCodec(nfs.DefaultCodec, []byte("secret"))
+	fh := nfs.FileHandle{Export: 1, Object: []byte("object")}
+	b, err := codec.EncodeHandle(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := codec.DecodeHandle(b); err != nil || !bytes.Equal(got.Object, fh.Object) {
+		t.Fatalf("got %+v, %v, want %+v", got, err, fh)
+	}
+
+	tampered := append([]byte(nil), b...)
+	tampered[len(tampered)-signedMACSize-1] ^= 1
+	other, err := nfs.NewSignedCodec(nfs.DefaultCodec, []byte("other")).EncodeHandle(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		handle []byte
+		want   error
+	}{
+		{"tampered", tampered, nfs.ErrBadHandle},
+		{"unsigned", b[4 : len(b)-signedMACSize], nfs.ErrBadHandle},
+		{"truncated", b[:20], nfs.ErrBadHandle},
+		{"other secret", other, nfs.ErrStale},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := codec.DecodeHandle(tt.handle); err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := codec.EncodeHandle(nfs.FileHandle{Object: make([]byte, nfs.FHSize-8-19)}); err != nfs.ErrBadHandle {
+		t.Errorf("object too long to sign: got %v, want %v", err, nfs.ErrBadHandle)
+	}
+}
+
+// signedMACSize is the size of the MAC ending signed handles.
+const signedMACSize = 16
+
+func TestLoadSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	secret, err := nfs.LoadSecret(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != nfs.SecretSize {
+		t.Errorf("created a secret of %d bytes, want %d", len(secret), nfs.SecretSize)
+	}
+	again, err := nfs.LoadSecret(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret, again) {
+		t.Error("secret changed when loaded again")
+	}
+
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nfs.LoadSecret(path); err == nil {
+		t.Error("loaded an empty secret")
+	}
+}
+
+func TestForgedHandles(t *testing.T) {
+	mux := nfs.NewServeMux(nfs.WithHandleCodec(nfs.NewSignedCodec(nfs.DefaultCodec, []byte("secret"))))
+	mux.Handle([]byte("root"), statFile{})
+	mux.Export("/", 1, []byte("root"))
+	r := mux.Receiver().(*nfs.NFS)
+
+	fh, err := mux.EncodeHandle(nfs.FileHandle{Export: 1, Object: []byte("root")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := append([]byte(nil), fh...)
+	forged[len(forged)-1] ^= 1
+	unsigned, err := nfs.DefaultCodec.EncodeHandle(nfs.FileHandle{Export: 1, Object: []byte("root")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		handle []byte
+		want   nfs.NFSStat
+	}{
+		{"signed", fh, nfs.NFSStatOk},
+		{"forged", forged, nfs.NFSStatBadhandle},
+		{"unsigned", unsigned, nfs.NFSStatBadhandle},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var res nfs.GETATTR3res
+			if err := r.Getattr(&nfs.GETATTR3args{Object: tt.handle}, &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Status != tt.want {
+				t.Errorf("got %v, want %v", res.Status, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,462 @@
+package nfs
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dzeromsk/xdrrpc"
+)
+
+// DefaultLease is how long NFSv4 clients keep their state without
+// renewing it.
+const DefaultLease = 90 * time.Second
+
+// WithLease sets the NFSv4 lease time, DefaultLease by default.
+func WithLease(d time.Duration) Option {
+	return func(mux *serveMux) {
+		mux.nfs4.state.lease = d
+	}
+}
+
+// state4 tracks NFSv4 clients and the files they have open. Client ids and
+// stateids carry the boot time of the server, so those issued before a
+// restart are detected as stale.
+type state4 struct {
+	mu      sync.Mutex
+	lease   time.Duration
+	boot    uint32
+	next    uint64
+	clients map[uint64]*client4
+	owners  map[ownerKey]*owner4
+	opens   map[[12]byte]*open4
+	byFile  map[Handle]map[*open4]bool
+}
+
+type client4 struct {
+	id        uint64
+	name      string
+	principal string      // who registered name
+	callback  ClientAddr4 // where the client takes callbacks
+	verf      [8]byte
+	confirm   [8]byte
+	confirmed bool
+	expired   bool // lease ran out, state is gone
+	renewed   time.Time
+}
+
+type ownerKey struct {
+	clientid uint64
+	owner    string
+}
+
+// owner4 is an open-owner, whose requests are ordered by seqid. The reply
+// to the last one is kept to answer retransmissions.
+type owner4 struct {
+	seqid uint32
+	used  bool
+	last  *replay4
+}
+
+// replay4 is the reply to a request of an open-owner: op on stateid, its
+// result, and for OPEN the file handle it left current.
+type replay4 struct {
+	op      uint32
+	stateid Stateid4
+	stat    NFSStat
+	res     interface{}
+	cur     fh4
+}
+
+type open4 struct {
+	other  [12]byte
+	seqid  uint32
+	owner  ownerKey
+	file   Handle
+	access uint32
+	deny   uint32
+}
+
+func newState4() *state4 {
+	return &state4{
+		lease:   DefaultLease,
+		boot:    uint32(time.Now().Unix()),
+		clients: map[uint64]*client4{},
+		owners:  map[ownerKey]*owner4{},
+		opens:   map[[12]byte]*open4{},
+		byFile:  map[Handle]map[*open4]bool{},
+	}
+}
+
+// sweep expires clients that did not renew their lease, dropping their
+// opens. It must be called with s.mu held.
+func (s *state4) sweep() {
+	now := time.Now()
+	for id, c := range s.clients {
+		if c.expired || now.Sub(c.renewed) <= s.lease {
+			continue
+		}
+		if !c.confirmed {
+			delete(s.clients, id)
+			continue
+		}
+		c.expired = true
+		s.drop(id)
+	}
+}
+
+// drop forgets state of client id. It must be called with s.mu held.
+func (s *state4) drop(id uint64) {
+	for other, o := range s.opens {
+		if o.owner.clientid == id {
+			s.remove(o)
+			delete(s.opens, other)
+		}
+	}
+	for k := range s.owners {
+		if k.clientid == id {
+			delete(s.owners, k)
+		}
+	}
+}
+
+// remove must be called with s.mu held.
+func (s *state4) remove(o *open4) {
+	delete(s.opens, o.other)
+	delete(s.byFile[o.file], o)
+	if len(s.byFile[o.file]) == 0 {
+		delete(s.byFile, o.file)
+	}
+}
+
+// client returns the confirmed client id, renewing its lease. It must be
+// called with s.mu held.
+func (s *state4) client(id uint64) (*client4, NFSStat) {
+	if uint32(id>>32) != s.boot {
+		return nil, NFSStatStaleClientid
+	}
+	c, ok := s.clients[id]
+	switch {
+	case !ok || !c.confirmed:
+		return nil, NFSStatStaleClientid
+	case c.expired:
+		return nil, NFSStatExpired
+	}
+	c.renewed = time.Now()
+	return c, NFSStatOk
+}
+
+func (s *state4) newID() uint64 {
+	s.next++
+	return uint64(s.boot)<<32 | s.next&0xffffffff
+}
+
+func newVerifier() [8]byte {
+	var v [8]byte
+	if _, err := rand.Read(v[:]); err != nil {
+		binary.BigEndian.PutUint64(v[:], uint64(time.Now().UnixNano()))
+	}
+	return v
+}
+
+// principal names who made call, telling apart clients using the same
+// name. AUTH_SYS callers are told apart by uid, as other servers do.
+func principal(call *xdrrpc.CallInfo) string {
+	if call == nil {
+		return ""
+	}
+	if p, err := call.AuthSys(); err == nil {
+		return fmt.Sprintf("sys:%d", p.UID)
+	}
+	return fmt.Sprintf("flavor:%d", call.Cred.Flavor)
+}
+
+// setclientid registers client name for principal. A client calling again
+// with the same verifier keeps its id; a new verifier means it rebooted
+// and gets a new one, its old state going away once confirmed. A name
+// confirmed by another principal whose lease still runs is refused with
+// CLID_INUSE and the callback address of its holder.
+func (s *state4) setclientid(name []byte, verf [8]byte, principal string, callback ClientAddr4) (uint64, [8]byte, ClientAddr4, NFSStat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	for _, c := range s.clients {
+		if c.name == string(name) && c.confirmed && !c.expired && c.principal != principal {
+			return 0, [8]byte{}, c.callback, NFSStatClidInuse
+		}
+	}
+	for id, c := range s.clients {
+		if c.name != string(name) {
+			continue
+		}
+		if c.confirmed && !c.expired && c.verf == verf {
+			c.confirm = newVerifier()
+			c.callback = callback
+			c.renewed = time.Now()
+			return c.id, c.confirm, ClientAddr4{}, NFSStatOk
+		}
+		if !c.confirmed {
+			delete(s.clients, id)
+		}
+	}
+	c := &client4{
+		id:        s.newID(),
+		name:      string(name),
+		principal: principal,
+		callback:  callback,
+		verf:      verf,
+		confirm:   newVerifier(),
+		renewed:   time.Now(),
+	}
+	s.clients[c.id] = c
+	return c.id, c.confirm, ClientAddr4{}, NFSStatOk
+}
+
+// confirm makes client id usable, replacing other clients of the same
+// name.
+func (s *state4) confirm(id uint64, confirm [8]byte) NFSStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	c, ok := s.clients[id]
+	if !ok || c.confirm != confirm {
+		return NFSStatStaleClientid
+	}
+	if !c.confirmed {
+		for old, o := range s.clients {
+			if old != id && o.name == c.name {
+				s.drop(old)
+				delete(s.clients, old)
+			}
+		}
+		c.confirmed = true
+	}
+	c.renewed = time.Now()
+	return NFSStatOk
+}
+
+func (s *state4) renew(id uint64) NFSStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	_, stat := s.client(id)
+	return stat
+}
+
+// seqid checks seqid of request op on stateid of owner k, which must
+// follow the previous one. The first request of an owner may use any
+// seqid. The previous request sent again gets its reply back.
+func (s *state4) seqid(k ownerKey, seqid, op uint32, stateid Stateid4) (*replay4, NFSStat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.owners[k]
+	switch {
+	case !ok || !o.used || seqid == o.seqid+1:
+		return nil, NFSStatOk
+	case seqid == o.seqid && o.last != nil && o.last.op == op && o.last.stateid == stateid:
+		return o.last, NFSStatOk
+	}
+	return nil, NFSStatBadSeqid
+}
+
+// advance records seqid as the last one owner k used, and last as the
+// reply to it, unless the request failed before the server got to look
+// at it.
+func (s *state4) advance(k ownerKey, seqid uint32, last *replay4) {
+	switch last.stat {
+	case NFSStatStaleClientid, NFSStatStaleStateid, NFSStatBadStateid,
+		NFSStatBadSeqid, NFSStatBadxdr, NFSStatResource,
+		NFSStatNofilehandle:
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.owners[k]
+	if !ok {
+		if _, stat := s.client(k.clientid); stat != NFSStatOk {
+			return
+		}
+		o = &owner4{}
+		s.owners[k] = o
+	}
+	o.seqid = seqid
+	o.used = true
+	o.last = last
+}
+
+// open opens file for owner k, or upgrades its open of file, and returns
+// the stateid. It fails with NFSStatShareDenied if other opens deny access
+// asked for or access the new open would deny.
+func (s *state4) open(k ownerKey, file Handle, access, deny uint32) (Stateid4, NFSStat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	if _, stat := s.client(k.clientid); stat != NFSStatOk {
+		return Stateid4{}, stat
+	}
+	var mine *open4
+	for o := range s.byFile[file] {
+		if o.owner == k {
+			mine = o
+			continue
+		}
+		if o.access&deny != 0 || o.deny&access != 0 {
+			return Stateid4{}, NFSStatShareDenied
+		}
+	}
+	if mine == nil {
+		mine = &open4{
+			owner: k,
+			file:  file,
+		}
+		binary.BigEndian.PutUint32(mine.other[:4], s.boot)
+		binary.BigEndian.PutUint64(mine.other[4:], s.newID())
+		s.opens[mine.other] = mine
+		if s.byFile[file] == nil {
+			s.byFile[file] = map[*open4]bool{}
+		}
+		s.byFile[file][mine] = true
+	}
+	mine.seqid++
+	mine.access |= access
+	mine.deny |= deny
+	return Stateid4{Seqid: mine.seqid, Other: mine.other}, NFSStatOk
+}
+
+// lookup returns the open stateid names, which must be current and of
+// file. It must be called with s.mu held.
+func (s *state4) lookup(id Stateid4, file Handle) (*open4, NFSStat) {
+	if binary.BigEndian.Uint32(id.Other[:4]) != s.boot {
+		return nil, NFSStatStaleStateid
+	}
+	o, ok := s.opens[id.Other]
+	if !ok || o.file != file {
+		return nil, NFSStatBadStateid
+	}
+	switch {
+	case id.Seqid > o.seqid:
+		return nil, NFSStatBadStateid
+	case id.Seqid < o.seqid:
+		return nil, NFSStatOldStateid
+	}
+	if _, stat := s.client(o.owner.clientid); stat != NFSStatOk {
+		return nil, stat
+	}
+	return o, NFSStatOk
+}
+
+// owner returns the owner of the open stateid names, for checking seqids.
+func (s *state4) owner(id Stateid4) (ownerKey, NFSStat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if binary.BigEndian.Uint32(id.Other[:4]) != s.boot {
+		return ownerKey{}, NFSStatStaleStateid
+	}
+	o, ok := s.opens[id.Other]
+	if !ok {
+		// a retransmitted CLOSE names an open that is gone
+		for k, w := range s.owners {
+			if w.last != nil && w.last.op == OpClose && w.last.stateid.Other == id.Other {
+				return k, NFSStatOk
+			}
+		}
+		return ownerKey{}, NFSStatBadStateid
+	}
+	return o.owner, NFSStatOk
+}
+
+// confirmOpen answers OPEN_CONFIRM. We never ask for one, but clients
+// may confirm anyway.
+func (s *state4) confirmOpen(id Stateid4, file Handle) (Stateid4, NFSStat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	o, stat := s.lookup(id, file)
+	if stat != NFSStatOk {
+		return Stateid4{}, stat
+	}
+	o.seqid++
+	return Stateid4{Seqid: o.seqid, Other: o.other}, NFSStatOk
+}
+
+// downgrade reduces access and deny of an open to those given, which must
+// be a subset.
+func (s *state4) downgrade(id Stateid4, file Handle, access, deny uint32) (Stateid4, NFSStat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	o, stat := s.lookup(id, file)
+	if stat != NFSStatOk {
+		return Stateid4{}, stat
+	}
+	if access == 0 || access&^o.access != 0 || deny&^o.deny != 0 {
+		return Stateid4{}, NFSStatInval
+	}
+	o.seqid++
+	o.access, o.deny = access, deny
+	return Stateid4{Seqid: o.seqid, Other: o.other}, NFSStatOk
+}
+
+func (s *state4) close(id Stateid4, file Handle) (Stateid4, NFSStat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	o, stat := s.lookup(id, file)
+	if stat != NFSStatOk {
+		return Stateid4{}, stat
+	}
+	s.remove(o)
+	return Stateid4{Seqid: o.seqid + 1, Other: o.other}, NFSStatOk
+}
+
+var (
+	anonStateid   = Stateid4{}
+	bypassStateid = Stateid4{
+		Seqid: 0xffffffff,
+		Other: [12]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+)
+
+// checkIO tells whether stateid allows reading or, if write is set,
+// writing file. The special stateids of all zeros and all ones may be used
+// without opening the file, as long as no open denies it. Reads are
+// allowed with any open, like Linux does, for clients filling pages
+// around writes.
+func (s *state4) checkIO(id Stateid4, file Handle, write bool) NFSStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+
+	if id == anonStateid || id == bypassStateid {
+		deny := uint32(ShareDenyRead)
+		if write {
+			deny = ShareDenyWrite
+		}
+		for o := range s.byFile[file] {
+			if o.deny&deny != 0 {
+				return NFSStatLocked
+			}
+		}
+		return NFSStatOk
+	}
+	o, stat := s.lookup(id, file)
+	if stat != NFSStatOk {
+		return stat
+	}
+	if write && o.access&ShareAccessWrite == 0 {
+		return NFSStatOpenmode
+	}
+	return NFSStatOk
+}
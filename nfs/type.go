@@ -1,5 +1,7 @@
 package nfs
 
+import "github.com/dzeromsk/xdrrpc"
+
 const (
 	Nfs3Prog = 100003
 	Nfs3Vers = 3
@@ -18,6 +20,23 @@ const (
 	NF3FIFO = 7
 )
 
+// ACCESS permission bits.
+const (
+	AccessRead    = 0x0001
+	AccessLookup  = 0x0002
+	AccessModify  = 0x0004
+	AccessExtend  = 0x0008
+	AccessDelete  = 0x0010
+	AccessExecute = 0x0020
+)
+
+// How WRITE data is committed to stable storage (stable_how).
+const (
+	Unstable int32 = 0
+	DataSync int32 = 1
+	FileSync int32 = 2
+)
+
 type AuthFlavor int32
 
 const (
@@ -131,6 +150,7 @@ type Sattr3 struct {
 }
 
 type FSINFO3args struct {
+	xdrrpc.Header
 	Object []byte
 }
 
@@ -150,6 +170,7 @@ type FSINFO3res struct {
 }
 
 type PATHCONF3args struct {
+	xdrrpc.Header
 	Object []byte
 }
 
@@ -161,10 +182,11 @@ type PATHCONF3res struct {
 	NoTrunc         bool
 	ChownRestricted bool
 	CaseInsensitive bool
-	CaseIreserving  bool
+	CasePreserving  bool
 }
 
 type GETATTR3args struct {
+	xdrrpc.Header
 	Object []byte
 }
 
@@ -174,6 +196,7 @@ type GETATTR3res struct {
 }
 
 type ACCESS3args struct {
+	xdrrpc.Header
 	Object []byte
 	Access uint32
 }
@@ -185,6 +208,7 @@ type ACCESS3res struct {
 }
 
 type FSSTAT3args struct {
+	xdrrpc.Header
 	FSRoot []byte
 }
 
@@ -206,6 +230,7 @@ type Diropargs3 struct {
 }
 
 type LOOKUP3args struct {
+	xdrrpc.Header
 	What Diropargs3
 }
 
@@ -217,6 +242,7 @@ type LOOKUP3res struct {
 }
 
 type READDIRPLUS3args struct {
+	xdrrpc.Header
 	Dir        []byte
 	Cookie     uint64
 	CookieVerf uint64
@@ -251,6 +277,7 @@ type PostOpFH3 struct {
 }
 
 type READ3args struct {
+	xdrrpc.Header
 	Object []byte
 	Offset uint64
 	Count  uint32
@@ -281,6 +308,7 @@ type WccData struct {
 }
 
 type MKDIR3args struct {
+	xdrrpc.Header
 	Where Diropargs3
 	Attr  Sattr3
 }
@@ -300,6 +328,7 @@ type Createhow3 struct {
 }
 
 type CREATE3args struct {
+	xdrrpc.Header
 	Where Diropargs3
 	How   Createhow3
 }
@@ -317,6 +346,7 @@ type Sattrguard3 struct {
 }
 
 type SETATTR3args struct {
+	xdrrpc.Header
 	Object []byte
 	Sattr  Sattr3
 	Guard  Sattrguard3
@@ -328,6 +358,7 @@ type SETATTR3res struct {
 }
 
 type LINK3args struct {
+	xdrrpc.Header
 	Object []byte
 	Link   Diropargs3
 }
@@ -339,6 +370,7 @@ type LINK3res struct {
 }
 
 type REMOVE3args struct {
+	xdrrpc.Header
 	Object Diropargs3
 }
 
@@ -348,6 +380,7 @@ type REMOVE3res struct {
 }
 
 type RMDIR3args struct {
+	xdrrpc.Header
 	Object Diropargs3
 }
 
@@ -357,6 +390,7 @@ type RMDIR3res struct {
 }
 
 type WRITE3args struct {
+	xdrrpc.Header
 	Object []byte
 	Offset uint64
 	Count  uint32
@@ -373,6 +407,7 @@ type WRITE3res struct {
 }
 
 type COMMIT3args struct {
+	xdrrpc.Header
 	Object []byte
 	Offset uint64
 	Count  uint32
@@ -385,6 +420,7 @@ type COMMIT3res struct {
 }
 
 type RENAME3args struct {
+	xdrrpc.Header
 	From Diropargs3
 	To   Diropargs3
 }
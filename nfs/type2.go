@@ -0,0 +1,193 @@
+package nfs
+
+import "github.com/dzeromsk/xdrrpc"
+
+const Nfs2Vers = 2
+
+const (
+	// FHSize2 is the size in bytes of NFSv2 file handles.
+	FHSize2 = 32
+
+	// MaxData2 is the most NFSv2 reads and writes transfer at once.
+	MaxData2 = 8192
+)
+
+// NFSStatWflush only exists in NFSv2.
+const NFSStatWflush NFSStat = 99
+
+// FHandle2 is an NFSv2 file handle: the length of the NFSv3 handle it
+// wraps followed by its bytes, zero padded.
+type FHandle2 [FHSize2]byte
+
+// NewFHandle2 wraps the NFSv3 handle b. It fails for handles longer than
+// FHSize2-1 bytes.
+func NewFHandle2(b []byte) (FHandle2, bool) {
+	var h FHandle2
+	if len(b) == 0 || len(b) >= FHSize2 {
+		return h, false
+	}
+	h[0] = byte(len(b))
+	copy(h[1:], b)
+	return h, true
+}
+
+// Handle returns the NFSv3 handle h wraps, nil if it is malformed.
+func (h FHandle2) Handle() []byte {
+	n := int(h[0])
+	if n == 0 || n >= FHSize2 {
+		return nil
+	}
+	return append([]byte(nil), h[1:1+n]...)
+}
+
+type Timeval2 struct {
+	Seconds  uint32
+	Useconds uint32
+}
+
+type Fattr2 struct {
+	Type      uint32
+	Mode      uint32
+	Nlink     uint32
+	UID       uint32
+	GID       uint32
+	Size      uint32
+	Blocksize uint32
+	Rdev      uint32
+	Blocks    uint32
+	FSID      uint32
+	Fileid    uint32
+	Atime     Timeval2
+	Mtime     Timeval2
+	Ctime     Timeval2
+}
+
+// Sattr2 leaves fields set to all ones unchanged.
+type Sattr2 struct {
+	Mode  uint32
+	UID   uint32
+	GID   uint32
+	Size  uint32
+	Atime Timeval2
+	Mtime Timeval2
+}
+
+type Diropargs2 struct {
+	Dir  FHandle2
+	Name string
+}
+
+type Attrstat2 struct {
+	Status NFSStat `xdr:"union"`
+	Attr   Fattr2  `xdr:"unioncase=0"`
+}
+
+type Diropres2 struct {
+	Status NFSStat  `xdr:"union"`
+	File   FHandle2 `xdr:"unioncase=0"`
+	Attr   Fattr2   `xdr:"unioncase=0"`
+}
+
+type Stat2res struct {
+	Status NFSStat
+}
+
+type GETATTR2args struct {
+	xdrrpc.Header
+	File FHandle2
+}
+
+type SETATTR2args struct {
+	xdrrpc.Header
+	File FHandle2
+	Attr Sattr2
+}
+
+type LOOKUP2args struct {
+	xdrrpc.Header
+	What Diropargs2
+}
+
+type READ2args struct {
+	xdrrpc.Header
+	File       FHandle2
+	Offset     uint32
+	Count      uint32
+	TotalCount uint32 // unused
+}
+
+type READ2res struct {
+	Status NFSStat `xdr:"union"`
+	Attr   Fattr2  `xdr:"unioncase=0"`
+	Data   []byte  `xdr:"unioncase=0"`
+}
+
+type WRITE2args struct {
+	xdrrpc.Header
+	File        FHandle2
+	BeginOffset uint32 // unused
+	Offset      uint32
+	TotalCount  uint32 // unused
+	Data        []byte
+}
+
+type CREATE2args struct {
+	xdrrpc.Header
+	Where Diropargs2
+	Attr  Sattr2
+}
+
+type REMOVE2args struct {
+	xdrrpc.Header
+	What Diropargs2
+}
+
+type RENAME2args struct {
+	xdrrpc.Header
+	From Diropargs2
+	To   Diropargs2
+}
+
+type LINK2args struct {
+	xdrrpc.Header
+	From FHandle2
+	To   Diropargs2
+}
+
+type READDIR2args struct {
+	xdrrpc.Header
+	Dir    FHandle2
+	Cookie uint32
+	Count  uint32
+}
+
+type Entry2 struct {
+	Fileid uint32
+	Name   string
+	Cookie uint32
+	Next   *Entry2 `xdr:"optional"`
+}
+
+type DirList2 struct {
+	Entries *Entry2 `xdr:"optional"`
+	EOF     bool
+}
+
+type READDIR2res struct {
+	Status NFSStat  `xdr:"union"`
+	Reply  DirList2 `xdr:"unioncase=0"`
+}
+
+type STATFS2args struct {
+	xdrrpc.Header
+	File FHandle2
+}
+
+type STATFS2res struct {
+	Status NFSStat `xdr:"union"`
+	Tsize  uint32  `xdr:"unioncase=0"`
+	Bsize  uint32  `xdr:"unioncase=0"`
+	Blocks uint32  `xdr:"unioncase=0"`
+	Bfree  uint32  `xdr:"unioncase=0"`
+	Bavail uint32  `xdr:"unioncase=0"`
+}
@@ -0,0 +1,570 @@
+package nfs
+
+import "github.com/dzeromsk/xdrrpc"
+
+const Nfs4Vers = 4
+
+// NFSv4 operations.
+const (
+	OpAccess             = 3
+	OpClose              = 4
+	OpCommit             = 5
+	OpCreate             = 6
+	OpDelegpurge         = 7
+	OpDelegreturn        = 8
+	OpGetattr            = 9
+	OpGetfh              = 10
+	OpLink               = 11
+	OpLock               = 12
+	OpLockt              = 13
+	OpLocku              = 14
+	OpLookup             = 15
+	OpLookupp            = 16
+	OpNverify            = 17
+	OpOpen               = 18
+	OpOpenattr           = 19
+	OpOpenConfirm        = 20
+	OpOpenDowngrade      = 21
+	OpPutfh              = 22
+	OpPutpubfh           = 23
+	OpPutrootfh          = 24
+	OpRead               = 25
+	OpReaddir            = 26
+	OpReadlink           = 27
+	OpRemove             = 28
+	OpRename             = 29
+	OpRenew              = 30
+	OpRestorefh          = 31
+	OpSavefh             = 32
+	OpSecinfo            = 33
+	OpSetattr            = 34
+	OpSetclientid        = 35
+	OpSetclientidConfirm = 36
+	OpVerify             = 37
+	OpWrite              = 38
+	OpReleaseLockowner   = 39
+	OpIllegal            = 10044
+)
+
+// Status codes only NFSv4 uses. The others are numbered like in NFSv3.
+const (
+	NFSStatSame              NFSStat = 10009
+	NFSStatDenied            NFSStat = 10010
+	NFSStatExpired           NFSStat = 10011
+	NFSStatLocked            NFSStat = 10012
+	NFSStatGrace             NFSStat = 10013
+	NFSStatFhexpired         NFSStat = 10014
+	NFSStatShareDenied       NFSStat = 10015
+	NFSStatWrongsec          NFSStat = 10016
+	NFSStatClidInuse         NFSStat = 10017
+	NFSStatResource          NFSStat = 10018
+	NFSStatMoved             NFSStat = 10019
+	NFSStatNofilehandle      NFSStat = 10020
+	NFSStatMinorVersMismatch NFSStat = 10021
+	NFSStatStaleClientid     NFSStat = 10022
+	NFSStatStaleStateid      NFSStat = 10023
+	NFSStatOldStateid        NFSStat = 10024
+	NFSStatBadStateid        NFSStat = 10025
+	NFSStatBadSeqid          NFSStat = 10026
+	NFSStatNotSame           NFSStat = 10027
+	NFSStatLockRange         NFSStat = 10028
+	NFSStatSymlink           NFSStat = 10029
+	NFSStatRestorefh         NFSStat = 10030
+	NFSStatAttrnotsupp       NFSStat = 10032
+	NFSStatNoGrace           NFSStat = 10033
+	NFSStatBadxdr            NFSStat = 10036
+	NFSStatOpenmode          NFSStat = 10038
+	NFSStatBadowner          NFSStat = 10039
+	NFSStatBadname           NFSStat = 10041
+	NFSStatOpIllegal         NFSStat = 10044
+)
+
+// OPEN share access and deny bits.
+const (
+	ShareAccessRead  = 1
+	ShareAccessWrite = 2
+	ShareDenyRead    = 1
+	ShareDenyWrite   = 2
+)
+
+// OPEN result flags.
+const (
+	OpenResultConfirm       = 0x2
+	OpenResultLocktypePosix = 0x4
+)
+
+// Bitmap4 is a set of attribute numbers, bit n of word n/32 for
+// attribute n.
+type Bitmap4 []uint32
+
+// Fattr4 holds the values of the attributes in Mask, XDR encoded in
+// attribute number order.
+type Fattr4 struct {
+	Mask Bitmap4
+	Vals []byte
+}
+
+type NFS4Time struct {
+	Seconds  int64
+	Nseconds uint32
+}
+
+type Stateid4 struct {
+	Seqid uint32
+	Other [12]byte
+}
+
+type ChangeInfo4 struct {
+	Atomic bool
+	Before uint64
+	After  uint64
+}
+
+type Specdata4 struct {
+	Major uint32
+	Minor uint32
+}
+
+type OpenOwner4 struct {
+	Clientid uint64
+	Owner    []byte
+}
+
+type LockOwner4 struct {
+	Clientid uint64
+	Owner    []byte
+}
+
+type COMPOUND4args struct {
+	xdrrpc.Header
+	Tag          string
+	MinorVersion uint32
+	Ops          []Argop4
+}
+
+type COMPOUND4res struct {
+	Status NFSStat
+	Tag    string
+	Ops    []Resop4
+}
+
+// Argop4 holds the arguments of one operation, in the field for Op.
+// Operations without arguments have no field.
+type Argop4 struct {
+	Op                 uint32                   `xdr:"union"`
+	Access             ACCESS4args              `xdr:"unioncase=3"`
+	Close              CLOSE4args               `xdr:"unioncase=4"`
+	Commit             COMMIT4args              `xdr:"unioncase=5"`
+	Create             CREATE4args              `xdr:"unioncase=6"`
+	Delegpurge         DELEGPURGE4args          `xdr:"unioncase=7"`
+	Delegreturn        DELEGRETURN4args         `xdr:"unioncase=8"`
+	Getattr            GETATTR4args             `xdr:"unioncase=9"`
+	Link               LINK4args                `xdr:"unioncase=11"`
+	Lock               LOCK4args                `xdr:"unioncase=12"`
+	Lockt              LOCKT4args               `xdr:"unioncase=13"`
+	Locku              LOCKU4args               `xdr:"unioncase=14"`
+	Lookup             LOOKUP4args              `xdr:"unioncase=15"`
+	Nverify            VERIFY4args              `xdr:"unioncase=17"`
+	Open               OPEN4args                `xdr:"unioncase=18"`
+	Openattr           OPENATTR4args            `xdr:"unioncase=19"`
+	OpenConfirm        OPEN_CONFIRM4args        `xdr:"unioncase=20"`
+	OpenDowngrade      OPEN_DOWNGRADE4args      `xdr:"unioncase=21"`
+	Putfh              PUTFH4args               `xdr:"unioncase=22"`
+	Read               READ4args                `xdr:"unioncase=25"`
+	Readdir            READDIR4args             `xdr:"unioncase=26"`
+	Remove             REMOVE4args              `xdr:"unioncase=28"`
+	Rename             RENAME4args              `xdr:"unioncase=29"`
+	Renew              RENEW4args               `xdr:"unioncase=30"`
+	Secinfo            SECINFO4args             `xdr:"unioncase=33"`
+	Setattr            SETATTR4args             `xdr:"unioncase=34"`
+	Setclientid        SETCLIENTID4args         `xdr:"unioncase=35"`
+	SetclientidConfirm SETCLIENTID_CONFIRM4args `xdr:"unioncase=36"`
+	Verify             VERIFY4args              `xdr:"unioncase=37"`
+	Write              WRITE4args               `xdr:"unioncase=38"`
+	ReleaseLockowner   RELEASE_LOCKOWNER4args   `xdr:"unioncase=39"`
+}
+
+// Resop4 holds the result of one operation, in the field for Op.
+type Resop4 struct {
+	Op                 uint32          `xdr:"union"`
+	Access             ACCESS4res      `xdr:"unioncase=3"`
+	Close              Stateid4res     `xdr:"unioncase=4"`
+	Commit             COMMIT4res      `xdr:"unioncase=5"`
+	Create             CREATE4res      `xdr:"unioncase=6"`
+	Delegpurge         Status4res      `xdr:"unioncase=7"`
+	Delegreturn        Status4res      `xdr:"unioncase=8"`
+	Getattr            GETATTR4res     `xdr:"unioncase=9"`
+	Getfh              GETFH4res       `xdr:"unioncase=10"`
+	Link               ChangeInfo4res  `xdr:"unioncase=11"`
+	Lock               Status4res      `xdr:"unioncase=12"`
+	Lockt              Status4res      `xdr:"unioncase=13"`
+	Locku              Status4res      `xdr:"unioncase=14"`
+	Lookup             Status4res      `xdr:"unioncase=15"`
+	Lookupp            Status4res      `xdr:"unioncase=16"`
+	Nverify            Status4res      `xdr:"unioncase=17"`
+	Open               OPEN4res        `xdr:"unioncase=18"`
+	Openattr           Status4res      `xdr:"unioncase=19"`
+	OpenConfirm        Stateid4res     `xdr:"unioncase=20"`
+	OpenDowngrade      Stateid4res     `xdr:"unioncase=21"`
+	Putfh              Status4res      `xdr:"unioncase=22"`
+	Putpubfh           Status4res      `xdr:"unioncase=23"`
+	Putrootfh          Status4res      `xdr:"unioncase=24"`
+	Read               READ4res        `xdr:"unioncase=25"`
+	Readdir            READDIR4res     `xdr:"unioncase=26"`
+	Readlink           Status4res      `xdr:"unioncase=27"`
+	Remove             ChangeInfo4res  `xdr:"unioncase=28"`
+	Rename             RENAME4res      `xdr:"unioncase=29"`
+	Renew              Status4res      `xdr:"unioncase=30"`
+	Restorefh          Status4res      `xdr:"unioncase=31"`
+	Savefh             Status4res      `xdr:"unioncase=32"`
+	Secinfo            SECINFO4res     `xdr:"unioncase=33"`
+	Setattr            SETATTR4res     `xdr:"unioncase=34"`
+	Setclientid        SETCLIENTID4res `xdr:"unioncase=35"`
+	SetclientidConfirm Status4res      `xdr:"unioncase=36"`
+	Verify             Status4res      `xdr:"unioncase=37"`
+	Write              WRITE4res       `xdr:"unioncase=38"`
+	ReleaseLockowner   Status4res      `xdr:"unioncase=39"`
+	Illegal            Status4res      `xdr:"unioncase=10044"`
+}
+
+// Status4res is the result of operations returning nothing but a status.
+type Status4res struct {
+	Status NFSStat
+}
+
+type Stateid4res struct {
+	Status  NFSStat  `xdr:"union"`
+	Stateid Stateid4 `xdr:"unioncase=0"`
+}
+
+type ChangeInfo4res struct {
+	Status NFSStat     `xdr:"union"`
+	Cinfo  ChangeInfo4 `xdr:"unioncase=0"`
+}
+
+type ACCESS4args struct {
+	Access uint32
+}
+
+type ACCESS4res struct {
+	Status    NFSStat `xdr:"union"`
+	Supported uint32  `xdr:"unioncase=0"`
+	Access    uint32  `xdr:"unioncase=0"`
+}
+
+type CLOSE4args struct {
+	Seqid   uint32
+	Stateid Stateid4
+}
+
+type COMMIT4args struct {
+	Offset uint64
+	Count  uint32
+}
+
+type COMMIT4res struct {
+	Status NFSStat `xdr:"union"`
+	Verf   [8]byte `xdr:"unioncase=0"`
+}
+
+// Createtype4 is the type of object CREATE makes, with what it needs.
+type Createtype4 struct {
+	Type     uint32    `xdr:"union"`
+	LinkData string    `xdr:"unioncase=5"`
+	BlkData  Specdata4 `xdr:"unioncase=3"`
+	ChrData  Specdata4 `xdr:"unioncase=4"`
+}
+
+type CREATE4args struct {
+	Objtype Createtype4
+	Name    string
+	Attrs   Fattr4
+}
+
+type CREATE4res struct {
+	Status  NFSStat     `xdr:"union"`
+	Cinfo   ChangeInfo4 `xdr:"unioncase=0"`
+	Attrset Bitmap4     `xdr:"unioncase=0"`
+}
+
+type DELEGPURGE4args struct {
+	Clientid uint64
+}
+
+type DELEGRETURN4args struct {
+	Stateid Stateid4
+}
+
+type GETATTR4args struct {
+	AttrRequest Bitmap4
+}
+
+type GETATTR4res struct {
+	Status NFSStat `xdr:"union"`
+	Attrs  Fattr4  `xdr:"unioncase=0"`
+}
+
+type GETFH4res struct {
+	Status NFSStat `xdr:"union"`
+	Object []byte  `xdr:"unioncase=0"`
+}
+
+type LINK4args struct {
+	Name string
+}
+
+type OpenToLockOwner4 struct {
+	OpenSeqid   uint32
+	OpenStateid Stateid4
+	LockSeqid   uint32
+	LockOwner   LockOwner4
+}
+
+type ExistLockOwner4 struct {
+	LockStateid Stateid4
+	LockSeqid   uint32
+}
+
+type Locker4 struct {
+	NewLockOwner bool             `xdr:"union"`
+	OpenOwner    OpenToLockOwner4 `xdr:"unioncase=1"`
+	LockOwner    ExistLockOwner4  `xdr:"unioncase=0"`
+}
+
+type LOCK4args struct {
+	Locktype uint32
+	Reclaim  bool
+	Offset   uint64
+	Length   uint64
+	Locker   Locker4
+}
+
+type LOCKT4args struct {
+	Locktype uint32
+	Offset   uint64
+	Length   uint64
+	Owner    LockOwner4
+}
+
+type LOCKU4args struct {
+	Locktype uint32
+	Seqid    uint32
+	Stateid  Stateid4
+	Offset   uint64
+	Length   uint64
+}
+
+type LOOKUP4args struct {
+	Name string
+}
+
+// VERIFY4args are the arguments of VERIFY and NVERIFY.
+type VERIFY4args struct {
+	Attrs Fattr4
+}
+
+// Createhow4 tells how OPEN creates a file: UNCHECKED, GUARDED or
+// EXCLUSIVE like in NFSv3.
+type Createhow4 struct {
+	Mode       uint32  `xdr:"union"`
+	Unchecked  Fattr4  `xdr:"unioncase=0"`
+	Guarded    Fattr4  `xdr:"unioncase=1"`
+	CreateVerf [8]byte `xdr:"unioncase=2"`
+}
+
+type Openflag4 struct {
+	Create bool       `xdr:"union"`
+	How    Createhow4 `xdr:"unioncase=1"`
+}
+
+type OpenClaimDelegateCur4 struct {
+	Stateid Stateid4
+	File    string
+}
+
+type OpenClaim4 struct {
+	Claim        uint32                `xdr:"union"`
+	File         string                `xdr:"unioncase=0"` // CLAIM_NULL
+	DelegateType uint32                `xdr:"unioncase=1"` // CLAIM_PREVIOUS
+	DelegateCur  OpenClaimDelegateCur4 `xdr:"unioncase=2"`
+	DelegatePrev string                `xdr:"unioncase=3"`
+}
+
+type OPEN4args struct {
+	Seqid       uint32
+	ShareAccess uint32
+	ShareDeny   uint32
+	Owner       OpenOwner4
+	Openhow     Openflag4
+	Claim       OpenClaim4
+}
+
+// OpenDelegation4 is always OPEN_DELEGATE_NONE, we do not delegate.
+type OpenDelegation4 struct {
+	Type uint32
+}
+
+type OPEN4res struct {
+	Status     NFSStat         `xdr:"union"`
+	Stateid    Stateid4        `xdr:"unioncase=0"`
+	Cinfo      ChangeInfo4     `xdr:"unioncase=0"`
+	Rflags     uint32          `xdr:"unioncase=0"`
+	Attrset    Bitmap4         `xdr:"unioncase=0"`
+	Delegation OpenDelegation4 `xdr:"unioncase=0"`
+}
+
+type OPENATTR4args struct {
+	Createdir bool
+}
+
+type OPEN_CONFIRM4args struct {
+	Stateid Stateid4
+	Seqid   uint32
+}
+
+type OPEN_DOWNGRADE4args struct {
+	Stateid     Stateid4
+	Seqid       uint32
+	ShareAccess uint32
+	ShareDeny   uint32
+}
+
+type PUTFH4args struct {
+	Object []byte
+}
+
+type READ4args struct {
+	Stateid Stateid4
+	Offset  uint64
+	Count   uint32
+}
+
+type READ4res struct {
+	Status NFSStat `xdr:"union"`
+	EOF    bool    `xdr:"unioncase=0"`
+	Data   []byte  `xdr:"unioncase=0"`
+}
+
+type READDIR4args struct {
+	Cookie      uint64
+	CookieVerf  [8]byte
+	DirCount    uint32
+	MaxCount    uint32
+	AttrRequest Bitmap4
+}
+
+type Entry4 struct {
+	Cookie uint64
+	Name   string
+	Attrs  Fattr4
+	Next   *Entry4 `xdr:"optional"`
+}
+
+type DirList4 struct {
+	Entries *Entry4 `xdr:"optional"`
+	EOF     bool
+}
+
+type READDIR4res struct {
+	Status     NFSStat  `xdr:"union"`
+	CookieVerf [8]byte  `xdr:"unioncase=0"`
+	Reply      DirList4 `xdr:"unioncase=0"`
+}
+
+type REMOVE4args struct {
+	Target string
+}
+
+type RENAME4args struct {
+	OldName string
+	NewName string
+}
+
+type RENAME4res struct {
+	Status      NFSStat     `xdr:"union"`
+	SourceCinfo ChangeInfo4 `xdr:"unioncase=0"`
+	TargetCinfo ChangeInfo4 `xdr:"unioncase=0"`
+}
+
+type RENEW4args struct {
+	Clientid uint64
+}
+
+type SECINFO4args struct {
+	Name string
+}
+
+// Secinfo4 names a security flavor. We offer none needing more.
+type Secinfo4 struct {
+	Flavor AuthFlavor
+}
+
+type SECINFO4res struct {
+	Status  NFSStat    `xdr:"union"`
+	Flavors []Secinfo4 `xdr:"unioncase=0"`
+}
+
+type SETATTR4args struct {
+	Stateid Stateid4
+	Attrs   Fattr4
+}
+
+type SETATTR4res struct {
+	Status   NFSStat
+	Attrsset Bitmap4
+}
+
+type NFSClientID4 struct {
+	Verifier [8]byte
+	ID       []byte
+}
+
+type ClientAddr4 struct {
+	Netid string
+	Addr  string
+}
+
+type CBClient4 struct {
+	Program  uint32
+	Location ClientAddr4
+}
+
+type SETCLIENTID4args struct {
+	Client        NFSClientID4
+	Callback      CBClient4
+	CallbackIdent uint32
+}
+
+type SETCLIENTID4resok struct {
+	Clientid uint64
+	Confirm  [8]byte
+}
+
+type SETCLIENTID4res struct {
+	Status NFSStat           `xdr:"union"`
+	Resok  SETCLIENTID4resok `xdr:"unioncase=0"`
+	InUse  ClientAddr4       `xdr:"unioncase=10017"`
+}
+
+type SETCLIENTID_CONFIRM4args struct {
+	Clientid uint64
+	Confirm  [8]byte
+}
+
+type WRITE4args struct {
+	Stateid Stateid4
+	Offset  uint64
+	Stable  int32
+	Data    []byte
+}
+
+type WRITE4res struct {
+	Status    NFSStat `xdr:"union"`
+	Count     uint32  `xdr:"unioncase=0"`
+	Committed int32   `xdr:"unioncase=0"`
+	Verf      [8]byte `xdr:"unioncase=0"`
+}
+
+type RELEASE_LOCKOWNER4args struct {
+	Owner LockOwner4
+}
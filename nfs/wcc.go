@@ -0,0 +1,40 @@
+package nfs
+
+import "time"
+
+// NewNFS3Time converts t to the wire representation of time.
+func NewNFS3Time(t time.Time) NFS3Time {
+	return NFS3Time{
+		Seconds:  uint32(t.Unix()),
+		Nseconds: uint32(t.Nanosecond()),
+	}
+}
+
+// NewPostOpAttr returns post operation attributes set to attr.
+func NewPostOpAttr(attr Fattr3) PostOpAttr {
+	return PostOpAttr{IsSet: true, Attr: attr}
+}
+
+// NewPreOpAttr returns the subset of attr clients use to check whether
+// their cache was valid before an operation.
+func NewPreOpAttr(attr Fattr3) PreOpAttr {
+	return PreOpAttr{
+		IsSet: true,
+		Attr: WccAttr{
+			Size:  attr.Filesize,
+			Mtime: attr.Mtime,
+			Ctime: attr.Ctime,
+		},
+	}
+}
+
+// NewWccData returns weak cache consistency data for an object that had
+// attributes before and after an operation. Both snapshots must be taken
+// while holding whatever lock serializes mutations of the object, otherwise
+// clients may wrongly conclude that nobody else changed it in between.
+func NewWccData(before, after Fattr3) WccData {
+	return WccData{
+		Pre:  NewPreOpAttr(before),
+		Post: NewPostOpAttr(after),
+	}
+}
@@ -0,0 +1,62 @@
+package nfs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+func TestNewWccData(t *testing.T) {
+	t1 := nfs.NewNFS3Time(time.Unix(1000, 1))
+	t2 := nfs.NewNFS3Time(time.Unix(2000, 2))
+	for _, tt := range []struct {
+		name          string
+		before, after nfs.Fattr3
+		pre           nfs.WccAttr
+	}{
+		{
+			"unchanged",
+			nfs.Fattr3{Filesize: 10, Mtime: t1, Ctime: t1},
+			nfs.Fattr3{Filesize: 10, Mtime: t1, Ctime: t1},
+			nfs.WccAttr{Size: 10, Mtime: t1, Ctime: t1},
+		},
+		{
+			"written",
+			nfs.Fattr3{Filesize: 10, Mtime: t1, Ctime: t1},
+			nfs.Fattr3{Filesize: 20, Mtime: t2, Ctime: t2},
+			nfs.WccAttr{Size: 10, Mtime: t1, Ctime: t1},
+		},
+		{
+			"only pre-op fields kept",
+			nfs.Fattr3{Type: nfs.NF3Reg, FileMode: 0644, UID: 1, Filesize: 10, Used: 4096, Fileid: 7, Atime: t2, Mtime: t1, Ctime: t2},
+			nfs.Fattr3{Type: nfs.NF3Reg, FileMode: 0600, UID: 1, Filesize: 10, Used: 4096, Fileid: 7, Atime: t2, Mtime: t1, Ctime: t2},
+			nfs.WccAttr{Size: 10, Mtime: t1, Ctime: t2},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			wcc := nfs.NewWccData(tt.before, tt.after)
+			if !wcc.Pre.IsSet || wcc.Pre.Attr != tt.pre {
+				t.Errorf("pre: got %+v, want %+v", wcc.Pre, tt.pre)
+			}
+			if !wcc.Post.IsSet || wcc.Post.Attr != tt.after {
+				t.Errorf("post: got %+v, want %+v", wcc.Post, tt.after)
+			}
+		})
+	}
+}
+
+func TestNewNFS3Time(t *testing.T) {
+	for _, tt := range []struct {
+		t    time.Time
+		want nfs.NFS3Time
+	}{
+		{time.Unix(0, 0), nfs.NFS3Time{}},
+		{time.Unix(1, 999999999), nfs.NFS3Time{Seconds: 1, Nseconds: 999999999}},
+		{time.Unix(1<<32-1, 5), nfs.NFS3Time{Seconds: 1<<32 - 1, Nseconds: 5}},
+	} {
+		if got := nfs.NewNFS3Time(tt.t); got != tt.want {
+			t.Errorf("NewNFS3Time(%v): got %+v, want %+v", tt.t, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package nfs_test
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+// cacheFile keeps writes unstable until flushed, flushing fails with err.
+type cacheFile struct {
+	flushFile
+	flushes int
+	err     error
+}
+
+func (f *cacheFile) Write(args *nfs.WRITE3args, res *nfs.WRITE3res) error {
+	res.Status = nfs.NFSStatOk
+	res.Count = args.Count
+	res.Committed = nfs.Unstable
+	return nil
+}
+
+func (f *cacheFile) Flush(offset uint64, count uint32) error {
+	f.flushes++
+	return f.err
+}
+
+func TestWriteVerifier(t *testing.T) {
+	f := &cacheFile{}
+	r, fh := serve(t, f)
+	write := func(stable int32) nfs.WRITE3res {
+		var res nfs.WRITE3res
+		r.Write(&nfs.WRITE3args{Object: fh, Count: 1, Stable: stable, Data: []byte{1}}, &res)
+		return res
+	}
+	commit := func() nfs.COMMIT3res {
+		var res nfs.COMMIT3res
+		r.Commit(&nfs.COMMIT3args{Object: fh}, &res)
+		return res
+	}
+
+	unstable := write(nfs.Unstable)
+	if unstable.Status != nfs.NFSStatOk || unstable.Committed != nfs.Unstable || f.flushes != 0 {
+		t.Fatalf("UNSTABLE write: status %v, committed %d, %d flushes", unstable.Status, unstable.Committed, f.flushes)
+	}
+	if sync := write(nfs.FileSync); sync.Committed != nfs.FileSync || f.flushes != 1 || sync.Verf != unstable.Verf {
+		t.Errorf("FILE_SYNC write: committed %d, %d flushes, verifier changed %v", sync.Committed, f.flushes, sync.Verf != unstable.Verf)
+	}
+	if res := commit(); res.Status != nfs.NFSStatOk || res.Verf != unstable.Verf {
+		t.Errorf("COMMIT: status %v, verifier changed %v", res.Status, res.Verf != unstable.Verf)
+	}
+
+	// a failed flush may have lost unstable data
+	f.err = syscall.EIO
+	if res := commit(); res.Status != nfs.NFSStatIo {
+		t.Errorf("COMMIT: status %v, want NFS3ERR_IO", res.Status)
+	}
+	f.err = nil
+	if res := commit(); res.Status != nfs.NFSStatOk || res.Verf == unstable.Verf {
+		t.Errorf("COMMIT after a failed flush: status %v, verifier changed %v, want changed", res.Status, res.Verf != unstable.Verf)
+	}
+}
@@ -0,0 +1,515 @@
+package nfstest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc/client"
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+// TestBackend checks that file systems f makes behave like the Linux NFS
+// server does, over NFSv3 as root. Every subtest gets a new file system.
+func TestBackend(t *testing.T, f Factory) {
+	for _, test := range []struct {
+		name string
+		fn   func(t *testing.T, c *client.Client)
+	}{
+		{"Create", testCreate},
+		{"ReadWrite", testReadWrite},
+		{"Setattr", testSetattr},
+		{"Rename", testRename},
+		{"Remove", testRemove},
+		{"Readdir", testReaddir},
+		{"Link", testLink},
+		{"Status", testStatus},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, NewClient(t, f))
+		})
+	}
+}
+
+// status returns the NFS status err carries, failing t for transport
+// errors.
+func status(t *testing.T, err error) nfs.NFSStat {
+	t.Helper()
+	if err == nil {
+		return nfs.NFSStatOk
+	}
+	var stat nfs.NFSStat
+	if !errors.As(err, &stat) {
+		t.Fatal(err)
+	}
+	return stat
+}
+
+// expect checks that the call described by what failed with want.
+func expect(t *testing.T, what string, err error, want nfs.NFSStat) {
+	t.Helper()
+	if got := status(t, err); got != want {
+		t.Errorf("%s: got %v, want %v", what, got, want)
+	}
+}
+
+// must fails t right away if the call described by what failed.
+func must(t *testing.T, what string, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+}
+
+func mode(m uint32) nfs.Sattr3 {
+	return nfs.Sattr3{Mode: nfs.Sattr3Mode{IsSet: true, Mode: m}}
+}
+
+func unchecked(attr nfs.Sattr3) nfs.Createhow3 {
+	return nfs.Createhow3{Mode: 0, UncheckedAttr: attr}
+}
+
+func guarded(attr nfs.Sattr3) nfs.Createhow3 {
+	return nfs.Createhow3{Mode: 1, GuardedAttr: attr}
+}
+
+// create makes the file name in dir holding data and returns its handle.
+func create(t *testing.T, c *client.Client, dir []byte, name, data string) []byte {
+	t.Helper()
+	res, err := c.Create(dir, name, guarded(mode(0644)))
+	must(t, "create "+name, err)
+	fh := handle(t, c, dir, name, res.Handle)
+	if data != "" {
+		_, err := c.Write(fh, 0, []byte(data), nfs.FileSync)
+		must(t, "write "+name, err)
+	}
+	return fh
+}
+
+// mkdir makes the directory name in dir and returns its handle.
+func mkdir(t *testing.T, c *client.Client, dir []byte, name string) []byte {
+	t.Helper()
+	res, err := c.Mkdir(dir, name, mode(0755))
+	must(t, "mkdir "+name, err)
+	return handle(t, c, dir, name, res.Handle)
+}
+
+// handle returns the handle fh holds, looking name up if it holds none.
+func handle(t *testing.T, c *client.Client, dir []byte, name string, fh nfs.PostOpFH3) []byte {
+	t.Helper()
+	if fh.IsSet {
+		return fh.FH
+	}
+	res, err := c.Lookup(dir, name)
+	must(t, "lookup "+name, err)
+	return res.Object
+}
+
+func getattr(t *testing.T, c *client.Client, fh []byte) nfs.Fattr3 {
+	t.Helper()
+	res, err := c.Getattr(fh)
+	must(t, "getattr", err)
+	return res.Attr
+}
+
+func read(t *testing.T, c *client.Client, fh []byte) string {
+	t.Helper()
+	var buf bytes.Buffer
+	for {
+		res, err := c.Read(fh, uint64(buf.Len()), 4096)
+		must(t, "read", err)
+		buf.Write(res.Data)
+		if res.EOF || res.Count == 0 {
+			return buf.String()
+		}
+	}
+}
+
+func testCreate(t *testing.T, c *client.Client) {
+	root := c.Root
+
+	res, err := c.Create(root, "f", unchecked(mode(0640)))
+	must(t, "create", err)
+	if !res.Attr.IsSet || res.Attr.Attr.Type != nfs.NF3Reg || res.Attr.Attr.FileMode&07777 != 0640 || res.Attr.Attr.Filesize != 0 {
+		t.Errorf("create: attributes %+v", res.Attr)
+	}
+	if !res.DirWcc.Post.IsSet {
+		t.Error("create: no directory attributes")
+	}
+	fh := handle(t, c, root, "f", res.Handle)
+	if attr := getattr(t, c, fh); res.Attr.IsSet && attr.Fileid != res.Attr.Attr.Fileid {
+		t.Errorf("create: fileid %d, getattr says %d", res.Attr.Attr.Fileid, attr.Fileid)
+	}
+	lres, err := c.Lookup(root, "f")
+	must(t, "lookup", err)
+	if lres.Attr.IsSet && lres.Attr.Attr.Fileid != getattr(t, c, fh).Fileid {
+		t.Error("lookup: different file")
+	}
+
+	_, err = c.Write(fh, 0, []byte("abc"), nfs.FileSync)
+	must(t, "write", err)
+	_, err = c.Create(root, "f", guarded(mode(0644)))
+	expect(t, "guarded create of existing file", err, nfs.NFSStatExist)
+	_, err = c.Create(root, "f", unchecked(nfs.Sattr3{}))
+	expect(t, "unchecked create of existing file", err, nfs.NFSStatOk)
+	if got := read(t, c, fh); got != "abc" {
+		t.Errorf("unchecked create changed data to %q", got)
+	}
+
+	mres, err := c.Mkdir(root, "d", mode(0750))
+	must(t, "mkdir", err)
+	if !mres.Attr.IsSet || mres.Attr.Attr.Type != nfs.NF3Dir || mres.Attr.Attr.FileMode&07777 != 0750 {
+		t.Errorf("mkdir: attributes %+v", mres.Attr)
+	}
+	_, err = c.Mkdir(root, "d", mode(0755))
+	expect(t, "mkdir of existing directory", err, nfs.NFSStatExist)
+	_, err = c.Mkdir(root, "f", mode(0755))
+	expect(t, "mkdir over file", err, nfs.NFSStatExist)
+	_, err = c.Create(root, "d", guarded(mode(0644)))
+	expect(t, "create over directory", err, nfs.NFSStatExist)
+
+	d := handle(t, c, root, "d", mres.Handle)
+	create(t, c, d, "nested", "")
+	if _, err := c.Lookup(d, "nested"); err != nil {
+		t.Error("lookup in new directory:", err)
+	}
+}
+
+func testReadWrite(t *testing.T, c *client.Client) {
+	fh := create(t, c, c.Root, "f", "")
+
+	wres, err := c.Write(fh, 0, []byte("hello"), nfs.FileSync)
+	must(t, "write", err)
+	if wres.Count != 5 || wres.Committed != nfs.FileSync {
+		t.Errorf("write: count %d committed %d", wres.Count, wres.Committed)
+	}
+	if !wres.FileWcc.Post.IsSet || wres.FileWcc.Post.Attr.Filesize != 5 {
+		t.Errorf("write: post attributes %+v", wres.FileWcc.Post)
+	}
+
+	_, err = c.Write(fh, 10, []byte("world"), nfs.FileSync)
+	must(t, "write past end", err)
+	if got := read(t, c, fh); got != "hello\x00\x00\x00\x00\x00world" {
+		t.Errorf("read after write past end: %q", got)
+	}
+
+	rres, err := c.Read(fh, 1, 3)
+	must(t, "read", err)
+	if string(rres.Data) != "ell" || rres.Count != 3 || rres.EOF {
+		t.Errorf("short read: %q count %d eof %v", rres.Data, rres.Count, rres.EOF)
+	}
+	rres, err = c.Read(fh, 10, 100)
+	must(t, "read to end", err)
+	if string(rres.Data) != "world" || !rres.EOF {
+		t.Errorf("read to end: %q eof %v", rres.Data, rres.EOF)
+	}
+	rres, err = c.Read(fh, 100, 10)
+	must(t, "read past end", err)
+	if rres.Count != 0 || !rres.EOF {
+		t.Errorf("read past end: count %d eof %v", rres.Count, rres.EOF)
+	}
+
+	// data truncated away must not come back
+	_, err = c.Setattr(fh, nfs.Sattr3{Size: nfs.Sattr3Size{IsSet: true, Size: 2}}, nfs.Sattrguard3{})
+	must(t, "truncate", err)
+	_, err = c.Write(fh, 6, []byte("!"), nfs.FileSync)
+	must(t, "write after truncate", err)
+	if got := read(t, c, fh); got != "he\x00\x00\x00\x00!" {
+		t.Errorf("read after truncate: %q", got)
+	}
+
+	wres, err = c.Write(fh, 0, []byte("HE"), nfs.Unstable)
+	must(t, "unstable write", err)
+	cres, err := c.Commit(fh, 0, 0)
+	if status(t, err) != nfs.NFSStatNotsupp {
+		must(t, "commit", err)
+		if cres.Verf != wres.Verf {
+			t.Error("commit: verifier changed without restart")
+		}
+	}
+	if got := read(t, c, fh); got != "HE\x00\x00\x00\x00!" {
+		t.Errorf("read after unstable write: %q", got)
+	}
+}
+
+func testSetattr(t *testing.T, c *client.Client) {
+	fh := create(t, c, c.Root, "f", "data")
+
+	res, err := c.Setattr(fh, mode(0600), nfs.Sattrguard3{})
+	must(t, "chmod", err)
+	if !res.ObjWcc.Post.IsSet || res.ObjWcc.Post.Attr.FileMode&07777 != 0600 {
+		t.Errorf("chmod: post attributes %+v", res.ObjWcc.Post)
+	}
+	if attr := getattr(t, c, fh); attr.FileMode&07777 != 0600 {
+		t.Errorf("chmod: mode %o", attr.FileMode&07777)
+	}
+
+	_, err = c.Setattr(fh, nfs.Sattr3{Size: nfs.Sattr3Size{IsSet: true, Size: 8}}, nfs.Sattrguard3{})
+	must(t, "extend", err)
+	if got := read(t, c, fh); got != "data\x00\x00\x00\x00" {
+		t.Errorf("read after extend: %q", got)
+	}
+
+	mtime := nfs.NFS3Time{Seconds: 1000000000, Nseconds: 5}
+	_, err = c.Setattr(fh, nfs.Sattr3{Mtime: nfs.Sattr3Time{TimeHow: 2, Time: mtime}}, nfs.Sattrguard3{})
+	must(t, "set mtime", err)
+	if attr := getattr(t, c, fh); attr.Mtime != mtime {
+		t.Errorf("set mtime: got %+v", attr.Mtime)
+	}
+
+	ctime := getattr(t, c, fh).Ctime
+	stale := ctime
+	stale.Seconds--
+	_, err = c.Setattr(fh, mode(0644), nfs.Sattrguard3{IsSet: true, Ctime: stale})
+	expect(t, "setattr with stale guard", err, nfs.NFSStatNotsync)
+	if attr := getattr(t, c, fh); attr.FileMode&07777 != 0600 {
+		t.Errorf("failed guard changed mode to %o", attr.FileMode&07777)
+	}
+	_, err = c.Setattr(fh, mode(0644), nfs.Sattrguard3{IsSet: true, Ctime: ctime})
+	expect(t, "setattr with guard", err, nfs.NFSStatOk)
+
+	d := mkdir(t, c, c.Root, "d")
+	_, err = c.Setattr(d, nfs.Sattr3{Size: nfs.Sattr3Size{IsSet: true}}, nfs.Sattrguard3{})
+	expect(t, "truncate directory", err, nfs.NFSStatIsdir)
+}
+
+func testRename(t *testing.T, c *client.Client) {
+	root := c.Root
+	fh := create(t, c, root, "a", "A")
+	fileid := getattr(t, c, fh).Fileid
+
+	_, err := c.Rename(root, "a", root, "b")
+	must(t, "rename", err)
+	_, err = c.Lookup(root, "a")
+	expect(t, "lookup of old name", err, nfs.NFSStatNoent)
+	res, err := c.Lookup(root, "b")
+	must(t, "lookup of new name", err)
+	if getattr(t, c, res.Object).Fileid != fileid {
+		t.Error("rename: new name is another file")
+	}
+	if attr := getattr(t, c, fh); attr.Fileid != fileid {
+		t.Error("rename: handle changed file")
+	}
+
+	d := mkdir(t, c, root, "d")
+	_, err = c.Rename(root, "b", d, "c")
+	must(t, "rename to other directory", err)
+	if got := read(t, c, fh); got != "A" {
+		t.Errorf("read after move: %q", got)
+	}
+
+	// replace an existing file
+	old := create(t, c, d, "x", "X")
+	_, err = c.Rename(d, "c", d, "x")
+	must(t, "rename over file", err)
+	res, err = c.Lookup(d, "x")
+	must(t, "lookup of replaced name", err)
+	if got := read(t, c, res.Object); got != "A" {
+		t.Errorf("replaced file reads %q", got)
+	}
+	_, err = c.Getattr(old)
+	expect(t, "getattr of replaced file", err, nfs.NFSStatStale)
+
+	_, err = c.Rename(d, "x", d, "x")
+	expect(t, "rename to itself", err, nfs.NFSStatOk)
+	if _, err := c.Lookup(d, "x"); err != nil {
+		t.Error("rename to itself lost the file:", err)
+	}
+	_, err = c.Rename(d, "missing", d, "y")
+	expect(t, "rename of missing file", err, nfs.NFSStatNoent)
+
+	sub := mkdir(t, c, root, "sub")
+	create(t, c, sub, "inside", "")
+	_, err = c.Rename(root, "sub", d, "sub")
+	must(t, "rename directory", err)
+	if _, err := c.Lookup(sub, "inside"); err != nil {
+		t.Error("lookup in moved directory:", err)
+	}
+}
+
+func testRemove(t *testing.T, c *client.Client) {
+	root := c.Root
+	fh := create(t, c, root, "f", "data")
+
+	res, err := c.Remove(root, "f")
+	must(t, "remove", err)
+	if !res.DirWcc.Post.IsSet {
+		t.Error("remove: no directory attributes")
+	}
+	_, err = c.Lookup(root, "f")
+	expect(t, "lookup of removed file", err, nfs.NFSStatNoent)
+	_, err = c.Getattr(fh)
+	expect(t, "getattr of removed file", err, nfs.NFSStatStale)
+	_, err = c.Remove(root, "f")
+	expect(t, "remove of missing file", err, nfs.NFSStatNoent)
+
+	d := mkdir(t, c, root, "d")
+	create(t, c, d, "f", "")
+	_, err = c.Remove(root, "d")
+	expect(t, "remove of directory", err, nfs.NFSStatIsdir)
+	_, err = c.Rmdir(root, "d")
+	expect(t, "rmdir of non-empty directory", err, nfs.NFSStatNotempty)
+	_, err = c.Rmdir(d, "f")
+	expect(t, "rmdir of file", err, nfs.NFSStatNotdir)
+	_, err = c.Rmdir(d, ".")
+	expect(t, "rmdir of .", err, nfs.NFSStatInval)
+
+	_, err = c.Remove(d, "f")
+	must(t, "remove", err)
+	_, err = c.Rmdir(root, "d")
+	must(t, "rmdir", err)
+	_, err = c.Getattr(d)
+	expect(t, "getattr of removed directory", err, nfs.NFSStatStale)
+	_, err = c.Rmdir(root, "d")
+	expect(t, "rmdir of missing directory", err, nfs.NFSStatNoent)
+}
+
+func testReaddir(t *testing.T, c *client.Client) {
+	d := mkdir(t, c, c.Root, "d")
+	const n = 200
+	fileids := make(map[string]uint64)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("entry-with-a-long-name-%03d", i)
+		fh := create(t, c, d, name, "")
+		fileids[name] = getattr(t, c, fh).Fileid
+	}
+
+	// small replies make clients come back with cookies
+	seen := make(map[string]bool)
+	var cookie, verf uint64
+	for calls := 0; ; calls++ {
+		if calls > n {
+			t.Fatal("readdir: no end in sight")
+		}
+		res, err := c.Readdirplus(d, cookie, verf, 512, 2048)
+		must(t, "readdirplus", err)
+		for e := res.Reply.Entry; e != nil; e = e.Next {
+			if seen[e.FileName] {
+				t.Errorf("readdir: %q returned twice", e.FileName)
+			}
+			seen[e.FileName] = true
+			if id, ok := fileids[e.FileName]; ok && id != e.FileID {
+				t.Errorf("readdir: %q has fileid %d, getattr says %d", e.FileName, e.FileID, id)
+			}
+			if e.Attr.IsSet && e.Attr.Attr.Fileid != e.FileID {
+				t.Errorf("readdir: %q attributes disagree on fileid", e.FileName)
+			}
+			if e.Cookie == 0 {
+				t.Errorf("readdir: %q has cookie 0", e.FileName)
+			}
+			cookie = e.Cookie
+		}
+		verf = res.CookieVerf
+		if res.Reply.EOF {
+			break
+		}
+		if res.Reply.Entry == nil {
+			t.Fatal("readdir: empty reply before end")
+		}
+	}
+	for name := range fileids {
+		if !seen[name] {
+			t.Errorf("readdir: %q missing", name)
+		}
+	}
+	for name := range seen {
+		if _, ok := fileids[name]; !ok && name != "." && name != ".." {
+			t.Errorf("readdir: unexpected %q", name)
+		}
+	}
+
+	empty := mkdir(t, c, c.Root, "empty")
+	res, err := c.Readdirplus(empty, 0, 0, 4096, 32768)
+	must(t, "readdirplus of empty directory", err)
+	for e := res.Reply.Entry; e != nil; e = e.Next {
+		if e.FileName != "." && e.FileName != ".." {
+			t.Errorf("empty directory lists %q", e.FileName)
+		}
+	}
+	if !res.Reply.EOF {
+		t.Error("readdir of empty directory: no eof")
+	}
+
+	f := create(t, c, c.Root, "f", "")
+	_, err = c.Readdirplus(f, 0, 0, 4096, 32768)
+	expect(t, "readdirplus of file", err, nfs.NFSStatNotdir)
+}
+
+func testLink(t *testing.T, c *client.Client) {
+	root := c.Root
+	fh := create(t, c, root, "f", "data")
+
+	res, err := c.Link(fh, root, "l")
+	must(t, "link", err)
+	if res.Attr.IsSet && res.Attr.Attr.Nlink != 2 {
+		t.Errorf("link: nlink %d", res.Attr.Attr.Nlink)
+	}
+	if attr := getattr(t, c, fh); attr.Nlink != 2 {
+		t.Errorf("getattr after link: nlink %d", attr.Nlink)
+	}
+	lres, err := c.Lookup(root, "l")
+	must(t, "lookup of link", err)
+	_, err = c.Write(lres.Object, 4, []byte("!"), nfs.FileSync)
+	must(t, "write through link", err)
+	if got := read(t, c, fh); got != "data!" {
+		t.Errorf("read of linked file: %q", got)
+	}
+
+	_, err = c.Remove(root, "f")
+	must(t, "remove of first name", err)
+	if attr := getattr(t, c, fh); attr.Nlink != 1 {
+		t.Errorf("getattr after remove: nlink %d", attr.Nlink)
+	}
+	if got := read(t, c, lres.Object); got != "data!" {
+		t.Errorf("read of link after remove: %q", got)
+	}
+
+	_, err = c.Link(fh, root, "l")
+	expect(t, "link over existing name", err, nfs.NFSStatExist)
+	d := mkdir(t, c, root, "d")
+	_, err = c.Link(d, root, "dl")
+	expect(t, "link to directory", err, nfs.NFSStatIsdir)
+	_, err = c.Link(fh, fh, "x")
+	expect(t, "link into file", err, nfs.NFSStatNotdir)
+}
+
+func testStatus(t *testing.T, c *client.Client) {
+	root := c.Root
+	fh := create(t, c, root, "f", "data")
+	d := mkdir(t, c, root, "d")
+
+	_, err := c.Lookup(root, "missing")
+	expect(t, "lookup of missing file", err, nfs.NFSStatNoent)
+	_, err = c.Lookup(fh, "x")
+	expect(t, "lookup in file", err, nfs.NFSStatNotdir)
+	_, err = c.Lookup(root, strings.Repeat("x", 256))
+	expect(t, "lookup of long name", err, nfs.NFSStatNametoolong)
+	_, err = c.Create(root, strings.Repeat("x", 256), guarded(mode(0644)))
+	expect(t, "create of long name", err, nfs.NFSStatNametoolong)
+	_, err = c.Create(root, "a/b", guarded(mode(0644)))
+	expect(t, "create of name with slash", err, nfs.NFSStatAcces)
+	_, err = c.Create(fh, "x", guarded(mode(0644)))
+	expect(t, "create in file", err, nfs.NFSStatNotdir)
+	_, err = c.Mkdir(fh, "x", mode(0755))
+	expect(t, "mkdir in file", err, nfs.NFSStatNotdir)
+
+	_, err = c.Read(d, 0, 10)
+	expect(t, "read of directory", err, nfs.NFSStatIsdir)
+	_, err = c.Write(d, 0, []byte("x"), nfs.FileSync)
+	expect(t, "write of directory", err, nfs.NFSStatIsdir)
+
+	_, err = c.Getattr([]byte{1, 2, 3})
+	expect(t, "getattr of bad handle", err, nfs.NFSStatBadhandle)
+
+	ares, err := c.Access(fh, nfs.AccessRead|nfs.AccessModify|nfs.AccessLookup)
+	must(t, "access", err)
+	if ares.Access&^(nfs.AccessRead|nfs.AccessModify|nfs.AccessLookup) != 0 {
+		t.Errorf("access granted more than asked: %#x", ares.Access)
+	}
+	if ares.Access&nfs.AccessRead == 0 {
+		t.Error("access: root may not read")
+	}
+}
@@ -0,0 +1,293 @@
+package nfstest
+
+import (
+	"bytes"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/client"
+	"github.com/dzeromsk/xdrrpc/nfs"
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+// method returns argument and result types of the procedure registered
+// for proc of prog vers, if one of rcvrs implements it.
+func method(prog, vers, proc uint32, rcvrs []interface{}) (name string, args, res reflect.Type, ok bool) {
+	name, ok = xdrrpc.Lookup(prog, vers, proc)
+	if !ok {
+		return "", nil, nil, false
+	}
+	service, m, _ := strings.Cut(name, ".")
+	for _, rcvr := range rcvrs {
+		typ := reflect.TypeOf(rcvr)
+		if typ.Elem().Name() != service {
+			continue
+		}
+		if m, ok := typ.MethodByName(m); ok {
+			return name, m.Type.In(1).Elem(), m.Type.In(2).Elem(), true
+		}
+	}
+	return "", nil, nil, false
+}
+
+// procedures calls fn with every procedure registered with xdrrpc.
+func procedures(fn func(prog, vers, proc uint32)) {
+	xdrrpc.DefaultMap.Range(func(k, _ interface{}) bool {
+		// keys are unexported, their fields are not
+		v := reflect.ValueOf(k)
+		fn(uint32(v.FieldByName("Program").Uint()),
+			uint32(v.FieldByName("Version").Uint()),
+			uint32(v.FieldByName("Procedure").Uint()))
+		return true
+	})
+}
+
+// FuzzArgs checks that arguments of procedures rcvrs implement decode
+// from any input without panicking, and encode back to what decodes to
+// the same value. Services are named after types of rcvrs, as net/rpc
+// does. Every procedure is seeded with its zero arguments.
+func FuzzArgs(f *testing.F, rcvrs ...interface{}) {
+	procedures(func(prog, vers, proc uint32) {
+		if _, args, _, ok := method(prog, vers, proc, rcvrs); ok {
+			var buf bytes.Buffer
+			if _, err := xdr.Marshal(&buf, reflect.New(args).Interface()); err != nil {
+				f.Fatalf("nfstest: encoding %v: %v", args, err)
+			}
+			f.Add(prog, vers, proc, buf.Bytes())
+		}
+	})
+	f.Fuzz(func(t *testing.T, prog, vers, proc uint32, data []byte) {
+		name, typ, _, ok := method(prog, vers, proc, rcvrs)
+		if !ok {
+			return
+		}
+		args := reflect.New(typ).Interface()
+		if err := xdrrpc.Unmarshal(bytes.NewReader(data), args); err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		if _, err := xdr.Marshal(&buf, args); err != nil {
+			t.Fatalf("%s: encoding decoded arguments: %v", name, err)
+		}
+		again := reflect.New(typ).Interface()
+		if err := xdrrpc.Unmarshal(bytes.NewReader(buf.Bytes()), again); err != nil {
+			t.Fatalf("%s: decoding encoded arguments: %v", name, err)
+		}
+		if !reflect.DeepEqual(args, again) {
+			t.Fatalf("%s: decoded %+v, encoded and decoded again %+v", name, args, again)
+		}
+	})
+}
+
+// maxCalls bounds calls made for a single input of FuzzDispatch.
+const maxCalls = 64
+
+// handleFields names fields of NFSv3 arguments and results holding
+// handles.
+var handleFields = map[string]bool{"Object": true, "Dir": true, "FSRoot": true, "FH": true}
+
+// handles calls fn with every handle v holds.
+func handles(v reflect.Value, fn func(fh *[]byte)) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			handles(v.Elem(), fn)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len() && v.Type().Elem().Kind() == reflect.Struct; i++ {
+			handles(v.Index(i), fn)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if handleFields[field.Name] && field.Type == reflect.TypeOf([]byte(nil)) {
+				fn(v.Field(i).Addr().Interface().(*[]byte))
+				continue
+			}
+			handles(v.Field(i), fn)
+		}
+	}
+}
+
+// FuzzDispatch runs sequences of NFSv3 calls as root against file systems
+// f makes, then checks that the tree they leave is consistent. Inputs
+// are procedure numbers, each followed by its XDR arguments. Handles of a
+// single byte n stand for the n-th distinct handle seen so far, counting
+// the root and handles of replies in order, so that calls refer to
+// objects that exist.
+func FuzzDispatch(f *testing.F, fac Factory) {
+	rcvrs := []interface{}{&nfs.NFS{}}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s := NewServer(fac)
+		defer s.Close()
+		c, err := s.Client(client.WithCred(nfs.Cred{}))
+		if err != nil {
+			t.Fatal("nfstest: mount:", err)
+		}
+		defer c.Close()
+
+		codec := xdrrpc.NewClientCodec(s.Pipe(), nfs.Nfs3Prog, nfs.Nfs3Vers)
+		codec.SetCred(xdrrpc.NewAuthSys(xdrrpc.AuthSysParms{MachineName: "nfstest"}))
+		rc := rpc.NewClientWithCodec(codec)
+		defer rc.Close()
+
+		pool := [][]byte{c.Root}
+		seen := func(fh *[]byte) {
+			for _, b := range pool {
+				if bytes.Equal(b, *fh) {
+					return
+				}
+			}
+			pool = append(pool, *fh)
+		}
+		r := bytes.NewReader(data)
+		for i := 0; i < maxCalls && r.Len() > 0; i++ {
+			proc, _ := r.ReadByte()
+			name, typ, rtyp, ok := method(nfs.Nfs3Prog, nfs.Nfs3Vers, uint32(proc), rcvrs)
+			if !ok {
+				continue
+			}
+			args := reflect.New(typ)
+			if err := xdrrpc.Unmarshal(r, args.Interface()); err != nil {
+				break
+			}
+			handles(args, func(fh *[]byte) {
+				if len(*fh) == 1 {
+					*fh = pool[int((*fh)[0])%len(pool)]
+				}
+			})
+			res := reflect.New(rtyp)
+			if err := rc.Call(name, args.Interface(), res.Interface()); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			handles(res, seen)
+		}
+		checkTree(t, c)
+	})
+}
+
+// entries returns entries of directory fh, but "." and "..".
+func entries(t *testing.T, c *client.Client, fh []byte) []nfs.Entryplus3 {
+	var (
+		list   []nfs.Entryplus3
+		cookie uint64
+		verf   uint64
+	)
+	for {
+		res, err := c.Readdirplus(fh, cookie, verf, 4096, 16384)
+		if err != nil {
+			t.Fatal("readdirplus:", err)
+		}
+		n := 0
+		for e := res.Reply.Entry; e != nil; e = e.Next {
+			n++
+			cookie = e.Cookie
+			if e.FileName != "." && e.FileName != ".." {
+				list = append(list, *e)
+			}
+		}
+		if res.Reply.EOF {
+			return list
+		}
+		if n == 0 {
+			t.Fatal("readdirplus: no progress")
+		}
+		verf = res.CookieVerf
+	}
+}
+
+// checkTree walks the tree c mounted and checks that every directory is
+// reachable once with ".." leading back to its parent, that entries look
+// up to the objects listed, and that link counts and sizes of files
+// match.
+func checkTree(t *testing.T, c *client.Client) {
+	type file struct {
+		path  string
+		fh    []byte
+		links uint32
+	}
+	var (
+		dirs  = map[uint64]string{}
+		files = map[uint64]*file{}
+	)
+	var walk func(path string, fh []byte, parent uint64)
+	walk = func(path string, fh []byte, parent uint64) {
+		attr, err := c.Getattr(fh)
+		if err != nil {
+			t.Fatalf("getattr %s: %v", path, err)
+		}
+		id := attr.Attr.Fileid
+		if p, ok := dirs[id]; ok {
+			t.Fatalf("%s: reached again as %s", p, path)
+		}
+		dirs[id] = path
+		up, err := c.Lookup(fh, "..")
+		if err != nil {
+			t.Fatalf("lookup %s/..: %v", path, err)
+		}
+		if got, err := c.Getattr(up.Object); err != nil || got.Attr.Fileid != parent {
+			t.Errorf("%s/..: not the parent directory", path)
+		}
+		for _, e := range entries(t, c, fh) {
+			name := path + "/" + e.FileName
+			res, err := c.Lookup(fh, e.FileName)
+			if err != nil {
+				t.Fatalf("lookup %s: %v", name, err)
+			}
+			attr, err := c.Getattr(res.Object)
+			if err != nil {
+				t.Fatalf("getattr %s: %v", name, err)
+			}
+			if attr.Attr.Fileid != e.FileID {
+				t.Errorf("%s: listed as %d, looked up as %d", name, e.FileID, attr.Attr.Fileid)
+			}
+			if attr.Attr.Type == nfs.NF3Dir {
+				walk(name, res.Object, id)
+				continue
+			}
+			if files[e.FileID] == nil {
+				files[e.FileID] = &file{path: name, fh: res.Object}
+			}
+			files[e.FileID].links++
+		}
+	}
+	root, err := c.Getattr(c.Root)
+	if err != nil {
+		t.Fatal("getattr root:", err)
+	}
+	// ".." of the root finds the root itself, as on Linux
+	walk("", c.Root, root.Attr.Fileid)
+
+	for _, f := range files {
+		attr, err := c.Getattr(f.fh)
+		if err != nil {
+			t.Fatalf("getattr %s: %v", f.path, err)
+		}
+		if attr.Attr.Nlink != f.links {
+			t.Errorf("%s: %d links, %d entries", f.path, attr.Attr.Nlink, f.links)
+		}
+		res, err := c.Read(f.fh, attr.Attr.Filesize, 1)
+		if err != nil {
+			t.Fatalf("read %s: %v", f.path, err)
+		}
+		if res.Count != 0 || !res.EOF {
+			t.Errorf("%s: data past size %d", f.path, attr.Attr.Filesize)
+		}
+		if attr.Attr.Filesize == 0 {
+			continue
+		}
+		res, err = c.Read(f.fh, attr.Attr.Filesize-1, 1)
+		if err != nil {
+			t.Fatalf("read %s: %v", f.path, err)
+		}
+		if res.Count != 1 || !res.EOF {
+			t.Errorf("%s: last byte missing before size %d", f.path, attr.Attr.Filesize)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package nfstest runs NFS backends in process, for tests of code using
+// them and of the backends themselves.
+package nfstest
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/client"
+	"github.com/dzeromsk/xdrrpc/mount"
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+// Factory returns the handler of the root directory of a new, empty file
+// system whose other objects are registered with mux.
+type Factory func(mux nfs.ServeMux) interface{}
+
+// Root is the object id of the exported root directory.
+var Root = []byte("nfstest root")
+
+// Server serves the file system a Factory made, exported as "/", with
+// MOUNT, NFSv3, NFSACL, NFSv4 and NFSv2 on every connection.
+type Server struct {
+	Mux   nfs.ServeMux
+	Mount *mount.Mount
+	RPC   *rpc.Server
+
+	mu    sync.Mutex
+	ln    net.Listener
+	conns map[net.Conn]bool
+}
+
+// NewServer returns a server for the file system f makes.
+func NewServer(f Factory, opts ...nfs.Option) *Server {
+	mux := nfs.NewServeMux(opts...)
+	mnt := mount.NewMount(mux)
+	mnt.Handle(mount.Export{
+		Path:    "/",
+		Root:    Root,
+		Handler: f(mux),
+	})
+
+	srv := rpc.NewServer()
+	srv.Register(mnt)
+	srv.Register(mux.Receiver())
+	srv.Register(mux.ACLReceiver())
+	srv.Register(mux.NFS4Receiver())
+	srv.Register(mux.NFS2Receiver())
+
+	return &Server{
+		Mux:   mux,
+		Mount: mnt,
+		RPC:   srv,
+		conns: make(map[net.Conn]bool),
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = true
+	s.mu.Unlock()
+
+	s.RPC.ServeCodec(xdrrpc.NewServerCodec(conn))
+
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+// Pipe returns the client end of a connection served over net.Pipe.
+func (s *Server) Pipe() net.Conn {
+	c, conn := net.Pipe()
+	go s.serve(conn)
+	return c
+}
+
+// Listen serves connections to a loopback TCP address, which it returns.
+// Later calls return the same address.
+func (s *Server) Listen() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln != nil {
+		return s.ln.Addr().String(), nil
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	s.ln = ln
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return ln.Addr().String(), nil
+}
+
+// Client mounts "/" over pipes.
+func (s *Server) Client(opts ...client.Option) (*client.Client, error) {
+	return client.MountConn(s.Pipe(), s.Pipe(), "/", opts...)
+}
+
+// Close stops listening and closes all connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
+
+// NewClient starts a server for f and returns a client that mounted it as
+// root, both closed when t ends.
+func NewClient(t testing.TB, f Factory, opts ...client.Option) *client.Client {
+	t.Helper()
+	s := NewServer(f)
+	t.Cleanup(func() { s.Close() })
+	c, err := s.Client(append([]client.Option{client.WithCred(nfs.Cred{})}, opts...)...)
+	if err != nil {
+		t.Fatal("nfstest: mount:", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
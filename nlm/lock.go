@@ -0,0 +1,238 @@
+package nlm
+
+import (
+	"math"
+	"sync"
+)
+
+// owner identifies the process holding a lock.
+type owner struct {
+	host string // caller name, as used by FREE_ALL and NSM
+	svid int32
+	oh   string
+}
+
+// lockRange is a lock on bytes [start, end) of a file.
+type lockRange struct {
+	owner     owner
+	exclusive bool
+	start     uint64
+	end       uint64 // math.MaxUint64 for locks to end of file
+}
+
+func newRange(l *Lock, exclusive bool) lockRange {
+	end := l.Offset + l.Len
+	if l.Len == 0 || end < l.Offset {
+		end = math.MaxUint64
+	}
+	return lockRange{
+		owner: owner{
+			host: l.CallerName,
+			svid: l.Svid,
+			oh:   string(l.OH),
+		},
+		exclusive: exclusive,
+		start:     l.Offset,
+		end:       end,
+	}
+}
+
+func (r *lockRange) overlaps(o *lockRange) bool {
+	return r.start < o.end && o.start < r.end
+}
+
+func (r *lockRange) conflicts(o *lockRange) bool {
+	return r.owner != o.owner && (r.exclusive || o.exclusive) && r.overlaps(o)
+}
+
+func (r *lockRange) holder() Holder {
+	h := Holder{
+		Exclusive: r.exclusive,
+		Svid:      r.owner.svid,
+		OH:        []byte(r.owner.oh),
+		Offset:    r.start,
+	}
+	if r.end != math.MaxUint64 {
+		h.Len = r.end - r.start
+	}
+	return h
+}
+
+// waiter is a blocked LOCK request.
+type waiter struct {
+	file string
+	lock lockRange
+	args LockArgs
+	addr string // where to send GRANTED
+}
+
+// table holds byte range locks of all files, keyed by file handle object
+// id, with POSIX semantics: locks of one owner never conflict with each
+// other, and locking or unlocking a range replaces whatever the owner held
+// there.
+type table struct {
+	mu      sync.Mutex
+	files   map[string][]lockRange
+	waiters []*waiter
+}
+
+func newTable() *table {
+	return &table{
+		files: map[string][]lockRange{},
+	}
+}
+
+// conflict must be called with t.mu held.
+func (t *table) conflict(file string, r *lockRange) (lockRange, bool) {
+	for _, l := range t.files[file] {
+		if l.conflicts(r) {
+			return l, true
+		}
+	}
+	return lockRange{}, false
+}
+
+// test returns a lock conflicting with r, if any.
+func (t *table) test(file string, r lockRange) (lockRange, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conflict(file, &r)
+}
+
+// lock acquires r unless it conflicts with a lock of another owner.
+func (t *table) lock(file string, r lockRange) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tryLock(file, r)
+}
+
+// tryLock must be called with t.mu held.
+func (t *table) tryLock(file string, r lockRange) bool {
+	if _, ok := t.conflict(file, &r); ok {
+		return false
+	}
+	t.release(file, r)
+	t.files[file] = append(t.files[file], r)
+	return true
+}
+
+// release must be called with t.mu held. It removes the part of locks of
+// r.owner overlapping r, splitting locks that extend past it on both sides.
+func (t *table) release(file string, r lockRange) {
+	var locks []lockRange
+	for _, l := range t.files[file] {
+		if l.owner != r.owner || !l.overlaps(&r) {
+			locks = append(locks, l)
+			continue
+		}
+		if l.start < r.start {
+			head := l
+			head.end = r.start
+			locks = append(locks, head)
+		}
+		if l.end > r.end {
+			tail := l
+			tail.start = r.end
+			locks = append(locks, tail)
+		}
+	}
+	if len(locks) == 0 {
+		delete(t.files, file)
+		return
+	}
+	t.files[file] = locks
+}
+
+// unlock releases r and returns waiters that got their locks as a result.
+func (t *table) unlock(file string, r lockRange) []*waiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.release(file, r)
+	return t.wake(file)
+}
+
+// lockOrBlock acquires w.lock or queues w, unless the same request is
+// queued already. It tells whether the lock was acquired.
+func (t *table) lockOrBlock(w *waiter) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tryLock(w.file, w.lock) {
+		return true
+	}
+	for _, x := range t.waiters {
+		if x.file == w.file && x.lock == w.lock {
+			return false
+		}
+	}
+	t.waiters = append(t.waiters, w)
+	return false
+}
+
+// cancel drops the queued request for r and tells whether there was one.
+func (t *table) cancel(file string, r lockRange) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, w := range t.waiters {
+		if w.file == file && w.lock == r {
+			t.waiters = append(t.waiters[:i], t.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// wake must be called with t.mu held. It grants queued requests for file
+// in order and returns them.
+func (t *table) wake(file string) []*waiter {
+	var granted []*waiter
+	waiters := t.waiters[:0]
+	for _, w := range t.waiters {
+		if w.file == file && t.tryLock(file, w.lock) {
+			granted = append(granted, w)
+			continue
+		}
+		waiters = append(waiters, w)
+	}
+	t.waiters = waiters
+	return granted
+}
+
+// freeHost drops all locks and queued requests of host and returns
+// waiters that got their locks as a result.
+func (t *table) freeHost(host string) []*waiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	waiters := t.waiters[:0]
+	for _, w := range t.waiters {
+		if w.lock.owner.host != host {
+			waiters = append(waiters, w)
+		}
+	}
+	t.waiters = waiters
+
+	var files []string
+	for file, locks := range t.files {
+		kept := locks[:0]
+		for _, l := range locks {
+			if l.owner.host != host {
+				kept = append(kept, l)
+			}
+		}
+		if len(kept) == len(locks) {
+			continue
+		}
+		files = append(files, file)
+		if len(kept) == 0 {
+			delete(t.files, file)
+		} else {
+			t.files[file] = kept
+		}
+	}
+
+	var granted []*waiter
+	for _, file := range files {
+		granted = append(granted, t.wake(file)...)
+	}
+	return granted
+}
@@ -0,0 +1,159 @@
+package nlm
+
+import (
+	"strconv"
+
+	"github.com/dzeromsk/xdrrpc"
+)
+
+const (
+	NlmProg = 100021
+	NlmVers = 4
+)
+
+func init() {
+	xdrrpc.Register(NlmProg, NlmVers, 0, "NLM", "Null")
+	xdrrpc.Register(NlmProg, NlmVers, 1, "NLM", "Test")
+	xdrrpc.Register(NlmProg, NlmVers, 2, "NLM", "Lock")
+	xdrrpc.Register(NlmProg, NlmVers, 3, "NLM", "Cancel")
+	xdrrpc.Register(NlmProg, NlmVers, 4, "NLM", "Unlock")
+	xdrrpc.Register(NlmProg, NlmVers, 5, "NLM", "Granted")
+	xdrrpc.Register(NlmProg, NlmVers, 6, "NLM", "TestMsg")
+	xdrrpc.Register(NlmProg, NlmVers, 7, "NLM", "LockMsg")
+	xdrrpc.Register(NlmProg, NlmVers, 8, "NLM", "CancelMsg")
+	xdrrpc.Register(NlmProg, NlmVers, 9, "NLM", "UnlockMsg")
+	xdrrpc.Register(NlmProg, NlmVers, 10, "NLM", "GrantedMsg")
+	xdrrpc.Register(NlmProg, NlmVers, 11, "NLM", "TestRes")
+	xdrrpc.Register(NlmProg, NlmVers, 12, "NLM", "LockRes")
+	xdrrpc.Register(NlmProg, NlmVers, 13, "NLM", "CancelRes")
+	xdrrpc.Register(NlmProg, NlmVers, 14, "NLM", "UnlockRes")
+	xdrrpc.Register(NlmProg, NlmVers, 15, "NLM", "GrantedRes")
+	xdrrpc.Register(NlmProg, NlmVers, 16, "NLM", "SmNotify")
+	// xdrrpc.Register(NlmProg, NlmVers, 20, "NLM", "Share")
+	// xdrrpc.Register(NlmProg, NlmVers, 21, "NLM", "Unshare")
+	xdrrpc.Register(NlmProg, NlmVers, 22, "NLM", "NmLock")
+	xdrrpc.Register(NlmProg, NlmVers, 23, "NLM", "FreeAll")
+}
+
+type NullArgs struct{}
+
+type NullRes struct{}
+
+type NLMStat int32
+
+const (
+	NLMGranted           NLMStat = 0 // lock granted
+	NLMDenied            NLMStat = 1 // conflicting lock held
+	NLMDeniedNolocks     NLMStat = 2 // out of resources
+	NLMBlocked           NLMStat = 3 // GRANTED will be sent when available
+	NLMDeniedGracePeriod NLMStat = 4 // only reclaims accepted for now
+	NLMDeadlck           NLMStat = 5 // deadlock detected
+	NLMRofs              NLMStat = 6 // read-only file system
+	NLMStaleFH           NLMStat = 7 // stale file handle
+	NLMFbig              NLMStat = 8 // offset or length too big
+	NLMFailed            NLMStat = 9 // anything else
+)
+
+var statNames = map[NLMStat]string{
+	NLMGranted:           "NLM4_GRANTED",
+	NLMDenied:            "NLM4_DENIED",
+	NLMDeniedNolocks:     "NLM4_DENIED_NOLOCKS",
+	NLMBlocked:           "NLM4_BLOCKED",
+	NLMDeniedGracePeriod: "NLM4_DENIED_GRACE_PERIOD",
+	NLMDeadlck:           "NLM4_DEADLCK",
+	NLMRofs:              "NLM4_ROFS",
+	NLMStaleFH:           "NLM4_STALE_FH",
+	NLMFbig:              "NLM4_FBIG",
+	NLMFailed:            "NLM4_FAILED",
+}
+
+func (s NLMStat) String() string {
+	if name, ok := statNames[s]; ok {
+		return name
+	}
+	return "NLMStat(" + strconv.Itoa(int(s)) + ")"
+}
+
+func (s NLMStat) Error() string {
+	return "nlm: " + s.String()
+}
+
+// Lock describes a byte range lock. Len zero locks to the end of file.
+type Lock struct {
+	CallerName string
+	FH         []byte
+	OH         []byte // owner handle
+	Svid       int32  // process id of the owner
+	Offset     uint64
+	Len        uint64
+}
+
+// Holder describes a conflicting lock reported by TEST.
+type Holder struct {
+	Exclusive bool
+	Svid      int32
+	OH        []byte
+	Offset    uint64
+	Len       uint64
+}
+
+type TestArgs struct {
+	xdrrpc.Header
+	Cookie    []byte
+	Exclusive bool
+	Alock     Lock
+}
+
+type TestRply struct {
+	Stat   NLMStat `xdr:"union"`
+	Holder Holder  `xdr:"unioncase=1"`
+}
+
+type TestRes struct {
+	Cookie []byte
+	Stat   TestRply
+}
+
+type LockArgs struct {
+	xdrrpc.Header
+	Cookie    []byte
+	Block     bool
+	Exclusive bool
+	Alock     Lock
+	Reclaim   bool
+	State     int32 // NSM state of the client
+}
+
+type CancArgs struct {
+	xdrrpc.Header
+	Cookie    []byte
+	Block     bool
+	Exclusive bool
+	Alock     Lock
+}
+
+type UnlockArgs struct {
+	xdrrpc.Header
+	Cookie []byte
+	Alock  Lock
+}
+
+type Res struct {
+	Cookie []byte
+	Stat   NLMStat
+}
+
+// SmStatus is sent by the local status monitor when a monitored host
+// restarted, see package nsm.
+type SmStatus struct {
+	xdrrpc.Header
+	MonName string
+	State   int32
+	Priv    [16]byte
+}
+
+type Notify struct {
+	xdrrpc.Header
+	Name  string
+	State int32
+}
@@ -0,0 +1,327 @@
+package nlm
+
+import (
+	"log"
+	"net"
+	"net/rpc"
+	"time"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+// NLM answers lock requests on files served by a ServeMux. Locks are
+// advisory and only kept in memory; clients reclaim them during the grace
+// period after a restart.
+type NLM struct {
+	mux   nfs.ServeMux
+	grace time.Time // end of grace period
+	locks *table
+
+	// Dial connects to the lock manager of a client, to send GRANTED and
+	// results of _MSG procedures. By default it asks the portmapper on
+	// host for the port.
+	Dial func(host string) (*rpc.Client, error)
+
+	// Monitor, if set, asks the status monitor to watch host, whose locks
+	// must be freed with FreeHost or SM_NOTIFY once it restarts.
+	Monitor func(host string) error
+}
+
+// NewNLM returns an NLM for files served by mux, which only accepts lock
+// reclaims for grace after start.
+func NewNLM(mux nfs.ServeMux, grace time.Duration) *NLM {
+	return &NLM{
+		mux:   mux,
+		grace: time.Now().Add(grace),
+		locks: newTable(),
+		Dial: func(host string) (*rpc.Client, error) {
+			return xdrrpc.DialProgram(host, NlmProg, NlmVers)
+		},
+	}
+}
+
+func (n *NLM) inGrace() bool {
+	return time.Now().Before(n.grace)
+}
+
+// file returns the lock table key of the file handle fh.
+func (n *NLM) file(call *xdrrpc.CallInfo, fh []byte) (string, NLMStat) {
+	h, err := n.mux.DecodeHandle(fh)
+	if err != nil {
+		return "", NLMStaleFH
+	}
+	if _, stat := n.mux.Authorize(call, h.Export, false); stat != nfs.NFSStatOk {
+		if stat == nfs.NFSStatStale {
+			return "", NLMStaleFH
+		}
+		return "", NLMDenied
+	}
+	return string(h.Object), NLMGranted
+}
+
+// addr returns the host to call back the client making call at.
+func addr(call *xdrrpc.CallInfo, callerName string) string {
+	if call != nil && call.RemoteAddr != nil {
+		if h, _, err := net.SplitHostPort(call.RemoteAddr.String()); err == nil {
+			return h
+		}
+	}
+	return callerName
+}
+
+// callback calls method of the lock manager at host with args.
+func (n *NLM) callback(host, method string, args interface{}) error {
+	c, err := n.Dial(host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Call(method, args, &NullRes{})
+}
+
+// grant tells clients of waiters they hold the lock now. Locks of clients
+// that cannot be reached or do not want them any more are released.
+func (n *NLM) grant(waiters []*waiter) {
+	for _, w := range waiters {
+		go func(w *waiter) {
+			args := TestArgs{
+				Cookie:    w.args.Cookie,
+				Exclusive: w.args.Exclusive,
+				Alock:     w.args.Alock,
+			}
+			c, err := n.Dial(w.addr)
+			if err == nil {
+				var res Res
+				err = c.Call("NLM.Granted", &args, &res)
+				c.Close()
+				if err == nil && res.Stat != NLMGranted {
+					err = res.Stat
+				}
+			}
+			if err != nil {
+				log.Printf("nlm: granting lock to %s: %v", w.addr, err)
+				n.grant(n.locks.unlock(w.file, w.lock))
+			}
+		}(w)
+	}
+}
+
+// FreeHost drops all locks held by host, as FREE_ALL does. It is meant
+// for status monitors noticing host has rebooted.
+func (n *NLM) FreeHost(host string) {
+	n.grant(n.locks.freeHost(host))
+}
+
+func (n *NLM) Null(args *NullArgs, res *NullRes) error {
+	return nil
+}
+
+func (n *NLM) Test(args *TestArgs, res *TestRes) error {
+	res.Cookie = args.Cookie
+	if n.inGrace() {
+		res.Stat.Stat = NLMDeniedGracePeriod
+		return nil
+	}
+	file, stat := n.file(args.Call(), args.Alock.FH)
+	if stat != NLMGranted {
+		res.Stat.Stat = stat
+		return nil
+	}
+	if l, ok := n.locks.test(file, newRange(&args.Alock, args.Exclusive)); ok {
+		res.Stat.Stat = NLMDenied
+		res.Stat.Holder = l.holder()
+		return nil
+	}
+	res.Stat.Stat = NLMGranted
+	return nil
+}
+
+// Lock acquires a lock, or queues blocking requests and tells the client
+// with GRANTED once the lock is available.
+func (n *NLM) Lock(args *LockArgs, res *Res) error {
+	if n.Monitor != nil {
+		if err := n.Monitor(args.Alock.CallerName); err != nil {
+			// we could never free the lock after the client restarts
+			log.Printf("nlm: monitoring %s: %v", args.Alock.CallerName, err)
+			res.Cookie = args.Cookie
+			res.Stat = NLMDeniedNolocks
+			return nil
+		}
+	}
+	return n.NmLock(args, res)
+}
+
+// NmLock is Lock for clients not monitored by a status monitor.
+func (n *NLM) NmLock(args *LockArgs, res *Res) error {
+	res.Cookie = args.Cookie
+	// reclaims are only accepted during grace period, and nothing else
+	if n.inGrace() != args.Reclaim {
+		res.Stat = NLMDeniedGracePeriod
+		return nil
+	}
+	file, stat := n.file(args.Call(), args.Alock.FH)
+	if stat != NLMGranted {
+		res.Stat = stat
+		return nil
+	}
+	r := newRange(&args.Alock, args.Exclusive)
+	if !args.Block {
+		res.Stat = NLMDenied
+		if n.locks.lock(file, r) {
+			res.Stat = NLMGranted
+		}
+		return nil
+	}
+	res.Stat = NLMBlocked
+	if n.locks.lockOrBlock(&waiter{
+		file: file,
+		lock: r,
+		args: *args,
+		addr: addr(args.Call(), args.Alock.CallerName),
+	}) {
+		res.Stat = NLMGranted
+	}
+	return nil
+}
+
+func (n *NLM) Cancel(args *CancArgs, res *Res) error {
+	res.Cookie = args.Cookie
+	if n.inGrace() {
+		res.Stat = NLMDeniedGracePeriod
+		return nil
+	}
+	file, stat := n.file(args.Call(), args.Alock.FH)
+	if stat != NLMGranted {
+		res.Stat = stat
+		return nil
+	}
+	if !n.locks.cancel(file, newRange(&args.Alock, args.Exclusive)) {
+		res.Stat = NLMDenied
+		return nil
+	}
+	res.Stat = NLMGranted
+	return nil
+}
+
+func (n *NLM) Unlock(args *UnlockArgs, res *Res) error {
+	res.Cookie = args.Cookie
+	if n.inGrace() {
+		res.Stat = NLMDeniedGracePeriod
+		return nil
+	}
+	file, stat := n.file(args.Call(), args.Alock.FH)
+	if stat != NLMGranted {
+		res.Stat = stat
+		return nil
+	}
+	n.grant(n.locks.unlock(file, newRange(&args.Alock, false)))
+	res.Stat = NLMGranted
+	return nil
+}
+
+// Granted is sent to clients; as we never wait for locks ourselves there
+// is nothing to grant.
+func (n *NLM) Granted(args *TestArgs, res *Res) error {
+	res.Cookie = args.Cookie
+	res.Stat = NLMDenied
+	return nil
+}
+
+// The _MSG procedures do the same as their synchronous counterparts but
+// send results back in a separate _RES call to the client.
+
+func (n *NLM) TestMsg(args *TestArgs, res *NullRes) error {
+	var r TestRes
+	n.Test(args, &r)
+	n.reply(args.Call(), args.Alock.CallerName, "NLM.TestRes", &r)
+	return nil
+}
+
+func (n *NLM) LockMsg(args *LockArgs, res *NullRes) error {
+	var r Res
+	n.Lock(args, &r)
+	n.reply(args.Call(), args.Alock.CallerName, "NLM.LockRes", &r)
+	return nil
+}
+
+func (n *NLM) CancelMsg(args *CancArgs, res *NullRes) error {
+	var r Res
+	n.Cancel(args, &r)
+	n.reply(args.Call(), args.Alock.CallerName, "NLM.CancelRes", &r)
+	return nil
+}
+
+func (n *NLM) UnlockMsg(args *UnlockArgs, res *NullRes) error {
+	var r Res
+	n.Unlock(args, &r)
+	n.reply(args.Call(), args.Alock.CallerName, "NLM.UnlockRes", &r)
+	return nil
+}
+
+func (n *NLM) GrantedMsg(args *TestArgs, res *NullRes) error {
+	var r Res
+	n.Granted(args, &r)
+	n.reply(args.Call(), args.Alock.CallerName, "NLM.GrantedRes", &r)
+	return nil
+}
+
+func (n *NLM) reply(call *xdrrpc.CallInfo, callerName, method string, res interface{}) {
+	host := addr(call, callerName)
+	go func() {
+		if err := n.callback(host, method, res); err != nil {
+			log.Printf("nlm: sending %s to %s: %v", method, host, err)
+		}
+	}()
+}
+
+// The _RES procedures carry results of _MSG calls we would have made as a
+// client. GRANTED is sent synchronously, so there is nothing to match
+// them with.
+
+func (n *NLM) TestRes(args *TestRes, res *NullRes) error {
+	return nil
+}
+
+func (n *NLM) LockRes(args *Res, res *NullRes) error {
+	return nil
+}
+
+func (n *NLM) CancelRes(args *Res, res *NullRes) error {
+	return nil
+}
+
+func (n *NLM) UnlockRes(args *Res, res *NullRes) error {
+	return nil
+}
+
+func (n *NLM) GrantedRes(args *Res, res *NullRes) error {
+	return nil
+}
+
+// trusted tells whether call comes from the local status monitor, which
+// alone may free locks of other hosts. Like Linux, it must call from a
+// privileged port on this host.
+func trusted(call *xdrrpc.CallInfo) bool {
+	return call.Local() && call.Privileged()
+}
+
+// SmNotify is called by the status monitor when a client restarted.
+func (n *NLM) SmNotify(args *SmStatus, res *NullRes) error {
+	if !trusted(args.Call()) {
+		log.Printf("nlm: ignoring SM_NOTIFY for %s from untrusted caller", args.MonName)
+		return nil
+	}
+	n.FreeHost(args.MonName)
+	return nil
+}
+
+// FreeAll drops locks of a client that rebooted.
+func (n *NLM) FreeAll(args *Notify, res *NullRes) error {
+	if !trusted(args.Call()) {
+		log.Printf("nlm: ignoring FREE_ALL for %s from untrusted caller", args.Name)
+		return nil
+	}
+	n.FreeHost(args.Name)
+	return nil
+}
@@ -0,0 +1,103 @@
+package nlm_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/nfs"
+	"github.com/dzeromsk/xdrrpc/nlm"
+)
+
+// newNLM returns a lock manager past its grace period and the handle of a
+// file it serves.
+func newNLM(t *testing.T) (*nlm.NLM, []byte) {
+	t.Helper()
+	mux := nfs.NewServeMux()
+	mux.Handle([]byte("file"), struct{}{})
+	fh, err := mux.EncodeHandle(nfs.FileHandle{Export: 1, Object: []byte("file")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nlm.NewNLM(mux, 0), fh
+}
+
+func lock(n *nlm.NLM, fh []byte, host string, exclusive bool, offset, length uint64) nlm.NLMStat {
+	var res nlm.Res
+	n.Lock(&nlm.LockArgs{
+		Exclusive: exclusive,
+		Alock: nlm.Lock{
+			CallerName: host,
+			FH:         fh,
+			OH:         []byte(host),
+			Svid:       1,
+			Offset:     offset,
+			Len:        length,
+		},
+	}, &res)
+	return res.Stat
+}
+
+func TestLock(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		exclusive bool
+		offset    uint64
+		length    uint64
+		want      nlm.NLMStat
+	}{
+		{"overlapping exclusive", true, 150, 10, nlm.NLMDenied},
+		{"overlapping shared", false, 0, 0, nlm.NLMDenied},
+		{"before", true, 0, 100, nlm.NLMGranted},
+		{"after", true, 200, 0, nlm.NLMGranted},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			n, fh := newNLM(t)
+			if stat := lock(n, fh, "alice", true, 100, 100); stat != nlm.NLMGranted {
+				t.Fatalf("first lock: %v", stat)
+			}
+			if stat := lock(n, fh, "bob", tt.exclusive, tt.offset, tt.length); stat != tt.want {
+				t.Errorf("got %v, want %v", stat, tt.want)
+			}
+		})
+	}
+}
+
+func TestFreeHostCallers(t *testing.T) {
+	tcp := func(ip string, port int) *xdrrpc.CallInfo {
+		return &xdrrpc.CallInfo{RemoteAddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: port}}
+	}
+	for _, tt := range []struct {
+		name  string
+		call  *xdrrpc.CallInfo
+		freed bool
+	}{
+		{"remote host", tcp("192.0.2.1", 700), false},
+		{"unprivileged local port", tcp("127.0.0.1", 40000), false},
+		{"no caller", nil, false},
+		{"local status monitor", tcp("127.0.0.1", 700), true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, proc := range []string{"SM_NOTIFY", "FREE_ALL"} {
+				n, fh := newNLM(t)
+				if stat := lock(n, fh, "alice", true, 0, 0); stat != nlm.NLMGranted {
+					t.Fatalf("lock: %v", stat)
+				}
+				switch proc {
+				case "SM_NOTIFY":
+					args := &nlm.SmStatus{MonName: "alice"}
+					args.SetCall(tt.call)
+					n.SmNotify(args, &nlm.NullRes{})
+				case "FREE_ALL":
+					args := &nlm.Notify{Name: "alice"}
+					args.SetCall(tt.call)
+					n.FreeAll(args, &nlm.NullRes{})
+				}
+				freed := lock(n, fh, "bob", true, 0, 0) == nlm.NLMGranted
+				if freed != tt.freed {
+					t.Errorf("%s: locks freed %v, want %v", proc, freed, tt.freed)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package nsm
+
+import (
+	"github.com/dzeromsk/xdrrpc"
+)
+
+const (
+	SmProg = 100024
+	SmVers = 1
+)
+
+func init() {
+	xdrrpc.Register(SmProg, SmVers, 0, "NSM", "Null")
+	xdrrpc.Register(SmProg, SmVers, 1, "NSM", "Stat")
+	xdrrpc.Register(SmProg, SmVers, 2, "NSM", "Mon")
+	xdrrpc.Register(SmProg, SmVers, 3, "NSM", "Unmon")
+	xdrrpc.Register(SmProg, SmVers, 4, "NSM", "UnmonAll")
+	// xdrrpc.Register(SmProg, SmVers, 5, "NSM", "SimuCrash")
+	xdrrpc.Register(SmProg, SmVers, 6, "NSM", "Notify")
+}
+
+type NullArgs struct{}
+
+type NullRes struct{}
+
+type Res int32
+
+const (
+	StatSucc Res = 0
+	StatFail Res = 1
+)
+
+type SmName struct {
+	MonName string
+}
+
+type SmStatRes struct {
+	ResStat Res
+	State   int32
+}
+
+type SmStat struct {
+	State int32
+}
+
+// MyID names the procedure called back when a monitored host reboots.
+type MyID struct {
+	MyName string
+	MyProg int32
+	MyVers int32
+	MyProc int32
+}
+
+type MonID struct {
+	MonName string // host to monitor
+	MyID    MyID
+}
+
+type Mon struct {
+	xdrrpc.Header
+	MonID MonID
+	Priv  [16]byte // passed back to MyID with the notification
+}
+
+// UnmonArgs are the arguments of SM_UNMON.
+type UnmonArgs struct {
+	xdrrpc.Header
+	MonID
+}
+
+// UnmonAllArgs are the arguments of SM_UNMON_ALL.
+type UnmonAllArgs struct {
+	xdrrpc.Header
+	MyID
+}
+
+// StatChge tells a host changed its state, by rebooting usually.
+type StatChge struct {
+	xdrrpc.Header
+	MonName string
+	State   int32
+}
+
+// Status is the argument of callbacks to MyID.
+type Status struct {
+	MonName string
+	State   int32
+	Priv    [16]byte
+}
@@ -0,0 +1,346 @@
+package nsm
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dzeromsk/xdrrpc"
+)
+
+var errUnknownCallback = errors.New("nsm: callback procedure not registered")
+
+// NSM is a status monitor. It tells monitored hosts when we restart, so
+// they reclaim their locks, and local programs when monitored hosts
+// restart, so their locks are freed.
+type NSM struct {
+	path string
+
+	mu       sync.Mutex
+	state    int32 // odd while we are up
+	monitors []Mon
+	pending  map[string]bool // hosts monitored before restart, not notified yet
+
+	// Dial connects to the status monitor of host, to send SM_NOTIFY. By
+	// default it asks the portmapper on host for the port.
+	Dial func(host string) (*rpc.Client, error)
+
+	// Callback tells the local program id that a monitored host changed
+	// state. By default it calls id.MyProc on id.MyName, which must be
+	// registered with xdrrpc.Register.
+	Callback func(id MyID, status Status) error
+}
+
+// NewNSM returns a status monitor keeping its state and monitored hosts in
+// the file at path, or only in memory if path is empty. It counts as a
+// restart, so hosts monitored before are notified by NotifyAll.
+func NewNSM(path string) (*NSM, error) {
+	s := &NSM{
+		path:     path,
+		pending:  map[string]bool{},
+		Dial:     dial,
+		Callback: callback,
+	}
+	if path != "" {
+		if err := s.load(); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	s.state++
+	if s.state%2 == 0 {
+		s.state++
+	}
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func dial(host string) (*rpc.Client, error) {
+	return xdrrpc.DialProgram(host, SmProg, SmVers)
+}
+
+func callback(id MyID, status Status) error {
+	method, ok := xdrrpc.Lookup(uint32(id.MyProg), uint32(id.MyVers), uint32(id.MyProc))
+	if !ok {
+		return errUnknownCallback
+	}
+	c, err := xdrrpc.DialProgram(id.MyName, uint32(id.MyProg), uint32(id.MyVers))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Call(method, &status, &NullRes{})
+}
+
+// load reads the file written by save: our state on the first line
+// followed by monitored hosts, one per line.
+func (s *NSM) load() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	if sc.Scan() {
+		state, err := strconv.ParseInt(strings.TrimSpace(sc.Text()), 10, 32)
+		if err != nil {
+			return err
+		}
+		s.state = int32(state)
+	}
+	for sc.Scan() {
+		if host := strings.TrimSpace(sc.Text()); host != "" {
+			s.pending[host] = true
+		}
+	}
+	return sc.Err()
+}
+
+// save must be called with s.mu held, unless s is not shared yet.
+func (s *NSM) save() error {
+	if s.path == "" {
+		return nil
+	}
+	hosts := map[string]bool{}
+	for host := range s.pending {
+		hosts[host] = true
+	}
+	for _, m := range s.monitors {
+		hosts[m.MonID.MonName] = true
+	}
+	var lines []string
+	for host := range hosts {
+		lines = append(lines, host)
+	}
+	sort.Strings(lines)
+
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(int(s.state)) + "\n")
+	for _, host := range lines {
+		b.WriteString(host + "\n")
+	}
+
+	// write and rename so a crash never leaves a partial file behind
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// State returns our current state number.
+func (s *NSM) State() int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Monitor starts monitoring m.MonID.MonName on behalf of m.MonID.MyID, as
+// SM_MON does.
+func (s *NSM) Monitor(m Mon) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, x := range s.monitors {
+		if x.MonID == m.MonID {
+			s.monitors[i] = m
+			return nil
+		}
+	}
+	s.monitors = append(s.monitors, m)
+	return s.save()
+}
+
+// unmonitor drops monitors drop returns true for and saves the host list.
+func (s *NSM) unmonitor(drop func(m *Mon) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	monitors := s.monitors[:0]
+	for _, m := range s.monitors {
+		if !drop(&m) {
+			monitors = append(monitors, m)
+		}
+	}
+	if len(monitors) == len(s.monitors) {
+		return nil
+	}
+	s.monitors = monitors
+	return s.save()
+}
+
+// NotifyAll sends SM_NOTIFY with our new state to hosts monitored before
+// restart, so they reclaim locks they held. myName is how those hosts
+// know us. Hosts that cannot be reached are tried again on next restart.
+func (s *NSM) NotifyAll(myName string) {
+	s.mu.Lock()
+	var hosts []string
+	for host := range s.pending {
+		hosts = append(hosts, host)
+	}
+	args := StatChge{MonName: myName, State: s.state}
+	s.mu.Unlock()
+
+	for _, host := range hosts {
+		err := s.notify(host, &args)
+		if err != nil {
+			log.Printf("nsm: notifying %s: %v", host, err)
+			continue
+		}
+		s.mu.Lock()
+		delete(s.pending, host)
+		if err := s.save(); err != nil {
+			log.Printf("nsm: %v", err)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *NSM) notify(host string, args *StatChge) error {
+	c, err := s.Dial(host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Call("NSM.Notify", args, &NullRes{})
+}
+
+func (s *NSM) Null(args *NullArgs, res *NullRes) error {
+	return nil
+}
+
+// Stat tells our state. We are willing to monitor any host.
+func (s *NSM) Stat(args *SmName, res *SmStatRes) error {
+	res.ResStat = StatSucc
+	res.State = s.State()
+	return nil
+}
+
+// Mon, Unmon and UnmonAll are only served to local programs, as callbacks
+// go to programs they name.
+
+func (s *NSM) Mon(args *Mon, res *SmStatRes) error {
+	res.State = s.State()
+	if !args.Call().Local() || args.MonID.MonName == "" {
+		res.ResStat = StatFail
+		return nil
+	}
+	if err := s.Monitor(Mon{MonID: args.MonID, Priv: args.Priv}); err != nil {
+		log.Printf("nsm: %v", err)
+		res.ResStat = StatFail
+		return nil
+	}
+	res.ResStat = StatSucc
+	return nil
+}
+
+func (s *NSM) Unmon(args *UnmonArgs, res *SmStat) error {
+	res.State = s.State()
+	if !args.Call().Local() {
+		return nil
+	}
+	if err := s.unmonitor(func(m *Mon) bool {
+		return m.MonID == args.MonID
+	}); err != nil {
+		log.Printf("nsm: %v", err)
+	}
+	return nil
+}
+
+func (s *NSM) UnmonAll(args *UnmonAllArgs, res *SmStat) error {
+	res.State = s.State()
+	if !args.Call().Local() {
+		return nil
+	}
+	if err := s.unmonitor(func(m *Mon) bool {
+		return m.MonID.MyID == args.MyID
+	}); err != nil {
+		log.Printf("nsm: %v", err)
+	}
+	return nil
+}
+
+// from tells whether call was made by host, which must resolve to the
+// address the call came from. Calls over pipes and unix sockets are
+// trusted.
+func from(call *xdrrpc.CallInfo, host string) bool {
+	if call == nil {
+		return false
+	}
+	var ip net.IP
+	switch a := call.RemoteAddr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	default:
+		return call.Local()
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ip.Equal(net.ParseIP(addr)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify is sent by a monitored host after it restarted. Programs
+// monitoring it are called back in the background.
+func (s *NSM) Notify(args *StatChge, res *NullRes) error {
+	s.mu.Lock()
+	var monitors []Mon
+	for _, m := range s.monitors {
+		if m.MonID.MonName == args.MonName {
+			monitors = append(monitors, m)
+		}
+	}
+	s.mu.Unlock()
+
+	// only the host itself may tell it restarted, others could make us
+	// drop its locks
+	if len(monitors) == 0 || !from(args.Call(), args.MonName) {
+		return nil
+	}
+
+	state := args.State
+	for _, m := range monitors {
+		go func(m Mon) {
+			status := Status{
+				MonName: m.MonID.MonName,
+				State:   state,
+				Priv:    m.Priv,
+			}
+			if err := s.Callback(m.MonID.MyID, status); err != nil {
+				log.Printf("nsm: calling back %s: %v", m.MonID.MyID.MyName, err)
+			}
+		}(m)
+	}
+	return nil
+}
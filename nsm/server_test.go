@@ -0,0 +1,147 @@
+package nsm_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/nsm"
+)
+
+func tcp(ip string, port int) *xdrrpc.CallInfo {
+	return &xdrrpc.CallInfo{RemoteAddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: port}}
+}
+
+var (
+	remote = tcp("192.0.2.1", 700)
+	local  = tcp("127.0.0.1", 40000)
+	lockd  = nsm.MyID{MyName: "localhost", MyProg: 100021, MyVers: 4, MyProc: 16}
+)
+
+// newNSM returns a status monitor sending callbacks to the returned
+// channel.
+func newNSM(t *testing.T) (*nsm.NSM, chan nsm.Status) {
+	t.Helper()
+	s, err := nsm.NewNSM("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := make(chan nsm.Status, 10)
+	s.Callback = func(id nsm.MyID, status nsm.Status) error {
+		called <- status
+		return nil
+	}
+	return s, called
+}
+
+func mon(s *nsm.NSM, call *xdrrpc.CallInfo, host string) nsm.Res {
+	args := &nsm.Mon{MonID: nsm.MonID{MonName: host, MyID: lockd}}
+	args.SetCall(call)
+	var res nsm.SmStatRes
+	s.Mon(args, &res)
+	return res.ResStat
+}
+
+func notify(s *nsm.NSM, call *xdrrpc.CallInfo, host string) {
+	args := &nsm.StatChge{MonName: host, State: 3}
+	args.SetCall(call)
+	s.Notify(args, &nsm.NullRes{})
+}
+
+// calledBack tells whether a callback arrives soon.
+func calledBack(called chan nsm.Status) bool {
+	select {
+	case <-called:
+		return true
+	case <-time.After(100 * time.Millisecond):
+		return false
+	}
+}
+
+func TestMonCallers(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		call *xdrrpc.CallInfo
+		want nsm.Res
+	}{
+		{"remote host", remote, nsm.StatFail},
+		{"no caller", nil, nsm.StatFail},
+		{"local program", local, nsm.StatSucc},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, called := newNSM(t)
+			if stat := mon(s, tt.call, "127.0.0.1"); stat != tt.want {
+				t.Fatalf("SM_MON: got %v, want %v", stat, tt.want)
+			}
+			notify(s, tcp("127.0.0.1", 700), "127.0.0.1")
+			if got := calledBack(called); got != (tt.want == nsm.StatSucc) {
+				t.Errorf("called back %v after SM_MON with status %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmonCallers(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		call    *xdrrpc.CallInfo
+		dropped bool
+	}{
+		{"remote host", remote, false},
+		{"local program", local, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, proc := range []string{"SM_UNMON", "SM_UNMON_ALL"} {
+				s, called := newNSM(t)
+				if stat := mon(s, local, "127.0.0.1"); stat != nsm.StatSucc {
+					t.Fatalf("SM_MON: %v", stat)
+				}
+				switch proc {
+				case "SM_UNMON":
+					args := &nsm.UnmonArgs{MonID: nsm.MonID{MonName: "127.0.0.1", MyID: lockd}}
+					args.SetCall(tt.call)
+					s.Unmon(args, &nsm.SmStat{})
+				case "SM_UNMON_ALL":
+					args := &nsm.UnmonAllArgs{MyID: lockd}
+					args.SetCall(tt.call)
+					s.UnmonAll(args, &nsm.SmStat{})
+				}
+				notify(s, tcp("127.0.0.1", 700), "127.0.0.1")
+				if got := calledBack(called); got == tt.dropped {
+					t.Errorf("%s: called back %v, want %v", proc, got, !tt.dropped)
+				}
+			}
+		})
+	}
+}
+
+func TestNotifyCallers(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		call *xdrrpc.CallInfo
+		want bool
+	}{
+		{"other host", remote, false},
+		{"no caller", nil, false},
+		{"monitored host", tcp("127.0.0.1", 900), true},
+		{"in process", &xdrrpc.CallInfo{RemoteAddr: pipeAddr{}}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, called := newNSM(t)
+			if stat := mon(s, local, "127.0.0.1"); stat != nsm.StatSucc {
+				t.Fatalf("SM_MON: %v", stat)
+			}
+			notify(s, tt.call, "127.0.0.1")
+			if got := calledBack(called); got != tt.want {
+				t.Errorf("called back %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// pipeAddr is the address of connections made with net.Pipe.
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
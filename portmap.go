@@ -0,0 +1,65 @@
+package xdrrpc
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"strconv"
+)
+
+const (
+	PmapProg = 100000
+	PmapVers = 2
+	PmapPort = 111
+
+	IPProtoTCP = 6
+	IPProtoUDP = 17
+)
+
+func init() {
+	Register(PmapProg, PmapVers, 3, "Portmap", "Getport")
+}
+
+var errNotRegistered = errors.New("xdrrpc: program not registered with portmapper")
+
+// Mapping is the argument of PMAPPROC_GETPORT.
+type Mapping struct {
+	Program  uint32
+	Version  uint32
+	Protocol uint32
+	Port     uint32
+}
+
+// GetPort asks the portmapper on host which TCP port program version
+// listens on.
+func GetPort(host string, program, version uint32) (int, error) {
+	c, err := Dial("tcp", net.JoinHostPort(host, strconv.Itoa(PmapPort)), PmapProg, PmapVers)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	var port uint32
+	err = c.Call("Portmap.Getport", &Mapping{
+		Program:  program,
+		Version:  version,
+		Protocol: IPProtoTCP,
+	}, &port)
+	if err != nil {
+		return 0, err
+	}
+	if port == 0 {
+		return 0, errNotRegistered
+	}
+	return int(port), nil
+}
+
+// DialProgram connects to program version on host, looking up its port
+// with the portmapper.
+func DialProgram(host string, program, version uint32) (*rpc.Client, error) {
+	port, err := GetPort(host, program, version)
+	if err != nil {
+		return nil, err
+	}
+	return Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)), program, version)
+}
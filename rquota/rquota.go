@@ -0,0 +1,103 @@
+package rquota
+
+import (
+	"time"
+
+	"github.com/dzeromsk/xdrrpc"
+)
+
+const (
+	RquotaProg    = 100011
+	RquotaVers    = 1
+	ExtRquotaVers = 2
+)
+
+func init() {
+	xdrrpc.Register(RquotaProg, RquotaVers, 0, "Rquota", "Null")
+	xdrrpc.Register(RquotaProg, RquotaVers, 1, "Rquota", "Getquota")
+	xdrrpc.Register(RquotaProg, RquotaVers, 2, "Rquota", "GetActiveQuota")
+
+	xdrrpc.Register(RquotaProg, ExtRquotaVers, 0, "Rquota", "Null")
+	xdrrpc.Register(RquotaProg, ExtRquotaVers, 1, "Rquota", "ExtGetquota")
+	xdrrpc.Register(RquotaProg, ExtRquotaVers, 2, "Rquota", "ExtGetActiveQuota")
+	// xdrrpc.Register(RquotaProg, ExtRquotaVers, 3, "Rquota", "ExtSetquota")
+	// xdrrpc.Register(RquotaProg, ExtRquotaVers, 4, "Rquota", "ExtSetActiveQuota")
+}
+
+// BlockSize is the size of blocks usage and limits are reported in.
+const BlockSize = 1024
+
+// Kind tells whether a quota limits a user or a group.
+type Kind int32
+
+const (
+	UsrQuota Kind = 0
+	GrpQuota Kind = 1
+)
+
+// Limits of a user or group. Zero means no limit. Soft limits may be
+// exceeded for a grace period, hard limits never.
+type Limits struct {
+	BytesSoft, BytesHard uint64
+	FilesSoft, FilesHard uint64
+}
+
+// Quota is usage and limits of a user or group.
+type Quota struct {
+	Limits
+	Bytes, Files uint64
+
+	// time left until soft limits exceeded turn hard, zero if not
+	// exceeded
+	BytesGrace, FilesGrace time.Duration
+}
+
+// Quotaer is implemented by root handlers of exports enforcing quotas.
+// Quota returns false if quotas are not enabled for users or groups.
+type Quotaer interface {
+	Quota(kind Kind, id uint32) (Quota, bool)
+}
+
+type NullArgs struct{}
+
+type NullRes struct{}
+
+type GetquotaArgs struct {
+	xdrrpc.Header
+	Path string
+	UID  int32
+}
+
+type ExtGetquotaArgs struct {
+	xdrrpc.Header
+	Path string
+	Type Kind
+	ID   int32
+}
+
+type Status int32
+
+const (
+	QOk      Status = 1
+	QNoquota Status = 2
+	QEperm   Status = 3
+)
+
+// Dqblk is a quota as sent to clients, counted in blocks of Bsize bytes.
+type Dqblk struct {
+	Bsize      int32
+	Active     bool
+	BHardLimit uint32
+	BSoftLimit uint32
+	CurBlocks  uint32
+	FHardLimit uint32
+	FSoftLimit uint32
+	CurFiles   uint32
+	BTimeLeft  uint32
+	FTimeLeft  uint32
+}
+
+type GetquotaRes struct {
+	Status Status `xdr:"union"`
+	Quota  Dqblk  `xdr:"unioncase=1"`
+}
@@ -0,0 +1,121 @@
+package rquota
+
+import (
+	"math"
+	"time"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/mount"
+	"github.com/dzeromsk/xdrrpc/nfs"
+)
+
+// Rquota tells clients usage and limits of users and groups on exports of
+// a Mount whose root handlers implement Quotaer.
+type Rquota struct {
+	mnt *mount.Mount
+}
+
+func NewRquota(mnt *mount.Mount) *Rquota {
+	return &Rquota{mnt: mnt}
+}
+
+func (r *Rquota) Null(args *NullArgs, res *NullRes) error {
+	return nil
+}
+
+func (r *Rquota) Getquota(args *GetquotaArgs, res *GetquotaRes) error {
+	*res = r.get(args.Call(), args.Path, UsrQuota, uint32(args.UID))
+	return nil
+}
+
+func (r *Rquota) GetActiveQuota(args *GetquotaArgs, res *GetquotaRes) error {
+	*res = r.get(args.Call(), args.Path, UsrQuota, uint32(args.UID))
+	return nil
+}
+
+func (r *Rquota) ExtGetquota(args *ExtGetquotaArgs, res *GetquotaRes) error {
+	*res = r.get(args.Call(), args.Path, args.Type, uint32(args.ID))
+	return nil
+}
+
+func (r *Rquota) ExtGetActiveQuota(args *ExtGetquotaArgs, res *GetquotaRes) error {
+	*res = r.get(args.Call(), args.Path, args.Type, uint32(args.ID))
+	return nil
+}
+
+// get returns the quota of id on the export path belongs to. Like rquotad,
+// users may only ask about themselves and groups they belong to, unless
+// they are root.
+func (r *Rquota) get(call *xdrrpc.CallInfo, path string, kind Kind, id uint32) GetquotaRes {
+	e, export, ok := r.mnt.Lookup(path)
+	if !ok {
+		return GetquotaRes{Status: QNoquota}
+	}
+	cred, stat := r.mnt.Authorize(call, export, false)
+	if stat != nfs.NFSStatOk || !allowed(cred, kind, id) {
+		return GetquotaRes{Status: QEperm}
+	}
+	q, ok := e.Handler.(Quotaer)
+	if !ok {
+		return GetquotaRes{Status: QNoquota}
+	}
+	quota, ok := q.Quota(kind, id)
+	if !ok {
+		return GetquotaRes{Status: QNoquota}
+	}
+	return GetquotaRes{
+		Status: QOk,
+		Quota: Dqblk{
+			Bsize:      BlockSize,
+			Active:     true,
+			BHardLimit: blocks(quota.BytesHard),
+			BSoftLimit: blocks(quota.BytesSoft),
+			CurBlocks:  blocks(quota.Bytes),
+			FHardLimit: clamp(quota.FilesHard),
+			FSoftLimit: clamp(quota.FilesSoft),
+			CurFiles:   clamp(quota.Files),
+			BTimeLeft:  seconds(quota.BytesGrace),
+			FTimeLeft:  seconds(quota.FilesGrace),
+		},
+	}
+}
+
+func allowed(cred nfs.Cred, kind Kind, id uint32) bool {
+	if cred.UID == 0 {
+		return true
+	}
+	switch kind {
+	case UsrQuota:
+		return cred.UID == id
+	case GrpQuota:
+		if cred.GID == id {
+			return true
+		}
+		for _, gid := range cred.GIDs {
+			if gid == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// blocks returns bytes in blocks, rounded up.
+func blocks(bytes uint64) uint32 {
+	n := bytes / BlockSize
+	if bytes%BlockSize != 0 {
+		n++
+	}
+	return clamp(n)
+}
+
+func clamp(n uint64) uint32 {
+	if n > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(n)
+}
+
+func seconds(d time.Duration) uint32 {
+	return clamp(uint64((d + time.Second - 1) / time.Second))
+}
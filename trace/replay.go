@@ -0,0 +1,413 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/nfs"
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+// Diff is a reply that differs from the recording.
+type Diff struct {
+	Call    *Entry
+	Method  string   // service method called, empty if not registered
+	Reasons []string // differences found, one per field
+}
+
+func (d *Diff) String() string {
+	method := d.Method
+	if method == "" {
+		method = "unknown"
+	}
+	return fmt.Sprintf("%s conn %d %s: %s", time.Unix(0, d.Call.Time).Format(time.RFC3339Nano),
+		d.Call.Conn, method, strings.Join(d.Reasons, "; "))
+}
+
+type callHeader struct {
+	Xid        uint32
+	Type       xdrrpc.MessageType
+	RPCVersion uint32
+	Program    uint32
+	Version    uint32
+	Procedure  uint32
+	Cred       xdrrpc.OpaqueAuth
+	Verf       xdrrpc.OpaqueAuth
+}
+
+type replyHeader struct {
+	Xid  uint32
+	Type xdrrpc.MessageType
+	Stat xdrrpc.ReplyStat
+}
+
+type acceptedReply struct {
+	Verf xdrrpc.OpaqueAuth
+	Stat xdrrpc.AcceptStat
+}
+
+// handleFields names byte slices holding file handles, in arguments and
+// results of NFS, MOUNT and NLM.
+var handleFields = map[string]bool{"Object": true, "Dir": true, "FSRoot": true, "FH": true, "Handle": true}
+
+var (
+	fhandle2Type = reflect.TypeOf(nfs.FHandle2{})
+	bytesType    = reflect.TypeOf([]byte(nil))
+	nfs3TimeType = reflect.TypeOf(nfs.NFS3Time{})
+	guardType    = reflect.TypeOf(nfs.Sattrguard3{})
+
+	// values of these types change from run to run
+	timeTypes = map[reflect.Type]bool{
+		reflect.TypeOf(nfs.NFS3Time{}): true,
+		reflect.TypeOf(nfs.NFS4Time{}): true,
+		reflect.TypeOf(nfs.Timeval2{}): true,
+	}
+)
+
+// replayer holds what a replay learned about the fresh server. Servers
+// number their objects as they please, so a recorded handle or time
+// stands for what the latest reply showing it had in its place, and a
+// recorded file id for what the first one had. File ids are never
+// reused, so a recorded one standing for another later is a difference.
+type replayer struct {
+	rcvrs   []interface{}
+	handles map[string][]byte             // replayed handles of recorded ones
+	fileids map[uint64]uint64             // replayed file ids of recorded ones
+	times   map[nfs.NFS3Time]nfs.NFS3Time // replayed times of recorded ones
+}
+
+// Replay sends every call of r to a fresh server, over a new connection
+// dialed for each recorded one, and returns replies that differ from the
+// recorded ones. Calls go one at a time in recorded order. Handles of
+// the recording are matched with those of the fresh server as replies
+// show them, and so are times guarding SETATTR and file ids. Handles,
+// times and write verifiers are not compared, file ids only with ids
+// the fresh server gave the same objects before.
+// Arguments and results are decoded with types of procedures rcvrs
+// implement, others are compared byte for byte.
+func Replay(r *Reader, dial func() (io.ReadWriteCloser, error), rcvrs ...interface{}) ([]Diff, error) {
+	var entries []*Entry
+	for {
+		e, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	p := &replayer{
+		rcvrs:   rcvrs,
+		handles: make(map[string][]byte),
+		fileids: make(map[uint64]uint64),
+		times:   make(map[nfs.NFS3Time]nfs.NFS3Time),
+	}
+	conns := make(map[uint32]io.ReadWriteCloser)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	var diffs []Diff
+	for i, e := range entries {
+		if e.Reply {
+			continue
+		}
+		var hdr callHeader
+		rd := bytes.NewReader(e.Record)
+		if err := xdrrpc.Unmarshal(rd, &hdr); err != nil || hdr.Type != xdrrpc.Call {
+			continue
+		}
+		conn, ok := conns[e.Conn]
+		if !ok {
+			var err error
+			if conn, err = dial(); err != nil {
+				return diffs, err
+			}
+			conns[e.Conn] = conn
+		}
+
+		name, args, res, _ := p.method(hdr.Program, hdr.Version, hdr.Procedure)
+		call, err := p.call(&hdr, rd, args)
+		if err != nil {
+			return diffs, err
+		}
+		if err := writeRecord(conn, call); err != nil {
+			return diffs, err
+		}
+		reply, err := readRecord(conn)
+		if err != nil {
+			return diffs, err
+		}
+
+		recorded := recordedReply(entries[i+1:], e.Conn, hdr.Xid)
+		if recorded == nil {
+			continue
+		}
+		if reasons := p.compare(recorded.Record, reply, res); len(reasons) > 0 {
+			diffs = append(diffs, Diff{Call: e, Method: name, Reasons: reasons})
+		}
+	}
+	return diffs, nil
+}
+
+// recordedReply returns the first reply to xid on conn in entries.
+func recordedReply(entries []*Entry, conn, xid uint32) *Entry {
+	for _, e := range entries {
+		if e.Conn == conn && e.Reply && len(e.Record) >= 4 && binary.BigEndian.Uint32(e.Record) == xid {
+			return e
+		}
+	}
+	return nil
+}
+
+func writeRecord(w io.Writer, record []byte) error {
+	b := make([]byte, 4, 4+len(record))
+	binary.BigEndian.PutUint32(b, uint32(len(record))|0x80000000)
+	_, err := w.Write(append(b, record...))
+	return err
+}
+
+// method returns argument and result types of the procedure registered
+// for proc of prog vers, if one of p.rcvrs implements it.
+func (p *replayer) method(prog, vers, proc uint32) (name string, args, res reflect.Type, ok bool) {
+	name, ok = xdrrpc.Lookup(prog, vers, proc)
+	if !ok {
+		return "", nil, nil, false
+	}
+	service, method, _ := strings.Cut(name, ".")
+	for _, rcvr := range p.rcvrs {
+		typ := reflect.TypeOf(rcvr)
+		if typ.Elem().Name() != service {
+			continue
+		}
+		if m, ok := typ.MethodByName(method); ok {
+			return name, m.Type.In(1).Elem(), m.Type.In(2).Elem(), true
+		}
+	}
+	return name, nil, nil, false
+}
+
+// call returns the recorded call of hdr with arguments rest, referring to
+// objects of the fresh server where known.
+func (p *replayer) call(hdr *callHeader, rest *bytes.Reader, typ reflect.Type) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := xdr.Marshal(&buf, hdr); err != nil {
+		return nil, err
+	}
+	raw := make([]byte, rest.Len())
+	rest.Read(raw)
+	if typ == nil {
+		buf.Write(raw)
+		return buf.Bytes(), nil
+	}
+	args := reflect.New(typ)
+	if err := xdrrpc.Unmarshal(bytes.NewReader(raw), args.Interface()); err != nil {
+		// garbage stays garbage
+		buf.Write(raw)
+		return buf.Bytes(), nil
+	}
+	p.translate(args)
+	if _, err := xdr.Marshal(&buf, args.Interface()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// translate replaces recorded handles and guard times v holds with
+// replayed ones.
+func (p *replayer) translate(v reflect.Value) {
+	switch v.Type() {
+	case fhandle2Type:
+		if fh, ok := p.handles[string(v.Slice(0, v.Len()).Bytes())]; ok {
+			reflect.Copy(v, reflect.ValueOf(fh))
+		}
+		return
+	case guardType:
+		guard := v.Addr().Interface().(*nfs.Sattrguard3)
+		if t, ok := p.times[guard.Ctime]; ok {
+			guard.Ctime = t
+		}
+		return
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			p.translate(v.Elem())
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			p.translate(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if handleFields[field.Name] && field.Type == bytesType {
+				if fh, ok := p.handles[string(v.Field(i).Bytes())]; ok {
+					v.Field(i).SetBytes(fh)
+				}
+				continue
+			}
+			p.translate(v.Field(i))
+		}
+	}
+}
+
+// compare returns differences of reply from the recorded one, decoding
+// results as typ if not nil.
+func (p *replayer) compare(recorded, reply []byte, typ reflect.Type) []string {
+	var (
+		rec, rep       replyHeader
+		recAcc, repAcc acceptedReply
+	)
+	recRd, repRd := bytes.NewReader(recorded), bytes.NewReader(reply)
+	if err := xdrrpc.Unmarshal(recRd, &rec); err != nil {
+		return nil
+	}
+	if err := xdrrpc.Unmarshal(repRd, &rep); err != nil {
+		return []string{fmt.Sprintf("reply: %v", err)}
+	}
+	if rec.Stat != rep.Stat {
+		return []string{fmt.Sprintf("reply stat %d, recorded %d", rep.Stat, rec.Stat)}
+	}
+	if rec.Stat == xdrrpc.MessageAccepted {
+		xdrrpc.Unmarshal(recRd, &recAcc)
+		if err := xdrrpc.Unmarshal(repRd, &repAcc); err != nil {
+			return []string{fmt.Sprintf("reply: %v", err)}
+		}
+		if recAcc.Stat != repAcc.Stat {
+			return []string{fmt.Sprintf("%v, recorded %v", repAcc.Stat, recAcc.Stat)}
+		}
+	}
+	if typ == nil || rec.Stat != xdrrpc.MessageAccepted || recAcc.Stat != xdrrpc.Success {
+		if !bytes.Equal(rest(recRd), rest(repRd)) {
+			return []string{"reply differs"}
+		}
+		return nil
+	}
+
+	recRes, repRes := reflect.New(typ), reflect.New(typ)
+	if err := xdrrpc.Unmarshal(recRd, recRes.Interface()); err != nil {
+		return nil
+	}
+	if err := xdrrpc.Unmarshal(repRd, repRes.Interface()); err != nil {
+		return []string{fmt.Sprintf("results: %v", err)}
+	}
+	var reasons []string
+	p.diff(typ.Name(), recRes.Elem(), repRes.Elem(), &reasons)
+	return reasons
+}
+
+func rest(r *bytes.Reader) []byte {
+	b := make([]byte, r.Len())
+	r.Read(b)
+	return b
+}
+
+// diff appends differences of replayed results rep from recorded ones
+// rec to reasons, learning handles and times of the fresh server.
+func (p *replayer) diff(path string, rec, rep reflect.Value, reasons *[]string) {
+	differ := func(format string, args ...interface{}) {
+		*reasons = append(*reasons, path+": "+fmt.Sprintf(format, args...))
+	}
+	typ := rec.Type()
+	switch {
+	case typ == nfs3TimeType:
+		p.times[rec.Interface().(nfs.NFS3Time)] = rep.Interface().(nfs.NFS3Time)
+		return
+	case timeTypes[typ]:
+		return
+	case typ == fhandle2Type:
+		p.learn(bytesOf(rec), bytesOf(rep))
+		return
+	}
+	switch rec.Kind() {
+	case reflect.Ptr:
+		if rec.IsNil() != rep.IsNil() {
+			differ("set %v, recorded %v", !rep.IsNil(), !rec.IsNil())
+			return
+		}
+		if !rec.IsNil() {
+			p.diff(path, rec.Elem(), rep.Elem(), reasons)
+		}
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			if !bytes.Equal(bytesOf(rec), bytesOf(rep)) {
+				differ("%x, recorded %x", bytesOf(rep), bytesOf(rec))
+			}
+			return
+		}
+		if rec.Len() != rep.Len() {
+			differ("%d elements, recorded %d", rep.Len(), rec.Len())
+		}
+		for i := 0; i < rec.Len() && i < rep.Len(); i++ {
+			p.diff(fmt.Sprintf("%s[%d]", path, i), rec.Index(i), rep.Index(i), reasons)
+		}
+	case reflect.Struct:
+		for i := 0; i < rec.NumField(); i++ {
+			field := typ.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := path + "." + field.Name
+			switch {
+			case handleFields[field.Name] && field.Type == bytesType:
+				p.learn(rec.Field(i).Bytes(), rep.Field(i).Bytes())
+			case field.Name == "Fileid" || field.Name == "FileID":
+				if id := rec.Field(i).Uint(); !p.sameFile(id, rep.Field(i).Uint()) {
+					*reasons = append(*reasons, fmt.Sprintf("%s: %d, recorded %d stood for %d before",
+						name, rep.Field(i).Uint(), id, p.fileids[id]))
+				}
+			case field.Name == "Verf":
+				// write verifiers are new for every server
+			default:
+				p.diff(name, rec.Field(i), rep.Field(i), reasons)
+			}
+		}
+	default:
+		if !reflect.DeepEqual(rec.Interface(), rep.Interface()) {
+			differ("%v, recorded %v", rep.Interface(), rec.Interface())
+		}
+	}
+}
+
+func bytesOf(v reflect.Value) []byte {
+	if v.Kind() == reflect.Array {
+		b := make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(b), v)
+		return b
+	}
+	return v.Bytes()
+}
+
+// sameFile tells whether recorded file id rec stands for replayed id rep,
+// making it do so if it stood for nothing yet.
+func (p *replayer) sameFile(rec, rep uint64) bool {
+	if id, ok := p.fileids[rec]; ok {
+		return id == rep
+	}
+	p.fileids[rec] = rep
+	return true
+}
+
+// learn makes recorded handle rec stand for replayed handle rep.
+func (p *replayer) learn(rec, rep []byte) {
+	if len(rec) > 0 && len(rep) > 0 {
+		p.handles[string(rec)] = append([]byte(nil), rep...)
+	}
+}
@@ -0,0 +1,194 @@
+// Package trace records RPC sessions of a server to a file and replays
+// them against a fresh server, reporting replies that differ from the
+// recording.
+//
+// A trace is a sequence of XDR encoded entries, each framed with record
+// marking as RPC messages are over TCP.
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/rasky/go-xdr/xdr2"
+)
+
+var errEntryTooLarge = errors.New("trace: entry too large")
+
+// Entry is a record read or written on a connection of a recorded server.
+type Entry struct {
+	Time   int64  // unix nanoseconds when the record was complete
+	Conn   uint32 // connection, numbered from 1 in order of recording
+	Remote string // remote address of the connection, if known
+	Reply  bool   // written by the server rather than read
+	Record []byte // RPC message, without record marking
+}
+
+// Recorder writes entries of connections it wraps.
+type Recorder struct {
+	mu    sync.Mutex
+	w     io.Writer
+	buf   bytes.Buffer
+	conns uint32
+	err   error
+}
+
+// NewRecorder returns a recorder writing entries to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// Conn returns conn, recording every record read from or written to it
+// as a new connection.
+func (r *Recorder) Conn(conn net.Conn) net.Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conns++
+	c := &recordedConn{Conn: conn, r: r, id: r.conns}
+	if addr := conn.RemoteAddr(); addr != nil {
+		c.remote = addr.String()
+	}
+	return c
+}
+
+// Err returns the first error writing entries, which stops recording.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(e *Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.buf.Reset()
+	r.buf.Write([]byte{0, 0, 0, 0})
+	if _, err := xdr.Marshal(&r.buf, e); err != nil {
+		r.err = err
+		return
+	}
+	b := r.buf.Bytes()
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4)|0x80000000)
+	_, r.err = r.w.Write(b)
+}
+
+type recordedConn struct {
+	net.Conn
+	r      *Recorder
+	id     uint32
+	remote string
+	in     records
+	out    records
+}
+
+func (c *recordedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in.write(p[:n], func(record []byte) {
+		c.r.record(&Entry{Time: time.Now().UnixNano(), Conn: c.id, Remote: c.remote, Record: record})
+	})
+	return n, err
+}
+
+func (c *recordedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out.write(p[:n], func(record []byte) {
+		c.r.record(&Entry{Time: time.Now().UnixNano(), Conn: c.id, Remote: c.remote, Reply: true, Record: record})
+	})
+	return n, err
+}
+
+// records splits a stream into records, as it passes.
+type records struct {
+	buf    []byte // bytes not split yet
+	record []byte // fragments of the record so far
+	broken bool   // stream too large to follow
+}
+
+func (s *records) write(p []byte, fn func(record []byte)) {
+	if s.broken {
+		return
+	}
+	s.buf = append(s.buf, p...)
+	for len(s.buf) >= 4 {
+		mark := binary.BigEndian.Uint32(s.buf)
+		size := int(mark & 0x7fffffff)
+		// the server gives up on such records too
+		if len(s.record)+size > xdrrpc.MaxRecordSize {
+			s.broken, s.buf, s.record = true, nil, nil
+			return
+		}
+		if len(s.buf)-4 < size {
+			return
+		}
+		s.record = append(s.record, s.buf[4:4+size]...)
+		s.buf = s.buf[4+size:]
+		if mark&0x80000000 != 0 {
+			fn(s.record)
+			s.record = nil
+		}
+	}
+	if len(s.buf) == 0 {
+		s.buf = nil
+	}
+}
+
+// Reader reads entries of a trace.
+type Reader struct {
+	r io.Reader
+}
+
+// NewReader returns a reader of the trace r holds.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Read returns the next entry, or io.EOF at the end of the trace.
+func (r *Reader) Read() (*Entry, error) {
+	record, err := readRecord(r.r)
+	if err != nil {
+		return nil, err
+	}
+	var e Entry
+	if err := xdrrpc.Unmarshal(bytes.NewReader(record), &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// readRecord reads a record marked as RPC messages are over TCP.
+func readRecord(r io.Reader) ([]byte, error) {
+	var record bytes.Buffer
+	for first := true; ; first = false {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF && !first {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		mark := binary.BigEndian.Uint32(hdr[:])
+		size := int64(mark & 0x7fffffff)
+		// entries hold a record and little else
+		if int64(record.Len())+size > 2*xdrrpc.MaxRecordSize {
+			return nil, errEntryTooLarge
+		}
+		if _, err := io.CopyN(&record, r, size); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if mark&0x80000000 != 0 {
+			return record.Bytes(), nil
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package trace_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc"
+	"github.com/dzeromsk/xdrrpc/client"
+	"github.com/dzeromsk/xdrrpc/cmd/simple-nfs-server/memfs"
+	"github.com/dzeromsk/xdrrpc/nfs"
+	"github.com/dzeromsk/xdrrpc/nfstest"
+	"github.com/dzeromsk/xdrrpc/trace"
+)
+
+func tree(content string) nfstest.Factory {
+	return func(mux nfs.ServeMux) interface{} {
+		return memfs.NewFS(memfs.NewDir(mux, map[string]memfs.Node{
+			"hello": memfs.NewFile(content),
+			"example": memfs.NewDir(mux, map[string]memfs.Node{
+				"alice": memfs.NewFile("bob\n"),
+			}),
+		}))
+	}
+}
+
+// record runs a session against the tree f makes, recorded with rec.
+func record(t *testing.T, f nfstest.Factory, rec *trace.Recorder) {
+	t.Helper()
+	s := nfstest.NewServer(f)
+	defer s.Close()
+	// the recording is complete once the server is done with every call
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	dial := func() net.Conn {
+		c, conn := net.Pipe()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.RPC.ServeCodec(xdrrpc.NewServerCodec(rec.Conn(conn)))
+		}()
+		return c
+	}
+	c, err := client.MountConn(dial(), dial(), "/", client.WithCred(nfs.Cred{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fsys := client.NewFS(c)
+	if _, err := fsys.ReadFile("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.ReadFile("example/alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReadDir("/"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func replay(t *testing.T, f nfstest.Factory, data []byte) []trace.Diff {
+	t.Helper()
+	s := nfstest.NewServer(f)
+	defer s.Close()
+	dial := func() (io.ReadWriteCloser, error) {
+		return s.Pipe(), nil
+	}
+	diffs, err := trace.Replay(trace.NewReader(bytes.NewReader(data)), dial,
+		s.Mount, s.Mux.Receiver(), s.Mux.ACLReceiver(), s.Mux.NFS4Receiver(), s.Mux.NFS2Receiver())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return diffs
+}
+
+func TestReplay(t *testing.T) {
+	var buf bytes.Buffer
+	rec := trace.NewRecorder(&buf)
+	record(t, tree("world\n"), rec)
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	conns := map[uint32]bool{}
+	r := trace.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		e, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[e.Conn] = true
+	}
+	if len(conns) != 2 {
+		t.Errorf("recorded %d connections, want 2", len(conns))
+	}
+
+	for _, d := range replay(t, tree("world\n"), buf.Bytes()) {
+		t.Errorf("same tree: %s", d.String())
+	}
+	if diffs := replay(t, tree("world!\n"), buf.Bytes()); len(diffs) == 0 {
+		t.Error("no differences replaying on another tree")
+	}
+}
+
+// failWriter fails every write after the first n bytes.
+type failWriter struct {
+	n      int
+	writes int
+}
+
+var errFull = errors.New("full")
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errFull
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestRecorderErr(t *testing.T) {
+	w := &failWriter{n: 100}
+	rec := trace.NewRecorder(w)
+	record(t, tree("world\n"), rec)
+	if err := rec.Err(); !errors.Is(err, errFull) {
+		t.Fatalf("Err: got %v, want %v", err, errFull)
+	}
+	if w.writes != 1 {
+		t.Errorf("%d writes, want recording to stop after the failed one", w.writes)
+	}
+}
@@ -1,12 +1,14 @@
 package xdrrpc
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/rpc"
 	"sync"
 
@@ -17,17 +19,20 @@ var (
 	errInvalidMessageType = errors.New("xdrrpc: invalid message type received")
 	errEncodingResponse   = errors.New("xdrrpc: xdr error encoding response")
 	errMappingDuplicate   = errors.New("xdrrpc: service already defined")
+	errGarbageArgs        = errors.New("xdrrpc: garbage arguments")
 )
 
 var Debug = false
 
 type serverCodec struct {
-	dec *xdr.Decoder // for reading XDR values
 	enc *xdr.Encoder // for writing XDR values
+	r   io.Reader
 	c   io.WriteCloser
 
-	lr  *io.LimitedReader // to limit decoder
-	buf *bytes.Buffer     // for encoder
+	rd  *bytes.Reader // record being read
+	buf *bytes.Buffer // for encoder
+
+	remote net.Addr // nil if conn does not tell
 
 	// temporary work space
 	req serverRequest
@@ -35,16 +40,18 @@ type serverCodec struct {
 
 // NewServerCodec returns a new rpc.ServerCodec using JSON-RPC on conn.
 func NewServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
-	// TODO(dzeromsk): we should wrap conn reader with bufio
-	lr := &io.LimitedReader{R: conn, N: 4}
 	buf := new(bytes.Buffer)
-	return &serverCodec{
-		lr:  lr,
-		dec: xdr.NewDecoder(lr),
+	c := &serverCodec{
 		enc: xdr.NewEncoder(buf),
+		r:   bufio.NewReader(conn),
 		c:   conn,
+		rd:  bytes.NewReader(nil),
 		buf: buf,
 	}
+	if a, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		c.remote = a.RemoteAddr()
+	}
+	return c
 }
 
 type serverRequest struct {
@@ -74,22 +81,16 @@ type serverResponse struct {
 }
 
 func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
-	// reset limited reader
-	c.lr.N = 4
-
-	size, _, err := c.dec.DecodeUint()
+	// calls are decoded from whole records, so neither short nor
+	// fragmented ones get the stream out of step
+	record, err := readRecord(c.r)
 	if err != nil {
 		return err
 	}
-
-	// TODO(dzeromsk): we should be able to read multiple chained
-	// records and not just assume there is always one
-
-	// reset limited reader, now to proper size
-	c.lr.N = int64(size & 0x7fffffff)
+	c.rd.Reset(record)
 
 	c.req.reset()
-	if _, err := c.dec.Decode(&c.req); err != nil {
+	if err := Unmarshal(c.rd, &c.req); err != nil {
 		return err
 	}
 
@@ -116,24 +117,33 @@ func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
 }
 
 func (c *serverCodec) ReadRequestBody(x interface{}) error {
-	// rpc server will try to discard body by calling us with nil x
+	// rpc server will try to discard body by calling us with nil x,
+	// remaining data of the record is ignored anyway
 	if x == nil {
-		_, err := io.Copy(ioutil.Discard, c.lr)
-		return err
+		return nil
 	}
 
-	_, err := c.dec.Decode(x)
-
-	// log.Printf("request: %s", spew.Sdump(x))
+	// the record stays in step, arguments that fail to decode only fail
+	// the call
+	if err := Unmarshal(c.rd, x); err != nil {
+		return errGarbageArgs
+	}
 
-	// make sure we ignore ramaining data
-	if _, err2 := io.Copy(ioutil.Discard, c.lr); err2 != nil {
-		if err != nil && err2 != io.EOF {
-			return err2
-		}
+	if h, ok := x.(callSetter); ok {
+		h.SetCall(&CallInfo{
+			Xid:        c.req.Xid,
+			Program:    c.req.Program,
+			Version:    c.req.Version,
+			Procedure:  c.req.Procedure,
+			Cred:       c.req.Cred,
+			Verf:       c.req.Verf,
+			RemoteAddr: c.remote,
+		})
 	}
 
-	return err
+	// log.Printf("request: %s", spew.Sdump(x))
+
+	return nil
 }
 
 func (c *serverCodec) WriteResponse(r *rpc.Response, x interface{}) error {
@@ -145,6 +155,8 @@ func (c *serverCodec) WriteResponse(r *rpc.Response, x interface{}) error {
 
 	if r.Error == "" {
 		resp.Stat = Success
+	} else if r.Error == errGarbageArgs.Error() {
+		resp.Stat = GarbageArgs
 	} else {
 		// TODO(dzeromsk): user c.req to determine proper error code or
 		// replace c.req with err error property
@@ -184,6 +196,20 @@ func (c *serverCodec) Close() error {
 	return c.c.Close()
 }
 
+// Unmarshal decodes v from r, which holds the rest of a message read from
+// a peer. Peers may send anything, so lengths claiming more than r holds
+// fail before anything is allocated for them, and panics of the decoder
+// are returned as errors rather than taking the server down.
+func Unmarshal(r *bytes.Reader, v interface{}) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("xdrrpc: decoding %T: %v", v, e)
+		}
+	}()
+	_, err = xdr.UnmarshalLimited(r, v, uint(r.Len()))
+	return err
+}
+
 // ServeConn runs the XRP-RPC server on a single connection.
 // ServeConn blocks, serving the connection until the client hangs up.
 // The caller typically invokes ServeConn in a go statement.
@@ -210,6 +236,12 @@ func Register(program, version, procedure uint32, service, method string) {
 	}
 }
 
+// Lookup returns the service method registered for procedure of program
+// version.
+func Lookup(program, version, procedure uint32) (string, bool) {
+	return lookup(program, version, procedure)
+}
+
 func lookup(program, version, procedure uint32) (string, bool) {
 	key := key{
 		Program:   program,
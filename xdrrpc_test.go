@@ -0,0 +1,38 @@
+package xdrrpc_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dzeromsk/xdrrpc"
+)
+
+type opaqueArgs struct {
+	Data []byte
+}
+
+type listArgs struct {
+	Entries []xdrrpc.Mapping
+}
+
+func TestUnmarshalLengths(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data []byte
+		v    interface{}
+		ok   bool
+	}{
+		{"opaque", []byte{0, 0, 0, 3, 'a', 'b', 'c', 0}, new(opaqueArgs), true},
+		{"opaque longer than the message", []byte{0x7f, 0xff, 0xff, 0xf0, 0, 0, 0, 0}, new(opaqueArgs), false},
+		{"string longer than the message", []byte{0x7f, 0xff, 0xff, 0xf0, 'a', 'b', 'c', 0}, new(string), false},
+		{"array longer than the message", []byte{0x7f, 0xff, 0xff, 0xf0, 0, 0, 0, 0}, new(listArgs), false},
+		{"empty array", []byte{0, 0, 0, 0}, new(listArgs), true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := xdrrpc.Unmarshal(bytes.NewReader(tt.data), tt.v)
+			if (err == nil) != tt.ok {
+				t.Errorf("got %v, want success %v", err, tt.ok)
+			}
+		})
+	}
+}